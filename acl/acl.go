@@ -0,0 +1,230 @@
+// Package acl keeps the users that connections authenticate as and decides
+// which commands, keys and pub/sub channels each of them can access.
+//
+// Users are changed with rules in the format of ACL SETUSER, for example
+//
+//	user alice on >secret ~cache:* &news.* +@read -keys
+//
+// which is also the format of the ACL file, one user per line.
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultUser is the user new connections are authenticated as
+const DefaultUser = "default"
+
+// Categories lists the command categories
+var Categories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash",
+	"string", "bitmap", "hyperloglog", "geo", "stream", "pubsub", "admin",
+	"fast", "slow", "blocking", "dangerous", "connection", "transaction",
+	"scripting",
+}
+
+// Command describes a command to the ACL system
+type Command struct {
+	Name        string
+	Categories  []string
+	Subcommands []string
+}
+
+// ACL holds the users
+type ACL struct {
+	commands map[string]Command
+	users    map[string]*User
+}
+
+// New creates the ACL for the given commands, with a default user that can
+// run everything without a password
+func New(commands []Command) *ACL {
+	a := &ACL{
+		commands: make(map[string]Command, len(commands)),
+		users:    make(map[string]*User),
+	}
+	for _, c := range commands {
+		a.commands[strings.ToLower(c.Name)] = c
+	}
+
+	a.users[DefaultUser] = a.defaultUser()
+	return a
+}
+
+func (a *ACL) defaultUser() *User {
+	u := newUser(DefaultUser)
+	for _, r := range []string{"on", "nopass", "~*", "&*", "+@all"} {
+		u.apply(a, r)
+	}
+	return u
+}
+
+// User looks a user up by name
+func (a *ACL) User(name string) *User {
+	return a.users[name]
+}
+
+// Users returns every user, sorted by name
+func (a *ACL) Users() []*User {
+	users := make([]*User, 0, len(a.users))
+	for _, u := range a.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].name < users[j].name })
+	return users
+}
+
+// SetUser creates or changes a user. The rules are applied in order, and
+// if any of them is invalid the user is left as it was.
+func (a *ACL) SetUser(name string, rules []string) error {
+	u, ok := a.users[name]
+	if !ok {
+		u = newUser(name)
+	}
+
+	changed := u.clone()
+	for _, r := range rules {
+		if err := changed.apply(a, r); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %w", r, err)
+		}
+	}
+
+	// update in place so authenticated connections see the change
+	*u = *changed
+	a.users[name] = u
+	return nil
+}
+
+// DelUser removes a user and reports whether it existed. The default user
+// can not be removed.
+func (a *ACL) DelUser(name string) (bool, error) {
+	if name == DefaultUser {
+		return false, fmt.Errorf("The '%s' user cannot be removed", DefaultUser)
+	}
+
+	u, ok := a.users[name]
+	if !ok {
+		return false, nil
+	}
+
+	u.removed = true
+	delete(a.users, name)
+	return true, nil
+}
+
+// Authenticate returns the user if it is enabled and password is one of
+// its passwords
+func (a *ACL) Authenticate(name, password string) (*User, bool) {
+	u, ok := a.users[name]
+	if !ok || !u.enabled || !u.CheckPassword(password) {
+		return nil, false
+	}
+	return u, true
+}
+
+// SetRequirePass sets the only password of the default user. An empty
+// password lets anyone authenticate as it.
+func (a *ACL) SetRequirePass(password string) {
+	rules := []string{"resetpass", ">" + password}
+	if password == "" {
+		rules = []string{"nopass"}
+	}
+	a.SetUser(DefaultUser, rules)
+}
+
+// Command returns the ACL description of a command
+func (a *ACL) Command(name string) (Command, bool) {
+	c, ok := a.commands[strings.ToLower(name)]
+	return c, ok
+}
+
+// CategoryCommands returns the names of the commands in category, sorted
+func (a *ACL) CategoryCommands(category string) ([]string, bool) {
+	names, ok := a.categoryCommands(strings.ToLower(category))
+	sort.Strings(names)
+	return names, ok
+}
+
+func (a *ACL) categoryCommands(category string) ([]string, bool) {
+	if category != "all" && !contains(Categories, category) {
+		return nil, false
+	}
+
+	names := make([]string, 0)
+	for name, c := range a.commands {
+		if category == "all" || contains(c.Categories, category) {
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// Load replaces the users with the ones in the ACL file at path. Users
+// that are not in the file are removed, and a default user that can run
+// everything is created if the file does not define one. Nothing changes
+// if the file has an error.
+func (a *ACL) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	loaded := &ACL{commands: a.commands, users: make(map[string]*User)}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: should start with user keyword", path, lineNo)
+		}
+		if _, ok := loaded.users[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, lineNo, fields[1])
+		}
+		if err := loaded.SetUser(fields[1], fields[2:]); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if _, ok := loaded.users[DefaultUser]; !ok {
+		loaded.users[DefaultUser] = a.defaultUser()
+	}
+
+	for name, u := range a.users {
+		if l, ok := loaded.users[name]; ok {
+			*u = *l
+			loaded.users[name] = u
+		} else {
+			u.removed = true
+		}
+	}
+	a.users = loaded.users
+	return nil
+}
+
+// Save writes every user to the ACL file at path
+func (a *ACL) Save(path string) error {
+	var b strings.Builder
+	for _, u := range a.Users() {
+		b.WriteString(u.Describe() + "\n")
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d-%s", os.Getpid(), filepath.Base(path)))
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
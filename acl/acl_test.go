@@ -0,0 +1,151 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testCommands = []Command{
+	{Name: "get", Categories: []string{"read", "string", "fast"}},
+	{Name: "set", Categories: []string{"write", "string", "slow"}},
+	{Name: "keys", Categories: []string{"read", "keyspace", "slow", "dangerous"}},
+	{Name: "acl", Categories: []string{"admin", "slow", "dangerous"}, Subcommands: []string{"whoami", "setuser"}},
+}
+
+func Test_Default_User(t *testing.T) {
+	assert := assert.New(t)
+	a := New(testCommands)
+
+	u, ok := a.Authenticate(DefaultUser, "anything")
+	assert.True(ok)
+	assert.True(u.CanRun("keys", ""))
+	assert.True(u.CanAccessKey("foo", true))
+	assert.True(u.CanAccessChannel("news", false))
+	assert.Equal("user default on nopass ~* &* +@all", u.Describe())
+
+	a.SetRequirePass("secret")
+	_, ok = a.Authenticate(DefaultUser, "anything")
+	assert.False(ok)
+	_, ok = a.Authenticate(DefaultUser, "secret")
+	assert.True(ok)
+	assert.Equal([]string{HashPassword("secret")}, u.Passwords())
+}
+
+func Test_SetUser(t *testing.T) {
+	assert := assert.New(t)
+	a := New(testCommands)
+
+	assert.NoError(a.SetUser("alice", []string{"on", ">pw", "~cache:*", "%R~shared:*", "&news.*", "+@read", "-keys", "+acl|whoami"}))
+	u := a.User("alice")
+
+	assert.True(u.CanRun("GET", ""))
+	assert.False(u.CanRun("set", ""))
+	assert.False(u.CanRun("keys", ""))
+	assert.True(u.CanRun("acl", "WHOAMI"))
+	assert.False(u.CanRun("acl", "setuser"))
+
+	assert.True(u.CanAccessKey("cache:1", true))
+	assert.True(u.CanAccessKey("shared:1", false))
+	assert.False(u.CanAccessKey("shared:1", true))
+	assert.False(u.CanAccessKey("other", false))
+
+	assert.True(u.CanAccessChannel("news.tech", false))
+	assert.False(u.CanAccessChannel("news.*.x", true))
+	assert.True(u.CanAccessChannel("news.*", true))
+
+	assert.Equal("user alice on #"+HashPassword("pw")+" ~cache:* %R~shared:* &news.* +@read -keys +acl|whoami", u.Describe())
+
+	// an invalid rule leaves the user unchanged
+	assert.EqualError(a.SetUser("alice", []string{"off", "+nosuchcommand"}),
+		"Error in ACL SETUSER modifier '+nosuchcommand': Unknown command or category name in ACL")
+	assert.True(u.Enabled())
+	assert.Error(a.SetUser("alice", []string{"+get|sub"}))
+	assert.Error(a.SetUser("alice", []string{"#nothex"}))
+
+	// connections holding the user see changes
+	assert.NoError(a.SetUser("alice", []string{"+@all"}))
+	assert.True(u.CanRun("set", ""))
+	assert.Equal("+@all", u.CommandRules())
+
+	assert.NoError(a.SetUser("alice", []string{"reset"}))
+	assert.Equal("user alice off resetchannels -@all", u.Describe())
+}
+
+func Test_DelUser(t *testing.T) {
+	assert := assert.New(t)
+	a := New(testCommands)
+	a.SetUser("bob", []string{"on", "nopass"})
+	u := a.User("bob")
+
+	_, err := a.DelUser(DefaultUser)
+	assert.Error(err)
+
+	deleted, err := a.DelUser("bob")
+	assert.NoError(err)
+	assert.True(deleted)
+	assert.True(u.Removed())
+	assert.Nil(a.User("bob"))
+
+	deleted, _ = a.DelUser("bob")
+	assert.False(deleted)
+}
+
+func Test_Save_And_Load(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "users.acl")
+
+	a := New(testCommands)
+	a.SetUser("alice", []string{"on", ">pw", "~*", "+get"})
+	a.SetUser("bob", []string{"on", "nopass"})
+	assert.NoError(a.Save(path))
+
+	b := New(testCommands)
+	b.SetUser("carol", []string{"on"})
+	carol := b.User("carol")
+	assert.NoError(b.Load(path))
+
+	assert.True(carol.Removed())
+	alice, ok := b.Authenticate("alice", "pw")
+	assert.True(ok)
+	assert.Equal(a.User("alice").Describe(), alice.Describe())
+	assert.NotNil(b.User(DefaultUser))
+
+	// a broken file leaves the users alone
+	assert.NoError(os.WriteFile(path, []byte("user dave on\nuser eve +nosuchcommand\n"), 0600))
+	assert.Error(b.Load(path))
+	assert.Nil(b.User("dave"))
+	assert.NotNil(b.User("alice"))
+}
+
+func Test_Categories(t *testing.T) {
+	assert := assert.New(t)
+	a := New(testCommands)
+
+	names, ok := a.CategoryCommands("read")
+	assert.True(ok)
+	assert.Equal([]string{"get", "keys"}, names)
+
+	_, ok = a.CategoryCommands("nosuchcategory")
+	assert.False(ok)
+}
+
+func Test_Log(t *testing.T) {
+	assert := assert.New(t)
+	var l Log
+
+	l.Add("command", "toplevel", "get", "alice", "")
+	l.Add("command", "toplevel", "get", "alice", "")
+	l.Add("key", "toplevel", "foo", "alice", "")
+
+	entries := l.Entries(-1)
+	assert.Len(entries, 2)
+	assert.Equal("key", entries[0].Reason)
+	assert.Equal(2, entries[1].Count)
+	assert.Len(l.Entries(1), 1)
+
+	l.Reset()
+	assert.Empty(l.Entries(-1))
+}
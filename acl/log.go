@@ -0,0 +1,93 @@
+package acl
+
+import (
+	"sync"
+	"time"
+)
+
+// LogMaxLen is the number of entries kept in the log
+const LogMaxLen = 128
+
+// logGroupingWindow is how long a denial can be counted in an earlier
+// entry with the same reason, object and user instead of getting its own
+const logGroupingWindow = 60 * time.Second
+
+// LogEntry records a command that was denied
+type LogEntry struct {
+	ID int64
+	// number of denials grouped in this entry
+	Count int
+	// command, key, channel or auth
+	Reason string
+	// toplevel for commands run by clients
+	Context string
+	// the command, key or channel that was denied
+	Object     string
+	Username   string
+	ClientInfo string
+	Created    time.Time
+	Updated    time.Time
+}
+
+// Log keeps the most recent denials, newest first. It is safe to use from
+// several goroutines.
+type Log struct {
+	mu      sync.Mutex
+	entries []*LogEntry
+	nextID  int64
+}
+
+// Add records a denial
+func (l *Log) Add(reason, context, object, username, clientInfo string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for _, e := range l.entries {
+		if e.Reason == reason && e.Context == context && e.Object == object && e.Username == username &&
+			now.Sub(e.Updated) < logGroupingWindow {
+			e.Count++
+			e.Updated = now
+			e.ClientInfo = clientInfo
+			return
+		}
+	}
+
+	e := &LogEntry{
+		ID:         l.nextID,
+		Count:      1,
+		Reason:     reason,
+		Context:    context,
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		Created:    now,
+		Updated:    now,
+	}
+	l.nextID++
+
+	l.entries = append([]*LogEntry{e}, l.entries...)
+	if len(l.entries) > LogMaxLen {
+		l.entries = l.entries[:LogMaxLen]
+	}
+}
+
+// Entries returns up to count entries, newest first. A negative count
+// returns all of them.
+func (l *Log) Entries(count int) []*LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	return l.entries[:count]
+}
+
+// Reset clears the log
+func (l *Log) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+}
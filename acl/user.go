@@ -0,0 +1,452 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"noelzubin/redis-go/glob"
+	"sort"
+	"strings"
+)
+
+// permission a key pattern grants
+type permission uint8
+
+const (
+	permRead permission = 1 << iota
+	permWrite
+	permReadWrite = permRead | permWrite
+)
+
+// pattern is a key or channel glob with the access it grants
+type pattern struct {
+	glob string
+	perm permission
+}
+
+// User is a set of credentials and the permissions that come with them.
+// Connections authenticated as a user keep pointing to it, so changes to
+// it apply to them immediately.
+type User struct {
+	name    string
+	enabled bool
+	nopass  bool
+	// SHA-256 hashes of the passwords, hex encoded
+	passwords map[string]struct{}
+
+	// allowed commands, keyed by name or by name|subcommand. An entry for
+	// a subcommand overrides the one for its command.
+	commands map[string]bool
+	// the command rules as given, for describing the user
+	commandRules []string
+
+	keys     []pattern
+	channels []pattern
+
+	removed bool
+}
+
+func newUser(name string) *User {
+	return &User{
+		name:         name,
+		passwords:    make(map[string]struct{}),
+		commands:     make(map[string]bool),
+		commandRules: make([]string, 0),
+	}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = make(map[string]struct{}, len(u.passwords))
+	for h := range u.passwords {
+		c.passwords[h] = struct{}{}
+	}
+	c.commands = make(map[string]bool, len(u.commands))
+	for k, v := range u.commands {
+		c.commands[k] = v
+	}
+	c.commandRules = append([]string{}, u.commandRules...)
+	c.keys = append([]pattern{}, u.keys...)
+	c.channels = append([]pattern{}, u.channels...)
+	return &c
+}
+
+// Name returns the username
+func (u *User) Name() string {
+	return u.name
+}
+
+// Enabled reports whether the user can authenticate
+func (u *User) Enabled() bool {
+	return u.enabled
+}
+
+// NoPass reports whether any password is accepted for the user
+func (u *User) NoPass() bool {
+	return u.nopass
+}
+
+// Removed reports whether the user has been deleted since a connection
+// authenticated as it
+func (u *User) Removed() bool {
+	return u.removed
+}
+
+// Flags returns the on/off and nopass flags
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// Passwords returns the hashes of the user's passwords, sorted
+func (u *User) Passwords() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for h := range u.passwords {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// CommandRules describes the commands the user can run
+func (u *User) CommandRules() string {
+	if len(u.commandRules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.commandRules, " ")
+}
+
+// KeyRules describes the keys the user can access
+func (u *User) KeyRules() string {
+	rules := make([]string, 0, len(u.keys))
+	for _, p := range u.keys {
+		switch p.perm {
+		case permRead:
+			rules = append(rules, "%R~"+p.glob)
+		case permWrite:
+			rules = append(rules, "%W~"+p.glob)
+		default:
+			rules = append(rules, "~"+p.glob)
+		}
+	}
+	return strings.Join(rules, " ")
+}
+
+// ChannelRules describes the pub/sub channels the user can access
+func (u *User) ChannelRules() string {
+	rules := make([]string, 0, len(u.channels))
+	for _, p := range u.channels {
+		rules = append(rules, "&"+p.glob)
+	}
+	return strings.Join(rules, " ")
+}
+
+// Describe returns the rules that recreate the user, as listed by ACL LIST
+// and saved to the ACL file
+func (u *User) Describe() string {
+	parts := append([]string{"user", u.name}, u.Flags()...)
+	for _, h := range u.Passwords() {
+		parts = append(parts, "#"+h)
+	}
+	if keys := u.KeyRules(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if channels := u.ChannelRules(); channels != "" {
+		parts = append(parts, channels)
+	} else {
+		parts = append(parts, "resetchannels")
+	}
+	parts = append(parts, u.CommandRules())
+	return strings.Join(parts, " ")
+}
+
+// CheckPassword reports whether password is one of the user's passwords
+func (u *User) CheckPassword(password string) bool {
+	if u.nopass {
+		return true
+	}
+
+	hash := HashPassword(password)
+	ok := false
+	for h := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// CanRun reports whether the user may run a command. sub is the first
+// argument, checked against rules for subcommands.
+func (u *User) CanRun(name, sub string) bool {
+	name = strings.ToLower(name)
+	if sub != "" {
+		if allowed, ok := u.commands[name+"|"+strings.ToLower(sub)]; ok {
+			return allowed
+		}
+	}
+	return u.commands[name]
+}
+
+// CanAccessKey reports whether the user may read, or write if write is
+// set, key
+func (u *User) CanAccessKey(key string, write bool) bool {
+	need := permRead
+	if write {
+		need = permWrite
+	}
+
+	for _, p := range u.keys {
+		if p.perm&need != 0 && glob.Match(p.glob, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessChannel reports whether the user may access channel. A pattern
+// subscription is only allowed if it is one of the user's patterns
+// verbatim.
+func (u *User) CanAccessChannel(channel string, isPattern bool) bool {
+	for _, p := range u.channels {
+		if p.glob == "*" {
+			return true
+		}
+		if isPattern && p.glob == channel {
+			return true
+		}
+		if !isPattern && glob.Match(p.glob, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashPassword returns the hash a password is stored as
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	errSyntax          = errors.New("Syntax error")
+	errUnknownCommand  = errors.New("Unknown command or category name in ACL")
+	errBadHash         = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errNoSuchPassword  = errors.New("no such password")
+	errNotAContainer   = errors.New("The command has no subcommands")
+	errUnknownSubcmd   = errors.New("Unknown subcommand")
+	errBadKeyPattern   = errors.New("Syntax error in key pattern, expected %R~, %W~ or %RW~")
+	errAllKeysConflict = errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+	errAllChanConflict = errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. Try 'resetchannels' to start with an empty list of channels")
+)
+
+// apply changes the user according to a single rule
+func (u *User) apply(a *ACL, rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "allkeys":
+		u.keys = []pattern{{glob: "*", perm: permReadWrite}}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		u.channels = []pattern{{glob: "*", perm: permReadWrite}}
+		return nil
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "allcommands":
+		return u.apply(a, "+@all")
+	case "nocommands":
+		return u.apply(a, "-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			u.apply(a, r)
+		}
+		return nil
+	}
+
+	if rule == "" {
+		return errSyntax
+	}
+
+	switch rule[0] {
+	case '>':
+		u.passwords[HashPassword(rule[1:])] = struct{}{}
+		u.nopass = false
+		return nil
+	case '<':
+		hash := HashPassword(rule[1:])
+		if _, ok := u.passwords[hash]; !ok {
+			return errNoSuchPassword
+		}
+		delete(u.passwords, hash)
+		return nil
+	case '#':
+		if !validHash(rule[1:]) {
+			return errBadHash
+		}
+		u.passwords[rule[1:]] = struct{}{}
+		u.nopass = false
+		return nil
+	case '!':
+		if !validHash(rule[1:]) {
+			return errBadHash
+		}
+		if _, ok := u.passwords[rule[1:]]; !ok {
+			return errNoSuchPassword
+		}
+		delete(u.passwords, rule[1:])
+		return nil
+	case '~', '%':
+		return u.addKeyPattern(rule)
+	case '&':
+		return u.addChannelPattern(rule[1:])
+	case '+', '-':
+		return u.applyCommandRule(a, rule)
+	}
+
+	return errSyntax
+}
+
+func validHash(h string) bool {
+	if len(h) != 64 {
+		return false
+	}
+	for i := 0; i < len(h); i++ {
+		if !(h[i] >= '0' && h[i] <= '9' || h[i] >= 'a' && h[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *User) addKeyPattern(rule string) error {
+	perm := permReadWrite
+	if rule[0] == '%' {
+		flags, glob, ok := strings.Cut(rule[1:], "~")
+		if !ok || flags == "" {
+			return errBadKeyPattern
+		}
+		perm = 0
+		for _, f := range strings.ToUpper(flags) {
+			switch f {
+			case 'R':
+				perm |= permRead
+			case 'W':
+				perm |= permWrite
+			default:
+				return errBadKeyPattern
+			}
+		}
+		rule = "~" + glob
+	}
+
+	if len(u.keys) == 1 && u.keys[0].glob == "*" && u.keys[0].perm == permReadWrite && rule != "~*" {
+		return errAllKeysConflict
+	}
+	if rule == "~*" && perm == permReadWrite {
+		u.keys = nil
+	}
+
+	u.keys = append(u.keys, pattern{glob: rule[1:], perm: perm})
+	return nil
+}
+
+func (u *User) addChannelPattern(glob string) error {
+	if len(u.channels) == 1 && u.channels[0].glob == "*" && glob != "*" {
+		return errAllChanConflict
+	}
+	if glob == "*" {
+		u.channels = nil
+	}
+
+	u.channels = append(u.channels, pattern{glob: glob, perm: permReadWrite})
+	return nil
+}
+
+// applyCommandRule applies +<command>, -<command>, +<command>|<subcommand>
+// and the @<category> forms of those
+func (u *User) applyCommandRule(a *ACL, rule string) error {
+	allow := rule[0] == '+'
+	target := strings.ToLower(rule[1:])
+
+	if strings.HasPrefix(target, "@") {
+		commands, ok := a.categoryCommands(target[1:])
+		if !ok {
+			return errUnknownCommand
+		}
+		for _, name := range commands {
+			u.setCommand(name, allow)
+		}
+
+		if target == "@all" {
+			u.commandRules = []string{rule[:1] + "@all"}
+			return nil
+		}
+		u.commandRules = append(u.commandRules, rule[:1]+target)
+		return nil
+	}
+
+	name, sub, isSub := strings.Cut(target, "|")
+	cmd, ok := a.commands[name]
+	if !ok {
+		return errUnknownCommand
+	}
+
+	if isSub {
+		if len(cmd.Subcommands) == 0 {
+			return errNotAContainer
+		}
+		if !contains(cmd.Subcommands, sub) {
+			return errUnknownSubcmd
+		}
+		u.commands[target] = allow
+	} else {
+		u.setCommand(name, allow)
+	}
+
+	u.commandRules = append(u.commandRules, rule[:1]+target)
+	return nil
+}
+
+// setCommand allows or denies a whole command, dropping any rules for its
+// subcommands
+func (u *User) setCommand(name string, allow bool) {
+	for k := range u.commands {
+		if strings.HasPrefix(k, name+"|") {
+			delete(u.commands, k)
+		}
+	}
+	u.commands[name] = allow
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
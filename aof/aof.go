@@ -0,0 +1,387 @@
+// Package aof implements an append only file. Every write command is logged
+// in RESP form so the dataset can be rebuilt by replaying the file on startup.
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"noelzubin/redis-go/protocol"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy decides how often the file is flushed to disk
+type FsyncPolicy int
+
+const (
+	// FsyncAlways fsyncs after every write command, before the reply is sent
+	FsyncAlways FsyncPolicy = iota
+	// FsyncEverySec fsyncs once a second in the background
+	FsyncEverySec
+	// FsyncNo leaves flushing to the operating system
+	FsyncNo
+)
+
+// ErrRewriteInProgress is returned when a rewrite is started while another
+// one is still running
+var ErrRewriteInProgress = errors.New("background append only file rewriting already in progress")
+
+// ParseFsyncPolicy parses the value of the appendfsync option
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNo, nil
+	}
+	return FsyncNo, fmt.Errorf("invalid appendfsync policy '%s'", s)
+}
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncEverySec:
+		return "everysec"
+	}
+	return "no"
+}
+
+// AOF is an open append only file.
+//
+// Append, StartRewrite and FinishRewrite must be called from a single
+// goroutine (the event loop). Sync may be called from anywhere.
+type AOF struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	policy     FsyncPolicy
+	dirty      bool
+	rewriting  bool
+	rewriteBuf bytes.Buffer
+	done       chan struct{}
+	// called with the time each fsync took
+	onSync func(d time.Duration, background bool)
+}
+
+// Open opens the file at path for appending, creating it if needed
+func Open(path string, policy FsyncPolicy) (*AOF, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &AOF{
+		path:   path,
+		file:   f,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+
+	if policy == FsyncEverySec {
+		go a.fsyncLoop()
+	}
+
+	return a, nil
+}
+
+// Path returns the location of the file
+func (a *AOF) Path() string {
+	return a.path
+}
+
+// Policy returns the fsync policy in use
+func (a *AOF) Policy() FsyncPolicy {
+	return a.policy
+}
+
+// OnSync sets a function called after each fsync with the time it took and
+// whether it ran in the background, in which case it is called from another
+// goroutine
+func (a *AOF) OnSync(fn func(d time.Duration, background bool)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onSync = fn
+}
+
+// sync flushes the file to disk, a.mu must be held
+func (a *AOF) sync(background bool) error {
+	start := time.Now()
+	err := a.file.Sync()
+	if a.onSync != nil {
+		a.onSync(time.Since(start), background)
+	}
+	return err
+}
+
+// Append logs a command. With FsyncAlways the data is on disk when Append
+// returns.
+func (a *AOF) Append(args []string) error {
+	return a.Write(Encode(args))
+}
+
+// Write logs commands that are already in RESP form
+func (a *AOF) Write(data []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rewriting {
+		a.rewriteBuf.Write(data)
+	}
+
+	if _, err := a.file.Write(data); err != nil {
+		return err
+	}
+
+	if a.policy == FsyncAlways {
+		return a.sync(false)
+	}
+
+	a.dirty = true
+	return nil
+}
+
+// Sync flushes any writes not yet on disk
+func (a *AOF) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.dirty {
+		return nil
+	}
+
+	a.dirty = false
+	return a.sync(true)
+}
+
+func (a *AOF) fsyncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			if err := a.Sync(); err != nil {
+				fmt.Println("error syncing append only file: ", err.Error())
+			}
+		}
+	}
+}
+
+// Close flushes and closes the file
+func (a *AOF) Close() error {
+	close(a.done)
+
+	if err := a.Sync(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// Rewriting reports whether a rewrite is in progress
+func (a *AOF) Rewriting() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rewriting
+}
+
+// StartRewrite begins buffering appended commands so they can be added to
+// the end of the rewritten file once it has been written
+func (a *AOF) StartRewrite() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rewriting {
+		return ErrRewriteInProgress
+	}
+
+	a.rewriting = true
+	a.rewriteBuf.Reset()
+	return nil
+}
+
+// WriteRewrite writes a new file next to the current one using write and
+// returns its path. It does not touch the live file and is safe to call from
+// a background goroutine while commands are still being appended.
+func (a *AOF) WriteRewrite(write func(w io.Writer) error) (string, error) {
+	tmp := filepath.Join(filepath.Dir(a.path), fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return tmp, f.Close()
+}
+
+// FinishRewrite completes a rewrite started with StartRewrite. The commands
+// buffered in the meantime are appended to the file at tmp, which then
+// atomically replaces the live file. If rewriteErr is set the rewrite is
+// abandoned and the live file is left untouched.
+func (a *AOF) FinishRewrite(tmp string, rewriteErr error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rewriting = false
+	buffered := a.rewriteBuf.Bytes()
+	defer a.rewriteBuf.Reset()
+
+	if rewriteErr != nil {
+		if tmp != "" {
+			os.Remove(tmp)
+		}
+		return rewriteErr
+	}
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if _, err := f.Write(buffered); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, a.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	a.file.Close()
+	a.file = f
+	a.dirty = false
+	return nil
+}
+
+// Encode returns the RESP form of a command as it is stored in the file
+func Encode(args []string) []byte {
+	return AppendCommand(nil, args)
+}
+
+// AppendCommand appends the RESP form of a command, an array of bulk
+// strings, to dst
+func AppendCommand(dst []byte, args []string) []byte {
+	dst = append(dst, '*')
+	dst = strconv.AppendInt(dst, int64(len(args)), 10)
+	dst = append(dst, "\r\n"...)
+	for _, arg := range args {
+		dst = append(dst, '$')
+		dst = strconv.AppendInt(dst, int64(len(arg)), 10)
+		dst = append(dst, "\r\n"...)
+		dst = append(dst, arg...)
+		dst = append(dst, "\r\n"...)
+	}
+	return dst
+}
+
+// WriteCommand writes a single command to w
+func WriteCommand(w io.Writer, args []string) error {
+	_, err := w.Write(Encode(args))
+	return err
+}
+
+// countingReader keeps track of how many bytes were read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Load replays every command stored in the file at path through apply and
+// returns the number of commands read. A missing file is treated as empty.
+//
+// If the last command was cut short, as happens when the server crashes in
+// the middle of a write, the file is truncated to the last complete command
+// and loading succeeds. Corruption anywhere else is returned as an error.
+func Load(path string, apply func(args []string)) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	cr := &countingReader{r: f}
+	reader := bufio.NewReader(cr)
+	count := 0
+	var valid int64
+
+	for {
+		if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+			return count, nil
+		}
+
+		value, err := protocol.DecodeRESP(reader)
+		if err != nil {
+			if _, perr := reader.Peek(1); errors.Is(perr, io.EOF) {
+				fmt.Printf("!!! Warning: short read while loading the AOF file %s, truncating to %d bytes\n", path, valid)
+				return count, os.Truncate(path, valid)
+			}
+			return count, fmt.Errorf("bad file format reading the append only file at offset %d: %w", valid, err)
+		}
+
+		items := value.Array()
+		if len(items) == 0 {
+			return count, fmt.Errorf("bad file format reading the append only file at offset %d", valid)
+		}
+
+		args := make([]string, 0, len(items))
+		for _, v := range items {
+			args = append(args, v.String())
+		}
+
+		apply(args)
+		count++
+		valid = cr.n - int64(reader.Buffered())
+	}
+}
@@ -0,0 +1,127 @@
+package aof
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadAll(path string) ([][]string, error) {
+	cmds := make([][]string, 0)
+	_, err := Load(path, func(args []string) {
+		cmds = append(cmds, args)
+	})
+	return cmds, err
+}
+
+func Test_ParseFsyncPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := ParseFsyncPolicy("EverySec")
+	assert.Nil(err)
+	assert.Equal(FsyncEverySec, p)
+
+	_, err = ParseFsyncPolicy("sometimes")
+	assert.NotNil(err)
+}
+
+func Test_Append_And_Load(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	a, err := Open(path, FsyncAlways)
+	assert.Nil(err)
+	assert.Nil(a.Append([]string{"SET", "foo", "bar"}))
+	assert.Nil(a.Append([]string{"SET", "multi\r\nline", ""}))
+	assert.Nil(a.Close())
+
+	cmds, err := loadAll(path)
+	assert.Nil(err)
+	assert.Equal([][]string{{"SET", "foo", "bar"}, {"SET", "multi\r\nline", ""}}, cmds)
+}
+
+func Test_Load_Missing_File(t *testing.T) {
+	assert := assert.New(t)
+
+	cmds, err := loadAll(filepath.Join(t.TempDir(), "missing.aof"))
+	assert.Nil(err)
+	assert.Empty(cmds)
+}
+
+func Test_Load_Truncated_Tail(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	complete := "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
+	os.WriteFile(path, []byte(complete+"*3\r\n$3\r\nSET\r\n$3\r\nba"), 0644)
+
+	cmds, err := loadAll(path)
+	assert.Nil(err)
+	assert.Equal([][]string{{"SET", "foo", "bar"}}, cmds)
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(complete, string(data))
+}
+
+func Test_Load_Corrupted(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	os.WriteFile(path, []byte("*1\r\n$4\r\nPING\r\n!garbage\r\n*1\r\n$4\r\nPING\r\n"), 0644)
+
+	_, err := loadAll(path)
+	assert.NotNil(err)
+}
+
+func Test_Rewrite_Keeps_Writes_During_Rewrite(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	a, err := Open(path, FsyncNo)
+	assert.Nil(err)
+	a.Append([]string{"SET", "foo", "1"})
+	a.Append([]string{"SET", "foo", "2"})
+
+	assert.Nil(a.StartRewrite())
+	assert.Equal(ErrRewriteInProgress, a.StartRewrite())
+
+	tmp, err := a.WriteRewrite(func(w io.Writer) error {
+		return WriteCommand(w, []string{"SET", "foo", "2"})
+	})
+	assert.Nil(err)
+
+	// written after the snapshot was taken
+	a.Append([]string{"SET", "bar", "3"})
+
+	assert.Nil(a.FinishRewrite(tmp, nil))
+	assert.False(a.Rewriting())
+
+	a.Append([]string{"SET", "baz", "4"})
+	assert.Nil(a.Close())
+
+	cmds, err := loadAll(path)
+	assert.Nil(err)
+	assert.Equal([][]string{{"SET", "foo", "2"}, {"SET", "bar", "3"}, {"SET", "baz", "4"}}, cmds)
+
+	_, err = os.Stat(tmp)
+	assert.True(os.IsNotExist(err))
+}
+
+func Test_OnSync(t *testing.T) {
+	assert := assert.New(t)
+	a, err := Open(filepath.Join(t.TempDir(), "appendonly.aof"), FsyncAlways)
+	assert.Nil(err)
+	defer a.Close()
+
+	syncs := 0
+	a.OnSync(func(d time.Duration, background bool) {
+		assert.False(background)
+		syncs++
+	})
+	assert.Nil(a.Append([]string{"SET", "foo", "bar"}))
+	assert.Equal(1, syncs)
+}
@@ -2,20 +2,41 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 
 	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/tlsconfig"
+)
+
+var (
+	useTLS  = flag.Bool("tls", false, "connect over TLS")
+	caCert  = flag.String("cacert", "", "CA certificate to verify the server with, instead of the system roots")
+	cert    = flag.String("cert", "", "client certificate to present to the server")
+	certKey = flag.String("key", "", "private key of the client certificate")
+	socket  = flag.String("socket", "", "connect to a Unix socket instead of a host")
+
+	scanMode    = flag.Bool("scan", false, "list the keys with SCAN, one per line, and exit")
+	scanPattern = flag.String("pattern", "", "with -scan, only list keys matching this glob")
+	scanCount   = flag.Int("count", 0, "with -scan, the COUNT hint of each SCAN call")
+	scanType    = flag.String("type", "", "with -scan, only list keys of this type")
 )
 
 func main() {
+	flag.Parse()
+
 	// Connect to Redis
 	host := getHostName()
-	conn, err := net.Dial("tcp", host)
+	conn, err := dial(host)
 
 	if err != nil {
 		fmt.Println("Error connecting to Redis:", err)
@@ -36,6 +57,14 @@ func main() {
 		return
 	}
 
+	if *scanMode {
+		if err := scanKeys(conn); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Handling Ctrl-C gracefully
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -56,6 +85,10 @@ func main() {
 		// Read user input
 		input, err := reader.ReadString('\n')
 
+		if errors.Is(err, io.EOF) {
+			fmt.Println()
+			return
+		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			continue
@@ -85,10 +118,73 @@ func encodeString(s string) []byte {
 	return sa.Encode()
 }
 
+// scanKeys prints every key of the database, iterating it with SCAN
+func scanKeys(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	cursor := "0"
+	for {
+		args := []string{"SCAN", cursor}
+		if *scanPattern != "" {
+			args = append(args, "MATCH", *scanPattern)
+		}
+		if *scanCount > 0 {
+			args = append(args, "COUNT", strconv.Itoa(*scanCount))
+		}
+		if *scanType != "" {
+			args = append(args, "TYPE", *scanType)
+		}
+		cmd := protocol.NewArrayBulkStringValue(args)
+		if _, err := conn.Write(cmd.Encode()); err != nil {
+			return err
+		}
+
+		resp, err := protocol.DecodeRESP(reader)
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			return errors.New(resp.String())
+		}
+		reply := resp.Array()
+		if len(reply) != 2 {
+			return fmt.Errorf("unexpected SCAN reply: %s", resp.Output())
+		}
+
+		for _, key := range reply[1].Array() {
+			fmt.Println(key.String())
+		}
+		cursor = reply[0].String()
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
 func getHostName() string {
-	if len(os.Args) > 1 {
-		return os.Args[1]
+	if flag.NArg() > 0 {
+		return flag.Arg(0)
 	}
 
 	return "localhost:6379"
 }
+
+func dial(host string) (net.Conn, error) {
+	if *socket != "" {
+		return net.Dial("unix", *socket)
+	}
+
+	if !*useTLS {
+		return net.Dial("tcp", host)
+	}
+
+	serverName, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := tlsconfig.Client(serverName, *caCert, *cert, *certKey)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", host, config)
+}
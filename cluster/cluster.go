@@ -0,0 +1,304 @@
+// Package cluster keeps track of which node serves which of the 16384 hash
+// slots the keyspace is split into.
+//
+// Slot ownership comes from a static config file shared by all the nodes.
+// Each line names a node, its address and the slots it serves:
+//
+//	# id      address         slots
+//	node-a    127.0.0.1:7000  0-5460
+//	node-b    127.0.0.1:7001  5461-10922
+//	node-c    127.0.0.1:7002  10923-16383
+//
+// A node finds itself in the file by its port. Ownership changes made with
+// CLUSTER SETSLOT are written back to the file.
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SlotCount is the number of hash slots
+const SlotCount = 16384
+
+// Node is a member of the cluster
+type Node struct {
+	ID   string
+	Host string
+	Port int
+}
+
+// Addr returns the host:port the node listens on
+func (n *Node) Addr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// SlotRange is an inclusive range of slots
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// Cluster is this node's view of the cluster
+type Cluster struct {
+	path   string
+	myself *Node
+	nodes  []*Node
+	byID   map[string]*Node
+	slots  [SlotCount]*Node
+
+	// slots being moved out of or into this node
+	migrating map[int]*Node
+	importing map[int]*Node
+}
+
+// Load reads the config file at path. port is the port this node listens on
+// and identifies it in the file.
+func Load(path string, port int) (*Cluster, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Cluster{
+		path:      path,
+		byID:      make(map[string]*Node),
+		migrating: make(map[int]*Node),
+		importing: make(map[int]*Node),
+	}
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if err := c.parseNode(fields); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, n := range c.nodes {
+		if n.Port != port {
+			continue
+		}
+		if c.myself != nil {
+			return nil, fmt.Errorf("%s: more than one node listens on port %d", path, port)
+		}
+		c.myself = n
+	}
+
+	if c.myself == nil {
+		return nil, fmt.Errorf("%s: no node listens on port %d", path, port)
+	}
+
+	return c, nil
+}
+
+func (c *Cluster) parseNode(fields []string) error {
+	if len(fields) < 2 {
+		return errors.New("expected '<id> <host>:<port> [slots ...]'")
+	}
+
+	if _, ok := c.byID[fields[0]]; ok {
+		return fmt.Errorf("duplicate node id %s", fields[0])
+	}
+
+	// the cluster bus port of the Redis nodes.conf format is accepted and
+	// ignored
+	addr, _, _ := strings.Cut(fields[1], "@")
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", portStr)
+	}
+
+	n := &Node{ID: fields[0], Host: host, Port: port}
+	c.nodes = append(c.nodes, n)
+	c.byID[n.ID] = n
+
+	for _, f := range fields[2:] {
+		r, err := parseSlotRange(f)
+		if err != nil {
+			return err
+		}
+		for slot := r.Start; slot <= r.End; slot++ {
+			if owner := c.slots[slot]; owner != nil {
+				return fmt.Errorf("slot %d is assigned to both %s and %s", slot, owner.ID, n.ID)
+			}
+			c.slots[slot] = n
+		}
+	}
+
+	return nil
+}
+
+func parseSlotRange(s string) (SlotRange, error) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	if !isRange {
+		endStr = startStr
+	}
+
+	start, err := ParseSlot(startStr)
+	if err != nil {
+		return SlotRange{}, err
+	}
+	end, err := ParseSlot(endStr)
+	if err != nil {
+		return SlotRange{}, err
+	}
+	if start > end {
+		return SlotRange{}, fmt.Errorf("invalid slot range %s", s)
+	}
+
+	return SlotRange{Start: start, End: end}, nil
+}
+
+// ParseSlot parses a slot number
+func ParseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, fmt.Errorf("invalid slot %s", s)
+	}
+	return slot, nil
+}
+
+// Myself returns this node
+func (c *Cluster) Myself() *Node {
+	return c.myself
+}
+
+// Nodes returns every node in the order they appear in the config
+func (c *Cluster) Nodes() []*Node {
+	return c.nodes
+}
+
+// Node looks a node up by ID
+func (c *Cluster) Node(id string) *Node {
+	return c.byID[id]
+}
+
+// Owner returns the node serving slot, or nil if it is unassigned
+func (c *Cluster) Owner(slot int) *Node {
+	return c.slots[slot]
+}
+
+// Migrating returns the node slot is being moved to, if any
+func (c *Cluster) Migrating(slot int) *Node {
+	return c.migrating[slot]
+}
+
+// Importing returns the node slot is being moved from, if any
+func (c *Cluster) Importing(slot int) *Node {
+	return c.importing[slot]
+}
+
+// MigratingSlots returns the slots being moved out of this node
+func (c *Cluster) MigratingSlots() map[int]*Node {
+	return c.migrating
+}
+
+// ImportingSlots returns the slots being moved into this node
+func (c *Cluster) ImportingSlots() map[int]*Node {
+	return c.importing
+}
+
+// SetMigrating marks slot as being moved from this node to n
+func (c *Cluster) SetMigrating(slot int, n *Node) {
+	c.migrating[slot] = n
+}
+
+// SetImporting marks slot as being moved from n to this node
+func (c *Cluster) SetImporting(slot int, n *Node) {
+	c.importing[slot] = n
+}
+
+// SetStable clears any migration of slot
+func (c *Cluster) SetStable(slot int) {
+	delete(c.migrating, slot)
+	delete(c.importing, slot)
+}
+
+// Assign makes n the owner of slot. A migration out of this node ends once
+// the slot is assigned away, and an import ends once it is assigned here.
+func (c *Cluster) Assign(slot int, n *Node) {
+	if n != c.myself {
+		delete(c.migrating, slot)
+	}
+	if n == c.myself {
+		delete(c.importing, slot)
+	}
+	c.slots[slot] = n
+}
+
+// SlotRanges returns the slots served by n as contiguous ranges
+func (c *Cluster) SlotRanges(n *Node) []SlotRange {
+	ranges := make([]SlotRange, 0)
+	start := -1
+
+	for slot := 0; slot <= SlotCount; slot++ {
+		if slot < SlotCount && c.slots[slot] == n {
+			if start == -1 {
+				start = slot
+			}
+			continue
+		}
+		if start != -1 {
+			ranges = append(ranges, SlotRange{Start: start, End: slot - 1})
+			start = -1
+		}
+	}
+
+	return ranges
+}
+
+// AssignedSlots returns the number of slots that have an owner
+func (c *Cluster) AssignedSlots() int {
+	count := 0
+	for _, n := range c.slots {
+		if n != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Save writes the current slot ownership back to the config file
+func (c *Cluster) Save() error {
+	var b strings.Builder
+	b.WriteString("# id address slots\n")
+
+	for _, n := range c.nodes {
+		b.WriteString(n.ID + " " + n.Addr())
+		for _, r := range c.SlotRanges(n) {
+			if r.Start == r.End {
+				fmt.Fprintf(&b, " %d", r.Start)
+			} else {
+				fmt.Fprintf(&b, " %d-%d", r.Start, r.End)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	tmp := filepath.Join(filepath.Dir(c.path), fmt.Sprintf("temp-%d-%s", os.Getpid(), filepath.Base(c.path)))
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
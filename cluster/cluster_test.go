@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `# id address slots
+node-a 127.0.0.1:7000 0-5460
+node-b 127.0.0.1:7001@17001 5461-10921 10922
+node-c 127.0.0.1:7002 10923-16383
+`
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "nodes.conf")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_CRC16(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(uint16(0x31c3), CRC16("123456789"))
+}
+
+func Test_KeySlot(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(12182, KeySlot("foo"))
+	assert.Equal(5061, KeySlot("bar"))
+	assert.Equal(KeySlot("{user1000}.following"), KeySlot("{user1000}.followers"))
+	assert.Equal(KeySlot("bar"), KeySlot("foo{bar}{zap}"))
+	assert.Equal(KeySlot("{bar"), KeySlot("foo{{bar}}zap"))
+	// empty or unterminated hashtags hash the whole key
+	assert.NotEqual(KeySlot(""), KeySlot("foo{}{bar}"))
+	assert.Equal(int(CRC16("foo{}{bar}"))&(SlotCount-1), KeySlot("foo{}{bar}"))
+	assert.Equal(int(CRC16("foo{bar"))&(SlotCount-1), KeySlot("foo{bar"))
+}
+
+func Test_Load(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := Load(writeConfig(t, testConfig), 7001)
+	assert.Nil(err)
+	assert.Equal("node-b", c.Myself().ID)
+	assert.Equal(3, len(c.Nodes()))
+	assert.Equal("node-a", c.Owner(0).ID)
+	assert.Equal("node-b", c.Owner(10922).ID)
+	assert.Equal(SlotCount, c.AssignedSlots())
+	assert.Equal([]SlotRange{{Start: 5461, End: 10922}}, c.SlotRanges(c.Myself()))
+}
+
+func Test_Load_Errors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Load(writeConfig(t, testConfig), 7005)
+	assert.NotNil(err)
+
+	_, err = Load(writeConfig(t, "a 127.0.0.1:7000 0-10\nb 127.0.0.1:7001 10-20\n"), 7000)
+	assert.NotNil(err)
+
+	_, err = Load(writeConfig(t, "a 127.0.0.1:7000 0-16384\n"), 7000)
+	assert.NotNil(err)
+}
+
+func Test_Assign_And_Save(t *testing.T) {
+	assert := assert.New(t)
+	path := writeConfig(t, testConfig)
+
+	c, _ := Load(path, 7000)
+	c.SetMigrating(100, c.Node("node-c"))
+	c.Assign(100, c.Node("node-c"))
+	assert.Nil(c.Migrating(100))
+	assert.Nil(c.Save())
+
+	reloaded, err := Load(path, 7002)
+	assert.Nil(err)
+	assert.Equal("node-c", reloaded.Owner(100).ID)
+	assert.Equal([]SlotRange{{Start: 0, End: 99}, {Start: 101, End: 5460}}, reloaded.SlotRanges(reloaded.Node("node-a")))
+}
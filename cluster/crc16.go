@@ -0,0 +1,53 @@
+package cluster
+
+// crc16Table is the lookup table for CRC16-CCITT (XMODEM), the checksum used
+// to map keys to hash slots
+var crc16Table = makeCRC16Table(0x1021)
+
+func makeCRC16Table(poly uint16) *[256]uint16 {
+	t := new([256]uint16)
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// CRC16 returns the CRC16-CCITT (XMODEM) checksum of s
+func CRC16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// KeySlot returns the hash slot of key. If the key contains a non empty
+// {hashtag} only the hashtag is hashed, so related keys can be kept in the
+// same slot.
+func KeySlot(key string) int {
+	for start := 0; start < len(key); start++ {
+		if key[start] != '{' {
+			continue
+		}
+
+		for end := start + 1; end < len(key); end++ {
+			if key[end] == '}' {
+				if end > start+1 {
+					key = key[start+1 : end]
+				}
+				return int(CRC16(key)) & (SlotCount - 1)
+			}
+		}
+		break
+	}
+
+	return int(CRC16(key)) & (SlotCount - 1)
+}
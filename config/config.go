@@ -0,0 +1,488 @@
+// Package config reads the server configuration from a redis.conf style
+// file and the command line, and backs CONFIG GET, SET and REWRITE.
+//
+// Each line of the file is a directive followed by its arguments, which
+// can be quoted:
+//
+//	# comments start with a hash
+//	port 7000
+//	bind 127.0.0.1 ::1
+//	requirepass "a password with spaces"
+//
+// The same directives can be given on the command line, after the optional
+// path of the file: --port 7000 --bind "127.0.0.1 ::1".
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"noelzubin/redis-go/glob"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrImmutable is returned when a param that can only be set at startup is
+// set at runtime
+var ErrImmutable = errors.New("can't set immutable config")
+
+// Param is a configuration parameter
+type Param struct {
+	name    string
+	mutable bool
+	// multi is set for params whose value is a list of arguments, written
+	// back to the file unquoted
+	multi bool
+	get   func() string
+	set   func(value string) error
+	// the value at registration, params still at their default are left
+	// out of a rewritten file
+	def string
+}
+
+// NewParam creates a param read and written with get and set. set is
+// expected to validate the value and leave the current one in place if it
+// is invalid.
+func NewParam(name string, get func() string, set func(value string) error) *Param {
+	return &Param{name: strings.ToLower(name), get: get, set: set}
+}
+
+// Mutable lets the param be changed with CONFIG SET
+func (p *Param) Mutable() *Param {
+	p.mutable = true
+	return p
+}
+
+// Multi marks the value as a list of arguments separated by spaces
+func (p *Param) Multi() *Param {
+	p.multi = true
+	return p
+}
+
+// String creates a param backed by v
+func String(name string, v *string) *Param {
+	return NewParam(name, func() string { return *v }, func(value string) error {
+		*v = value
+		return nil
+	})
+}
+
+// Int creates a param backed by v that only accepts values between min and
+// max
+func Int(name string, v *int, min, max int) *Param {
+	return NewParam(name, func() string { return strconv.Itoa(*v) }, func(value string) error {
+		n, err := ParseInt(value, min, max)
+		if err != nil {
+			return err
+		}
+		*v = n
+		return nil
+	})
+}
+
+// ParseInt parses the value of an integer param that must be between min
+// and max
+func ParseInt(value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+	}
+	return n, nil
+}
+
+// Bool creates a yes/no param backed by v
+func Bool(name string, v *bool) *Param {
+	return NewParam(name, func() string {
+		if *v {
+			return "yes"
+		}
+		return "no"
+	}, func(value string) error {
+		switch strings.ToLower(value) {
+		case "yes":
+			*v = true
+		case "no":
+			*v = false
+		default:
+			return errors.New("argument must be 'yes' or 'no'")
+		}
+		return nil
+	})
+}
+
+// Enum creates a param backed by v that only accepts one of values
+func Enum(name string, v *string, values ...string) *Param {
+	return NewParam(name, func() string { return *v }, func(value string) error {
+		for _, allowed := range values {
+			if strings.EqualFold(value, allowed) {
+				*v = allowed
+				return nil
+			}
+		}
+		return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(values, ", "))
+	})
+}
+
+// Memory creates a param backed by v holding a number of bytes, which can
+// be given with a unit as in 100mb
+func Memory(name string, v *int64) *Param {
+	return NewParam(name, func() string { return strconv.FormatInt(*v, 10) }, func(value string) error {
+		n, err := ParseMemory(value)
+		if err != nil {
+			return err
+		}
+		*v = n
+		return nil
+	})
+}
+
+// memoryUnits are the units memory values can be given in. As in Redis, k
+// is a thousand bytes and kb is 1024.
+var memoryUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory parses a number of bytes, optionally followed by one of the
+// units k, kb, m, mb, g, gb
+func ParseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	unit := int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, unit = strings.TrimSuffix(lower, u.suffix), u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * unit, nil
+}
+
+// Config is the set of params of a server
+type Config struct {
+	params map[string]*Param
+	// file the config was loaded from, if any
+	path string
+}
+
+// New creates an empty config
+func New() *Config {
+	return &Config{params: make(map[string]*Param)}
+}
+
+// Register adds params. Their current values become their defaults.
+func (c *Config) Register(params ...*Param) {
+	for _, p := range params {
+		p.def = p.get()
+		c.params[p.name] = p
+	}
+}
+
+// Path returns the file the config was loaded from
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Directive is a line of the config file or an option from the command
+// line
+type Directive struct {
+	Name string
+	Args []string
+	// where the directive comes from, for error messages
+	Source string
+}
+
+// Load applies the command line of the server: the optional path of a
+// config file, followed by options overriding it. Options start with "--",
+// or with "-" for compatibility with flag style arguments.
+func (c *Config) Load(args []string) error {
+	directives := make([]Directive, 0)
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		fileDirectives, err := ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		directives = append(directives, fileDirectives...)
+		c.path = args[0]
+		args = args[1:]
+	}
+
+	argDirectives, err := c.parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	return c.Apply(append(directives, argDirectives...))
+}
+
+func (c *Config) parseArgs(args []string) ([]Directive, error) {
+	directives := make([]Directive, 0)
+
+	for _, arg := range args {
+		if name, ok := c.optionName(arg); ok {
+			directives = append(directives, Directive{Name: name, Source: "argument " + arg})
+			continue
+		}
+		if len(directives) == 0 {
+			return nil, fmt.Errorf("unexpected argument %s", arg)
+		}
+		d := &directives[len(directives)-1]
+		d.Args = append(d.Args, arg)
+	}
+
+	return directives, nil
+}
+
+// optionName recognizes "--name", and "-name" if name is a param, as the
+// start of an option
+func (c *Config) optionName(arg string) (string, bool) {
+	if strings.HasPrefix(arg, "--") && len(arg) > 2 {
+		return strings.ToLower(arg[2:]), true
+	}
+	if strings.HasPrefix(arg, "-") {
+		if _, ok := c.params[strings.ToLower(arg[1:])]; ok {
+			return strings.ToLower(arg[1:]), true
+		}
+	}
+	return "", false
+}
+
+// ReadFile reads the directives of a config file
+func ReadFile(path string) ([]Directive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	directives := make([]Directive, 0)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		source := fmt.Sprintf("%s:%d", path, lineNo)
+		args, err := SplitArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		directives = append(directives, Directive{Name: strings.ToLower(args[0]), Args: args[1:], Source: source})
+	}
+
+	return directives, scanner.Err()
+}
+
+// Apply sets the params named by directives, in order, whether they are
+// mutable or not
+func (c *Config) Apply(directives []Directive) error {
+	for _, d := range directives {
+		p, ok := c.params[d.Name]
+		if !ok || (len(d.Args) != 1 && !p.multi) {
+			return fmt.Errorf("%s: '%s': Bad directive or wrong number of arguments", d.Source, d.Name)
+		}
+		if err := p.set(strings.Join(d.Args, " ")); err != nil {
+			return fmt.Errorf("%s: '%s': %w", d.Source, d.Name, err)
+		}
+	}
+	return nil
+}
+
+// Get returns the names and values of the params matching any of
+// patterns, sorted by name
+func (c *Config) Get(patterns ...string) [][2]string {
+	names := make([]string, 0)
+	for name := range c.params {
+		for _, pattern := range patterns {
+			if glob.Match(strings.ToLower(pattern), name) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	values := make([][2]string, 0, len(names))
+	for _, name := range names {
+		values = append(values, [2]string{name, c.params[name].get()})
+	}
+	return values
+}
+
+// SetError is returned by Set, naming the param that could not be set
+type SetError struct {
+	Name string
+	Err  error
+}
+
+func (e *SetError) Error() string {
+	return fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %s", e.Name, e.Err)
+}
+
+func (e *SetError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnknown is returned when setting a param that does not exist
+type ErrUnknown string
+
+func (e ErrUnknown) Error() string {
+	return fmt.Sprintf("Unknown option or number of arguments for CONFIG SET - '%s'", string(e))
+}
+
+// Set changes mutable params at runtime, given as name and value pairs.
+// Either all of them are changed or, if one fails, none.
+func (c *Config) Set(pairs ...string) error {
+	if len(pairs)%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+
+	params := make([]*Param, 0, len(pairs)/2)
+	seen := make(map[string]bool)
+	for i := 0; i < len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		p, ok := c.params[name]
+		if !ok {
+			return ErrUnknown(pairs[i])
+		}
+		if !p.mutable {
+			return &SetError{Name: name, Err: ErrImmutable}
+		}
+		if seen[name] {
+			return &SetError{Name: name, Err: errors.New("duplicate parameter")}
+		}
+		seen[name] = true
+		params = append(params, p)
+	}
+
+	old := make([]string, len(params))
+	for i, p := range params {
+		old[i] = p.get()
+	}
+
+	for i, p := range params {
+		if err := p.set(pairs[2*i+1]); err != nil {
+			// restore the ones already set
+			for j := i - 1; j >= 0; j-- {
+				params[j].set(old[j])
+			}
+			return &SetError{Name: p.name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// line renders a param as a config file line
+func (p *Param) line() string {
+	value := p.get()
+	if p.multi && value != "" {
+		args := strings.Fields(value)
+		for i, a := range args {
+			args[i] = Quote(a)
+		}
+		return p.name + " " + strings.Join(args, " ")
+	}
+	return p.name + " " + Quote(value)
+}
+
+// rewriteSignature marks the section holding params that were not in the
+// file yet
+const rewriteSignature = "# Generated by CONFIG REWRITE"
+
+// Rewrite updates the config file with the current values. Params already
+// in the file are changed in place, keeping comments and the order of the
+// lines, and params that are not at their defaults are added at the end.
+func (c *Config) Rewrite() error {
+	if c.path == "" {
+		return errors.New("The server is running without a config file")
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := make([]string, 0)
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	out := make([]string, 0, len(lines))
+	written := make(map[string]bool)
+	signed := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == rewriteSignature {
+			signed = true
+		}
+
+		args, err := SplitArgs(trimmed)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || err != nil || len(args) == 0 {
+			out = append(out, line)
+			continue
+		}
+
+		name := strings.ToLower(args[0])
+		p, ok := c.params[name]
+		if !ok {
+			out = append(out, line)
+			continue
+		}
+
+		// later occurrences of a param are dropped
+		if !written[name] {
+			out = append(out, p.line())
+			written[name] = true
+		}
+	}
+
+	// drop blank lines left at the end by earlier rewrites
+	for len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+		out = out[:len(out)-1]
+	}
+
+	names := make([]string, 0)
+	for name, p := range c.params {
+		if !written[name] && p.get() != p.def {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) > 0 {
+		if !signed {
+			if len(out) > 0 {
+				out = append(out, "")
+			}
+			out = append(out, rewriteSignature)
+		}
+		for _, name := range names {
+			out = append(out, c.params[name].line())
+		}
+	}
+
+	tmp := filepath.Join(filepath.Dir(c.path), fmt.Sprintf("temp-%d-%s", os.Getpid(), filepath.Base(c.path)))
+	if err := os.WriteFile(tmp, []byte(strings.Join(out, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
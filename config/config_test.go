@@ -0,0 +1,161 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SplitArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	args, err := SplitArgs(`requirepass "a \"b\"\x41\n" 'it\'s'  plain`)
+	assert.NoError(err)
+	assert.Equal([]string{"requirepass", "a \"b\"A\n", "it's", "plain"}, args)
+
+	args, err = SplitArgs(`save ""`)
+	assert.NoError(err)
+	assert.Equal([]string{"save", ""}, args)
+
+	_, err = SplitArgs(`requirepass "open`)
+	assert.Error(err)
+	_, err = SplitArgs(`requirepass "a"b`)
+	assert.Error(err)
+}
+
+func Test_Quote(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, s := range []string{"plain", "", "with space", `q"uo'te`, "new\nline", "\x01bin"} {
+		args, err := SplitArgs("name " + Quote(s))
+		assert.NoError(err)
+		assert.Equal([]string{"name", s}, args)
+	}
+	assert.Equal("plain", Quote("plain"))
+}
+
+type testParams struct {
+	port     int
+	bind     string
+	password string
+	policy   string
+	enabled  bool
+}
+
+func newTestConfig() (*Config, *testParams) {
+	v := &testParams{port: 6379, bind: "*", policy: "everysec"}
+	c := New()
+	c.Register(
+		Int("port", &v.port, 0, 65535),
+		String("bind", &v.bind).Multi(),
+		String("requirepass", &v.password).Mutable(),
+		Enum("appendfsync", &v.policy, "always", "everysec", "no").Mutable(),
+		Bool("appendonly", &v.enabled),
+	)
+	return c, v
+}
+
+func writeFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_Load(t *testing.T) {
+	assert := assert.New(t)
+	path := writeFile(t, "# a comment\nport 7000\nbind 127.0.0.1 ::1\nappendonly yes\n")
+
+	c, v := newTestConfig()
+	assert.NoError(c.Load([]string{path, "--port", "7001", "-requirepass", "a b"}))
+	assert.Equal(7001, v.port)
+	assert.Equal("127.0.0.1 ::1", v.bind)
+	assert.Equal("a b", v.password)
+	assert.True(v.enabled)
+	assert.Equal(path, c.Path())
+}
+
+func Test_Load_Errors(t *testing.T) {
+	assert := assert.New(t)
+
+	c, _ := newTestConfig()
+	path := writeFile(t, "port 7000\nnosuchoption 1\n")
+	assert.EqualError(c.Load([]string{path}), path+":2: 'nosuchoption': Bad directive or wrong number of arguments")
+
+	assert.Error(c.Load([]string{"--port", "70000"}))
+	assert.Error(c.Load([]string{"--port", "1", "2"}))
+	assert.Error(c.Load([]string{"--appendonly", "maybe"}))
+}
+
+func Test_Get(t *testing.T) {
+	assert := assert.New(t)
+	c, _ := newTestConfig()
+
+	assert.Equal([][2]string{{"appendfsync", "everysec"}, {"appendonly", "no"}}, c.Get("append*"))
+	assert.Equal([][2]string{{"port", "6379"}}, c.Get("PORT", "nosuch"))
+	assert.Len(c.Get("*"), 5)
+}
+
+func Test_Set(t *testing.T) {
+	assert := assert.New(t)
+	c, v := newTestConfig()
+
+	assert.NoError(c.Set("requirepass", "secret", "APPENDFSYNC", "always"))
+	assert.Equal("secret", v.password)
+	assert.Equal("always", v.policy)
+
+	err := c.Set("port", "7000")
+	assert.True(errors.Is(err, ErrImmutable))
+	assert.EqualError(err, "CONFIG SET failed (possibly related to argument 'port') - can't set immutable config")
+
+	var unknown ErrUnknown
+	assert.True(errors.As(c.Set("nosuch", "1"), &unknown))
+
+	// a failure undoes the params set before it
+	assert.Error(c.Set("requirepass", "other", "appendfsync", "sometimes"))
+	assert.Equal("secret", v.password)
+	assert.Equal("always", v.policy)
+}
+
+func Test_Rewrite(t *testing.T) {
+	assert := assert.New(t)
+	contents := "# the port\nport 7000\n\n# unknown lines are kept\nfoo bar\nport 7001\nappendfsync no\n"
+	path := writeFile(t, "port 7001\n")
+
+	c, _ := newTestConfig()
+	assert.Error(c.Rewrite())
+	assert.NoError(c.Load([]string{path}))
+
+	// the file was edited since it was loaded
+	assert.NoError(os.WriteFile(path, []byte(contents), 0644))
+	assert.NoError(c.Set("requirepass", "secret word", "appendfsync", "everysec"))
+	assert.NoError(c.Rewrite())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("# the port\nport 7001\n\n# unknown lines are kept\nfoo bar\nappendfsync everysec\n\n"+
+		"# Generated by CONFIG REWRITE\nrequirepass \"secret word\"\n", string(data))
+
+	// rewriting again keeps the file as it is
+	assert.NoError(c.Rewrite())
+	again, _ := os.ReadFile(path)
+	assert.Equal(string(data), string(again))
+}
+
+func Test_ParseMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	for value, bytes := range map[string]int64{"0": 0, "100": 100, "1k": 1000, "1KB": 1024, "2mb": 2 << 20, "1g": 1000 * 1000 * 1000} {
+		n, err := ParseMemory(value)
+		assert.NoError(err)
+		assert.Equal(bytes, n, value)
+	}
+	for _, value := range []string{"", "mb", "-1", "1tb", "1.5mb"} {
+		_, err := ParseMemory(value)
+		assert.Error(err, value)
+	}
+}
@@ -0,0 +1,309 @@
+package eventloop
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"noelzubin/redis-go/acl"
+	"noelzubin/redis-go/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetRequirePass makes the default user require password. It must be
+// called before the loop is started.
+func (e *Eventloop) SetRequirePass(password string) {
+	e.requirePass = password
+	e.acl.SetRequirePass(password)
+}
+
+// EnableACLFile loads the users from the ACL file at path, which ACL LOAD
+// and ACL SAVE then work with. It must be called before the loop is
+// started.
+func (e *Eventloop) EnableACLFile(path string) error {
+	if err := e.acl.Load(path); err != nil {
+		return err
+	}
+	e.aclFile = path
+	return nil
+}
+
+// EnableTLSClientCertUsers authenticates TLS clients as the ACL user named
+// by the common name of their verified certificate, if there is one. It
+// must be called before the loop is started.
+func (e *Eventloop) EnableTLSClientCertUsers() {
+	e.tlsCertUsers = true
+}
+
+// authenticated reports whether c may run commands. TLS clients may be
+// authenticated by their certificate, others count as the default user
+// while it needs no password. Connections have to authenticate again once
+// the user they authenticated as is removed.
+func (e *Eventloop) authenticated(c *client) bool {
+	if c.user != nil && c.user.Removed() {
+		c.user = nil
+	}
+
+	if c.user == nil && e.tlsCertUsers && c.certCN != "" {
+		if u := e.acl.User(c.certCN); u != nil && u.Enabled() {
+			c.user = u
+		}
+	}
+
+	if c.user == nil {
+		if u := e.acl.User(acl.DefaultUser); u.Enabled() && u.NoPass() {
+			c.user = u
+		}
+	}
+
+	return c.user != nil
+}
+
+// aclCheck checks that u can run a command with the given arguments. If it
+// can not, it returns the reason and the command or key that was denied.
+func (e *Eventloop) aclCheck(u *acl.User, cmd command, args []string) (string, string) {
+	sub := ""
+	if len(cmd.subcommands) > 0 && len(args) > 1 {
+		sub = strings.ToLower(args[1])
+	}
+
+	if !u.CanRun(cmd.name, sub) {
+		if sub != "" {
+			return "command", cmd.name + "|" + sub
+		}
+		return "command", cmd.name
+	}
+
+	for _, k := range cmd.keys(args) {
+		if !u.CanAccessKey(k, cmd.isWrite()) {
+			return "key", k
+		}
+	}
+
+	if cmd.channels != nil && len(args) > 1 {
+		for _, ch := range cmd.channels(args) {
+			if !u.CanAccessChannel(ch, false) {
+				return "channel", ch
+			}
+		}
+	}
+
+	return "", ""
+}
+
+func aclDenied(u *acl.User, reason, object string) protocol.Value {
+	switch reason {
+	case "command":
+		return protocol.NewErrorValue(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", u.Name(), object))
+	case "key":
+		return protocol.NewErrorValue("NOPERM No permissions to access a key")
+	}
+	return protocol.NewErrorValue("NOPERM No permissions to access a channel")
+}
+
+func (e *Eventloop) auth(c *client, args []string) protocol.Value {
+	var name, password string
+	switch len(args) {
+	case 2:
+		if e.acl.User(acl.DefaultUser).NoPass() {
+			return protocol.NewErrorValue("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+		name, password = acl.DefaultUser, args[1]
+	case 3:
+		name, password = args[1], args[2]
+	default:
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'auth' command")
+	}
+
+	u, ok := e.acl.Authenticate(name, password)
+	if !ok {
+		e.aclLog.Add("auth", "toplevel", "AUTH", name, c.info())
+		return protocol.NewErrorValue("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+
+	c.user = u
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+func (e *Eventloop) aclCommand(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'acl' command")
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "setuser":
+		if len(args) < 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'acl|setuser' command")
+		}
+		if err := e.acl.SetUser(args[2], args[3:]); err != nil {
+			return protocol.NewErrorValue("ERR " + err.Error())
+		}
+		return protocol.NewSimpleStringValue(&OK)
+	case "getuser":
+		if len(args) != 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'acl|getuser' command")
+		}
+		return aclGetUser(e.acl.User(args[2]))
+	case "deluser":
+		if len(args) < 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'acl|deluser' command")
+		}
+		count := 0
+		for _, name := range args[2:] {
+			deleted, err := e.acl.DelUser(name)
+			if err != nil {
+				return protocol.NewErrorValue("ERR " + err.Error())
+			}
+			if deleted {
+				count++
+			}
+		}
+		return protocol.NewSimpleIntValue(int64(count))
+	case "list", "users":
+		lines := make([]string, 0)
+		for _, u := range e.acl.Users() {
+			if strings.EqualFold(args[1], "users") {
+				lines = append(lines, u.Name())
+			} else {
+				lines = append(lines, u.Describe())
+			}
+		}
+		return protocol.NewArrayBulkStringValue(lines)
+	case "whoami":
+		return protocol.NewBulkStringValue(c.user.Name())
+	case "cat":
+		if len(args) == 2 {
+			return protocol.NewArrayBulkStringValue(acl.Categories)
+		}
+		names, ok := e.acl.CategoryCommands(args[2])
+		if !ok {
+			return protocol.NewErrorValue("ERR Unknown category '" + args[2] + "'")
+		}
+		return protocol.NewArrayBulkStringValue(names)
+	case "log":
+		return e.aclLogCommand(args[2:])
+	case "dryrun":
+		return e.aclDryRun(args)
+	case "load":
+		if e.aclFile == "" {
+			return protocol.NewErrorValue("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if err := e.acl.Load(e.aclFile); err != nil {
+			return protocol.NewErrorValue("ERR " + err.Error())
+		}
+		return protocol.NewSimpleStringValue(&OK)
+	case "save":
+		if e.aclFile == "" {
+			return protocol.NewErrorValue("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		if err := e.acl.Save(e.aclFile); err != nil {
+			return protocol.NewErrorValue("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+		}
+		return protocol.NewSimpleStringValue(&OK)
+	case "genpass":
+		bits := 256
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n <= 0 || n > 4096 {
+				return protocol.NewErrorValue("ERR ACL GENPASS argument must be the number of bits for the output password, a positive number up to 4096")
+			}
+			bits = n
+		}
+		b := make([]byte, (bits+7)/8)
+		rand.Read(b)
+		return protocol.NewBulkStringValue(hex.EncodeToString(b)[:(bits+3)/4])
+	}
+
+	return protocol.NewErrorValue("ERR unknown subcommand '" + args[1] + "'")
+}
+
+func aclGetUser(u *acl.User) protocol.Value {
+	if u == nil {
+		return protocol.NewNilValue()
+	}
+
+	return protocol.NewArrayValue([]protocol.Value{
+		protocol.NewBulkStringValue("flags"),
+		protocol.NewArrayBulkStringValue(u.Flags()),
+		protocol.NewBulkStringValue("passwords"),
+		protocol.NewArrayBulkStringValue(u.Passwords()),
+		protocol.NewBulkStringValue("commands"),
+		protocol.NewBulkStringValue(u.CommandRules()),
+		protocol.NewBulkStringValue("keys"),
+		protocol.NewBulkStringValue(u.KeyRules()),
+		protocol.NewBulkStringValue("channels"),
+		protocol.NewBulkStringValue(u.ChannelRules()),
+	})
+}
+
+func (e *Eventloop) aclLogCommand(args []string) protocol.Value {
+	count := -1
+	if len(args) > 0 {
+		if strings.EqualFold(args[0], "reset") {
+			e.aclLog.Reset()
+			return protocol.NewSimpleStringValue(&OK)
+		}
+
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return protocol.NewErrorValue("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	now := time.Now()
+	entries := make([]protocol.Value, 0)
+	for _, l := range e.aclLog.Entries(count) {
+		entries = append(entries, protocol.NewArrayValue([]protocol.Value{
+			protocol.NewBulkStringValue("count"),
+			protocol.NewSimpleIntValue(int64(l.Count)),
+			protocol.NewBulkStringValue("reason"),
+			protocol.NewBulkStringValue(l.Reason),
+			protocol.NewBulkStringValue("context"),
+			protocol.NewBulkStringValue(l.Context),
+			protocol.NewBulkStringValue("object"),
+			protocol.NewBulkStringValue(l.Object),
+			protocol.NewBulkStringValue("username"),
+			protocol.NewBulkStringValue(l.Username),
+			protocol.NewBulkStringValue("age-seconds"),
+			protocol.NewBulkStringValue(strconv.FormatFloat(now.Sub(l.Created).Seconds(), 'f', 3, 64)),
+			protocol.NewBulkStringValue("client-info"),
+			protocol.NewBulkStringValue(l.ClientInfo),
+			protocol.NewBulkStringValue("entry-id"),
+			protocol.NewSimpleIntValue(l.ID),
+			protocol.NewBulkStringValue("timestamp-created"),
+			protocol.NewSimpleIntValue(l.Created.UnixMilli()),
+			protocol.NewBulkStringValue("timestamp-last-updated"),
+			protocol.NewSimpleIntValue(l.Updated.UnixMilli()),
+		}))
+	}
+
+	return protocol.NewArrayValue(entries)
+}
+
+// aclDryRun reports whether a user could run a command, without running it
+func (e *Eventloop) aclDryRun(args []string) protocol.Value {
+	if len(args) < 4 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'acl|dryrun' command")
+	}
+
+	u := e.acl.User(args[2])
+	if u == nil {
+		return protocol.NewErrorValue("ERR User '" + args[2] + "' not found")
+	}
+
+	cmd, ok := lookupCommand(args[3])
+	if !ok {
+		return protocol.NewErrorValue("ERR Command '" + args[3] + "' not found")
+	}
+
+	switch reason, object := e.aclCheck(u, cmd, args[3:]); reason {
+	case "command":
+		return protocol.NewBulkStringValue(fmt.Sprintf("User %s has no permissions to run the '%s' command", u.Name(), object))
+	case "key":
+		return protocol.NewBulkStringValue(fmt.Sprintf("User %s has no permissions to access the '%s' key", u.Name(), object))
+	}
+
+	return protocol.NewSimpleStringValue(&OK)
+}
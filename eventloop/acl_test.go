@@ -0,0 +1,117 @@
+package eventloop
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Auth(t *testing.T) {
+	assert := assert.New(t)
+	l := listenTest(t)
+	serveTest(t, l, func(el *Eventloop) {
+		el.SetRequirePass("secret")
+	})
+	c := dialTestServer(t, l.Addr().String())
+
+	assert.Equal("NOAUTH Authentication required.", c.do("GET", "foo").String())
+	assert.Equal("NOAUTH Authentication required.", c.do("NOSUCHCOMMAND").String())
+	assert.True(strings.HasPrefix(c.do("AUTH", "wrong").String(), "WRONGPASS"))
+	assert.Equal("OK", c.do("AUTH", "secret").String())
+	assert.Equal("OK", c.do("SET", "foo", "bar").String())
+	assert.Equal("default", c.do("ACL", "WHOAMI").String())
+}
+
+func Test_ACL_Permissions(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	admin := dialTestServer(t, addr)
+	c := dialTestServer(t, addr)
+
+	assert.Equal("OK", admin.do("ACL", "SETUSER", "alice", "on", ">pw", "~cache:*", "%R~shared:*", "+@read", "+set", "-keys", "+acl|whoami").String())
+	assert.Equal("OK", c.do("AUTH", "alice", "pw").String())
+	assert.Equal("alice", c.do("ACL", "WHOAMI").String())
+
+	assert.Equal("OK", c.do("SET", "cache:1", "v").String())
+	assert.Equal("v", c.do("GET", "cache:1").String())
+	assert.Equal("", c.do("GET", "shared:1").String())
+	assert.Equal("NOPERM No permissions to access a key", c.do("SET", "shared:1", "v").String())
+	assert.Equal("NOPERM No permissions to access a key", c.do("GET", "other").String())
+	assert.Equal("NOPERM User alice has no permissions to run the 'keys' command", c.do("KEYS").String())
+	assert.Equal("NOPERM User alice has no permissions to run the 'del' command", c.do("DEL", "cache:1").String())
+
+	assert.Equal("OK", admin.do("ACL", "DRYRUN", "alice", "GET", "cache:2").String())
+	assert.Equal("User alice has no permissions to access the 'other' key", admin.do("ACL", "DRYRUN", "alice", "GET", "other").String())
+	assert.Equal("User alice has no permissions to run the 'del' command", admin.do("ACL", "DRYRUN", "alice", "DEL", "cache:1").String())
+
+	// denials are logged and grouped
+	log := admin.do("ACL", "LOG").Array()
+	assert.Len(log, 4)
+	assert.Equal("del", log[0].Array()[7].String())
+	assert.Equal("key", log[2].Array()[3].String())
+	assert.Equal("OK", admin.do("ACL", "LOG", "RESET").String())
+	assert.Empty(admin.do("ACL", "LOG").Array())
+
+	// changes apply to connections already authenticated
+	assert.Equal("OK", admin.do("ACL", "SETUSER", "alice", "+del").String())
+	assert.Equal(int64(1), c.do("DEL", "cache:1").Integer())
+
+	getuser := admin.do("ACL", "GETUSER", "alice").Array()
+	assert.Equal("~cache:* %R~shared:*", getuser[7].String())
+	assert.Equal("", admin.do("ACL", "GETUSER", "nobody").String())
+
+	// and a removed user has to authenticate again
+	assert.Equal(int64(1), admin.do("ACL", "DELUSER", "alice").Integer())
+	assert.Equal("default", c.do("ACL", "WHOAMI").String())
+	assert.True(admin.do("ACL", "DELUSER", "default").IsError())
+}
+
+func Test_ACL_File(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "users.acl")
+
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	assert.True(c.do("ACL", "SAVE").IsError())
+
+	l := listenTest(t)
+	serveTest(t, l, func(el *Eventloop) {
+		el.acl.SetUser("bob", []string{"on", ">pw", "+@all", "~*"})
+		assert.NoError(el.acl.Save(path))
+		assert.NoError(el.EnableACLFile(path))
+	})
+	c = dialTestServer(t, l.Addr().String())
+
+	c.do("ACL", "SETUSER", "carol", "on", "nopass")
+	assert.Equal("OK", c.do("ACL", "SAVE").String())
+	c.do("ACL", "DELUSER", "carol")
+	assert.Equal("OK", c.do("ACL", "LOAD").String())
+	assert.Equal([]string{"bob", "carol", "default"}, stringArray(c.do("ACL", "USERS").Array()))
+}
+
+func Test_Replication_MasterAuth(t *testing.T) {
+	assert := assert.New(t)
+	ml := listenTest(t)
+	serveTest(t, ml, func(el *Eventloop) {
+		el.SetRequirePass("secret")
+	})
+	rl := listenTest(t)
+	serveTest(t, rl, func(el *Eventloop) {
+		el.SetMasterAuth("", "secret")
+	})
+
+	m := dialTestServer(t, ml.Addr().String())
+	r := dialTestServer(t, rl.Addr().String())
+	host, port := splitHostPort(t, ml.Addr().String())
+
+	m.do("AUTH", "secret")
+	m.do("SET", "foo", "bar")
+	r.do("REPLICAOF", host, port)
+
+	assert.Eventually(func() bool {
+		return r.do("GET", "foo").String() == "bar"
+	}, 5*time.Second, 20*time.Millisecond)
+}
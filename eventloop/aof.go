@@ -0,0 +1,256 @@
+package eventloop
+
+import (
+	"fmt"
+	"io"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/store"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aofRewriteItemsPerCmd caps the number of members written per ZADD when
+// rewriting large sorted sets
+const aofRewriteItemsPerCmd = 64
+
+// aofRewriteDone is sent to the loop when the background rewrite has finished
+// writing the compacted file
+type aofRewriteDone struct {
+	tmp string
+	err error
+}
+
+// EnableAOF replays the append only file at path and then logs every write
+// command to it. It must be called before the loop is started.
+func (e *Eventloop) EnableAOF(path string, policy aof.FsyncPolicy) error {
+	c := &client{}
+	count, err := aof.Load(path, func(args []string) {
+		e.execute(c, args)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("loaded %d commands from the append only file\n", count)
+
+	f, err := aof.Open(path, policy)
+	if err != nil {
+		return err
+	}
+
+	f.OnSync(func(d time.Duration, background bool) {
+		if background {
+			e.latency.add("aof-fsync-background", d)
+			return
+		}
+		e.latency.add("aof-fsync-always", d)
+	})
+	e.aof = f
+	return nil
+}
+
+// propagate sends a write command that was just executed against database
+// db to the AOF and to the replicas
+func (e *Eventloop) propagate(db int, args []string) error {
+	for _, cmd := range propagatedCommands(args, time.Now()) {
+		if db != e.repl.selDB {
+			e.replicationFeed(aof.Encode(selectCommand(db)))
+			e.repl.selDB = db
+		}
+		e.replicationFeed(aof.Encode(cmd))
+
+		if err := e.appendAOF(db, cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendAOF logs a command run against database db, preceded by a SELECT
+// if the previous one ran against another database
+func (e *Eventloop) appendAOF(db int, cmd []string) error {
+	if e.aof == nil {
+		return nil
+	}
+
+	if db != e.aofSelDB {
+		if err := e.aof.Append(selectCommand(db)); err != nil {
+			return err
+		}
+		e.aofSelDB = db
+	}
+
+	return e.aof.Append(cmd)
+}
+
+func selectCommand(db int) []string {
+	return []string{"SELECT", strconv.Itoa(db)}
+}
+
+// propagatedCommands converts a command into the form that is logged, so that
+// replaying it later gives the same result. Relative expiry times are turned
+// into absolute ones.
+func propagatedCommands(args []string, now time.Time) [][]string {
+	switch strings.ToLower(args[0]) {
+	case "set":
+		if len(args) > 3 {
+			set := []string{"SET", args[1], args[2]}
+			seconds, err := strconv.Atoi(args[3])
+			if err != nil {
+				return [][]string{set}
+			}
+			return [][]string{set, pexpireat(args[1], now.Add(time.Duration(seconds)*time.Second))}
+		}
+	case "expire":
+		seconds, _ := strconv.Atoi(args[2])
+		return [][]string{pexpireat(args[1], now.Add(time.Duration(seconds)*time.Second))}
+	case "restore", "restore-asking":
+		restore := []string{"RESTORE", args[1], "0", args[3], "REPLACE"}
+		ttl, _ := strconv.ParseInt(args[2], 10, 64)
+		if ttl == 0 {
+			return [][]string{restore}
+		}
+
+		expiry := now.Add(time.Duration(ttl) * time.Millisecond)
+		for _, opt := range args[4:] {
+			if strings.EqualFold(opt, "absttl") {
+				expiry = time.UnixMilli(ttl)
+			}
+		}
+		// the key was not created
+		if !expiry.After(now) {
+			return [][]string{{"DEL", args[1]}}
+		}
+		return [][]string{restore, pexpireat(args[1], expiry)}
+	case "migrate":
+		// the keys that were moved away are propagated as a DEL
+		return nil
+	}
+
+	return [][]string{args}
+}
+
+func pexpireat(key string, t time.Time) []string {
+	return []string{"PEXPIREAT", key, strconv.FormatInt(t.UnixMilli(), 10)}
+}
+
+// rewriteCommands returns the shortest list of commands that recreate entry
+func rewriteCommands(entry store.Entry) [][]string {
+	cmds := make([][]string, 0, 2)
+
+	switch entry.Value.Type() {
+	case "string":
+		s, _ := entry.Value.Str()
+		cmds = append(cmds, []string{"SET", entry.Key, s})
+	case "zset":
+		members := entry.Value.ScoreMembers()
+		for start := 0; start < len(members); start += aofRewriteItemsPerCmd {
+			end := start + aofRewriteItemsPerCmd
+			if end > len(members) {
+				end = len(members)
+			}
+
+			zadd := []string{"ZADD", entry.Key}
+			for _, m := range members[start:end] {
+				zadd = append(zadd, strconv.FormatInt(m.Score(), 10), m.Member())
+			}
+			cmds = append(cmds, zadd)
+		}
+	}
+
+	if exp := entry.Value.Expiry(); exp != nil {
+		cmds = append(cmds, pexpireat(entry.Key, *exp))
+	}
+
+	return cmds
+}
+
+// snapshot copies the live entries of every database, so they can be
+// written out in the background
+func (e *Eventloop) snapshot() [][]store.Entry {
+	snap := make([][]store.Entry, len(e.dbs))
+	for i, db := range e.dbs {
+		snap[i] = db.Snapshot()
+	}
+	return snap
+}
+
+// writeSnapshot writes the commands that recreate snap, selecting each
+// database that has keys
+func writeSnapshot(w io.Writer, snap [][]store.Entry) error {
+	for db, entries := range snap {
+		if len(entries) == 0 {
+			continue
+		}
+
+		if err := aof.WriteCommand(w, selectCommand(db)); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			for _, cmd := range rewriteCommands(entry) {
+				if err := aof.WriteCommand(w, cmd); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bgRewriteAOF starts compacting the append only file from the current
+// dataset. The snapshot is taken on the loop and written out in the
+// background, while new writes keep being appended to the old file and
+// buffered for the new one.
+func (e *Eventloop) bgRewriteAOF() protocol.Value {
+	if e.aof == nil {
+		return protocol.NewErrorValue("ERR append only file is not enabled")
+	}
+
+	if err := e.aof.StartRewrite(); err != nil {
+		return protocol.NewErrorValue("ERR " + err.Error())
+	}
+
+	snap := e.snapshot()
+	// the rewritten file ends on whichever database was written last, so
+	// the first write buffered for it has to select its own
+	e.aofSelDB = -1
+
+	go func() {
+		tmp, err := e.aof.WriteRewrite(func(w io.Writer) error {
+			return writeSnapshot(w, snap)
+		})
+		// a rewrite still running when the server shuts down is dropped
+		if !e.send(aofRewriteDone{tmp: tmp, err: err}) && tmp != "" {
+			os.Remove(tmp)
+		}
+	}()
+
+	started := "Background append only file rewriting started"
+	return protocol.NewSimpleStringValue(&started)
+}
+
+// rewriteAOF compacts the append only file from the current dataset before
+// returning, as SHUTDOWN SAVE does
+func (e *Eventloop) rewriteAOF() error {
+	if err := e.aof.StartRewrite(); err != nil {
+		return err
+	}
+
+	snap := e.snapshot()
+	e.aofSelDB = -1
+	tmp, err := e.aof.WriteRewrite(func(w io.Writer) error {
+		return writeSnapshot(w, snap)
+	})
+	return e.aof.FinishRewrite(tmp, err)
+}
+
+func (e *Eventloop) finishAOFRewrite(done aofRewriteDone) {
+	if err := e.aof.FinishRewrite(done.tmp, done.err); err != nil {
+		fmt.Println("background append only file rewrite failed: ", err.Error())
+		return
+	}
+	fmt.Println("background append only file rewrite finished successfully")
+}
@@ -0,0 +1,60 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/set"
+	"noelzubin/redis-go/store"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PropagatedCommands_Relative_Expiry(t *testing.T) {
+	assert := assert.New(t)
+	now := time.UnixMilli(1000)
+
+	assert.Equal(
+		[][]string{{"SET", "foo", "bar"}, {"PEXPIREAT", "foo", "11000"}},
+		propagatedCommands([]string{"set", "foo", "bar", "10"}, now),
+	)
+	assert.Equal(
+		[][]string{{"PEXPIREAT", "foo", "3000"}},
+		propagatedCommands([]string{"EXPIRE", "foo", "2"}, now),
+	)
+	assert.Equal(
+		[][]string{{"DEL", "foo"}},
+		propagatedCommands([]string{"DEL", "foo"}, now),
+	)
+	assert.Equal(
+		[][]string{{"RESTORE", "foo", "0", "payload", "REPLACE"}, {"PEXPIREAT", "foo", "1500"}},
+		propagatedCommands([]string{"RESTORE", "foo", "500", "payload"}, now),
+	)
+	assert.Equal(
+		[][]string{{"RESTORE", "foo", "0", "payload", "REPLACE"}, {"PEXPIREAT", "foo", "5000"}},
+		propagatedCommands([]string{"RESTORE", "foo", "5000", "payload", "ABSTTL"}, now),
+	)
+	assert.Equal(
+		[][]string{{"DEL", "foo"}},
+		propagatedCommands([]string{"RESTORE", "foo", "500", "payload", "ABSTTL"}, now),
+	)
+}
+
+func Test_RewriteCommands(t *testing.T) {
+	assert := assert.New(t)
+	s := store.InitStore(set.InitStringSet())
+	exp := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	s.Set("foo", "bar", &exp)
+	s.ZAdd("zs", []store.ScoreMember{store.NewScoreMember(2, "two"), store.NewScoreMember(1, "one")})
+
+	cmds := make([][]string, 0)
+	for _, entry := range s.Snapshot() {
+		cmds = append(cmds, rewriteCommands(entry)...)
+	}
+
+	assert.ElementsMatch([][]string{
+		{"SET", "foo", "bar"},
+		{"PEXPIREAT", "foo", strconv.FormatInt(exp.UnixMilli(), 10)},
+		{"ZADD", "zs", "1", "one", "2", "two"},
+	}, cmds)
+}
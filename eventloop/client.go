@@ -0,0 +1,238 @@
+package eventloop
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"noelzubin/redis-go/acl"
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/tlsconfig"
+	"sync"
+	"time"
+)
+
+// pushQueueSize is how many pushed messages a client may have waiting to
+// be written before it is disconnected
+const pushQueueSize = 1024
+
+// client holds the state of a single connection
+type client struct {
+	conn io.ReadWriteCloser
+	// unique ID, given when the loop registers the client
+	id    int64
+	addr  string
+	laddr string
+	// set with CLIENT SETNAME
+	name string
+	// index of the selected database
+	db int
+	// user the connection is authenticated as, nil until it is
+	user *acl.User
+
+	// subject and common name of the verified certificate of a TLS client
+	certSubject string
+	certCN      string
+
+	// port the replica on the other end of this connection listens on, as
+	// announced with REPLCONF listening-port
+	listeningPort int
+	// set once a replica on this connection has issued PSYNC
+	replica *replica
+	// set on the fake client that applies the stream from our master
+	master bool
+	// set by ASKING, lets the next command access an importing slot
+	asking bool
+
+	created         time.Time
+	lastInteraction time.Time
+	// name of the last command run, with its subcommand if any
+	lastCmd string
+	// bytes read from the connection but not parsed yet, and the size of
+	// the read buffer
+	qbuf     int
+	qbufSize int
+	// set with CLIENT NO-EVICT
+	noEvict bool
+	// set when the client kills its own connection, which is closed once
+	// the reply is written
+	closeAfterReply bool
+
+	// protocol version selected with HELLO
+	resp int
+	// messages pushed to the client while it is not running a command,
+	// written by writePushes, and those pushed by its own command, written
+	// after the reply
+	pushes  chan []byte
+	pending []byte
+	// held while writing to conn, so replies and pushes do not interleave
+	writeMu sync.Mutex
+	// channels the client is subscribed to
+	channels map[string]bool
+	// set with CLIENT TRACKING
+	tracking trackingState
+	// set once the client runs MONITOR
+	monitor bool
+	// set while the client waits for its SHUTDOWN to finish
+	blocked bool
+}
+
+func newClient(conn io.ReadWriteCloser) *client {
+	now := time.Now()
+	c := &client{
+		conn:            conn,
+		created:         now,
+		lastInteraction: now,
+		resp:            2,
+		pushes:          make(chan []byte, pushQueueSize),
+		channels:        make(map[string]bool),
+	}
+
+	if nc, ok := conn.(net.Conn); ok {
+		c.addr = nc.RemoteAddr().String()
+		c.laddr = nc.LocalAddr().String()
+		// peers on a Unix socket have no address of their own
+		if nc.LocalAddr().Network() == "unix" {
+			c.addr = nc.LocalAddr().String() + ":0"
+			c.laddr = c.addr
+		}
+	}
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		c.certSubject, c.certCN, _ = tlsconfig.PeerSubject(tc)
+	}
+
+	return c
+}
+
+// host returns the address of the peer without the port
+func (c *client) host() string {
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		return c.addr
+	}
+	return host
+}
+
+// userName returns the name of the user the client is authenticated as
+func (c *client) userName() string {
+	if c.user == nil {
+		return acl.DefaultUser
+	}
+	return c.user.Name()
+}
+
+// kind returns the type of the client as named by CLIENT LIST and KILL
+func (c *client) kind() string {
+	switch {
+	case c.master:
+		return "master"
+	case c.replica != nil:
+		return "replica"
+	case len(c.channels) > 0:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// flags returns the CLIENT LIST flags of the client
+func (c *client) flags() string {
+	flags := ""
+	switch c.kind() {
+	case "master":
+		flags += "M"
+	case "replica":
+		flags += "S"
+	case "pubsub":
+		flags += "P"
+	}
+	if c.asking {
+		flags += "A"
+	}
+	if c.monitor {
+		flags += "O"
+	}
+	if c.noEvict {
+		flags += "e"
+	}
+	if c.closeAfterReply {
+		flags += "c"
+	}
+	if c.blocked {
+		flags += "b"
+	}
+	if c.tracking.enabled {
+		flags += "t"
+	}
+	if c.tracking.bcast {
+		flags += "B"
+	}
+	if c.tracking.redirectBroken {
+		flags += "R"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return flags
+}
+
+// info describes the client the way CLIENT LIST and the ACL log do
+func (c *client) info() string {
+	now := time.Now()
+	cmd := c.lastCmd
+	if cmd == "" {
+		cmd = "NULL"
+	}
+
+	info := fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d qbuf=%d qbuf-free=%d obl=0 oll=0 omem=0 cmd=%s user=%s resp=%d",
+		c.id, c.addr, c.laddr, c.name, int64(now.Sub(c.created).Seconds()), int64(now.Sub(c.lastInteraction).Seconds()),
+		c.flags(), c.db, c.qbuf, c.qbufSize-c.qbuf, cmd, c.userName(), c.resp)
+	if c.certSubject != "" {
+		info += " cert=" + c.certSubject
+	}
+	return info
+}
+
+// writePushes writes the messages pushed to the client until the channel is
+// closed
+func (c *client) writePushes() {
+	for b := range c.pushes {
+		c.writeMu.Lock()
+		c.conn.Write(b)
+		c.writeMu.Unlock()
+	}
+}
+
+// interrupt has reads of the connection fail from read on and writes from
+// write on, so its handler winds down once it has written what it was
+// writing. Connections without deadlines are closed right away.
+func (c *client) interrupt(read, write time.Time) {
+	conn, ok := c.conn.(interface {
+		SetReadDeadline(t time.Time) error
+		SetWriteDeadline(t time.Time) error
+	})
+	if !ok {
+		c.conn.Close()
+		return
+	}
+	conn.SetReadDeadline(read)
+	conn.SetWriteDeadline(write)
+}
+
+// pushValue wraps an out of band message the way the protocol of the client
+// allows, as a push in RESP3 and a plain array in RESP2
+func (c *client) pushValue(vals ...protocol.Value) protocol.Value {
+	if c.resp == 3 {
+		return protocol.NewPushValue(vals)
+	}
+	return protocol.NewArrayValue(vals)
+}
+
+// mapValue replies with a map in RESP3 and a flat array of keys and values
+// in RESP2
+func (c *client) mapValue(vals ...protocol.Value) protocol.Value {
+	if c.resp == 3 {
+		return protocol.NewMapValue(vals)
+	}
+	return protocol.NewArrayValue(vals)
+}
@@ -0,0 +1,403 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clientConnected and clientDisconnected keep the registry of clients up to
+// date. They are sent by the connection goroutines.
+type clientConnected struct {
+	client *client
+}
+
+type clientDisconnected struct {
+	client *client
+}
+
+// registerClient gives a new client its ID and adds it to the registry
+func (e *Eventloop) registerClient(c *client) {
+	e.nextClientID++
+	c.id = e.nextClientID
+	e.clients[c.id] = c
+	e.stats.totalConnections++
+}
+
+func (e *Eventloop) unregisterClient(c *client) {
+	delete(e.clients, c.id)
+	e.disableTracking(c)
+	e.unsubscribeAll(c)
+	delete(e.monitors, c)
+}
+
+// sortedClients returns the registered clients in the order they connected
+func (e *Eventloop) sortedClients() []*client {
+	clients := make([]*client, 0, len(e.clients))
+	for _, c := range e.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// pauseState holds the state of CLIENT PAUSE
+type pauseState struct {
+	// "write" or "all" while clients are paused, empty otherwise
+	mode  string
+	until time.Time
+	// commands held back until the pause ends
+	queue []*ReqCommand
+}
+
+// pauseTimeout is sent when a pause may have ended
+type pauseTimeout struct{}
+
+// paused reports whether a command has to wait for the pause to end, or
+// for a shutdown waiting for replicas to fail, which holds back writes. The
+// CLIENT command is never paused, so the pause can be inspected and lifted.
+func (e *Eventloop) paused(c *client, args []string) bool {
+	if (e.pause.mode == "" && !e.shutdown.pending) || c.master || len(args) == 0 {
+		return false
+	}
+
+	cmd, known := lookupCommand(args[0])
+	if known && cmd.name == "client" {
+		return false
+	}
+	return e.pause.mode == "all" || (known && cmd.isWrite())
+}
+
+// endPauseIfDue lifts a pause whose timeout has passed
+func (e *Eventloop) endPauseIfDue() {
+	if e.pause.mode != "" && !time.Now().Before(e.pause.until) {
+		e.unpause()
+	}
+}
+
+// unpause ends the pause and runs the commands held back, in the order
+// they arrived
+func (e *Eventloop) unpause() {
+	queue := e.pause.queue
+	e.pause = pauseState{}
+	for _, req := range queue {
+		e.runCommands(req)
+	}
+}
+
+func (e *Eventloop) clientCommand(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'client' command")
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "id":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'client|id' command")
+		}
+		return protocol.NewSimpleIntValue(c.id)
+	case "info":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'client|info' command")
+		}
+		return protocol.NewBulkStringValue(c.info() + "\n")
+	case "list":
+		return e.clientList(args)
+	case "setname":
+		if len(args) != 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'client|setname' command")
+		}
+		if !validClientName(args[2]) {
+			return errClientName
+		}
+		c.name = args[2]
+		return protocol.NewSimpleStringValue(&OK)
+	case "getname":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'client|getname' command")
+		}
+		if c.name == "" {
+			return protocol.NewNilValue()
+		}
+		return protocol.NewBulkStringValue(c.name)
+	case "kill":
+		return e.clientKill(c, args)
+	case "pause":
+		return e.clientPause(args)
+	case "unpause":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'client|unpause' command")
+		}
+		e.unpause()
+		return protocol.NewSimpleStringValue(&OK)
+	case "no-evict":
+		if len(args) != 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'client|no-evict' command")
+		}
+		switch strings.ToLower(args[2]) {
+		case "on":
+			c.noEvict = true
+		case "off":
+			c.noEvict = false
+		default:
+			return protocol.NewErrorValue("ERR syntax error")
+		}
+		return protocol.NewSimpleStringValue(&OK)
+	case "tracking":
+		return e.clientTracking(c, args)
+	case "caching":
+		return clientCaching(c, args)
+	case "trackinginfo":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'client|trackinginfo' command")
+		}
+		return trackingInfo(c)
+	case "getredir":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'client|getredir' command")
+		}
+		return protocol.NewSimpleIntValue(c.tracking.redirectID())
+	}
+
+	return protocol.NewErrorValue("ERR unknown subcommand '" + args[1] + "'. Try CLIENT HELP.")
+}
+
+var errClientName = protocol.NewErrorValue("ERR Client names cannot contain spaces, newlines or special characters.")
+
+// validClientName reports whether name can be given with CLIENT SETNAME
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// hello implements HELLO [protover [AUTH username password] [SETNAME name]],
+// which switches the protocol of the connection and describes the server
+func (e *Eventloop) hello(c *client, args []string) protocol.Value {
+	resp := c.resp
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return protocol.NewErrorValue("ERR Protocol version is not an integer or out of range")
+		}
+		if n != 2 && n != 3 {
+			return protocol.NewErrorValue("NOPROTO unsupported protocol version")
+		}
+		resp = n
+	}
+
+	name, setName := "", false
+	for i := 2; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "auth") && i+2 < len(args):
+			if r := e.auth(c, []string{"auth", args[i+1], args[i+2]}); r.IsError() {
+				return r
+			}
+			i += 2
+		case strings.EqualFold(args[i], "setname") && i+1 < len(args):
+			if !validClientName(args[i+1]) {
+				return errClientName
+			}
+			name, setName = args[i+1], true
+			i++
+		default:
+			return protocol.NewErrorValue("ERR Syntax error in HELLO option '" + args[i] + "'")
+		}
+	}
+
+	if !e.authenticated(c) {
+		return protocol.NewErrorValue("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	if setName {
+		c.name = name
+	}
+	c.resp = resp
+
+	mode := "standalone"
+	if e.cluster != nil {
+		mode = "cluster"
+	}
+	role := "master"
+	if e.repl.isReplica() {
+		role = "replica"
+	}
+	return c.mapValue(
+		protocol.NewBulkStringValue("server"), protocol.NewBulkStringValue("redis"),
+		protocol.NewBulkStringValue("version"), protocol.NewBulkStringValue(redisVersion),
+		protocol.NewBulkStringValue("proto"), protocol.NewSimpleIntValue(int64(c.resp)),
+		protocol.NewBulkStringValue("id"), protocol.NewSimpleIntValue(c.id),
+		protocol.NewBulkStringValue("mode"), protocol.NewBulkStringValue(mode),
+		protocol.NewBulkStringValue("role"), protocol.NewBulkStringValue(role),
+		protocol.NewBulkStringValue("modules"), protocol.NewArrayValue([]protocol.Value{}),
+	)
+}
+
+// parseClientType parses the type of client named by TYPE filters
+func parseClientType(s string) (string, bool) {
+	switch strings.ToLower(s) {
+	case "normal", "master", "pubsub":
+		return strings.ToLower(s), true
+	case "replica", "slave":
+		return "replica", true
+	}
+	return "", false
+}
+
+// clientList implements CLIENT LIST [TYPE type] [ID id ...]
+func (e *Eventloop) clientList(args []string) protocol.Value {
+	kind := ""
+	ids := make(map[int64]bool)
+
+	for i := 2; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "type") && i+1 < len(args):
+			var ok bool
+			if kind, ok = parseClientType(args[i+1]); !ok {
+				return protocol.NewErrorValue("ERR Unknown client type '" + args[i+1] + "'")
+			}
+			i++
+		case strings.EqualFold(args[i], "id") && i+1 < len(args):
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil || id <= 0 {
+					return protocol.NewErrorValue("ERR Invalid client ID")
+				}
+				ids[id] = true
+			}
+		default:
+			return protocol.NewErrorValue("ERR syntax error")
+		}
+	}
+
+	var b strings.Builder
+	for _, c := range e.sortedClients() {
+		if kind != "" && c.kind() != kind {
+			continue
+		}
+		if len(ids) > 0 && !ids[c.id] {
+			continue
+		}
+		b.WriteString(c.info())
+		b.WriteString("\n")
+	}
+	return protocol.NewBulkStringValue(b.String())
+}
+
+// clientKill implements both CLIENT KILL addr:port and CLIENT KILL with
+// filters, which replies with the number of clients killed
+func (e *Eventloop) clientKill(c *client, args []string) protocol.Value {
+	if len(args) < 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'client|kill' command")
+	}
+
+	if len(args) == 3 {
+		for _, k := range e.sortedClients() {
+			if k.addr == args[2] {
+				e.killClient(c, k)
+				return protocol.NewSimpleStringValue(&OK)
+			}
+		}
+		return protocol.NewErrorValue("ERR No such client")
+	}
+
+	if len(args)%2 != 0 {
+		return protocol.NewErrorValue("ERR syntax error")
+	}
+
+	var id int64
+	addr, laddr, user, kind := "", "", "", ""
+	skipMe := true
+	for i := 2; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "id":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return protocol.NewErrorValue("ERR client-id should be greater than 0")
+			}
+			id = n
+		case "addr":
+			addr = value
+		case "laddr":
+			laddr = value
+		case "user":
+			user = value
+		case "type":
+			var ok bool
+			if kind, ok = parseClientType(value); !ok {
+				return protocol.NewErrorValue("ERR Unknown client type '" + value + "'")
+			}
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return protocol.NewErrorValue("ERR syntax error")
+			}
+		default:
+			return protocol.NewErrorValue("ERR syntax error")
+		}
+	}
+
+	killed := 0
+	for _, k := range e.sortedClients() {
+		if (id != 0 && k.id != id) || (addr != "" && k.addr != addr) || (laddr != "" && k.laddr != laddr) ||
+			(user != "" && k.userName() != user) || (kind != "" && k.kind() != kind) || (skipMe && k == c) {
+			continue
+		}
+		e.killClient(c, k)
+		killed++
+	}
+	return protocol.NewSimpleIntValue(int64(killed))
+}
+
+// killClient closes the connection of k. A client killing itself gets its
+// reply first.
+func (e *Eventloop) killClient(c, k *client) {
+	if k == c {
+		k.closeAfterReply = true
+		return
+	}
+	k.conn.Close()
+}
+
+// clientPause implements CLIENT PAUSE timeout [WRITE|ALL]. A pause that is
+// already in effect is only ever extended and made stricter.
+func (e *Eventloop) clientPause(args []string) protocol.Value {
+	if len(args) != 3 && len(args) != 4 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'client|pause' command")
+	}
+
+	ms, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || ms < 0 {
+		return protocol.NewErrorValue("ERR timeout is not an integer or out of range")
+	}
+
+	mode := "all"
+	if len(args) == 4 {
+		mode = strings.ToLower(args[3])
+		if mode != "write" && mode != "all" {
+			return protocol.NewErrorValue("ERR syntax error")
+		}
+	}
+
+	until := time.Now().Add(time.Duration(ms) * time.Millisecond)
+	if e.pause.mode != "all" {
+		e.pause.mode = mode
+	}
+	if until.After(e.pause.until) {
+		e.pause.until = until
+	}
+
+	time.AfterFunc(time.Until(e.pause.until), func() {
+		e.send(pauseTimeout{})
+	})
+	return protocol.NewSimpleStringValue(&OK)
+}
@@ -0,0 +1,122 @@
+package eventloop
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Client_List(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	other := dialTestServer(t, addr)
+
+	id := c.do("CLIENT", "ID").Integer()
+	otherID := other.do("CLIENT", "ID").Integer()
+	assert.Greater(otherID, id)
+
+	assert.Equal("", c.do("CLIENT", "GETNAME").String())
+	assert.Equal("OK", c.do("CLIENT", "SETNAME", "worker").String())
+	assert.Equal("worker", c.do("CLIENT", "GETNAME").String())
+	assert.True(c.do("CLIENT", "SETNAME", "two words").IsError())
+	c.do("SELECT", "3")
+
+	info := c.do("CLIENT", "INFO").String()
+	assert.Contains(info, "id="+strconv.FormatInt(id, 10)+" addr="+c.conn.LocalAddr().String())
+	assert.Contains(info, " name=worker ")
+	assert.Contains(info, " db=3 ")
+	assert.Contains(info, " cmd=client|info ")
+	assert.Contains(info, " user=default ")
+
+	lines := strings.Split(strings.TrimSuffix(c.do("CLIENT", "LIST").String(), "\n"), "\n")
+	assert.Len(lines, 2)
+	assert.True(strings.HasPrefix(lines[0], "id="+strconv.FormatInt(id, 10)+" "))
+	assert.Contains(lines[1], " cmd=client|id ")
+
+	lines = strings.Split(strings.TrimSuffix(c.do("CLIENT", "LIST", "ID", strconv.FormatInt(otherID, 10)).String(), "\n"), "\n")
+	assert.Len(lines, 1)
+	assert.Equal("", c.do("CLIENT", "LIST", "TYPE", "replica").String())
+	assert.Equal("ERR Unknown client type 'nosuch'", c.do("CLIENT", "LIST", "TYPE", "nosuch").String())
+
+	assert.Equal("OK", c.do("CLIENT", "NO-EVICT", "on").String())
+	assert.Contains(c.do("CLIENT", "INFO").String(), " flags=e ")
+}
+
+func Test_Client_Kill(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	first := dialTestServer(t, addr)
+	second := dialTestServer(t, addr)
+	first.do("PING")
+	second.do("PING")
+
+	assert.Equal("OK", c.do("CLIENT", "KILL", first.conn.LocalAddr().String()).String())
+	assert.Equal("ERR No such client", c.do("CLIENT", "KILL", "127.0.0.1:1").String())
+	_, err := first.reader.ReadByte()
+	assert.Error(err)
+
+	// the killing client is skipped unless asked otherwise
+	id := second.do("CLIENT", "ID").Integer()
+	assert.Equal(int64(0), second.do("CLIENT", "KILL", "ID", strconv.FormatInt(id, 10)).Integer())
+	assert.Equal(int64(1), second.do("CLIENT", "KILL", "ID", strconv.FormatInt(id, 10), "SKIPME", "no").Integer())
+	_, err = second.reader.ReadByte()
+	assert.Error(err)
+
+	assert.Equal(int64(0), c.do("CLIENT", "KILL", "TYPE", "normal").Integer())
+	assert.Equal("ERR syntax error", c.do("CLIENT", "KILL", "NOSUCH", "filter").String())
+}
+
+func Test_Client_Pause(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	writer := dialTestServer(t, addr)
+
+	assert.Equal("OK", c.do("CLIENT", "PAUSE", "100000", "WRITE").String())
+	// reads go on while writes wait for the pause to end
+	assert.Equal("", c.do("GET", "foo").String())
+
+	done := make(chan string)
+	go func() { done <- writer.do("SET", "foo", "bar").String() }()
+	select {
+	case <-done:
+		t.Fatal("write ran while clients were paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.Equal("OK", c.do("CLIENT", "UNPAUSE").String())
+	assert.Equal("OK", <-done)
+	assert.Equal("bar", c.do("GET", "foo").String())
+
+	// a pause ends by itself once its timeout passes
+	assert.Equal("OK", c.do("CLIENT", "PAUSE", "50").String())
+	start := time.Now()
+	assert.Equal("bar", writer.do("GET", "foo").String())
+	assert.GreaterOrEqual(time.Since(start), 40*time.Millisecond)
+}
+
+func Test_Hello(t *testing.T) {
+	assert := assert.New(t)
+	el, addr := startTestServer(t)
+	el.SetRequirePass("secret")
+	c := dialTestServer(t, addr)
+
+	assert.Equal("NOPROTO unsupported protocol version", c.do("HELLO", "4").String())
+	assert.True(strings.HasPrefix(c.do("HELLO", "3").String(), "NOAUTH HELLO must be called with the client already authenticated"))
+	assert.Equal("WRONGPASS invalid username-password pair or user is disabled.", c.do("HELLO", "3", "AUTH", "default", "wrong").String())
+
+	reply := c.do("HELLO", "3", "AUTH", "default", "secret", "SETNAME", "cache")
+	assert.Equal([]string{"server", "redis", "version", redisVersion, "proto"}, stringArray(reply.Array()[:5]))
+	assert.Equal(int64(3), reply.Array()[5].Integer())
+	assert.Contains(c.do("CLIENT", "INFO").String(), " name=cache ")
+	assert.Contains(c.do("CLIENT", "INFO").String(), " resp=3")
+
+	// without a version HELLO keeps the protocol and describes the server
+	assert.Equal(int64(3), c.do("HELLO").Array()[5].Integer())
+	assert.Equal(int64(2), c.do("HELLO", "2").Array()[5].Integer())
+}
@@ -0,0 +1,334 @@
+package eventloop
+
+import (
+	"fmt"
+	"noelzubin/redis-go/cluster"
+	"noelzubin/redis-go/protocol"
+	"strconv"
+	"strings"
+)
+
+// keys asked for at a time when walking the keyspace for the keys of a slot
+const slotScanCount = 100
+
+// EnableCluster turns on cluster mode. Commands touching keys in slots not
+// served by this node are redirected. It must be called before the loop is
+// started.
+func (e *Eventloop) EnableCluster(c *cluster.Cluster) {
+	e.cluster = c
+}
+
+// clusterRedirect checks that the keys of a command are served by this node.
+// If not it returns the redirection or error to reply with and false.
+func (e *Eventloop) clusterRedirect(cmd command, args []string, asking bool) (protocol.Value, bool) {
+	keys := cmd.keys(args)
+	if len(keys) == 0 {
+		return protocol.Value{}, true
+	}
+
+	slot := cluster.KeySlot(keys[0])
+	for _, k := range keys[1:] {
+		if cluster.KeySlot(k) != slot {
+			return protocol.NewErrorValue("CROSSSLOT Keys in request don't hash to the same slot"), false
+		}
+	}
+
+	owner := e.cluster.Owner(slot)
+	if owner == nil {
+		return protocol.NewErrorValue("CLUSTERDOWN Hash slot not served"), false
+	}
+
+	if owner != e.cluster.Myself() {
+		if asking && e.cluster.Importing(slot) != nil {
+			return protocol.Value{}, true
+		}
+		return protocol.NewErrorValue(fmt.Sprintf("MOVED %d %s", slot, owner.Addr())), false
+	}
+
+	// keys that were already moved out of a migrating slot are asked for
+	// on the target
+	if target := e.cluster.Migrating(slot); target != nil {
+		missing := 0
+		for _, k := range keys {
+			// a routing check, not an access to the key
+			if _, ok := e.dbs[0].Peek(k); !ok {
+				missing++
+			}
+		}
+
+		if missing == len(keys) {
+			return protocol.NewErrorValue(fmt.Sprintf("ASK %d %s", slot, target.Addr())), false
+		}
+		if missing > 0 {
+			return protocol.NewErrorValue("TRYAGAIN Multiple keys request during rehashing of slot"), false
+		}
+	}
+
+	return protocol.Value{}, true
+}
+
+// keysInSlot returns up to limit keys of this node that hash to slot, or all
+// of them if limit is negative. The keyspace is walked with a cursor rather
+// than copied, and keys are neither touched nor expired.
+func (e *Eventloop) keysInSlot(slot int, limit int) []string {
+	keys := make([]string, 0)
+	if limit == 0 {
+		return keys
+	}
+
+	var cursor uint64
+	for {
+		next, batch := e.dbs[0].Scan(cursor, slotScanCount)
+		for _, k := range batch {
+			if cluster.KeySlot(k) != slot {
+				continue
+			}
+			keys = append(keys, k)
+			if len(keys) == limit {
+				return keys
+			}
+		}
+		if next == 0 {
+			return keys
+		}
+		cursor = next
+	}
+}
+
+func (e *Eventloop) clusterCommand(args []string) protocol.Value {
+	if e.cluster == nil {
+		return protocol.NewErrorValue("ERR This instance has cluster support disabled")
+	}
+
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'cluster' command")
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "info":
+		return protocol.NewBulkStringValue(e.clusterInfo())
+	case "myid":
+		return protocol.NewBulkStringValue(e.cluster.Myself().ID)
+	case "nodes":
+		return protocol.NewBulkStringValue(e.clusterNodes())
+	case "slots":
+		return e.clusterSlots()
+	case "shards":
+		return e.clusterShards()
+	case "keyslot":
+		if len(args) != 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'cluster|keyslot' command")
+		}
+		return protocol.NewSimpleIntValue(int64(cluster.KeySlot(args[2])))
+	case "countkeysinslot":
+		if len(args) != 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'cluster|countkeysinslot' command")
+		}
+		slot, err := cluster.ParseSlot(args[2])
+		if err != nil {
+			return protocol.NewErrorValue("ERR Invalid slot")
+		}
+		return protocol.NewSimpleIntValue(int64(len(e.keysInSlot(slot, -1))))
+	case "getkeysinslot":
+		if len(args) != 4 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'cluster|getkeysinslot' command")
+		}
+		slot, err := cluster.ParseSlot(args[2])
+		if err != nil {
+			return protocol.NewErrorValue("ERR Invalid slot")
+		}
+		count, err := strconv.Atoi(args[3])
+		if err != nil || count < 0 {
+			return protocol.NewErrorValue("ERR Invalid number of keys")
+		}
+		return protocol.NewArrayBulkStringValue(e.keysInSlot(slot, count))
+	case "setslot":
+		return e.clusterSetSlot(args)
+	}
+
+	return protocol.NewErrorValue("ERR unknown subcommand '" + args[1] + "'. Try CLUSTER HELP.")
+}
+
+// clusterSetSlot moves slots between nodes. To move a slot, the target is
+// marked IMPORTING and the source MIGRATING, the keys are moved with
+// MIGRATE, and then every node is told the new owner with NODE.
+func (e *Eventloop) clusterSetSlot(args []string) protocol.Value {
+	if len(args) < 4 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'cluster|setslot' command")
+	}
+
+	slot, err := cluster.ParseSlot(args[2])
+	if err != nil {
+		return protocol.NewErrorValue("ERR Invalid or out of range slot")
+	}
+
+	myself := e.cluster.Myself()
+	action := strings.ToLower(args[3])
+
+	if action == "stable" {
+		e.cluster.SetStable(slot)
+		return protocol.NewSimpleStringValue(&OK)
+	}
+
+	if len(args) != 5 {
+		return protocol.NewErrorValue("ERR syntax error")
+	}
+
+	node := e.cluster.Node(args[4])
+	if node == nil {
+		return protocol.NewErrorValue("ERR I don't know about node " + args[4])
+	}
+
+	switch action {
+	case "migrating":
+		if e.cluster.Owner(slot) != myself {
+			return protocol.NewErrorValue(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot))
+		}
+		if node == myself {
+			return protocol.NewErrorValue("ERR Target node is myself")
+		}
+		e.cluster.SetMigrating(slot, node)
+	case "importing":
+		if e.cluster.Owner(slot) == myself {
+			return protocol.NewErrorValue(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot))
+		}
+		if node == myself {
+			return protocol.NewErrorValue("ERR Source node is myself")
+		}
+		e.cluster.SetImporting(slot, node)
+	case "node":
+		if e.cluster.Owner(slot) == myself && node != myself && len(e.keysInSlot(slot, 1)) > 0 {
+			return protocol.NewErrorValue(fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+		}
+		e.cluster.Assign(slot, node)
+		if err := e.cluster.Save(); err != nil {
+			fmt.Println("error saving the cluster config: ", err.Error())
+		}
+	default:
+		return protocol.NewErrorValue("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+func (e *Eventloop) clusterState() string {
+	if e.cluster.AssignedSlots() == cluster.SlotCount {
+		return "ok"
+	}
+	return "fail"
+}
+
+func (e *Eventloop) clusterInfo() string {
+	assigned := e.cluster.AssignedSlots()
+	size := 0
+	for _, n := range e.cluster.Nodes() {
+		if len(e.cluster.SlotRanges(n)) > 0 {
+			size++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_enabled:1\r\n")
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", e.clusterState())
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_pfail:0\r\n")
+	fmt.Fprintf(&b, "cluster_slots_fail:0\r\n")
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(e.cluster.Nodes()))
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", size)
+	return b.String()
+}
+
+// clusterNodes renders the nodes in the Redis nodes.conf format
+func (e *Eventloop) clusterNodes() string {
+	var b strings.Builder
+	myself := e.cluster.Myself()
+
+	for _, n := range e.cluster.Nodes() {
+		flags := "master"
+		if n == myself {
+			flags = "myself,master"
+		}
+
+		fmt.Fprintf(&b, "%s %s@%d %s - 0 0 0 connected", n.ID, n.Addr(), n.Port+10000, flags)
+		for _, r := range e.cluster.SlotRanges(n) {
+			if r.Start == r.End {
+				fmt.Fprintf(&b, " %d", r.Start)
+			} else {
+				fmt.Fprintf(&b, " %d-%d", r.Start, r.End)
+			}
+		}
+
+		if n == myself {
+			for slot, target := range e.cluster.MigratingSlots() {
+				fmt.Fprintf(&b, " [%d->-%s]", slot, target.ID)
+			}
+			for slot, source := range e.cluster.ImportingSlots() {
+				fmt.Fprintf(&b, " [%d-<-%s]", slot, source.ID)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func (e *Eventloop) clusterSlots() protocol.Value {
+	slots := make([]protocol.Value, 0)
+
+	for _, n := range e.cluster.Nodes() {
+		for _, r := range e.cluster.SlotRanges(n) {
+			slots = append(slots, protocol.NewArrayValue([]protocol.Value{
+				protocol.NewSimpleIntValue(int64(r.Start)),
+				protocol.NewSimpleIntValue(int64(r.End)),
+				protocol.NewArrayValue([]protocol.Value{
+					protocol.NewBulkStringValue(n.Host),
+					protocol.NewSimpleIntValue(int64(n.Port)),
+					protocol.NewBulkStringValue(n.ID),
+				}),
+			}))
+		}
+	}
+
+	return protocol.NewArrayValue(slots)
+}
+
+func (e *Eventloop) clusterShards() protocol.Value {
+	shards := make([]protocol.Value, 0)
+
+	for _, n := range e.cluster.Nodes() {
+		ranges := make([]protocol.Value, 0)
+		for _, r := range e.cluster.SlotRanges(n) {
+			ranges = append(ranges, protocol.NewSimpleIntValue(int64(r.Start)), protocol.NewSimpleIntValue(int64(r.End)))
+		}
+
+		offset := int64(0)
+		if n == e.cluster.Myself() {
+			offset = e.repl.offset.Load()
+		}
+
+		node := protocol.NewArrayValue([]protocol.Value{
+			protocol.NewBulkStringValue("id"), protocol.NewBulkStringValue(n.ID),
+			protocol.NewBulkStringValue("port"), protocol.NewSimpleIntValue(int64(n.Port)),
+			protocol.NewBulkStringValue("ip"), protocol.NewBulkStringValue(n.Host),
+			protocol.NewBulkStringValue("endpoint"), protocol.NewBulkStringValue(n.Host),
+			protocol.NewBulkStringValue("role"), protocol.NewBulkStringValue("master"),
+			protocol.NewBulkStringValue("replication-offset"), protocol.NewSimpleIntValue(offset),
+			protocol.NewBulkStringValue("health"), protocol.NewBulkStringValue("online"),
+		})
+
+		shards = append(shards, protocol.NewArrayValue([]protocol.Value{
+			protocol.NewBulkStringValue("slots"), protocol.NewArrayValue(ranges),
+			protocol.NewBulkStringValue("nodes"), protocol.NewArrayValue([]protocol.Value{node}),
+		}))
+	}
+
+	return protocol.NewArrayValue(shards)
+}
+
+func (e *Eventloop) infoCluster() string {
+	if e.cluster == nil {
+		return "cluster_enabled:0\r\n"
+	}
+	return "cluster_enabled:1\r\n"
+}
@@ -0,0 +1,121 @@
+package eventloop
+
+import (
+	"fmt"
+	"net"
+	"noelzubin/redis-go/cluster"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestCluster runs two nodes splitting the slots at 8192
+func startTestCluster(t *testing.T) (*testConn, *testConn, string, string) {
+	la, lb := listenTest(t), listenTest(t)
+	config := fmt.Sprintf("node-a %s 0-8191\nnode-b %s 8192-16383\n", la.Addr(), lb.Addr())
+
+	dir := t.TempDir()
+	for _, l := range []net.Listener{la, lb} {
+		port := l.Addr().(*net.TCPAddr).Port
+		path := filepath.Join(dir, strconv.Itoa(port)+".conf")
+		os.WriteFile(path, []byte(config), 0644)
+
+		c, err := cluster.Load(path, port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serveTest(t, l, func(el *Eventloop) { el.EnableCluster(c) })
+	}
+
+	return dialTestServer(t, la.Addr().String()), dialTestServer(t, lb.Addr().String()), la.Addr().String(), lb.Addr().String()
+}
+
+func Test_Cluster_Redirects(t *testing.T) {
+	assert := assert.New(t)
+	a, b, _, addrB := startTestCluster(t)
+
+	// "foo" hashes to slot 12182, served by node b
+	assert.Equal("MOVED 12182 "+addrB, a.do("SET", "foo", "bar").String())
+	assert.Equal("OK", b.do("SET", "foo", "bar").String())
+	assert.Equal("OK", b.do("SET", "{foo}", "bar").String())
+	assert.Equal(int64(12182), a.do("CLUSTER", "KEYSLOT", "foo").Integer())
+
+	assert.Equal("CROSSSLOT Keys in request don't hash to the same slot", b.do("DEL", "foo", "bar").String())
+	assert.Equal(int64(1), b.do("DEL", "{foo}", "{foo}.b").Integer())
+
+	slots := a.do("CLUSTER", "SLOTS").Array()
+	assert.Equal(2, len(slots))
+	assert.Contains(b.do("CLUSTER", "NODES").String(), "node-b "+addrB+"@")
+	assert.Contains(b.do("CLUSTER", "INFO").String(), "cluster_state:ok")
+	assert.Equal("node-a", a.do("CLUSTER", "MYID").String())
+}
+
+func Test_Cluster_Slot_Migration(t *testing.T) {
+	assert := assert.New(t)
+	a, b, addrA, addrB := startTestCluster(t)
+	hostA, portA := splitHostPort(t, addrA)
+
+	// move slot 12182 ("foo") from b to a
+	b.do("SET", "foo", "bar")
+	b.do("SET", "{foo}.2", "baz", "100")
+	assert.Equal(int64(2), b.do("CLUSTER", "COUNTKEYSINSLOT", "12182").Integer())
+
+	assert.Equal("OK", a.do("CLUSTER", "SETSLOT", "12182", "IMPORTING", "node-b").String())
+	assert.Equal("OK", b.do("CLUSTER", "SETSLOT", "12182", "MIGRATING", "node-a").String())
+
+	// keys not moved yet are still served by the source
+	assert.Equal("bar", b.do("GET", "foo").String())
+	assert.Equal("MOVED 12182 "+addrB, a.do("GET", "foo").String())
+
+	keys := b.do("CLUSTER", "GETKEYSINSLOT", "12182", "10").Array()
+	assert.Equal(2, len(keys))
+	assert.Equal("OK", b.do("MIGRATE", hostA, portA, "", "0", "1000", "KEYS", keys[0].String(), keys[1].String()).String())
+
+	// moved keys are asked for on the target
+	assert.Equal("ASK 12182 "+addrA, b.do("GET", "foo").String())
+	assert.Equal("OK", a.do("ASKING").String())
+	assert.Equal("bar", a.do("GET", "foo").String())
+	// ASKING only applies to the next command
+	assert.Equal("MOVED 12182 "+addrB, a.do("GET", "foo").String())
+
+	for _, c := range []*testConn{a, b} {
+		assert.Equal("OK", c.do("CLUSTER", "SETSLOT", "12182", "NODE", "node-a").String())
+	}
+
+	assert.Equal("bar", a.do("GET", "foo").String())
+	assert.Equal("baz", a.do("GET", "{foo}.2").String())
+	assert.Equal("MOVED 12182 "+addrA, b.do("GET", "foo").String())
+	assert.Equal(int64(0), b.do("CLUSTER", "COUNTKEYSINSLOT", "12182").Integer())
+}
+
+func Test_Cluster_Keys_In_Slot(t *testing.T) {
+	assert := assert.New(t)
+	_, b, _, _ := startTestCluster(t)
+
+	// more keys than a step of the walk over the keyspace
+	for i := 0; i < 250; i++ {
+		b.do("SET", "{foo}."+strconv.Itoa(i), "v")
+	}
+	b.do("SET", "other", "v")
+
+	assert.Equal(int64(250), b.do("CLUSTER", "COUNTKEYSINSLOT", "12182").Integer())
+	assert.Len(b.do("CLUSTER", "GETKEYSINSLOT", "12182", "1000").Array(), 250)
+	assert.Len(b.do("CLUSTER", "GETKEYSINSLOT", "12182", "10").Array(), 10)
+}
+
+func Test_Cluster_Redirect_Does_Not_Touch_Keys(t *testing.T) {
+	assert := assert.New(t)
+	_, b, _, _ := startTestCluster(t)
+
+	b.do("SET", "foo", "bar")
+	payload := b.do("DUMP", "foo").String()
+	assert.Equal("OK", b.do("RESTORE", "{foo}.idle", "0", payload, "IDLETIME", "1000").String())
+	assert.Equal("OK", b.do("CLUSTER", "SETSLOT", "12182", "MIGRATING", "node-a").String())
+
+	// checking which keys of a migrating slot are still here is no access
+	assert.Equal("TRYAGAIN Multiple keys request during rehashing of slot", b.do("DEL", "{foo}.idle", "{foo}.gone").String())
+	assert.GreaterOrEqual(b.do("OBJECT", "IDLETIME", "{foo}.idle").Integer(), int64(1000))
+}
@@ -0,0 +1,195 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/acl"
+	"strings"
+)
+
+// commandFlag describes a property of a command that the event loop needs to
+// know about independently of how the command is executed
+type commandFlag uint
+
+const (
+	// flagWrite marks commands that may modify the dataset
+	flagWrite commandFlag = 1 << iota
+	// flagAdmin marks server administration commands
+	flagAdmin
+	// flagNoAuth marks commands that can be run before authenticating
+	flagNoAuth
+	// flagDenyOOM marks commands that may grow the dataset, which are
+	// refused when it does not fit in maxmemory
+	flagDenyOOM
+	// flagKeysOnly marks commands that touch nothing but the keys they
+	// name, which the shards owning those keys can run
+	flagKeysOnly
+)
+
+// command holds the static properties of a command
+type command struct {
+	name  string
+	flags commandFlag
+	// ACL categories, separated by spaces
+	categories string
+	// subcommands that ACL rules can allow or deny on their own
+	subcommands []string
+	// position of the first and last key argument and the step between
+	// keys. A negative lastKey counts from the end, zero firstKey means
+	// the command takes no keys.
+	firstKey int
+	lastKey  int
+	step     int
+	// getKeys finds the keys of commands whose keys are not at fixed
+	// positions
+	getKeys func(args []string) []string
+	// channels returns the channels the command publishes or subscribes to
+	channels func(args []string) []string
+}
+
+var commandTable = map[string]command{
+	"ping":           {name: "ping", categories: "fast connection"},
+	"get":            {name: "get", flags: flagKeysOnly, categories: "read string fast", firstKey: 1, lastKey: 1, step: 1},
+	"set":            {name: "set", flags: flagWrite | flagDenyOOM | flagKeysOnly, categories: "write string slow", firstKey: 1, lastKey: 1, step: 1},
+	"del":            {name: "del", flags: flagWrite | flagKeysOnly, categories: "write keyspace slow", firstKey: 1, lastKey: -1, step: 1},
+	"unlink":         {name: "unlink", flags: flagWrite | flagKeysOnly, categories: "write keyspace fast", firstKey: 1, lastKey: -1, step: 1},
+	"exists":         {name: "exists", flags: flagKeysOnly, categories: "read keyspace fast", firstKey: 1, lastKey: -1, step: 1},
+	"type":           {name: "type", flags: flagKeysOnly, categories: "read keyspace fast", firstKey: 1, lastKey: 1, step: 1},
+	"rename":         {name: "rename", flags: flagWrite | flagKeysOnly, categories: "write keyspace slow", firstKey: 1, lastKey: 2, step: 1},
+	"renamenx":       {name: "renamenx", flags: flagWrite | flagKeysOnly, categories: "write keyspace fast", firstKey: 1, lastKey: 2, step: 1},
+	"copy":           {name: "copy", flags: flagWrite | flagDenyOOM | flagKeysOnly, categories: "write keyspace slow", firstKey: 1, lastKey: 2, step: 1},
+	"touch":          {name: "touch", flags: flagKeysOnly, categories: "read keyspace fast", firstKey: 1, lastKey: -1, step: 1},
+	"randomkey":      {name: "randomkey", categories: "read keyspace slow"},
+	"expire":         {name: "expire", flags: flagWrite | flagKeysOnly, categories: "write keyspace fast", firstKey: 1, lastKey: 1, step: 1},
+	"pexpireat":      {name: "pexpireat", flags: flagWrite | flagKeysOnly, categories: "write keyspace fast", firstKey: 1, lastKey: 1, step: 1},
+	"keys":           {name: "keys", categories: "read keyspace slow dangerous"},
+	"scan":           {name: "scan", categories: "read keyspace slow"},
+	"zscan":          {name: "zscan", flags: flagKeysOnly, categories: "read sortedset slow", firstKey: 1, lastKey: 1, step: 1},
+	"zadd":           {name: "zadd", flags: flagWrite | flagDenyOOM | flagKeysOnly, categories: "write sortedset fast", firstKey: 1, lastKey: 1, step: 1},
+	"zrange":         {name: "zrange", flags: flagKeysOnly, categories: "read sortedset slow", firstKey: 1, lastKey: 1, step: 1},
+	"bgrewriteaof":   {name: "bgrewriteaof", flags: flagAdmin, categories: "admin slow dangerous"},
+	"shutdown":       {name: "shutdown", flags: flagAdmin, categories: "admin slow dangerous"},
+	"replicaof":      {name: "replicaof", flags: flagAdmin, categories: "admin slow dangerous"},
+	"slaveof":        {name: "slaveof", flags: flagAdmin, categories: "admin slow dangerous"},
+	"replconf":       {name: "replconf", flags: flagAdmin, categories: "admin slow dangerous"},
+	"psync":          {name: "psync", flags: flagAdmin, categories: "admin slow dangerous"},
+	"role":           {name: "role", categories: "admin fast dangerous"},
+	"info":           {name: "info", categories: "slow dangerous"},
+	"cluster":        {name: "cluster", categories: "slow", subcommands: []string{"info", "myid", "nodes", "slots", "shards", "keyslot", "countkeysinslot", "getkeysinslot", "setslot"}},
+	"asking":         {name: "asking", categories: "fast connection"},
+	"dump":           {name: "dump", flags: flagKeysOnly, categories: "read keyspace slow", firstKey: 1, lastKey: 1, step: 1},
+	"restore":        {name: "restore", flags: flagWrite | flagDenyOOM | flagKeysOnly, categories: "write keyspace slow dangerous", firstKey: 1, lastKey: 1, step: 1},
+	"restore-asking": {name: "restore-asking", flags: flagWrite | flagDenyOOM, categories: "write keyspace slow dangerous", firstKey: 1, lastKey: 1, step: 1},
+	"migrate":        {name: "migrate", flags: flagWrite, categories: "write keyspace slow dangerous", getKeys: migrateKeys},
+	"select":         {name: "select", categories: "fast connection"},
+	"swapdb":         {name: "swapdb", flags: flagWrite, categories: "write keyspace fast dangerous"},
+	"move":           {name: "move", flags: flagWrite | flagKeysOnly, categories: "write keyspace fast", firstKey: 1, lastKey: 1, step: 1},
+	"object":         {name: "object", categories: "read keyspace slow", subcommands: []string{"encoding", "refcount", "idletime", "freq", "help"}, firstKey: 2, lastKey: 2, step: 1},
+	"memory":         {name: "memory", categories: "read slow", subcommands: []string{"usage", "stats", "doctor", "help"}, getKeys: memoryKeys},
+	"dbsize":         {name: "dbsize", categories: "read keyspace fast"},
+	"flushdb":        {name: "flushdb", flags: flagWrite, categories: "write keyspace slow dangerous"},
+	"flushall":       {name: "flushall", flags: flagWrite, categories: "write keyspace slow dangerous"},
+	"auth":           {name: "auth", flags: flagNoAuth, categories: "fast connection"},
+	"client":         {name: "client", categories: "slow connection", subcommands: []string{"id", "info", "list", "setname", "getname", "kill", "pause", "unpause", "no-evict", "tracking", "caching", "trackinginfo", "getredir"}},
+	"hello":          {name: "hello", flags: flagNoAuth, categories: "fast connection"},
+	"subscribe":      {name: "subscribe", categories: "pubsub slow", channels: func(args []string) []string { return args[1:] }},
+	"unsubscribe":    {name: "unsubscribe", categories: "pubsub slow"},
+	"publish":        {name: "publish", categories: "pubsub fast", channels: func(args []string) []string { return args[1:2] }},
+	"config":         {name: "config", flags: flagAdmin, categories: "admin slow dangerous", subcommands: []string{"get", "set", "rewrite", "resetstat"}},
+	"monitor":        {name: "monitor", flags: flagAdmin, categories: "admin slow dangerous"},
+	"slowlog":        {name: "slowlog", flags: flagAdmin, categories: "admin slow dangerous", subcommands: []string{"get", "len", "reset"}},
+	"latency":        {name: "latency", flags: flagAdmin, categories: "admin slow dangerous", subcommands: []string{"latest", "history", "reset", "histogram"}},
+	"acl":            {name: "acl", flags: flagAdmin, categories: "admin slow dangerous", subcommands: []string{"setuser", "getuser", "deluser", "list", "users", "whoami", "cat", "log", "dryrun", "load", "save", "genpass"}},
+}
+
+// lookupCommand finds a command by name, ignoring case
+func lookupCommand(name string) (command, bool) {
+	cmd, ok := commandTable[strings.ToLower(name)]
+	return cmd, ok
+}
+
+func (c command) isWrite() bool {
+	return c.flags&flagWrite != 0
+}
+
+// isRead reports whether the command reads keys, whose values clients may
+// then cache
+func (c command) isRead() bool {
+	return c.hasCategory("read")
+}
+
+// hasCategory reports whether the command is in an ACL category, without
+// splitting its categories
+func (c command) hasCategory(name string) bool {
+	rest := c.categories
+	for rest != "" {
+		var category string
+		category, rest, _ = strings.Cut(rest, " ")
+		if category == name {
+			return true
+		}
+	}
+	return false
+}
+
+// keys returns the key arguments of a call to the command
+func (c command) keys(args []string) []string {
+	if c.getKeys != nil {
+		return c.getKeys(args)
+	}
+	if c.firstKey == 0 || c.firstKey >= len(args) {
+		return nil
+	}
+
+	last := c.lastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+
+	keys := make([]string, 0, (last-c.firstKey)/c.step+1)
+	for i := c.firstKey; i <= last; i += c.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// migrateKeys returns the key argument of MIGRATE, or the keys after KEYS
+func migrateKeys(args []string) []string {
+	if len(args) < 6 {
+		return nil
+	}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "keys":
+			return args[i+1:]
+		// skip the credentials, which could read KEYS
+		case "auth":
+			i++
+		case "auth2":
+			i += 2
+		}
+	}
+	return args[3:4]
+}
+
+// memoryKeys returns the key argument of MEMORY USAGE
+func memoryKeys(args []string) []string {
+	if len(args) < 3 || !strings.EqualFold(args[1], "usage") {
+		return nil
+	}
+	return args[2:3]
+}
+
+// aclCommands describes the command table to the ACL system
+func aclCommands() []acl.Command {
+	commands := make([]acl.Command, 0, len(commandTable))
+	for _, c := range commandTable {
+		commands = append(commands, acl.Command{
+			Name:        c.name,
+			Categories:  strings.Fields(c.categories),
+			Subcommands: c.subcommands,
+		})
+	}
+	return commands
+}
@@ -0,0 +1,178 @@
+package eventloop
+
+import (
+	"errors"
+	"math"
+	"noelzubin/redis-go/config"
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/store"
+	"strconv"
+	"strings"
+)
+
+// defaultHz is how many times per second the cleanup runs by default
+const defaultHz = 10
+
+// defaultProtoMaxBulkLen is the longest bulk string a client may send by
+// default, 512mb as in Redis
+const defaultProtoMaxBulkLen = 512 << 20
+
+// RegisterConfig adds the params owned by the loop to cfg, which CONFIG
+// then works with instead of the config the loop was created with. It must
+// be called before the loop is started.
+func (e *Eventloop) RegisterConfig(cfg *config.Config) {
+	e.config = cfg
+
+	cfg.Register(
+		config.NewParam("hz", func() string {
+			return strconv.FormatInt(e.hz.Load(), 10)
+		}, func(value string) error {
+			hz, err := config.ParseInt(value, 1, 500)
+			if err != nil {
+				return err
+			}
+			e.hz.Store(int64(hz))
+			return nil
+		}).Mutable(),
+		e.expireCycleParam("active-expire-samples", &e.expireCycle.Samples, 1, 1000),
+		config.Int("slowlog-log-slower-than", &e.slowlog.slowerThan, -1, math.MaxInt32).Mutable(),
+		config.Int("slowlog-max-len", &e.slowlog.maxLen, 0, math.MaxInt32).Mutable(),
+		config.NewParam("latency-monitor-threshold", func() string {
+			return strconv.FormatInt(e.latency.getThreshold(), 10)
+		}, func(value string) error {
+			ms, err := config.ParseInt(value, 0, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			e.latency.setThreshold(int64(ms))
+			return nil
+		}).Mutable(),
+		e.expireCycleParam("active-expire-threshold", &e.expireCycle.Threshold, 1, 100),
+		e.expireCycleParam("active-expire-effort", &e.expireCycle.Effort, 1, 10),
+		config.Memory("maxmemory", &e.evict.maxmemory).Mutable(),
+		config.NewParam("proto-max-bulk-len", func() string {
+			return strconv.FormatInt(e.protoMaxBulkLen.Load(), 10)
+		}, func(value string) error {
+			n, err := config.ParseMemory(value)
+			if err != nil {
+				return err
+			}
+			if n < 1<<20 || n > math.MaxInt32*2 {
+				return errors.New("argument must be between 1048576 and 4294967294 inclusive")
+			}
+			e.protoMaxBulkLen.Store(n)
+			return nil
+		}).Mutable(),
+		config.Enum("maxmemory-policy", &e.evict.policy, evictionPolicies...).Mutable(),
+		config.Int("maxmemory-samples", &e.evict.samples, 1, 64).Mutable(),
+		config.Int("shutdown-timeout", &e.shutdownTimeout, 0, math.MaxInt32).Mutable(),
+		config.NewParam("requirepass", func() string {
+			return e.requirePass
+		}, func(value string) error {
+			e.SetRequirePass(value)
+			return nil
+		}).Mutable(),
+		config.NewParam("masteruser", func() string {
+			user, _ := e.repl.masterAuthInfo()
+			return user
+		}, func(value string) error {
+			_, password := e.repl.masterAuthInfo()
+			e.SetMasterAuth(value, password)
+			return nil
+		}).Mutable(),
+		config.NewParam("masterauth", func() string {
+			_, password := e.repl.masterAuthInfo()
+			return password
+		}, func(value string) error {
+			user, _ := e.repl.masterAuthInfo()
+			e.SetMasterAuth(user, value)
+			return nil
+		}).Mutable(),
+	)
+}
+
+// expireCycleParam creates a param for a field of the active expiry cycle,
+// which is handed to the databases whenever it changes
+func (e *Eventloop) expireCycleParam(name string, v *int, min, max int) *config.Param {
+	return config.NewParam(name, func() string {
+		return strconv.Itoa(*v)
+	}, func(value string) error {
+		n, err := config.ParseInt(value, min, max)
+		if err != nil {
+			return err
+		}
+		*v = n
+		e.applyExpireCycle()
+		return nil
+	}).Mutable()
+}
+
+// applyExpireCycle hands the active expiry params to every database
+func (e *Eventloop) applyExpireCycle() {
+	for _, db := range e.dbs {
+		db.SetExpireCycle(e.expireCycle)
+	}
+}
+
+func (e *Eventloop) configCommand(args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'config' command")
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "get":
+		if len(args) < 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'config|get' command")
+		}
+		values := make([]string, 0)
+		for _, pair := range e.config.Get(args[2:]...) {
+			values = append(values, pair[0], pair[1])
+		}
+		return protocol.NewArrayStringValue(values)
+	case "set":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'config|set' command")
+		}
+		if err := e.config.Set(args[2:]...); err != nil {
+			return protocol.NewErrorValue("ERR " + err.Error())
+		}
+		return protocol.NewSimpleStringValue(&OK)
+	case "rewrite":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'config|rewrite' command")
+		}
+		if err := e.config.Rewrite(); err != nil {
+			return protocol.NewErrorValue("ERR Rewriting config file: " + err.Error())
+		}
+		return protocol.NewSimpleStringValue(&OK)
+	case "resetstat":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'config|resetstat' command")
+		}
+		e.resetStats()
+		return protocol.NewSimpleStringValue(&OK)
+	}
+
+	return protocol.NewErrorValue("ERR unknown subcommand '" + args[1] + "'. Try CONFIG HELP.")
+}
+
+// resetStats zeroes the counters reported by INFO
+func (e *Eventloop) resetStats() {
+	e.stats.reset(e.shards)
+	for _, db := range e.dbs {
+		db.ResetStats()
+	}
+	e.repl.syncFull.Store(0)
+	e.repl.syncPartialOk.Store(0)
+	e.repl.syncPartialErr.Store(0)
+	e.lazyfree.freed.Store(0)
+	e.expire.timeCapReached = 0
+	e.expire.cpuTime = 0
+}
+
+// SetDatabases replaces the databases of the loop. It must be called before
+// the loop is started.
+func (e *Eventloop) SetDatabases(dbs ...store.Store) {
+	e.dbs = dbs
+	e.applyExpireCycle()
+}
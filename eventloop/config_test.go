@@ -0,0 +1,49 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/config"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Config(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	assert.NoError(os.WriteFile(path, []byte("# loop params\nhz 20\n"), 0644))
+
+	l := listenTest(t)
+	el := serveTest(t, l, func(el *Eventloop) {
+		cfg := config.New()
+		el.RegisterConfig(cfg)
+		assert.NoError(cfg.Load([]string{path, "--active-expire-samples", "40"}))
+	})
+	c := dialTestServer(t, l.Addr().String())
+
+	assert.Equal([]string{"hz", "20"}, stringArray(c.do("CONFIG", "GET", "hz").Array()))
+	assert.Equal([]string{"active-expire-effort", "1", "active-expire-samples", "40", "active-expire-threshold", "25"},
+		stringArray(c.do("CONFIG", "GET", "active-expire-*").Array()))
+
+	assert.Equal("OK", c.do("CONFIG", "SET", "hz", "50", "active-expire-threshold", "10").String())
+	assert.Equal(int64(50), el.hz.Load())
+	assert.Equal("ERR CONFIG SET failed (possibly related to argument 'hz') - argument must be between 1 and 500 inclusive",
+		c.do("CONFIG", "SET", "hz", "0").String())
+	assert.Equal("ERR Unknown option or number of arguments for CONFIG SET - 'nosuch'",
+		c.do("CONFIG", "SET", "nosuch", "1").String())
+
+	// requirepass applies to new connections straight away
+	assert.Equal("OK", c.do("CONFIG", "SET", "requirepass", "secret").String())
+	other := dialTestServer(t, l.Addr().String())
+	assert.Equal("NOAUTH Authentication required.", other.do("GET", "foo").String())
+	assert.Equal("OK", other.do("AUTH", "secret").String())
+
+	assert.Equal("OK", c.do("CONFIG", "REWRITE").String())
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("# loop params\nhz 50\n\n# Generated by CONFIG REWRITE\n"+
+		"active-expire-samples 40\nactive-expire-threshold 10\nrequirepass secret\n", string(data))
+
+	assert.Equal("OK", c.do("CONFIG", "RESETSTAT").String())
+}
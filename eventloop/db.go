@@ -0,0 +1,109 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/store"
+	"strconv"
+	"strings"
+)
+
+// dbIndex parses the index of a database
+func (e *Eventloop) dbIndex(arg string) (int, protocol.Value, bool) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, protocol.NewErrorValue("ERR value is not an integer or out of range"), false
+	}
+	if index < 0 || index >= len(e.dbs) {
+		return 0, protocol.NewErrorValue("ERR DB index is out of range"), false
+	}
+	return index, protocol.Value{}, true
+}
+
+func (e *Eventloop) selectDB(c *client, args []string) protocol.Value {
+	if len(args) != 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'select' command")
+	}
+
+	index, errResp, ok := e.dbIndex(args[1])
+	if !ok {
+		return errResp
+	}
+	if e.cluster != nil && index != 0 {
+		return protocol.NewErrorValue("ERR SELECT is not allowed in cluster mode")
+	}
+
+	c.db = index
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+// swapDB exchanges two databases. Connections keep their selected index, so
+// they see the other dataset straight away.
+func (e *Eventloop) swapDB(args []string) protocol.Value {
+	if len(args) != 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'swapdb' command")
+	}
+	if e.cluster != nil {
+		return protocol.NewErrorValue("ERR SWAPDB is not allowed in cluster mode")
+	}
+
+	first, errResp, ok := e.dbIndex(args[1])
+	if !ok {
+		return errResp
+	}
+	second, errResp, ok := e.dbIndex(args[2])
+	if !ok {
+		return errResp
+	}
+
+	e.dbs[first], e.dbs[second] = e.dbs[second], e.dbs[first]
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+// move moves a key to another database, unless it already exists there
+func (e *Eventloop) move(c *client, args []string) protocol.Value {
+	if len(args) != 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'move' command")
+	}
+	if e.cluster != nil {
+		return protocol.NewErrorValue("ERR MOVE is not allowed in cluster mode")
+	}
+
+	target, errResp, ok := e.dbIndex(args[2])
+	if !ok {
+		return errResp
+	}
+	if target == c.db {
+		return protocol.NewErrorValue("ERR source and destination objects are the same")
+	}
+
+	src, dst := e.dbs[c.db], e.dbs[target]
+	value, ok := src.GetValue(args[1])
+	if !ok {
+		return protocol.NewSimpleIntValue(0)
+	}
+	if _, exists := dst.GetValue(args[1]); exists {
+		return protocol.NewSimpleIntValue(0)
+	}
+
+	dst.SetValue(args[1], value)
+	src.Del(args[1])
+	return protocol.NewSimpleIntValue(1)
+}
+
+// flush empties dbs. Dropping a keyspace is O(1) with SYNC and ASYNC
+// alike, the memory being reclaimed by the garbage collector in the
+// background.
+func (e *Eventloop) flush(args []string, dbs []store.Store) protocol.Value {
+	switch {
+	case len(args) == 1:
+	case len(args) == 2 && (strings.EqualFold(args[1], "async") || strings.EqualFold(args[1], "sync")):
+	default:
+		return protocol.NewErrorValue("ERR syntax error")
+	}
+
+	for _, db := range dbs {
+		db.Flush()
+	}
+
+	return protocol.NewSimpleStringValue(&OK)
+}
@@ -0,0 +1,95 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/aof"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Databases(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	other := dialTestServer(t, addr)
+
+	c.do("SET", "foo", "zero")
+	assert.Equal("OK", c.do("SELECT", "1").String())
+	assert.Equal("", c.do("GET", "foo").String())
+	c.do("SET", "foo", "one")
+	c.do("SET", "bar", "one")
+	assert.Equal(int64(2), c.do("DBSIZE").Integer())
+
+	// the selected database is per connection
+	assert.Equal("zero", other.do("GET", "foo").String())
+	assert.Equal(int64(1), other.do("DBSIZE").Integer())
+
+	assert.Equal("ERR DB index is out of range", c.do("SELECT", "16").String())
+	assert.Equal("ERR value is not an integer or out of range", c.do("SELECT", "one").String())
+
+	// MOVE leaves keys that already exist in the target alone
+	assert.Equal(int64(0), c.do("MOVE", "foo", "0").Integer())
+	assert.Equal(int64(1), c.do("MOVE", "bar", "0").Integer())
+	assert.Equal(int64(0), c.do("MOVE", "missing", "0").Integer())
+	assert.Equal("one", other.do("GET", "bar").String())
+	assert.Equal("", c.do("GET", "bar").String())
+	assert.True(c.do("MOVE", "foo", "1").IsError())
+
+	// SWAPDB swaps the data under connections that stay on their index
+	assert.Equal("OK", c.do("SWAPDB", "0", "1").String())
+	assert.Equal("zero", c.do("GET", "foo").String())
+	assert.Equal("one", other.do("GET", "foo").String())
+
+	assert.Equal("OK", c.do("FLUSHDB", "ASYNC").String())
+	assert.Equal(int64(0), c.do("DBSIZE").Integer())
+	assert.Equal(int64(1), other.do("DBSIZE").Integer())
+	assert.True(c.do("FLUSHDB", "LATER").IsError())
+
+	c.do("SET", "foo", "again")
+	assert.Equal("OK", c.do("FLUSHALL").String())
+	assert.Equal(int64(0), c.do("DBSIZE").Integer())
+	assert.Equal(int64(0), other.do("DBSIZE").Integer())
+}
+
+func Test_Databases_AOF(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	l := listenTest(t)
+	serveTest(t, l, func(el *Eventloop) {
+		assert.NoError(el.EnableAOF(path, aof.FsyncAlways))
+	})
+	c := dialTestServer(t, l.Addr().String())
+
+	c.do("SET", "foo", "zero")
+	c.do("SELECT", "2")
+	c.do("SET", "foo", "two")
+	c.do("SELECT", "0")
+	c.do("DEL", "foo")
+	c.do("SELECT", "5")
+	c.do("SET", "foo", "five")
+	c.do("SWAPDB", "5", "6")
+
+	reloaded := testDBs(testDatabases)
+	el := InitEventloop(reloaded...)
+	assert.NoError(el.EnableAOF(path, aof.FsyncNo))
+	el.aof.Close()
+
+	assert.Nil(reloaded[0].Get("foo"))
+	assert.Equal("two", *reloaded[2].Get("foo"))
+	assert.Nil(reloaded[5].Get("foo"))
+	assert.Equal("five", *reloaded[6].Get("foo"))
+}
+
+func Test_WriteSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	dbs := testDBs(3)
+	dbs[1].Set("foo", "bar", nil)
+	el := InitEventloop(dbs...)
+
+	var b strings.Builder
+	assert.NoError(writeSnapshot(&b, el.snapshot()))
+	assert.Equal(string(aof.Encode([]string{"SELECT", "1"}))+string(aof.Encode([]string{"SET", "foo", "bar"})), b.String())
+}
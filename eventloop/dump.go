@@ -0,0 +1,305 @@
+package eventloop
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/rdb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dump serializes the value of a key in the format RESTORE reads
+func (e *Eventloop) dump(c *client, args []string) protocol.Value {
+	if len(args) != 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'dump' command")
+	}
+
+	value, ok := e.dbs[c.db].GetValue(args[1])
+	if !ok {
+		return protocol.NewNilValue()
+	}
+	return protocol.NewBulkStringValue(string(rdb.Dump(value)))
+}
+
+// restoreOptions are the options of RESTORE after the payload
+type restoreOptions struct {
+	replace bool
+	// the ttl is a unix time in milliseconds rather than a duration
+	absTTL bool
+	// idle time in seconds and LFU counter to give the key, -1 if not set
+	idle int64
+	freq int64
+}
+
+func parseRestore(args []string) (restoreOptions, protocol.Value, bool) {
+	opts := restoreOptions{idle: -1, freq: -1}
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "replace":
+			opts.replace = true
+		case "absttl":
+			opts.absTTL = true
+		case "idletime":
+			if i+1 >= len(args) || opts.freq != -1 {
+				return opts, protocol.NewErrorValue("ERR syntax error"), false
+			}
+			idle, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, protocol.NewErrorValue("ERR value is not an integer or out of range"), false
+			}
+			if idle < 0 {
+				return opts, protocol.NewErrorValue("ERR Invalid IDLETIME value, must be >= 0"), false
+			}
+			opts.idle = idle
+			i++
+		case "freq":
+			if i+1 >= len(args) || opts.idle != -1 {
+				return opts, protocol.NewErrorValue("ERR syntax error"), false
+			}
+			freq, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, protocol.NewErrorValue("ERR value is not an integer or out of range"), false
+			}
+			if freq < 0 || freq > 255 {
+				return opts, protocol.NewErrorValue("ERR Invalid FREQ value, must be >= 0 and <= 255"), false
+			}
+			opts.freq = freq
+			i++
+		default:
+			return opts, protocol.NewErrorValue("ERR syntax error"), false
+		}
+	}
+
+	return opts, protocol.Value{}, true
+}
+
+// restore creates a key from a payload made by DUMP
+func (e *Eventloop) restore(c *client, args []string) protocol.Value {
+	if len(args) < 4 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'restore' command")
+	}
+
+	key := args[1]
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return protocol.NewErrorValue("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return protocol.NewErrorValue("ERR Invalid TTL value, must be >= 0")
+	}
+
+	opts, errValue, ok := parseRestore(args[4:])
+	if !ok {
+		return errValue
+	}
+
+	db := e.dbs[c.db]
+	if _, exists := db.Peek(key); exists && !opts.replace {
+		return protocol.NewErrorValue("BUSYKEY Target key name already exists.")
+	}
+
+	value, err := rdb.Restore([]byte(args[3]))
+	if err != nil {
+		return protocol.NewErrorValue("ERR " + err.Error())
+	}
+
+	if ttl > 0 {
+		expiry := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		if opts.absTTL {
+			expiry = time.UnixMilli(ttl)
+		}
+		// a key that would already be expired is not created at all
+		if !expiry.After(time.Now()) {
+			db.Del(key)
+			return protocol.NewSimpleStringValue(&OK)
+		}
+		value = value.WithExpiry(&expiry)
+	}
+	if opts.idle != -1 {
+		value = value.WithIdle(time.Duration(opts.idle) * time.Second)
+	}
+	if opts.freq != -1 {
+		value = value.WithFreq(uint8(opts.freq))
+	}
+
+	db.SetValue(key, value)
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+// migrateOptions are the options of MIGRATE after the timeout
+type migrateOptions struct {
+	copy    bool
+	replace bool
+	// credentials to authenticate to the target with, user being empty
+	// for AUTH <password>
+	user     string
+	password string
+	keys     []string
+}
+
+func parseMigrate(args []string) (migrateOptions, protocol.Value, bool) {
+	opts := migrateOptions{keys: args[3:4]}
+
+	for i := 6; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "copy":
+			opts.copy = true
+		case "replace":
+			opts.replace = true
+		case "auth":
+			if i+1 >= len(args) {
+				return opts, protocol.NewErrorValue("ERR syntax error"), false
+			}
+			opts.password = args[i+1]
+			i++
+		case "auth2":
+			if i+2 >= len(args) {
+				return opts, protocol.NewErrorValue("ERR syntax error"), false
+			}
+			opts.user, opts.password = args[i+1], args[i+2]
+			i += 2
+		case "keys":
+			if args[3] != "" {
+				return opts, protocol.NewErrorValue("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"), false
+			}
+			opts.keys = args[i+1:]
+			i = len(args)
+		default:
+			return opts, protocol.NewErrorValue("ERR syntax error"), false
+		}
+	}
+
+	return opts, protocol.Value{}, true
+}
+
+// migrate moves keys to another instance with RESTORE and deletes them
+// here unless COPY is given. Like in Redis it blocks the loop until the
+// target has replied or the timeout is reached.
+func (e *Eventloop) migrate(c *client, args []string) protocol.Value {
+	if len(args) < 6 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'migrate' command")
+	}
+
+	host, port := args[1], args[2]
+	targetDB, err := strconv.Atoi(args[4])
+	if err != nil {
+		return protocol.NewErrorValue("ERR value is not an integer or out of range")
+	}
+
+	timeoutMs, err := strconv.Atoi(args[5])
+	if err != nil {
+		return protocol.NewErrorValue("ERR value is not an integer or out of range")
+	}
+	if timeoutMs <= 0 {
+		timeoutMs = 1000
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+
+	opts, errValue, ok := parseMigrate(args)
+	if !ok {
+		return errValue
+	}
+
+	restoreCmd := "RESTORE"
+	if e.cluster != nil {
+		restoreCmd = "RESTORE-ASKING"
+	}
+
+	// replies to read before the ones of the RESTOREs
+	var payload []byte
+	preamble := 1
+	switch {
+	case opts.user != "":
+		payload = aof.Encode([]string{"AUTH", opts.user, opts.password})
+		preamble++
+	case opts.password != "":
+		payload = aof.Encode([]string{"AUTH", opts.password})
+		preamble++
+	}
+	payload = append(payload, aof.Encode(selectCommand(targetDB))...)
+
+	db := e.dbs[c.db]
+	found := make([]string, 0, len(opts.keys))
+	for _, k := range opts.keys {
+		value, ok := db.GetValue(k)
+		if !ok {
+			continue
+		}
+
+		ttl := int64(0)
+		if exp := value.Expiry(); exp != nil {
+			ttl = time.Until(*exp).Milliseconds()
+			if ttl < 1 {
+				ttl = 1
+			}
+		}
+
+		restore := []string{restoreCmd, k, strconv.FormatInt(ttl, 10), string(rdb.Dump(value))}
+		if opts.replace {
+			restore = append(restore, "REPLACE")
+		}
+		payload = append(payload, aof.Encode(restore)...)
+		found = append(found, k)
+	}
+
+	if len(found) == 0 {
+		noKey := "NOKEY"
+		return protocol.NewSimpleStringValue(&noKey)
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
+	if err != nil {
+		return protocol.NewErrorValue("IOERR error or timeout connecting to the client")
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(payload); err != nil {
+		return protocol.NewErrorValue("IOERR error or timeout writing to target instance")
+	}
+
+	reader := bufio.NewReader(conn)
+	for i := 0; i < preamble; i++ {
+		reply, err := protocol.DecodeRESP(reader)
+		if err != nil {
+			return protocol.NewErrorValue("IOERR error or timeout reading to target instance")
+		}
+		if reply.IsError() {
+			return protocol.NewErrorValue("ERR Target instance replied with error: " + reply.String())
+		}
+	}
+
+	moved := make([]string, 0, len(found))
+	var targetErr string
+	for _, k := range found {
+		reply, err := protocol.DecodeRESP(reader)
+		if err != nil {
+			return protocol.NewErrorValue("IOERR error or timeout reading to target instance")
+		}
+		if reply.IsError() {
+			if targetErr == "" {
+				targetErr = reply.String()
+			}
+			continue
+		}
+		moved = append(moved, k)
+	}
+
+	if !opts.copy && len(moved) > 0 {
+		db.Del(moved...)
+		if err := e.propagate(c.db, append([]string{"DEL"}, moved...)); err != nil {
+			fmt.Println("error writing to append only file: ", err.Error())
+		}
+	}
+
+	if targetErr != "" {
+		return protocol.NewErrorValue("ERR Target instance replied with error: " + targetErr)
+	}
+
+	return protocol.NewSimpleStringValue(&OK)
+}
@@ -0,0 +1,87 @@
+package eventloop
+
+import (
+	"encoding/binary"
+	"noelzubin/redis-go/rdb"
+	"noelzubin/redis-go/store"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Dump_Restore(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	c.do("SET", "foo", "bar")
+	c.do("ZADD", "z", "1", "a", "2", "b")
+	payload := c.do("DUMP", "foo").String()
+	assert.Equal(string(rdb.Dump(store.NewStringValue("bar"))), payload)
+	assert.Equal("", c.do("DUMP", "missing").String())
+
+	assert.Equal("BUSYKEY Target key name already exists.", c.do("RESTORE", "foo", "0", payload).String())
+	assert.Equal("OK", c.do("RESTORE", "copy", "0", payload).String())
+	assert.Equal("bar", c.do("GET", "copy").String())
+	assert.Equal("OK", c.do("RESTORE", "z2", "0", c.do("DUMP", "z").String(), "REPLACE").String())
+	assert.Equal([]string{"a", "b"}, stringArray(c.do("ZRANGE", "z2", "0", "-1").Array()))
+
+	// an absolute ttl in the past creates no key
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10)
+	assert.Equal("OK", c.do("RESTORE", "expired", past, payload, "ABSTTL").String())
+	assert.Equal(int64(0), c.do("EXISTS", "expired").Integer())
+	future := strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10)
+	assert.Equal("OK", c.do("RESTORE", "later", future, payload, "ABSTTL").String())
+	assert.Equal("bar", c.do("GET", "later").String())
+
+	assert.Equal("OK", c.do("RESTORE", "idle", "0", payload, "IDLETIME", "1000").String())
+	assert.GreaterOrEqual(c.do("OBJECT", "IDLETIME", "idle").Integer(), int64(1000))
+	c.do("CONFIG", "SET", "maxmemory-policy", "allkeys-lfu")
+	assert.Equal("OK", c.do("RESTORE", "hot", "0", payload, "FREQ", "200").String())
+	assert.Equal(int64(200), c.do("OBJECT", "FREQ", "hot").Integer())
+
+	assert.True(c.do("RESTORE", "x", "0", payload, "IDLETIME", "1", "FREQ", "1").IsError())
+	assert.True(c.do("RESTORE", "x", "0", payload, "FREQ", "256").IsError())
+	assert.True(c.do("RESTORE", "x", "0", "garbage").IsError())
+
+	// a sorted set with a made up member count, checksummed like a real one
+	malformed := []byte{5, 0x81, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	malformed = binary.LittleEndian.AppendUint16(malformed, rdb.Version)
+	malformed = binary.LittleEndian.AppendUint64(malformed, rdb.CRC64(0, malformed))
+	assert.Equal("ERR DUMP payload version or checksum are wrong", c.do("RESTORE", "x", "0", string(malformed)).String())
+	assert.Equal(int64(0), c.do("EXISTS", "x").Integer())
+}
+
+func Test_Migrate(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	src := dialTestServer(t, addr)
+
+	l := listenTest(t)
+	serveTest(t, l, func(el *Eventloop) { el.SetRequirePass("secret") })
+	dst := dialTestServer(t, l.Addr().String())
+	dst.do("AUTH", "secret")
+	host, port := splitHostPort(t, l.Addr().String())
+
+	src.do("SET", "foo", "bar", "100")
+	src.do("SET", "other", "baz")
+	src.do("ZADD", "z", "1", "a")
+
+	assert.True(src.do("MIGRATE", host, port, "foo", "0", "1000").IsError())
+	assert.Equal("OK", src.do("MIGRATE", host, port, "foo", "0", "1000", "AUTH", "secret").String())
+	assert.Equal(int64(0), src.do("EXISTS", "foo").Integer())
+	assert.Equal("bar", dst.do("GET", "foo").String())
+
+	// COPY keeps the keys here, REPLACE overwrites them there
+	dst.do("SELECT", "2")
+	dst.do("SET", "other", "old")
+	assert.True(src.do("MIGRATE", host, port, "", "2", "1000", "COPY", "AUTH2", "default", "secret", "KEYS", "other", "z").IsError())
+	assert.Equal("OK", src.do("MIGRATE", host, port, "", "2", "1000", "COPY", "REPLACE", "AUTH2", "default", "secret", "KEYS", "other", "z").String())
+	assert.Equal("baz", src.do("GET", "other").String())
+	assert.Equal("baz", dst.do("GET", "other").String())
+	assert.Equal([]string{"a"}, stringArray(dst.do("ZRANGE", "z", "0", "-1").Array()))
+
+	assert.Equal("NOKEY", src.do("MIGRATE", host, port, "missing", "0", "1000").String())
+}
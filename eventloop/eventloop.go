@@ -2,14 +2,21 @@ package eventloop
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"noelzubin/redis-go/acl"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/cluster"
+	"noelzubin/redis-go/config"
 	"noelzubin/redis-go/protocol"
 	"noelzubin/redis-go/store"
 	"noelzubin/redis-go/utils"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,188 +24,832 @@ var OK = "OK"
 
 type Eventloop struct {
 	reqChan chan interface{}
-	store   store.Store
+	// numbered databases, selected per connection with SELECT
+	dbs     []store.Store
+	aof     *aof.AOF
+	repl    *replicationState
+	cluster *cluster.Cluster
+	port    int
+
+	acl    *acl.ACL
+	aclLog acl.Log
+	// file ACL LOAD and ACL SAVE work with, if any
+	aclFile string
+	// authenticate TLS clients as the user named by the common name of
+	// their certificate
+	tlsCertUsers bool
+
+	// database the last command written to the AOF was run against, -1
+	// forces a SELECT before the next one
+	aofSelDB int
+
+	// params read and changed with CONFIG
+	config *config.Config
+	// password of the default user set with requirepass
+	requirePass string
+	// how many times per second the cleanup runs, read by the timer
+	hz atomic.Int64
+	// longest bulk string a client may send, read by the connections
+	protoMaxBulkLen atomic.Int64
+	expireCycle     store.ExpireCycle
+	expire          expireState
+
+	stats   *serverStats
+	metrics *loopMetrics
+	slowlog *slowLog
+	latency *latencyMonitor
+
+	// connected clients by ID
+	clients      map[int64]*client
+	nextClientID int64
+	pause        pauseState
+	// client whose command is running, whose pushes follow its reply
+	current *client
+
+	// subscribers of each channel
+	channels map[string]map[*client]bool
+	// clients that ran MONITOR
+	monitors map[*client]bool
+	// keys and prefixes clients track with CLIENT TRACKING
+	tracking trackingTable
+	// maxmemory params and candidates for eviction
+	evict evictionState
+	// values UNLINK released in the background
+	lazyfree lazyfreeStats
+
+	// shards running the commands that only touch their keys, each owning
+	// a partition of every database. Empty unless EnableShards was called.
+	shards []*shard
+	// held for writing by the main loop while it handles anything, and for
+	// reading by the shards while they run a command
+	world sync.RWMutex
+	// held by the shards while they update what they share with each
+	// other: monitors, client tracking and propagation to the AOF and
+	// replicas, see sharesState
+	shared sync.Mutex
+
+	// SHUTDOWN waiting for replicas, and the seconds it waits at most
+	shutdown        shutdownState
+	shutdownTimeout int
+	// closed once the server has shut down
+	done chan struct{}
+	// held while adding listeners and connections, so none is added once
+	// the server has shut down
+	stopMu    sync.Mutex
+	listeners []io.Closer
+	// open connections
+	conns sync.WaitGroup
 }
 
-func InitEventloop(store store.Store) *Eventloop {
-	return &Eventloop{
-		store:   store,
-		reqChan: make(chan interface{}),
+// InitEventloop creates a loop serving one database per store
+func InitEventloop(dbs ...store.Store) *Eventloop {
+	e := &Eventloop{
+		dbs:         dbs,
+		reqChan:     make(chan interface{}),
+		repl:        newReplicationState(),
+		acl:         acl.New(aclCommands()),
+		aofSelDB:    -1,
+		expireCycle: store.DefaultExpireCycle,
+		stats:       newServerStats(),
+		metrics:     newLoopMetrics(),
+		slowlog:     newSlowLog(),
+		latency:     newLatencyMonitor(),
+		clients:     make(map[int64]*client),
+		channels:    make(map[string]map[*client]bool),
+		monitors:    make(map[*client]bool),
+		tracking:    newTrackingTable(),
+		evict:       newEvictionState(),
+
+		shutdownTimeout: defaultShutdownTimeout,
+		done:            make(chan struct{}),
 	}
+	e.hz.Store(defaultHz)
+	e.protoMaxBulkLen.Store(defaultProtoMaxBulkLen)
+	e.RegisterConfig(config.New())
+	return e
 }
 
 func (e *Eventloop) StartCleanUpTimer() {
-	ticker := time.NewTicker(100 * time.Millisecond)
+	hz := e.hz.Load()
+	ticker := time.NewTicker(time.Second / time.Duration(hz))
+	defer ticker.Stop()
 	for {
-		<-ticker.C
-		reqCmd := CleanUp{}
-		e.reqChan <- reqCmd
+		select {
+		case <-ticker.C:
+		case <-e.done:
+			return
+		}
+		e.send(CleanUp{})
+
+		// hz may have been changed with CONFIG SET
+		if current := e.hz.Load(); current != hz {
+			hz = current
+			ticker.Reset(time.Second / time.Duration(hz))
+		}
 	}
 }
 
 func (el *Eventloop) Start() {
 	go el.RunLoop()
 	go el.StartCleanUpTimer()
+	go el.StartReplicationTimer()
 }
 
 func (e *Eventloop) RunLoop() {
+	for _, s := range e.shards {
+		go e.runShard(s)
+	}
+
 	for loopCmd := range e.reqChan {
-		var resp protocol.Value
-		switch cmd := loopCmd.(type) {
-
-		// interval cleanup
-		case CleanUp:
-			e.store.CleanUp()
-			continue
-
-		// handle user commands
-		case ReqCommand:
-			switch strings.ToLower(cmd.command[0]) {
-			case "ping":
-				r := e.store.Ping()
-				resp = protocol.NewSimpleStringValue(r)
-			case "set":
-
-				if len(cmd.command) < 3 {
-					resp = protocol.NewErrorValue("ERR wrong number of arguments for 'set' command")
-					break
-				}
+		// the shards wait while the loop touches anything
+		e.world.Lock()
+		e.handle(loopCmd)
+		e.world.Unlock()
 
-				var exp *time.Time = nil
-				if len(cmd.command) > 3 {
-					if seconds, err := strconv.Atoi(cmd.command[3]); err == nil {
-						expiry := time.Now().Add(time.Duration(seconds) * time.Second)
-						exp = &expiry
-					}
-				}
+		if e.stopped() {
+			return
+		}
+	}
+}
 
-				e.store.Set(cmd.command[1], cmd.command[2], exp)
-				resp = protocol.NewSimpleStringValue(&OK)
+// handle processes a message received by the loop
+func (e *Eventloop) handle(loopCmd interface{}) {
+	switch cmd := loopCmd.(type) {
 
-			case "get":
-				if (len(cmd.command)) < 2 {
-					resp = protocol.NewErrorValue("ERR wrong number of arguments for 'set' command")
-					break
-				}
-				r := e.store.Get(cmd.command[1])
-				if r == nil {
-					resp = protocol.NewNilValue()
-					break
-				}
-				resp = protocol.NewSimpleStringValue(r)
-			case "del":
-				if len(cmd.command) < 2 {
-					resp = protocol.NewErrorValue("ERR wrong number of arguments for 'del' command")
-					break
-				}
+	// interval cleanup, which leaves the dataset alone while clients
+	// are paused or a shutdown waits for replicas
+	case CleanUp:
+		e.shutdownCron()
+		if e.pause.mode != "" || e.shutdown.pending {
+			return
+		}
+		e.activeExpireCycle()
 
-				r := e.store.Del(cmd.command[1:]...)
-				resp = protocol.NewSimpleIntValue(int64(r))
-			case "expire":
-				if len(cmd.command) < 3 {
-					resp = protocol.NewErrorValue("ERR wrong number of arguments for 'del' command")
-					break
-				}
+	// registry of connected clients
+	case clientConnected:
+		e.registerClient(cmd.client)
+	case clientDisconnected:
+		e.unregisterClient(cmd.client)
 
-				seconds, err := strconv.Atoi(cmd.command[2])
-				if err != nil {
-					resp = protocol.NewErrorValue("ERR value is not an integer or out of range")
-					break
-				}
+	// CLIENT PAUSE may have timed out
+	case pauseTimeout:
+		e.endPauseIfDue()
 
-				r := e.store.Expire(cmd.command[1], seconds)
-				resp = protocol.NewSimpleIntValue(int64(r))
-			case "keys":
-				r := e.store.Keys("")
-				resp = protocol.NewArrayStringValue(r)
-			case "zadd":
+	// background AOF rewrite has finished writing the new file
+	case aofRewriteDone:
+		e.finishAOFRewrite(cmd)
 
-				if (len(cmd.command))%2 != 0 || len(cmd.command) < 4 {
-					resp = protocol.NewErrorValue("ERR wrong number of arguments for 'zadd' command")
-					break
-				}
-				scoreMembers, err := utils.GetScoreMemberPairs(cmd.command[2:])
-				if err != nil {
-					resp = protocol.NewErrorValue("ERR syntax error")
-					break
-				}
-				r := e.store.ZAdd(cmd.command[1], scoreMembers)
-				resp = protocol.NewSimpleIntValue(int64(r))
-			case "zrange":
-				if len(cmd.command) < 4 {
-					resp = protocol.NewErrorValue("ERR wrong number of arguments for 'zrange' command")
-					break
-				}
+	// periodic replication tasks
+	case replicationCron:
+		e.replicationCron()
+
+	// dataset and stream received from our master
+	case masterFullSync:
+		if cmd.link == e.repl.link {
+			e.loadFullSync(cmd)
+		}
+	case masterContinue:
+		if cmd.link == e.repl.link {
+			e.continueSync(cmd)
+		}
+	case masterCommand:
+		if cmd.link == e.repl.link {
+			e.applyMasterCommand(cmd.args)
+		}
 
-				fmt.Println(cmd.command)
+	// scrape of the metrics endpoint
+	case metricsRequest:
+		cmd.respChan <- e.writeMetrics()
 
-				start, err := strconv.Atoi(cmd.command[2])
-				if err != nil {
-					resp = protocol.NewErrorValue("ERR value is not an integer or out of range")
-					break
-				}
+	// SIGTERM, SIGINT or Stop
+	case shutdownRequest:
+		if err := e.startShutdown(cmd.flags); err != nil || !e.shutdown.pending {
+			cmd.result <- err
+		} else {
+			e.shutdown.results = append(e.shutdown.results, cmd.result)
+		}
 
-				end, err := strconv.Atoi(cmd.command[3])
-				if err != nil {
-					resp = protocol.NewErrorValue("ERR value is not an integer or out of range")
-					break
-				}
+	// handle user commands
+	case *ReqCommand:
+		e.runCommands(cmd)
+	}
+}
 
-				withScores := false
-				for _, v := range cmd.command {
-					if strings.ToLower(v) == "withscores" {
-						withScores = true
-						break
-					}
-				}
+// runCommands runs the commands of a batch from where it stopped, and
+// hands their replies over once they have all run. A command held back by
+// a pause is queued along with the rest of its batch, the replies before it
+// going out right away unless earlier ones are still waiting to.
+func (e *Eventloop) runCommands(req *ReqCommand) {
+	c := req.client
+	for ; req.next < len(req.commands); req.next++ {
+		args := req.commands[req.next].args
+		if e.paused(c, args) {
+			req.flushSoFar()
+			e.pause.queue = append(e.pause.queue, req)
+			return
+		}
+
+		c.qbuf = req.qbuf
+		e.current = c
+		resp := e.call(c, args)
+		e.current = nil
+		// the connection of a client that shut the server down is closed
+		// without a reply
+		if e.stopped() {
+			return
+		}
+		// SHUTDOWN waiting for the replicas replies only if it fails
+		if c.blocked {
+			req.flushSoFar()
+			e.shutdown.blocked = append(e.shutdown.blocked, req)
+			return
+		}
+		req.reply(resp)
+
+		// what was pipelined after QUIT, a kill or PSYNC is dropped
+		if c.closeAfterReply || c.replica != nil {
+			break
+		}
+	}
+	req.flush(true)
+}
+
+// call executes a command from a client and propagates it to the AOF and
+// replicas if it changed the dataset
+func (e *Eventloop) call(c *client, args []string) protocol.Value {
+	run, resp, ok := e.beginCall(&e.stats.calls, c, args)
+	if !ok {
+		return resp
+	}
+
+	start := time.Now()
+	resp = e.execute(c, args)
+	return e.finishCall(c, run, args, resp, time.Since(start))
+}
+
+// callState is what beginCall found out about a call, for finishCall
+type callState struct {
+	cmd   command
+	known bool
+	name  string
+	calls *callStats
+	stats *commandStats
+}
+
+// beginCall counts a call in calls, those of the loop or of the shard
+// running it, and checks it may run, returning the error to reply with if
+// not
+func (e *Eventloop) beginCall(calls *callStats, c *client, args []string) (callState, protocol.Value, bool) {
+	if len(args) == 0 {
+		return callState{}, protocol.NewErrorValue("ERR empty command"), false
+	}
+
+	cmd, known := lookupCommand(args[0])
+	calls.total++
+	stats, name := &commandStats{}, strings.ToLower(args[0])
+	if known {
+		stats, name = calls.command(cmd, args)
+	}
+	c.lastCmd = name
+	c.lastInteraction = time.Now()
+
+	if resp, ok := e.checkCall(c, cmd, known, args); !ok {
+		stats.rejected++
+		return callState{}, resp, false
+	}
+	e.feedMonitors(c, cmd, args)
+
+	return callState{cmd: cmd, known: known, name: name, calls: calls, stats: stats}, protocol.Value{}, true
+}
+
+// finishCall records a call that took took to run, and propagates it to
+// the AOF and replicas if it changed the dataset
+func (e *Eventloop) finishCall(c *client, run callState, args []string, resp protocol.Value, took time.Duration) protocol.Value {
+	cmd, stats := run.cmd, run.stats
+	stats.calls++
+	stats.usec += took.Microseconds()
+	if run.known {
+		stats.latency[latencyBucket(took.Microseconds())]++
+		run.calls.observe(run.name, took)
+		if e.slowlog.slow(took) {
+			e.slowlog.add(c, redactArgs(cmd, args), took)
+		}
+		e.latency.add(latencyEventName(cmd), took)
+	}
+
+	// CLIENT CACHING only applies to the command right after it
+	caching := c.tracking.caching
+	if run.name != "client|caching" {
+		c.tracking.caching = ""
+	}
+
+	if resp.IsError() {
+		stats.failed++
+		return resp
+	}
+
+	if run.known && cmd.isRead() {
+		e.trackKeys(c, cmd, args, caching)
+	}
+
+	if run.known && cmd.isWrite() {
+		if err := e.propagate(c.db, args); err != nil {
+			fmt.Println("error writing to append only file: ", err.Error())
+			return protocol.NewErrorValue("ERR error writing to the append only file: " + err.Error())
+		}
+		e.invalidateWrite(c, cmd, args)
+	}
+
+	return resp
+}
+
+// checkCall decides whether a client may run a command here, returning the
+// error to reply with if not
+func (e *Eventloop) checkCall(c *client, cmd command, known bool, args []string) (protocol.Value, bool) {
+	if !e.authenticated(c) && cmd.flags&flagNoAuth == 0 {
+		return protocol.NewErrorValue("NOAUTH Authentication required."), false
+	}
+
+	// a RESP2 connection is taken up by the messages of its channels
+	if c.resp == 2 && len(c.channels) > 0 && !allowedSubscribed(args[0]) {
+		return protocol.NewErrorValue("ERR Can't execute '" + strings.ToLower(args[0]) +
+			"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"), false
+	}
+
+	// commands that need no authentication are not checked until there
+	// is a user to check them against
+	if known && c.user != nil {
+		if reason, object := e.aclCheck(c.user, cmd, args); reason != "" {
+			e.aclLog.Add(reason, "toplevel", object, c.user.Name(), c.info())
+			return aclDenied(c.user, reason, object), false
+		}
+	}
+
+	// ASKING only applies to the command right after it
+	asking := c.asking || cmd.name == "restore-asking"
+	c.asking = false
+
+	if known && cmd.isWrite() && e.repl.isReplica() && !c.master {
+		return protocol.NewErrorValue("READONLY You can't write against a read only replica."), false
+	}
+
+	if known && e.cluster != nil {
+		if redirect, ok := e.clusterRedirect(cmd, args, asking); !ok {
+			return redirect, false
+		}
+	}
+
+	// make room before running the command, refusing commands that may
+	// grow the dataset if there is none. Our master evicts for us.
+	if known && !c.master && !e.freeMemory() && cmd.flags&flagDenyOOM != 0 {
+		return protocol.NewErrorValue("OOM command not allowed when used memory > 'maxmemory'."), false
+	}
+
+	return protocol.Value{}, true
+}
+
+// execute runs a single command against the store
+func (e *Eventloop) execute(c *client, args []string) protocol.Value {
+	var resp protocol.Value
+
+	if len(args) == 0 {
+		return protocol.NewErrorValue("ERR empty command")
+	}
 
-				r := e.store.ZRange(cmd.command[1], start, end, withScores)
-				resp = protocol.NewArrayStringValue(r)
-			default:
-				resp = protocol.NewErrorValue("unknown command '" + cmd.command[0] + "'")
+	db := e.dbs[c.db]
+
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		r := db.Ping()
+		resp = protocol.NewSimpleStringValue(r)
+	case "set":
+
+		if len(args) < 3 {
+			resp = protocol.NewErrorValue("ERR wrong number of arguments for 'set' command")
+			break
+		}
+
+		var exp *time.Time = nil
+		if len(args) > 3 {
+			if seconds, err := strconv.Atoi(args[3]); err == nil {
+				expiry := time.Now().Add(time.Duration(seconds) * time.Second)
+				exp = &expiry
+			}
+		}
+
+		db.Set(args[1], args[2], exp)
+		resp = protocol.NewSimpleStringValue(&OK)
+
+	case "get":
+		if (len(args)) < 2 {
+			resp = protocol.NewErrorValue("ERR wrong number of arguments for 'set' command")
+			break
+		}
+		r := db.Get(args[1])
+		if r == nil {
+			resp = protocol.NewNilValue()
+			break
+		}
+		resp = protocol.NewSimpleStringValue(r)
+	case "del":
+		if len(args) < 2 {
+			resp = protocol.NewErrorValue("ERR wrong number of arguments for 'del' command")
+			break
+		}
+
+		r := db.Del(args[1:]...)
+		resp = protocol.NewSimpleIntValue(int64(r))
+	case "unlink":
+		resp = e.unlink(c, args)
+	case "exists":
+		resp = e.exists(c, args)
+	case "type":
+		resp = e.typeCommand(c, args)
+	case "rename":
+		resp = e.rename(c, args, false)
+	case "renamenx":
+		resp = e.rename(c, args, true)
+	case "copy":
+		resp = e.copyCommand(c, args)
+	case "touch":
+		resp = e.touch(c, args)
+	case "randomkey":
+		resp = e.randomKey(c, args)
+	case "expire":
+		if len(args) < 3 {
+			resp = protocol.NewErrorValue("ERR wrong number of arguments for 'del' command")
+			break
+		}
+
+		seconds, err := strconv.Atoi(args[2])
+		if err != nil {
+			resp = protocol.NewErrorValue("ERR value is not an integer or out of range")
+			break
+		}
+
+		r := db.Expire(args[1], seconds)
+		resp = protocol.NewSimpleIntValue(int64(r))
+	case "keys":
+		if len(args) != 2 {
+			resp = protocol.NewErrorValue("ERR wrong number of arguments for 'keys' command")
+			break
+		}
+		r := db.Keys(args[1])
+		resp = protocol.NewArrayBulkStringValue(r)
+	case "scan":
+		resp = e.scan(c, args)
+	case "zscan":
+		resp = e.zscan(c, args)
+	case "zadd":
+
+		if (len(args))%2 != 0 || len(args) < 4 {
+			resp = protocol.NewErrorValue("ERR wrong number of arguments for 'zadd' command")
+			break
+		}
+		scoreMembers, err := utils.GetScoreMemberPairs(args[2:])
+		if err != nil {
+			resp = protocol.NewErrorValue("ERR syntax error")
+			break
+		}
+		r := db.ZAdd(args[1], scoreMembers)
+		resp = protocol.NewSimpleIntValue(int64(r))
+	case "zrange":
+		if len(args) < 4 {
+			resp = protocol.NewErrorValue("ERR wrong number of arguments for 'zrange' command")
+			break
+		}
+
+		fmt.Println(args)
+
+		start, err := strconv.Atoi(args[2])
+		if err != nil {
+			resp = protocol.NewErrorValue("ERR value is not an integer or out of range")
+			break
+		}
+
+		end, err := strconv.Atoi(args[3])
+		if err != nil {
+			resp = protocol.NewErrorValue("ERR value is not an integer or out of range")
+			break
+		}
+
+		withScores := false
+		for _, v := range args {
+			if strings.ToLower(v) == "withscores" {
+				withScores = true
+				break
 			}
-			cmd.respChan <- resp
 		}
+
+		r := db.ZRange(args[1], start, end, withScores)
+		resp = protocol.NewArrayStringValue(r)
+	case "pexpireat":
+		if len(args) < 3 {
+			resp = protocol.NewErrorValue("ERR wrong number of arguments for 'pexpireat' command")
+			break
+		}
+
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			resp = protocol.NewErrorValue("ERR value is not an integer or out of range")
+			break
+		}
+
+		r := db.ExpireAt(args[1], time.UnixMilli(ms))
+		resp = protocol.NewSimpleIntValue(int64(r))
+	case "bgrewriteaof":
+		resp = e.bgRewriteAOF()
+	case "shutdown":
+		resp = e.shutdownCommand(c, args)
+	case "replicaof", "slaveof":
+		resp = e.replicaOfCommand(args)
+	case "replconf":
+		resp = e.replconf(c, args)
+	case "psync":
+		resp = e.psync(c, args)
+	case "role":
+		resp = e.role()
+	case "info":
+		resp = e.info(args[1:])
+	case "cluster":
+		resp = e.clusterCommand(args)
+	case "asking":
+		if e.cluster == nil {
+			resp = protocol.NewErrorValue("ERR This instance has cluster support disabled")
+			break
+		}
+		c.asking = true
+		resp = protocol.NewSimpleStringValue(&OK)
+	case "dump":
+		resp = e.dump(c, args)
+	case "restore", "restore-asking":
+		resp = e.restore(c, args)
+	case "migrate":
+		resp = e.migrate(c, args)
+	case "auth":
+		resp = e.auth(c, args)
+	case "acl":
+		resp = e.aclCommand(c, args)
+	case "config":
+		resp = e.configCommand(args)
+	case "client":
+		resp = e.clientCommand(c, args)
+	case "monitor":
+		resp = e.monitor(c, args)
+	case "slowlog":
+		resp = e.slowlogCommand(args)
+	case "latency":
+		resp = e.latencyCommand(c, args)
+	case "hello":
+		resp = e.hello(c, args)
+	case "subscribe":
+		resp = e.subscribe(c, args)
+	case "unsubscribe":
+		resp = e.unsubscribe(c, args)
+	case "publish":
+		resp = e.publish(args)
+	case "select":
+		resp = e.selectDB(c, args)
+	case "swapdb":
+		resp = e.swapDB(args)
+	case "move":
+		resp = e.move(c, args)
+	case "object":
+		resp = e.objectCommand(c, args)
+	case "memory":
+		resp = e.memoryCommand(c, args)
+	case "dbsize":
+		resp = protocol.NewSimpleIntValue(int64(db.Len()))
+	case "flushdb":
+		resp = e.flush(args, e.dbs[c.db:c.db+1])
+	case "flushall":
+		resp = e.flush(args, e.dbs)
+	default:
+		resp = protocol.NewErrorValue("unknown command '" + args[0] + "'")
 	}
+
+	return resp
 }
 
+// maxBatch is the most pipelined commands a client submits at once
+const maxBatch = 64
+
+// ReqCommand is a batch of commands a client pipelined, which the loop runs
+// one after the other. Each client reuses its own.
 type ReqCommand struct {
-	command  []string
-	respChan chan protocol.Value
+	client *client
+	// arguments of each command, in buffers taken from argsPool
+	commands []*argsBuf
+	// index of the command to run next
+	next int
+	// bytes buffered after the batch, reported by CLIENT LIST
+	qbuf int
+	// replies of the commands run so far, each followed by what was pushed
+	// to the client while it ran
+	out []byte
+	// replies handed over to the connection to write
+	done chan replies
+}
+
+// replies of a batch to write to the client, those of every command once
+// the batch has run, or those so far when the rest of it waits for a pause
+// to end
+type replies struct {
+	out  []byte
+	last bool
+}
+
+// argsBuf holds the arguments of a command
+type argsBuf struct {
+	args []string
+}
+
+// argsPool recycles argument buffers between commands of every client
+var argsPool = sync.Pool{New: func() interface{} { return &argsBuf{args: make([]string, 0, 8)} }}
+
+// maxOutBuffer is the largest reply buffer a client keeps between batches
+const maxOutBuffer = 64 * 1024
+
+func newReqCommand(c *client) *ReqCommand {
+	// room for the replies before a pause and those of the whole batch, so
+	// the loop never waits for the connection
+	return &ReqCommand{client: c, done: make(chan replies, 2)}
+}
+
+// read reads a command, and the commands pipelined after it that are
+// already buffered, up to maxBatch. Bulk strings longer than maxBulkLen are
+// rejected. The commands read before an error are kept.
+func (r *ReqCommand) read(reader *bufio.Reader, maxBulkLen int) error {
+	for len(r.commands) == 0 || (reader.Buffered() > 0 && len(r.commands) < maxBatch) {
+		buf := argsPool.Get().(*argsBuf)
+		args, err := protocol.ReadCommand(reader, buf.args[:0], maxBulkLen)
+		buf.args = args
+		if err != nil {
+			releaseArgs(buf)
+			return err
+		}
+		r.commands = append(r.commands, buf)
+	}
+	r.qbuf = reader.Buffered()
+	return nil
+}
+
+// reply adds the reply of the command that just ran, and the messages
+// pushed to the client while it ran
+func (r *ReqCommand) reply(resp protocol.Value) {
+	c := r.client
+	r.out = resp.AppendEncode(r.out)
+	if len(c.pending) > 0 {
+		r.out = append(r.out, c.pending...)
+		c.pending = c.pending[:0]
+	}
+}
+
+// flush hands the replies so far over to the connection, which owns them
+// from then on
+func (r *ReqCommand) flush(last bool) {
+	out := r.out
+	r.out = nil
+	r.done <- replies{out: out, last: last}
+}
+
+// flushSoFar hands over the replies so far of a batch whose next command
+// has to wait, unless earlier ones are still waiting to be written
+func (r *ReqCommand) flushSoFar() {
+	if len(r.out) > 0 && len(r.done) == 0 {
+		r.flush(false)
+	}
+}
+
+// reset readies the request for the next batch once its replies are
+// written, reusing out for the next ones
+func (r *ReqCommand) reset(out []byte) {
+	for i, buf := range r.commands {
+		releaseArgs(buf)
+		r.commands[i] = nil
+	}
+	r.commands = r.commands[:0]
+	r.next = 0
+	r.out = nil
+	if cap(out) <= maxOutBuffer {
+		r.out = out[:0]
+	}
+}
+
+// releaseArgs returns a buffer to the pool, without the strings it held
+func releaseArgs(buf *argsBuf) {
+	for i := range buf.args {
+		buf.args[i] = ""
+	}
+	buf.args = buf.args[:0]
+	argsPool.Put(buf)
+}
+
+// wait returns the next replies of a batch, or false if the server shut
+// down before they came
+func (e *Eventloop) wait(req *ReqCommand) (replies, bool) {
+	select {
+	case r := <-req.done:
+		return r, true
+	case <-e.done:
+	}
+
+	select {
+	case r := <-req.done:
+		return r, true
+	default:
+		return replies{}, false
+	}
 }
 
 type CleanUp struct{}
 
 func (e *Eventloop) HandleConnection(conn io.ReadWriteCloser) {
+	if !e.addConn() {
+		conn.Close()
+		return
+	}
+	defer e.conns.Done()
 	defer conn.Close()
 
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			fmt.Println("TLS handshake failed: ", err.Error())
+			return
+		}
+	}
+
+	c := newClient(conn)
+	// a single reader for the whole connection, so pipelined commands
+	// buffered along with the current one are not lost
+	reader := bufio.NewReader(conn)
+	c.qbufSize = reader.Size()
+
+	if !e.send(clientConnected{client: c}) {
+		return
+	}
+	go c.writePushes()
+	// once the loop has dropped the client nothing is pushed to it
+	defer func() {
+		e.send(clientDisconnected{client: c})
+		close(c.pushes)
+	}()
+
+	req := newReqCommand(c)
 	for {
-		value, err := protocol.DecodeRESP(bufio.NewReader(conn))
-		if errors.Is(err, io.EOF) {
+		err := req.read(reader, int(e.protoMaxBulkLen.Load()))
+		if errors.Is(err, io.EOF) && len(req.commands) == 0 {
 			break
 		}
-		if err != nil {
+		var protoErr protocol.ProtocolError
+		isProtoErr := errors.As(err, &protoErr)
+		if err != nil && !isProtoErr && !errors.Is(err, io.EOF) && !e.stopped() {
 			fmt.Println("error decoding RESP: ", err.Error())
-			return
 		}
 
-		respChan := make(chan protocol.Value)
-
-		valuesArr := value.Array()
-		strValues := make([]string, 0)
-		for _, v := range valuesArr {
-			strValues = append(strValues, v.String())
+		// commands read before an error still run
+		if len(req.commands) > 0 {
+			// time spent waiting for the loop to take the batch
+			queued := time.Now()
+			if !e.submit(req) {
+				return
+			}
+			e.metrics.queueWait.Observe(time.Since(queued).Seconds())
+			for {
+				r, ok := e.wait(req)
+				if !ok {
+					return
+				}
+				c.writeMu.Lock()
+				conn.Write(r.out)
+				c.writeMu.Unlock()
+				if r.last {
+					req.reset(r.out)
+					break
+				}
+			}
 		}
-
-		reqCmd := ReqCommand{
-			command:  strValues,
-			respChan: respChan,
+		if err != nil {
+			// the client is told why its connection is closed, after the
+			// replies of the commands read before the malformed one
+			if isProtoErr {
+				reply := protocol.NewErrorValue("ERR " + protoErr.Error())
+				c.writeMu.Lock()
+				conn.Write(reply.Encode())
+				c.writeMu.Unlock()
+			}
+			return
 		}
 
-		e.reqChan <- reqCmd
+		// the client killed its own connection
+		if c.closeAfterReply {
+			return
+		}
 
-		cmdRes := <-respChan
-		conn.Write(cmdRes.Encode())
-		fmt.Println("wrote to connetion", string(cmdRes.Encode()))
+		// the connection now belongs to a replica
+		if c.replica != nil {
+			e.serveReplica(c)
+			return
+		}
 	}
 }
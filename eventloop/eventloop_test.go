@@ -6,20 +6,21 @@ import (
 	storeMock "noelzubin/redis-go/store/mocks"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/mock"
 )
 
 var st storeMock.Store
-var el Eventloop
+var el *Eventloop
 
 func setup() {
 	st = storeMock.Store{}
-	el = *InitEventloop(&st)
+	el = InitEventloop(&st)
 	go el.RunLoop()
 }
 
 func Test_Set(t *testing.T) {
 	setup()
-	go el.RunLoop()
 	var tm *time.Time = nil
 	st.On("Set", "foo", "bar", tm).Return()
 	el.HandleConnection(
@@ -78,7 +79,7 @@ func Test_Zadd(t *testing.T) {
 }
 
 func Test_Zrange(t *testing.T) {
-	go el.RunLoop()
+	setup()
 	st.On("ZRange", "foo", 1, -1, false).Return([]string{})
 	el.HandleConnection(
 		rwMock.NewMockReadWriteCloser("*4\r\n$6\r\nZRANGE\r\n$3\r\nfoo\r\n$1\r\n1\r\n$2\r\n-1\r\n"),
@@ -97,7 +98,7 @@ func Test_Zrange_WithScores(t *testing.T) {
 
 func Test_CleanUp(t *testing.T) {
 	setup()
-	st.On("CleanUp").Return()
+	st.On("CleanUp", mock.AnythingOfType("time.Time")).Return(store.ExpireRun{Done: true})
 	go el.StartCleanUpTimer()
 	<-time.NewTimer(500 * time.Millisecond).C
 	st.AssertNumberOfCalls(t, "CleanUp", 5)
@@ -0,0 +1,168 @@
+package eventloop
+
+import (
+	"fmt"
+	"math"
+	"noelzubin/redis-go/store"
+	"strings"
+)
+
+const (
+	// evictionPoolSize is how many of the best candidates for eviction
+	// found by sampling are remembered
+	evictionPoolSize = 16
+	// defaultMaxmemorySamples is how many keys of each database are sampled
+	// to refill the pool
+	defaultMaxmemorySamples = 5
+)
+
+// evictionPolicies are the values of maxmemory-policy
+var evictionPolicies = []string{
+	"noeviction", "allkeys-lru", "volatile-lru", "allkeys-lfu", "volatile-lfu",
+	"allkeys-random", "volatile-random", "volatile-ttl",
+}
+
+// evictionCandidate is a key that may be evicted. Keys with higher scores
+// are evicted first.
+type evictionCandidate struct {
+	db    int
+	key   string
+	score int64
+}
+
+// evictionState holds the maxmemory params and the pool of candidates,
+// which is kept between evictions so that good candidates found by earlier
+// samples are not lost
+type evictionState struct {
+	maxmemory int64
+	policy    string
+	samples   int
+	// sorted by score, the best candidate last
+	pool []evictionCandidate
+	// database the random policies evict from next
+	nextDB int
+}
+
+func newEvictionState() evictionState {
+	return evictionState{policy: "noeviction", samples: defaultMaxmemorySamples}
+}
+
+// usedMemory adds up the memory held by the keys of every database
+func (e *Eventloop) usedMemory() int64 {
+	var used int64
+	for _, db := range e.dbs {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// freeMemory evicts keys until the dataset fits in maxmemory. It returns
+// false if it does not fit, because the policy allows no eviction or there
+// is nothing left to evict. Replicas leave eviction to their master.
+func (e *Eventloop) freeMemory() bool {
+	if e.evict.maxmemory == 0 || e.repl.isReplica() {
+		return true
+	}
+
+	for e.usedMemory() > e.evict.maxmemory {
+		if !e.evictOne() {
+			return false
+		}
+	}
+	return true
+}
+
+// evictOne evicts the best key according to the policy. The deletion goes
+// to the AOF and replicas, and clients caching the key are told.
+func (e *Eventloop) evictOne() bool {
+	db, key, ok := e.evictionVictim()
+	if !ok {
+		return false
+	}
+
+	if err := e.propagate(db, []string{"DEL", key}); err != nil {
+		fmt.Println("error writing to append only file: ", err.Error())
+	}
+	e.invalidateKeys(nil, []string{key})
+	return true
+}
+
+// evictionVictim picks a key and evicts it from its database
+func (e *Eventloop) evictionVictim() (int, string, bool) {
+	policy := e.evict.policy
+	volatile := strings.HasPrefix(policy, "volatile-")
+
+	switch policy {
+	case "noeviction":
+		return 0, "", false
+	case "allkeys-random", "volatile-random":
+		for range e.dbs {
+			db := e.evict.nextDB
+			e.evict.nextDB = (e.evict.nextDB + 1) % len(e.dbs)
+			if sample := e.dbs[db].Sample(1, volatile); len(sample) > 0 && e.dbs[db].Evict(sample[0].Key) {
+				return db, sample[0].Key, true
+			}
+		}
+		return 0, "", false
+	}
+
+	for {
+		for db, s := range e.dbs {
+			for _, entry := range s.Sample(e.evict.samples, volatile) {
+				e.evict.addCandidate(db, entry.Key, evictionScore(policy, entry.Value))
+			}
+		}
+		if len(e.evict.pool) == 0 {
+			return 0, "", false
+		}
+
+		// candidates may have been deleted since they were sampled
+		for len(e.evict.pool) > 0 {
+			best := e.evict.pool[len(e.evict.pool)-1]
+			e.evict.pool = e.evict.pool[:len(e.evict.pool)-1]
+			if best.db < len(e.dbs) && e.dbs[best.db].Evict(best.key) {
+				return best.db, best.key, true
+			}
+		}
+	}
+}
+
+// evictionScore ranks a key for eviction: the longer it has been idle, the
+// less often it is used or the sooner it expires, the higher the score
+func evictionScore(policy string, v store.Value) int64 {
+	switch policy {
+	case "allkeys-lfu", "volatile-lfu":
+		return 255 - int64(v.Freq())
+	case "volatile-ttl":
+		if exp := v.Expiry(); exp != nil {
+			return math.MaxInt64 - exp.UnixNano()
+		}
+		return 0
+	}
+	return v.Idle().Milliseconds()
+}
+
+// addCandidate adds a key to the pool if there is room for it or it is a
+// better candidate than the worst one
+func (s *evictionState) addCandidate(db int, key string, score int64) {
+	for _, c := range s.pool {
+		if c.db == db && c.key == key {
+			return
+		}
+	}
+	if len(s.pool) == evictionPoolSize && score <= s.pool[0].score {
+		return
+	}
+
+	i := 0
+	for i < len(s.pool) && s.pool[i].score < score {
+		i++
+	}
+	s.pool = append(s.pool, evictionCandidate{})
+	copy(s.pool[i+1:], s.pool[i:])
+	s.pool[i] = evictionCandidate{db: db, key: key, score: score}
+
+	if len(s.pool) > evictionPoolSize {
+		s.pool = s.pool[1:]
+	}
+}
@@ -0,0 +1,78 @@
+package eventloop
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Maxmemory_NoEviction(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	assert.Equal("OK", c.do("CONFIG", "SET", "maxmemory", "1kb").String())
+	assert.Equal("1024", c.do("CONFIG", "GET", "maxmemory").Array()[1].String())
+	for i := 0; i < 20; i++ {
+		c.do("SET", "key:"+strconv.Itoa(i), strings.Repeat("x", 100))
+	}
+
+	// writes that grow the dataset are refused, reads and deletes are not
+	assert.Equal("OOM command not allowed when used memory > 'maxmemory'.", c.do("SET", "more", "x").String())
+	assert.Equal(strings.Repeat("x", 100), c.do("GET", "key:0").String())
+	assert.Equal(int64(1), c.do("DEL", "key:0").Integer())
+
+	assert.True(c.do("CONFIG", "SET", "maxmemory-policy", "sometimes").IsError())
+	assert.True(c.do("CONFIG", "SET", "maxmemory", "lots").IsError())
+}
+
+func Test_Maxmemory_Eviction(t *testing.T) {
+	assert := assert.New(t)
+	el, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	for _, policy := range evictionPolicies[1:] {
+		c.do("FLUSHALL")
+		c.do("CONFIG", "SET", "maxmemory", "0")
+		assert.Equal("OK", c.do("CONFIG", "SET", "maxmemory-policy", policy).String())
+
+		// the volatile policies only evict keys with an expiry
+		c.do("SET", "persistent", "x")
+		for i := 0; i < 100; i++ {
+			c.do("SET", "key:"+strconv.Itoa(i), strings.Repeat("x", 100), "100")
+		}
+		c.do("CONFIG", "SET", "maxmemory", "4kb")
+		for i := 100; i < 200; i++ {
+			assert.Equal("OK", c.do("SET", "key:"+strconv.Itoa(i), strings.Repeat("x", 100), "100").String(), policy)
+		}
+
+		info := c.do("INFO", "memory").String()
+		assert.Contains(info, "maxmemory_policy:"+policy)
+		assert.Less(c.do("DBSIZE").Integer(), int64(100), policy)
+		if strings.HasPrefix(policy, "volatile-") {
+			assert.Equal("x", c.do("GET", "persistent").String(), policy)
+		}
+	}
+	assert.Greater(el.dbStats().EvictedKeys, int64(0))
+}
+
+func Test_EvictionPool(t *testing.T) {
+	assert := assert.New(t)
+	s := newEvictionState()
+
+	for i := 0; i < 2*evictionPoolSize; i++ {
+		s.addCandidate(0, strconv.Itoa(i), int64(i))
+	}
+	// the same key is only added once
+	s.addCandidate(0, "31", 31)
+
+	assert.Len(s.pool, evictionPoolSize)
+	assert.Equal("16", s.pool[0].key)
+	assert.Equal("31", s.pool[len(s.pool)-1].key)
+
+	// candidates worse than the whole pool are ignored
+	s.addCandidate(1, "worse", 0)
+	assert.Equal("16", s.pool[0].key)
+}
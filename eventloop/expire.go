@@ -0,0 +1,56 @@
+package eventloop
+
+import "time"
+
+// expireState is what the active expiry cycle carries from one run to the
+// next
+type expireState struct {
+	// database the next cycle starts with, the one the last cycle ran out
+	// of time in
+	nextDB int
+	// running estimate of the percentage of keys with an expiry that are
+	// expired but not deleted yet
+	stalePerc float64
+	// cycles that stopped at their time limit, and the time spent in all
+	// of them
+	timeCapReached int64
+	cpuTime        time.Duration
+}
+
+// activeExpireCycle deletes expired keys from every database, within a
+// budget of TimeLimitPercent of the time between two cycles. A cycle that
+// runs out of time leaves the rest for the next one, which starts where it
+// stopped.
+func (e *Eventloop) activeExpireCycle() {
+	if len(e.dbs) == 0 {
+		return
+	}
+
+	start := time.Now()
+	period := time.Second / time.Duration(e.hz.Load())
+	deadline := start.Add(period * time.Duration(e.expireCycle.TimeLimitPercent()) / 100)
+
+	sampled, expired := 0, 0
+	for i := 0; i < len(e.dbs); i++ {
+		db := e.expire.nextDB % len(e.dbs)
+		run := e.dbs[db].CleanUp(deadline)
+		sampled += run.Sampled
+		expired += run.Expired
+
+		if !run.Done {
+			e.expire.nextDB = db
+			e.expire.timeCapReached++
+			break
+		}
+		e.expire.nextDB = db + 1
+	}
+
+	if sampled > 0 {
+		current := float64(expired) * 100 / float64(sampled)
+		e.expire.stalePerc = current*0.05 + e.expire.stalePerc*0.95
+	}
+
+	took := time.Since(start)
+	e.expire.cpuTime += took
+	e.latency.add("expire-cycle", took)
+}
@@ -0,0 +1,50 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/store"
+	storeMock "noelzubin/redis-go/store/mocks"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_ActiveExpireCycle_Resumes(t *testing.T) {
+	assert := assert.New(t)
+	db0, db1 := &storeMock.Store{}, &storeMock.Store{}
+	e := InitEventloop(db0, db1)
+
+	// db0 runs out of time, so the next cycle starts with it again
+	db0.On("CleanUp", mock.AnythingOfType("time.Time")).
+		Return(store.ExpireRun{Sampled: 20, Expired: 20}).Once()
+	e.activeExpireCycle()
+	db1.AssertNotCalled(t, "CleanUp", mock.Anything)
+	assert.Equal(0, e.expire.nextDB)
+	assert.Equal(int64(1), e.expire.timeCapReached)
+	assert.InDelta(5.0, e.expire.stalePerc, 0.01)
+
+	db0.On("CleanUp", mock.AnythingOfType("time.Time")).
+		Return(store.ExpireRun{Sampled: 20, Done: true})
+	db1.On("CleanUp", mock.AnythingOfType("time.Time")).
+		Return(store.ExpireRun{Sampled: 20, Done: true})
+	e.activeExpireCycle()
+	db0.AssertNumberOfCalls(t, "CleanUp", 2)
+	db1.AssertNumberOfCalls(t, "CleanUp", 1)
+	assert.Equal(int64(1), e.expire.timeCapReached)
+	assert.InDelta(4.75, e.expire.stalePerc, 0.01)
+}
+
+func Test_ActiveExpireCycle_Stats(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	assert.Equal("OK", c.do("CONFIG", "SET", "active-expire-effort", "10").String())
+	assert.Equal("ERR CONFIG SET failed (possibly related to argument 'active-expire-effort') - argument must be between 1 and 10 inclusive",
+		c.do("CONFIG", "SET", "active-expire-effort", "11").String())
+
+	fields := infoFields(c.do("INFO", "stats").String())
+	assert.Equal("0.00", fields["expired_stale_perc"])
+	assert.Equal("0", fields["expired_time_cap_reached_count"])
+	assert.Contains(fields, "expire_cycle_cpu_milliseconds")
+}
@@ -0,0 +1,115 @@
+package eventloop
+
+import (
+	"bufio"
+	"net"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/set"
+	"noelzubin/redis-go/store"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testDatabases is the number of databases test servers are started with
+const testDatabases = 16
+
+// testDBs creates n empty in-memory databases
+func testDBs(n int) []store.Store {
+	dbs := make([]store.Store, n)
+	for i := range dbs {
+		dbs[i] = store.InitStore(set.InitStringSet())
+	}
+	return dbs
+}
+
+// startTestServer runs an event loop with a real store behind a listener on
+// a random local port
+func startTestServer(t *testing.T) (*Eventloop, string) {
+	l := listenTest(t)
+	return serveTest(t, l, nil), l.Addr().String()
+}
+
+func listenTest(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// serveTest runs an event loop serving connections from l. configure, if
+// given, is called before the loop starts.
+func serveTest(t *testing.T, l net.Listener, configure func(el *Eventloop)) *Eventloop {
+	el := InitEventloop(testDBs(testDatabases)...)
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		el.SetPort(addr.Port)
+	}
+	if configure != nil {
+		configure(el)
+	}
+	go el.RunLoop()
+	go el.StartReplicationTimer()
+	go el.Serve(l)
+
+	return el
+}
+
+// testConn is a connection to a test server that sends one command at a time
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestServer(t *testing.T, addr string) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *testConn) do(args ...string) protocol.Value {
+	if _, err := c.conn.Write(aof.Encode(args)); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+// read reads the next reply or pushed message
+func (c *testConn) read() protocol.Value {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	value, err := protocol.DecodeRESP(c.reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return value
+}
+
+// stringArray returns the strings in an array reply
+func stringArray(values []protocol.Value) []string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, v.String())
+	}
+	return strs
+}
+
+func splitHostPort(t *testing.T, addr string) (string, string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		t.Fatal(err)
+	}
+	return host, port
+}
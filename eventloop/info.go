@@ -0,0 +1,52 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"strings"
+)
+
+// infoSection is one section of the INFO reply
+type infoSection struct {
+	name string
+	gen  func(e *Eventloop) string
+	// only printed when asked for by name or with ALL or EVERYTHING
+	explicit bool
+}
+
+// infoSections lists the sections of the INFO reply in the order they are
+// printed
+var infoSections = []infoSection{
+	{name: "Server", gen: (*Eventloop).infoServer},
+	{name: "Clients", gen: (*Eventloop).infoClients},
+	{name: "Memory", gen: (*Eventloop).infoMemory},
+	{name: "Stats", gen: (*Eventloop).infoStats},
+	{name: "Replication", gen: (*Eventloop).infoReplication},
+	{name: "Commandstats", gen: (*Eventloop).infoCommandStats, explicit: true},
+	{name: "Cluster", gen: (*Eventloop).infoCluster},
+	{name: "Keyspace", gen: (*Eventloop).infoKeyspace},
+}
+
+// info renders the requested sections, or all of them if none are given
+func (e *Eventloop) info(args []string) protocol.Value {
+	defaults, all := len(args) == 0, false
+	wanted := make(map[string]bool)
+	for _, a := range args {
+		switch strings.ToLower(a) {
+		case "default":
+			defaults = true
+		case "all", "everything":
+			all = true
+		default:
+			wanted[strings.ToLower(a)] = true
+		}
+	}
+
+	parts := make([]string, 0, len(infoSections))
+	for _, s := range infoSections {
+		if all || (defaults && !s.explicit) || wanted[strings.ToLower(s.name)] {
+			parts = append(parts, "# "+s.name+"\r\n"+s.gen(e))
+		}
+	}
+
+	return protocol.NewBulkStringValue(strings.Join(parts, "\r\n"))
+}
@@ -0,0 +1,84 @@
+package eventloop
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// infoFields parses an INFO reply into its fields
+func infoFields(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\r\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok && !strings.HasPrefix(line, "#") {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+func Test_Info(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	c.do("SET", "foo", "bar")
+	c.do("SET", "gone", "soon", "1")
+	c.do("SELECT", "2")
+	c.do("SET", "other", "db")
+	c.do("SELECT", "0")
+	c.do("GET", "foo")
+	c.do("GET", "missing")
+	c.do("ZRANGE", "foo")
+
+	time.Sleep(1100 * time.Millisecond)
+	c.do("GET", "gone")
+
+	info := c.do("INFO").String()
+	assert.NotContains(info, "# Commandstats")
+	fields := infoFields(info)
+	assert.Equal(redisVersion, fields["redis_version"])
+	assert.Equal("1", fields["connected_clients"])
+	assert.Equal("1", fields["expired_keys"])
+	assert.Equal("1", fields["keyspace_hits"])
+	assert.Equal("2", fields["keyspace_misses"])
+	assert.Equal("10", fields["total_commands_processed"])
+	assert.Equal("keys=1,expires=0", fields["db0"])
+	assert.Equal("keys=1,expires=0", fields["db2"])
+	assert.NotContains(fields, "db1")
+
+	fields = infoFields(c.do("INFO", "commandstats").String())
+	assert.Len(fields, 5)
+	assert.Contains(fields["cmdstat_set"], "calls=3,")
+	assert.Contains(fields["cmdstat_get"], "calls=3,")
+	assert.Contains(fields["cmdstat_zrange"], "calls=1,")
+	assert.Contains(fields["cmdstat_zrange"], "failed_calls=1")
+	assert.Contains(fields["cmdstat_info"], "calls=1,")
+	assert.Contains(fields["cmdstat_select"], "calls=2,")
+
+	assert.Equal("OK", c.do("CONFIG", "RESETSTAT").String())
+	fields = infoFields(c.do("INFO", "stats", "commandstats").String())
+	assert.Equal("0", fields["keyspace_hits"])
+	assert.Equal("1", fields["total_commands_processed"])
+	assert.Contains(fields["cmdstat_config|resetstat"], "calls=1,")
+}
+
+func Test_Info_Keyspace_Expires(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	c.do("SET", "foo", "bar", "100")
+	assert.Equal("keys=1,expires=1", infoFields(c.do("INFO", "keyspace").String())["db0"])
+
+	// overwriting the key without a ttl makes it persistent
+	c.do("SET", "foo", "baz")
+	assert.Equal("keys=1,expires=0", infoFields(c.do("INFO", "keyspace").String())["db0"])
+
+	c.do("ZADD", "z", "1", "a")
+	c.do("EXPIRE", "z", "100")
+	c.do("ZADD", "z", "2", "b")
+	assert.Equal("keys=2,expires=0", infoFields(c.do("INFO", "keyspace").String())["db0"])
+}
@@ -0,0 +1,187 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// lazyfreeThreshold is how many elements a value must have for UNLINK
+	// to release it on another goroutine, below it is not worth it
+	lazyfreeThreshold = 64
+	// randomKeyTries is how many keys RANDOMKEY samples before giving up
+	// when they keep turning out to be expired
+	randomKeyTries = 100
+)
+
+// lazyfreeStats counts the values released away from the loop
+type lazyfreeStats struct {
+	pending atomic.Int64
+	freed   atomic.Int64
+}
+
+// exists counts the given keys that exist, a key given twice counting twice
+func (e *Eventloop) exists(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'exists' command")
+	}
+
+	count := 0
+	for _, k := range args[1:] {
+		if _, ok := e.dbs[c.db].Peek(k); ok {
+			count++
+		}
+	}
+	return protocol.NewSimpleIntValue(int64(count))
+}
+
+func (e *Eventloop) typeCommand(c *client, args []string) protocol.Value {
+	if len(args) != 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'type' command")
+	}
+
+	typ := "none"
+	if value, ok := e.dbs[c.db].Peek(args[1]); ok {
+		typ = value.Type()
+	}
+	return protocol.NewSimpleStringValue(&typ)
+}
+
+// rename moves the value of a key to another key along with its expiry,
+// replacing the target unless nx is set
+func (e *Eventloop) rename(c *client, args []string, nx bool) protocol.Value {
+	if len(args) != 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for '" + strings.ToLower(args[0]) + "' command")
+	}
+
+	db := e.dbs[c.db]
+	src, dst := args[1], args[2]
+	value, ok := db.Peek(src)
+	if !ok {
+		return protocol.NewErrorValue("ERR no such key")
+	}
+
+	if src == dst {
+		if nx {
+			return protocol.NewSimpleIntValue(0)
+		}
+		return protocol.NewSimpleStringValue(&OK)
+	}
+	if _, exists := db.Peek(dst); exists && nx {
+		return protocol.NewSimpleIntValue(0)
+	}
+
+	db.SetValue(dst, value)
+	db.Del(src)
+	if nx {
+		return protocol.NewSimpleIntValue(1)
+	}
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+// copyCommand copies the value of a key and its expiry to another key,
+// possibly in another database
+func (e *Eventloop) copyCommand(c *client, args []string) protocol.Value {
+	if len(args) < 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'copy' command")
+	}
+
+	target, replace := c.db, false
+	for i := 3; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "replace"):
+			replace = true
+		case strings.EqualFold(args[i], "db") && i+1 < len(args):
+			index, errResp, ok := e.dbIndex(args[i+1])
+			if !ok {
+				return errResp
+			}
+			if e.cluster != nil && index != 0 {
+				return protocol.NewErrorValue("ERR Copying to another database is not allowed in cluster mode")
+			}
+			target = index
+			i++
+		default:
+			return protocol.NewErrorValue("ERR syntax error")
+		}
+	}
+
+	src, dst := args[1], args[2]
+	if target == c.db && src == dst {
+		return protocol.NewErrorValue("ERR source and destination objects are the same")
+	}
+
+	value, ok := e.dbs[c.db].Peek(src)
+	if !ok {
+		return protocol.NewSimpleIntValue(0)
+	}
+	if _, exists := e.dbs[target].Peek(dst); exists && !replace {
+		return protocol.NewSimpleIntValue(0)
+	}
+
+	e.dbs[target].SetValue(dst, value.Clone())
+	return protocol.NewSimpleIntValue(1)
+}
+
+// touch counts as an access to the given keys, returning how many exist
+func (e *Eventloop) touch(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'touch' command")
+	}
+
+	count := 0
+	for _, k := range args[1:] {
+		if _, ok := e.dbs[c.db].GetValue(k); ok {
+			count++
+		}
+	}
+	return protocol.NewSimpleIntValue(int64(count))
+}
+
+// randomKey returns a key of the selected database picked at random
+func (e *Eventloop) randomKey(c *client, args []string) protocol.Value {
+	if len(args) != 1 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'randomkey' command")
+	}
+
+	db := e.dbs[c.db]
+	for i := 0; i < randomKeyTries; i++ {
+		sample := db.Sample(1, false)
+		if len(sample) == 0 {
+			break
+		}
+		// expired keys are deleted and another one is picked
+		if _, ok := db.Peek(sample[0].Key); ok {
+			return protocol.NewBulkStringValue(sample[0].Key)
+		}
+	}
+	return protocol.NewNilValue()
+}
+
+// unlink deletes keys like DEL, but releases large values on their own
+// goroutine rather than in the loop
+func (e *Eventloop) unlink(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'unlink' command")
+	}
+
+	count := 0
+	for _, k := range args[1:] {
+		value, ok := e.dbs[c.db].Unlink(k)
+		if !ok {
+			continue
+		}
+		count++
+
+		if value.Len() > lazyfreeThreshold {
+			e.lazyfree.pending.Add(1)
+			go func() {
+				value.Release()
+				e.lazyfree.pending.Add(-1)
+				e.lazyfree.freed.Add(1)
+			}()
+		}
+	}
+	return protocol.NewSimpleIntValue(int64(count))
+}
@@ -0,0 +1,84 @@
+package eventloop
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_KeyspaceCommands(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	c.do("SET", "foo", "bar")
+	c.do("ZADD", "z", "1", "a")
+	assert.Equal(int64(3), c.do("EXISTS", "foo", "foo", "z", "missing").Integer())
+	assert.Equal("string", c.do("TYPE", "foo").String())
+	assert.Equal("zset", c.do("TYPE", "z").String())
+	assert.Equal("none", c.do("TYPE", "missing").String())
+	assert.Equal(int64(2), c.do("TOUCH", "foo", "z", "missing").Integer())
+
+	// RENAME takes the expiry along
+	c.do("SET", "ttl", "x", "100")
+	assert.Equal("OK", c.do("RENAME", "ttl", "moved").String())
+	assert.Equal(int64(0), c.do("EXISTS", "ttl").Integer())
+	assert.Equal(int64(1), c.do("EXISTS", "moved").Integer())
+	assert.Contains(stringArray(c.do("SCAN", "0", "COUNT", "100").Array()[1].Array()), "moved")
+	assert.Equal("ERR no such key", c.do("RENAME", "ttl", "other").String())
+	assert.Equal("OK", c.do("RENAME", "z", "z2").String())
+	assert.Equal("zset", c.do("TYPE", "z2").String())
+
+	assert.Equal(int64(0), c.do("RENAMENX", "foo", "moved").Integer())
+	assert.Equal(int64(1), c.do("RENAMENX", "foo", "foo2").Integer())
+	assert.Equal("bar", c.do("GET", "foo2").String())
+
+	// COPY leaves the source alone and the target too without REPLACE
+	assert.Equal(int64(1), c.do("COPY", "foo2", "copy").Integer())
+	assert.Equal(int64(0), c.do("COPY", "moved", "copy").Integer())
+	assert.Equal(int64(1), c.do("COPY", "moved", "copy", "REPLACE").Integer())
+	assert.Equal("x", c.do("GET", "copy").String())
+	assert.Equal(int64(1), c.do("COPY", "z2", "z2", "DB", "3").Integer())
+	assert.Equal(int64(0), c.do("COPY", "missing", "other").Integer())
+	assert.True(c.do("COPY", "foo2", "foo2").IsError())
+	assert.True(c.do("COPY", "foo2", "x", "DB", "99").IsError())
+	c.do("SELECT", "3")
+	assert.Equal([]string{"a"}, stringArray(c.do("ZRANGE", "z2", "0", "-1").Array()))
+	c.do("SELECT", "0")
+
+	assert.Equal(int64(2), c.do("UNLINK", "copy", "foo2", "missing").Integer())
+	assert.Equal(int64(0), c.do("EXISTS", "copy", "foo2").Integer())
+}
+
+func Test_RandomKey(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	assert.Equal("", c.do("RANDOMKEY").String())
+	c.do("SET", "a", "1")
+	c.do("SET", "b", "2")
+	for i := 0; i < 10; i++ {
+		assert.Contains([]string{"a", "b"}, c.do("RANDOMKEY").String())
+	}
+}
+
+func Test_Unlink_Lazyfree(t *testing.T) {
+	assert := assert.New(t)
+	el, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	args := []string{"ZADD", "big"}
+	for i := 0; i < 1000; i++ {
+		args = append(args, strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	c.do(args...)
+	c.do("SET", "small", "x")
+
+	assert.Equal(int64(2), c.do("UNLINK", "big", "small").Integer())
+	assert.Equal(int64(0), c.do("DBSIZE").Integer())
+	assert.Eventually(func() bool { return el.lazyfree.freed.Load() == 1 }, time.Second, time.Millisecond)
+	assert.Contains(c.do("INFO", "stats").String(), "lazyfreed_objects:1")
+}
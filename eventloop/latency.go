@@ -0,0 +1,253 @@
+package eventloop
+
+import (
+	"math/bits"
+	"noelzubin/redis-go/protocol"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyHistoryLen is how many samples are kept for each event
+const latencyHistoryLen = 160
+
+// latencySample is the worst latency of an event within a second
+type latencySample struct {
+	time    int64
+	latency int64
+}
+
+type latencyEvent struct {
+	// oldest first
+	samples []latencySample
+	max     int64
+}
+
+// latencyMonitor records events such as commands, expire cycles and fsyncs
+// that took at least latency-monitor-threshold milliseconds. Fsyncs in the
+// background are recorded from outside the loop, so it has a lock of its
+// own. The threshold is checked before taking it, as every command is
+// reported.
+type latencyMonitor struct {
+	mu sync.Mutex
+	// zero disables the monitor
+	threshold atomic.Int64
+	events    map[string]*latencyEvent
+}
+
+func newLatencyMonitor() *latencyMonitor {
+	return &latencyMonitor{events: make(map[string]*latencyEvent)}
+}
+
+func (m *latencyMonitor) getThreshold() int64 {
+	return m.threshold.Load()
+}
+
+func (m *latencyMonitor) setThreshold(ms int64) {
+	m.threshold.Store(ms)
+}
+
+// add records that event took d, if that reaches the threshold
+func (m *latencyMonitor) add(event string, d time.Duration) {
+	ms, threshold := d.Milliseconds(), m.threshold.Load()
+	if threshold == 0 || ms < threshold {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ev, ok := m.events[event]
+	if !ok {
+		ev = &latencyEvent{}
+		m.events[event] = ev
+	}
+	if ms > ev.max {
+		ev.max = ms
+	}
+
+	now := time.Now().Unix()
+	if n := len(ev.samples); n > 0 && ev.samples[n-1].time == now {
+		if ms > ev.samples[n-1].latency {
+			ev.samples[n-1].latency = ms
+		}
+		return
+	}
+	ev.samples = append(ev.samples, latencySample{time: now, latency: ms})
+	if len(ev.samples) > latencyHistoryLen {
+		ev.samples = ev.samples[1:]
+	}
+}
+
+// names returns the names of the events recorded so far, sorted. m.mu
+// must be held.
+func (m *latencyMonitor) names() []string {
+	names := make([]string, 0, len(m.events))
+	for name := range m.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reset forgets the given events, or every event if none is given, and
+// returns how many were forgotten
+func (m *latencyMonitor) reset(events []string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = make(map[string]*latencyEvent)
+		return n
+	}
+
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[strings.ToLower(event)]; ok {
+			delete(m.events, strings.ToLower(event))
+			n++
+		}
+	}
+	return n
+}
+
+// latencyHistogramBuckets is the number of buckets of the per command
+// latency histograms, bucket i counting the calls that took up to 2^i
+// microseconds
+const latencyHistogramBuckets = 40
+
+// latencyBucket returns the bucket of the histogram a call taking usec
+// microseconds falls in
+func latencyBucket(usec int64) int {
+	if usec <= 1 {
+		return 0
+	}
+	b := bits.Len64(uint64(usec - 1))
+	if b >= latencyHistogramBuckets {
+		b = latencyHistogramBuckets - 1
+	}
+	return b
+}
+
+// latencyCommand implements LATENCY LATEST, HISTORY event, RESET [event ...]
+// and HISTOGRAM [command ...]
+func (e *Eventloop) latencyCommand(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'latency' command")
+	}
+
+	m := e.latency
+	switch strings.ToLower(args[1]) {
+	case "latest":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'latency|latest' command")
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		latest := make([]protocol.Value, 0, len(m.events))
+		for _, name := range m.names() {
+			ev := m.events[name]
+			last := ev.samples[len(ev.samples)-1]
+			latest = append(latest, protocol.NewArrayValue([]protocol.Value{
+				protocol.NewBulkStringValue(name),
+				protocol.NewSimpleIntValue(last.time),
+				protocol.NewSimpleIntValue(last.latency),
+				protocol.NewSimpleIntValue(ev.max),
+			}))
+		}
+		return protocol.NewArrayValue(latest)
+	case "history":
+		if len(args) != 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'latency|history' command")
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		history := []protocol.Value{}
+		if ev, ok := m.events[strings.ToLower(args[2])]; ok {
+			for _, s := range ev.samples {
+				history = append(history, protocol.NewArrayValue([]protocol.Value{
+					protocol.NewSimpleIntValue(s.time),
+					protocol.NewSimpleIntValue(s.latency),
+				}))
+			}
+		}
+		return protocol.NewArrayValue(history)
+	case "reset":
+		return protocol.NewSimpleIntValue(int64(m.reset(args[2:])))
+	case "histogram":
+		return e.latencyHistogram(c, args[2:])
+	}
+
+	return protocol.NewErrorValue("ERR unknown subcommand '" + args[1] + "'. Try LATENCY HELP.")
+}
+
+// latencyHistogram replies with the cumulative distribution of the latency
+// of the given commands, or of every command that was called. A command
+// with subcommands stands for all of them.
+func (e *Eventloop) latencyHistogram(c *client, commands []string) protocol.Value {
+	calls := e.callStats()
+	names := make([]string, 0, len(calls.commands))
+	for name, stats := range calls.commands {
+		if stats.calls == 0 {
+			continue
+		}
+		if len(commands) > 0 && !matchCommandName(name, commands) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	histograms := make([]protocol.Value, 0, 2*len(names))
+	for _, name := range names {
+		stats := calls.commands[name]
+
+		buckets := []protocol.Value{}
+		var count int64
+		for i, n := range stats.latency {
+			if count == 0 && n == 0 {
+				continue
+			}
+			count += n
+			buckets = append(buckets, protocol.NewSimpleIntValue(1<<i), protocol.NewSimpleIntValue(count))
+			if count == stats.calls {
+				break
+			}
+		}
+
+		histograms = append(histograms,
+			protocol.NewBulkStringValue(name),
+			c.mapValue(
+				protocol.NewBulkStringValue("calls"), protocol.NewSimpleIntValue(stats.calls),
+				protocol.NewBulkStringValue("histogram_usec"), c.mapValue(buckets...),
+			),
+		)
+	}
+	return c.mapValue(histograms...)
+}
+
+// matchCommandName reports whether the stats reported under name belong to
+// one of commands
+func matchCommandName(name string, commands []string) bool {
+	for _, cmd := range commands {
+		cmd = strings.ToLower(cmd)
+		if name == cmd || strings.HasPrefix(name, cmd+"|") {
+			return true
+		}
+	}
+	return false
+}
+
+// latencyEventName names the latency event of a command, Redis telling
+// fast commands apart from the others
+func latencyEventName(cmd command) string {
+	if cmd.hasCategory("fast") {
+		return "fast-command"
+	}
+	return "command"
+}
@@ -0,0 +1,68 @@
+package eventloop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LatencyMonitor(t *testing.T) {
+	assert := assert.New(t)
+	m := newLatencyMonitor()
+
+	// disabled until a threshold is set
+	m.add("command", time.Second)
+	assert.Empty(m.events)
+
+	m.setThreshold(100)
+	m.add("command", 50*time.Millisecond)
+	m.add("command", 200*time.Millisecond)
+	m.add("command", 300*time.Millisecond)
+	m.add("expire-cycle", 150*time.Millisecond)
+
+	assert.Equal([]string{"command", "expire-cycle"}, m.names())
+	// samples within the same second keep the worst one
+	assert.Len(m.events["command"].samples, 1)
+	assert.Equal(int64(300), m.events["command"].samples[0].latency)
+
+	assert.Equal(1, m.reset([]string{"expire-cycle", "nosuch"}))
+	assert.Equal(1, m.reset(nil))
+}
+
+func Test_LatencyBucket(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, latencyBucket(0))
+	assert.Equal(0, latencyBucket(1))
+	assert.Equal(1, latencyBucket(2))
+	assert.Equal(2, latencyBucket(3))
+	assert.Equal(10, latencyBucket(1024))
+	assert.Equal(latencyHistogramBuckets-1, latencyBucket(1<<50))
+}
+
+func Test_Latency(t *testing.T) {
+	assert := assert.New(t)
+	el, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	assert.Equal("OK", c.do("CONFIG", "SET", "latency-monitor-threshold", "10").String())
+	el.latency.add("expire-cycle", 20*time.Millisecond)
+
+	latest := c.do("LATENCY", "LATEST").Array()
+	assert.Len(latest, 1)
+	assert.Equal("expire-cycle", latest[0].Array()[0].String())
+	assert.Equal(int64(20), latest[0].Array()[3].Integer())
+	assert.Len(c.do("LATENCY", "HISTORY", "expire-cycle").Array(), 1)
+	assert.Empty(c.do("LATENCY", "HISTORY", "nosuch").Array())
+	assert.Equal(int64(1), c.do("LATENCY", "RESET").Integer())
+
+	c.do("SET", "foo", "bar")
+	c.do("SET", "foo", "baz")
+	histogram := c.do("LATENCY", "HISTOGRAM", "set").Array()
+	assert.Equal("set", histogram[0].String())
+	set := histogram[1].Array()
+	assert.Equal("calls", set[0].String())
+	assert.Equal(int64(2), set[1].Integer())
+	buckets := set[3].Array()
+	assert.Equal(int64(2), buckets[len(buckets)-1].Integer())
+}
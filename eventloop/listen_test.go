@@ -0,0 +1,49 @@
+package eventloop
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Unix_Socket(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "redis.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	tcp := listenTest(t)
+
+	// connections from both listeners reach the same loop
+	el := serveTest(t, l, nil)
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go el.HandleConnection(conn)
+		}
+	}()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	assert.Equal("OK", c.do("SET", "foo", "bar").String())
+	assert.Equal("bar", dialTestServer(t, tcp.Addr().String()).do("GET", "foo").String())
+
+	// clients on the socket are known by its path
+	c.do("AUTH", "nobody", "pw")
+	log := c.do("ACL", "LOG").Array()
+	assert.Contains(log[0].Array()[13].String(), "addr="+path+":0")
+}
@@ -0,0 +1,171 @@
+package eventloop
+
+import (
+	"fmt"
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/store"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// defaultMemoryUsageSamples is how many members of a collection MEMORY USAGE
+// looks at to estimate its size
+const defaultMemoryUsageSamples = 5
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+func (e *Eventloop) memoryCommand(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'memory' command")
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "usage":
+		return e.memoryUsage(c, args)
+	case "stats":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'memory|stats' command")
+		}
+		return e.memoryStats(c)
+	case "doctor":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'memory|doctor' command")
+		}
+		return protocol.NewBulkStringValue(e.memoryDoctor())
+	case "help":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'memory|help' command")
+		}
+		return protocol.NewArrayStringValue(memoryHelp)
+	}
+
+	return protocol.NewErrorValue("ERR unknown subcommand '" + args[1] + "'. Try MEMORY HELP.")
+}
+
+// memoryUsage estimates the bytes held by a key and its value
+func (e *Eventloop) memoryUsage(c *client, args []string) protocol.Value {
+	if len(args) != 3 && len(args) != 5 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'memory|usage' command")
+	}
+
+	samples := defaultMemoryUsageSamples
+	if len(args) == 5 {
+		if !strings.EqualFold(args[3], "samples") {
+			return protocol.NewErrorValue("ERR syntax error")
+		}
+		n, err := strconv.Atoi(args[4])
+		if err != nil || n < 0 {
+			return protocol.NewErrorValue("ERR value is not an integer or out of range")
+		}
+		samples = n
+	}
+
+	value, ok := e.dbs[c.db].Peek(args[2])
+	if !ok {
+		return protocol.NewNilValue()
+	}
+	return protocol.NewSimpleIntValue(store.MemoryUsage(args[2], value, samples))
+}
+
+// memoryStats breaks down the memory of the server like Redis does, with
+// the dataset estimated by the stores and the rest taken from the runtime
+func (e *Eventloop) memoryStats(c *client) protocol.Value {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	dataset := e.usedMemory()
+	dbs := e.dbStats()
+	startup := int64(e.stats.startupAllocated)
+	total := int64(m.HeapAlloc)
+
+	overhead := total - dataset
+	if overhead < 0 {
+		overhead = 0
+	}
+	var perKey int64
+	if dbs.Keys > 0 {
+		perKey = (total - startup) / int64(dbs.Keys)
+	}
+	var percentage float64
+	if total > startup {
+		percentage = float64(dataset) * 100 / float64(total-startup)
+	}
+	var fragmentation float64
+	if m.HeapAlloc > 0 {
+		fragmentation = float64(m.Sys) / float64(m.HeapAlloc)
+	}
+
+	vals := []protocol.Value{
+		protocol.NewBulkStringValue("total.allocated"), protocol.NewSimpleIntValue(total),
+		protocol.NewBulkStringValue("startup.allocated"), protocol.NewSimpleIntValue(startup),
+		protocol.NewBulkStringValue("overhead.total"), protocol.NewSimpleIntValue(overhead),
+		protocol.NewBulkStringValue("keys.count"), protocol.NewSimpleIntValue(int64(dbs.Keys)),
+		protocol.NewBulkStringValue("keys.bytes-per-key"), protocol.NewSimpleIntValue(perKey),
+		protocol.NewBulkStringValue("dataset.bytes"), protocol.NewSimpleIntValue(dataset),
+		protocol.NewBulkStringValue("dataset.percentage"), protocol.NewBulkStringValue(strconv.FormatFloat(percentage, 'f', 2, 64)),
+		protocol.NewBulkStringValue("allocator.allocated"), protocol.NewSimpleIntValue(int64(m.HeapAlloc)),
+		protocol.NewBulkStringValue("allocator.resident"), protocol.NewSimpleIntValue(int64(m.Sys)),
+		protocol.NewBulkStringValue("fragmentation"), protocol.NewBulkStringValue(strconv.FormatFloat(fragmentation, 'f', 2, 64)),
+	}
+	for i, db := range e.dbs {
+		s := db.Stats()
+		if s.Keys == 0 {
+			continue
+		}
+		vals = append(vals, protocol.NewBulkStringValue("db."+strconv.Itoa(i)), c.mapValue(
+			protocol.NewBulkStringValue("keys"), protocol.NewSimpleIntValue(int64(s.Keys)),
+			protocol.NewBulkStringValue("expires"), protocol.NewSimpleIntValue(int64(s.Expires)),
+			protocol.NewBulkStringValue("dataset.bytes"), protocol.NewSimpleIntValue(db.UsedMemory()),
+		))
+	}
+	return c.mapValue(vals...)
+}
+
+// memoryDoctor looks for the memory problems Redis reports on, and says so
+// if there are none
+func (e *Eventloop) memoryDoctor() string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	if e.dbStats().Keys == 0 {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	var issues []string
+	used := e.usedMemory()
+	if max := e.evict.maxmemory; max > 0 && used*100 > max*90 {
+		issue := fmt.Sprintf(" * High memory use: the dataset is %s, over 90%% of maxmemory (%s).",
+			humanBytes(uint64(used)), humanBytes(uint64(max)))
+		if e.evict.policy == "noeviction" {
+			issue += " With the noeviction policy writes will soon be refused, consider an eviction policy or a higher maxmemory."
+		}
+		issues = append(issues, issue)
+	}
+	if m.HeapAlloc > 1<<20 && float64(m.Sys)/float64(m.HeapAlloc) > 1.4 {
+		issues = append(issues, fmt.Sprintf(" * High fragmentation: the process holds %s for a heap of %s (%.2f ratio). "+
+			"This is common after deleting many keys, the runtime returns the memory over time.",
+			humanBytes(m.Sys), humanBytes(m.HeapAlloc), float64(m.Sys)/float64(m.HeapAlloc)))
+	}
+	if n := len(e.monitors); n > 0 {
+		issues = append(issues, fmt.Sprintf(" * %d MONITOR clients: each of them buffers every command the server runs.", n))
+	}
+
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" + strings.Join(issues, "\n\n") +
+		"\n\nI'm here to keep you safe, Sam. I want to help you.\n"
+}
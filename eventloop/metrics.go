@@ -0,0 +1,108 @@
+package eventloop
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"noelzubin/redis-go/metrics"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// command latency and queue wait histograms
+var latencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// loopMetrics holds the histograms exported on /metrics. Counters, and the
+// latency of each command, come from the INFO stats.
+type loopMetrics struct {
+	// time connections spend blocked handing a command to the loop,
+	// observed by the connection goroutines
+	queueWait *metrics.Histogram
+}
+
+func newLoopMetrics() *loopMetrics {
+	return &loopMetrics{queueWait: metrics.NewHistogram(latencyBuckets...)}
+}
+
+// metricsRequest asks the loop to render the metrics
+type metricsRequest struct {
+	respChan chan []byte
+}
+
+// ServeMetrics serves the Prometheus metrics of the server on /metrics to
+// HTTP clients connecting to l, until the server shuts down
+func (e *Eventloop) ServeMetrics(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		req := metricsRequest{respChan: make(chan []byte, 1)}
+		if !e.send(req) {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(<-req.respChan)
+	})
+	if !e.addListener(l) {
+		return nil
+	}
+	if err := http.Serve(l, mux); err != nil && !e.stopped() {
+		return err
+	}
+	return nil
+}
+
+// writeMetrics renders the metrics in the Prometheus text format
+func (e *Eventloop) writeMetrics() []byte {
+	var b bytes.Buffer
+	w := metrics.NewWriter(&b)
+	dbs := e.dbStats()
+	calls := e.callStats()
+
+	w.Gauge("redis_uptime_seconds", "Time since the server started.", time.Since(e.stats.startTime).Seconds())
+	w.Gauge("redis_connected_clients", "Number of client connections.", float64(len(e.clients)))
+	w.Gauge("redis_memory_used_dataset_bytes", "Estimated bytes held by keys and values.", float64(e.usedMemory()))
+	w.Gauge("redis_memory_max_bytes", "The maxmemory limit, 0 if there is none.", float64(e.evict.maxmemory))
+	w.Counter("redis_connections_received_total", "Connections accepted by the server.", float64(e.stats.totalConnections))
+	w.Counter("redis_commands_processed_total", "Commands received, including rejected and unknown ones.", float64(calls.total))
+
+	names := make([]string, 0, len(calls.commands))
+	for name := range calls.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		w.Counter("redis_commands_total", "Calls of each command.", float64(calls.commands[name].calls), metrics.L("cmd", name))
+	}
+	for _, name := range names {
+		w.Counter("redis_commands_failed_total", "Calls of each command that returned an error.", float64(calls.commands[name].failed), metrics.L("cmd", name))
+	}
+	for _, name := range names {
+		w.Counter("redis_commands_rejected_total", "Calls of each command refused before running.", float64(calls.commands[name].rejected), metrics.L("cmd", name))
+	}
+	for _, name := range names {
+		if h, ok := calls.histograms[name]; ok {
+			w.Histogram("redis_command_duration_seconds", "Time spent executing each command.", h, metrics.L("cmd", name))
+		}
+	}
+
+	for i, db := range e.dbs {
+		w.Gauge("redis_db_keys", "Number of keys in each database.", float64(db.Stats().Keys), metrics.L("db", "db"+strconv.Itoa(i)))
+	}
+	for i, db := range e.dbs {
+		w.Gauge("redis_db_keys_expiring", "Number of keys with an expiry in each database.", float64(db.Stats().Expires), metrics.L("db", "db"+strconv.Itoa(i)))
+	}
+	w.Counter("redis_expired_keys_total", "Keys deleted because they expired.", float64(dbs.ExpiredKeys))
+	w.Gauge("redis_expired_stale_percent", "Estimated percentage of keys with an expiry that are expired but not deleted yet.", e.expire.stalePerc)
+	w.Counter("redis_expire_cycle_time_cap_reached_total", "Active expiry cycles that stopped at their time limit.", float64(e.expire.timeCapReached))
+	w.Counter("redis_expire_cycle_seconds_total", "Time spent in the active expiry cycle.", e.expire.cpuTime.Seconds())
+	w.Counter("redis_evicted_keys_total", "Keys deleted to free memory.", float64(dbs.EvictedKeys))
+	w.Counter("redis_keyspace_hits_total", "Lookups of keys that existed.", float64(dbs.Hits))
+	w.Counter("redis_keyspace_misses_total", "Lookups of keys that did not exist.", float64(dbs.Misses))
+
+	w.Histogram("redis_eventloop_queue_wait_seconds", "Time connections wait for the event loop to take their command.", e.metrics.queueWait)
+
+	return b.Bytes()
+}
@@ -0,0 +1,39 @@
+package eventloop
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Metrics(t *testing.T) {
+	assert := assert.New(t)
+	el, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	ml := listenTest(t)
+	go el.ServeMetrics(ml)
+
+	c.do("SET", "foo", "bar")
+	c.do("GET", "foo")
+	c.do("GET", "missing")
+
+	resp, err := http.Get("http://" + ml.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	metrics := string(body)
+
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Contains(metrics, "# TYPE redis_commands_total counter\n")
+	assert.Contains(metrics, `redis_commands_total{cmd="get"} 2`+"\n")
+	assert.Contains(metrics, `redis_command_duration_seconds_count{cmd="set"} 1`+"\n")
+	assert.Contains(metrics, "redis_connected_clients 1\n")
+	assert.Contains(metrics, `redis_db_keys{db="db0"} 1`+"\n")
+	assert.Contains(metrics, "redis_keyspace_misses_total 1\n")
+	assert.Contains(metrics, "redis_eventloop_queue_wait_seconds_count 3\n")
+}
@@ -0,0 +1,132 @@
+package eventloop
+
+import (
+	"fmt"
+	"net"
+	"noelzubin/redis-go/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// monitor implements MONITOR, after which the client is sent every command
+// the loop runs. The feed goes through the pushes of the client, so a
+// monitor that reads too slowly is disconnected instead of holding up the
+// loop.
+func (e *Eventloop) monitor(c *client, args []string) protocol.Value {
+	if len(args) != 1 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'monitor' command")
+	}
+	e.monitors[c] = true
+	c.monitor = true
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+// feedMonitors sends a command run by c to the monitors. Admin commands are
+// left out.
+func (e *Eventloop) feedMonitors(c *client, cmd command, args []string) {
+	if len(e.monitors) == 0 || cmd.flags&flagAdmin != 0 {
+		return
+	}
+
+	addr := c.addr
+	if c.master && e.repl.link != nil {
+		addr = net.JoinHostPort(e.repl.link.host, strconv.Itoa(e.repl.link.port))
+	}
+
+	line := monitorLine(time.Now(), c.db, addr, redactArgs(cmd, args))
+	for m := range e.monitors {
+		e.push(m, protocol.NewSimpleStringValue(&line))
+	}
+}
+
+// monitorLine formats a command the way Redis shows it to monitors:
+// 1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func monitorLine(t time.Time, db int, addr string, args []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [%d %s]", t.Unix(), t.Nanosecond()/1000, db, addr)
+	for _, arg := range args {
+		b.WriteByte(' ')
+		b.WriteString(quoteArg(arg))
+	}
+	return b.String()
+}
+
+// quoteArg quotes an argument, escaping quotes, backslashes and bytes that
+// are not printable
+func quoteArg(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c < ' ' || c > '~' {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// redacted replaces secrets in the commands shown to monitors and kept in
+// the slow log
+const redacted = "(redacted)"
+
+// redactArgs returns args with the passwords given to AUTH, HELLO and
+// MIGRATE replaced
+func redactArgs(cmd command, args []string) []string {
+	var secrets []int
+	switch cmd.name {
+	case "auth":
+		for i := 1; i < len(args); i++ {
+			secrets = append(secrets, i)
+		}
+	case "hello":
+		for i := 2; i < len(args); i++ {
+			if strings.EqualFold(args[i], "auth") {
+				secrets = append(secrets, i+1, i+2)
+				i += 2
+			}
+		}
+	case "migrate":
+		for i := 6; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "auth":
+				secrets = append(secrets, i+1)
+				i++
+			case "auth2":
+				secrets = append(secrets, i+1, i+2)
+				i += 2
+			case "keys":
+				i = len(args)
+			}
+		}
+	}
+
+	if len(secrets) == 0 {
+		return args
+	}
+	redactedArgs := append([]string{}, args...)
+	for _, i := range secrets {
+		if i < len(redactedArgs) {
+			redactedArgs[i] = redacted
+		}
+	}
+	return redactedArgs
+}
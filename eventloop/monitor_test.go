@@ -0,0 +1,53 @@
+package eventloop
+
+import (
+	"io"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Monitor(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	m := dialTestServer(t, addr)
+	c := dialTestServer(t, addr)
+
+	assert.Equal("OK", m.do("MONITOR").String())
+	c.do("SELECT", "2")
+	c.do("SET", "foo", "say \"hi\"\n")
+	c.do("CONFIG", "GET", "hz")
+	c.do("AUTH", "default", "secret")
+	c.do("HELLO", "2", "AUTH", "default", "secret")
+
+	prefix := `^\d+\.\d{6} \[\d+ ` + regexp.QuoteMeta(c.conn.LocalAddr().String()) + `\] `
+	assert.Regexp(prefix+`"SELECT" "2"$`, m.read().String())
+	assert.Regexp(prefix+`"SET" "foo" "say \\"hi\\"\\n"$`, m.read().String())
+	// admin commands are not shown and passwords are redacted
+	assert.Regexp(prefix+`"AUTH" "\(redacted\)" "\(redacted\)"$`, m.read().String())
+	assert.Regexp(prefix+`"HELLO" "2" "AUTH" "\(redacted\)" "\(redacted\)"$`, m.read().String())
+
+	assert.Contains(c.do("CLIENT", "LIST").String(), " flags=O ")
+}
+
+func Test_Monitor_Slow(t *testing.T) {
+	assert := assert.New(t)
+	el := InitEventloop(testDBs(1)...)
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	// a monitor that never reads has its connection closed once its
+	// messages pile up, and the loop goes on
+	m := newClient(conn)
+	el.monitors[m] = true
+	for i := 0; i <= pushQueueSize; i++ {
+		el.feedMonitors(&client{}, commandTable["get"], []string{"GET", "foo"})
+	}
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := peer.Read(make([]byte, 1))
+	assert.ErrorIs(err, io.EOF)
+}
@@ -0,0 +1,80 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"strings"
+)
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// lfuPolicy reports whether keys are evicted by access frequency, in which
+// case OBJECT reports the frequency rather than the idle time
+func (e *Eventloop) lfuPolicy() bool {
+	return strings.HasSuffix(e.evict.policy, "-lfu")
+}
+
+// objectCommand inspects the value stored at a key without counting as an
+// access to it
+func (e *Eventloop) objectCommand(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'object' command")
+	}
+
+	sub := strings.ToLower(args[1])
+	if sub == "help" {
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'object|help' command")
+		}
+		return protocol.NewArrayStringValue(objectHelp)
+	}
+
+	switch sub {
+	case "encoding", "refcount", "idletime", "freq":
+	default:
+		return protocol.NewErrorValue("ERR unknown subcommand '" + args[1] + "'. Try OBJECT HELP.")
+	}
+	if len(args) != 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'object|" + sub + "' command")
+	}
+
+	value, ok := e.dbs[c.db].Peek(args[2])
+	if !ok {
+		return protocol.NewNilValue()
+	}
+
+	switch sub {
+	case "encoding":
+		return protocol.NewBulkStringValue(value.Encoding())
+	case "refcount":
+		// values are never shared between keys
+		return protocol.NewSimpleIntValue(1)
+	case "idletime":
+		if e.lfuPolicy() {
+			return protocol.NewErrorValue("ERR An LFU maxmemory policy is selected, idle time not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return protocol.NewSimpleIntValue(int64(value.Idle().Seconds()))
+	default:
+		if !e.lfuPolicy() {
+			return protocol.NewErrorValue("ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return protocol.NewSimpleIntValue(int64(value.Freq()))
+	}
+}
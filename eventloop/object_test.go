@@ -0,0 +1,69 @@
+package eventloop
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Object(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	c.do("SET", "n", "12")
+	c.do("SET", "s", "hello")
+	c.do("ZADD", "z", "1", "a")
+	assert.Equal("int", c.do("OBJECT", "ENCODING", "n").String())
+	assert.Equal("embstr", c.do("OBJECT", "ENCODING", "s").String())
+	assert.Equal("listpack", c.do("OBJECT", "ENCODING", "z").String())
+	assert.Equal("", c.do("OBJECT", "ENCODING", "missing").String())
+	assert.Equal(int64(1), c.do("OBJECT", "REFCOUNT", "s").Integer())
+	assert.Equal(int64(0), c.do("OBJECT", "IDLETIME", "s").Integer())
+
+	// the frequency is only tracked under an LFU policy, the idle time
+	// under the others
+	assert.True(c.do("OBJECT", "FREQ", "s").IsError())
+	c.do("CONFIG", "SET", "maxmemory-policy", "allkeys-lfu")
+	assert.GreaterOrEqual(c.do("OBJECT", "FREQ", "s").Integer(), int64(5))
+	assert.True(c.do("OBJECT", "IDLETIME", "s").IsError())
+
+	assert.Greater(len(c.do("OBJECT", "HELP").Array()), 1)
+	assert.True(c.do("OBJECT", "ENCODING").IsError())
+	assert.True(c.do("OBJECT", "WHATEVER", "s").IsError())
+}
+
+func Test_Memory(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	assert.Contains(c.do("MEMORY", "DOCTOR").String(), "empty")
+
+	c.do("SET", "small", "x")
+	c.do("SET", "big", strings.Repeat("x", 1000))
+	small := c.do("MEMORY", "USAGE", "small").Integer()
+	big := c.do("MEMORY", "USAGE", "big", "SAMPLES", "0").Integer()
+	assert.Greater(big-small, int64(990))
+	assert.Equal("", c.do("MEMORY", "USAGE", "missing").String())
+	assert.True(c.do("MEMORY", "USAGE", "big", "SAMPLES", "-1").IsError())
+
+	reply := c.do("MEMORY", "STATS").Array()
+	stats := stringArray(reply)
+	assert.Contains(stats, "dataset.bytes")
+	assert.Contains(stats, "db.0")
+	assert.Equal(int64(2), reply[indexOf(stats, "keys.count")+1].Integer())
+
+	assert.Contains(c.do("MEMORY", "DOCTOR").String(), "Sam")
+	assert.True(c.do("MEMORY", "WHATEVER").IsError())
+}
+
+func indexOf(strs []string, s string) int {
+	for i, str := range strs {
+		if str == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,154 @@
+package eventloop
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/protocol"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Pipeline(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	other := dialTestServer(t, addr)
+
+	// replies come back in the order of the commands, whatever their number
+	var batch []byte
+	for i := 0; i < 3*maxBatch; i++ {
+		batch = append(batch, aof.Encode([]string{"SET", "key", strconv.Itoa(i)})...)
+		batch = append(batch, aof.Encode([]string{"GET", "key"})...)
+	}
+	_, err := c.conn.Write(batch)
+	assert.NoError(err)
+	for i := 0; i < 3*maxBatch; i++ {
+		assert.Equal("OK", c.read().String())
+		assert.Equal(strconv.Itoa(i), c.read().String())
+	}
+
+	// the commands after a pause wait for it to end
+	batch = aof.Encode([]string{"CLIENT", "PAUSE", "100000", "WRITE"})
+	batch = append(batch, aof.Encode([]string{"SET", "key", "paused"})...)
+	batch = append(batch, aof.Encode([]string{"GET", "key"})...)
+	_, err = c.conn.Write(batch)
+	assert.NoError(err)
+	assert.Equal("OK", c.read().String())
+
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = c.reader.Peek(1)
+	assert.Error(err)
+	c.conn.SetReadDeadline(time.Time{})
+
+	assert.Equal("OK", other.do("CLIENT", "UNPAUSE").String())
+	assert.Equal("OK", c.read().String())
+	assert.Equal("paused", c.read().String())
+}
+
+func Test_Protocol_Error(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	assert.Equal("OK", c.do("CONFIG", "SET", "proto-max-bulk-len", "1mb").String())
+	assert.Equal([]string{"proto-max-bulk-len", "1048576"}, stringArray(c.do("CONFIG", "GET", "proto-max-bulk-len").Array()))
+	assert.Equal("ERR CONFIG SET failed (possibly related to argument 'proto-max-bulk-len') - argument must be between 1048576 and 4294967294 inclusive",
+		c.do("CONFIG", "SET", "proto-max-bulk-len", "1000").String())
+
+	// the commands before the bad one still run, then the connection is
+	// closed without waiting for the string
+	batch := aof.Encode([]string{"SET", "key", "value"})
+	batch = append(batch, "*2\r\n$3\r\nGET\r\n$9999999999\r\n"...)
+	_, err := c.conn.Write(batch)
+	assert.NoError(err)
+	assert.Equal("OK", c.read().String())
+	assert.Equal("ERR Protocol error: invalid bulk length", c.read().String())
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.reader.Peek(1)
+	assert.Equal(io.EOF, err)
+
+	other := dialTestServer(t, addr)
+	assert.Equal("value", other.do("GET", "key").String())
+}
+
+// benchmarkServer runs a test server for a benchmark and connects to it
+func benchmarkServer(b *testing.B) (net.Conn, *bufio.Reader) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { l.Close() })
+
+	el := InitEventloop(testDBs(testDatabases)...)
+	go el.RunLoop()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go el.HandleConnection(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+// benchmarkPipeline sends SET and GET of random keys in batches of depth
+// commands, reading every reply of a batch before sending the next one. An
+// op is a batch, ops/s counts commands. Allocations are those of the client
+// and the server together.
+func benchmarkPipeline(b *testing.B, depth int) {
+	conn, reader := benchmarkServer(b)
+
+	batches := make([][]byte, 0, 16)
+	for i := 0; i < 16; i++ {
+		var batch []byte
+		for j := 0; j < depth; j++ {
+			k := "key:" + strconv.Itoa((i*depth+j)%1000)
+			if j%2 == 0 {
+				batch = append(batch, aof.Encode([]string{"SET", k, "value"})...)
+			} else {
+				batch = append(batch, aof.Encode([]string{"GET", k})...)
+			}
+		}
+		batches = append(batches, batch)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(batches[i%len(batches)]); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < depth; j++ {
+			if _, err := protocol.DecodeRESP(reader); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(b.N*depth)/time.Since(start).Seconds(), "ops/s")
+}
+
+func BenchmarkRoundTrip(b *testing.B) {
+	benchmarkPipeline(b, 1)
+}
+
+func BenchmarkPipeline16(b *testing.B) {
+	benchmarkPipeline(b, 16)
+}
+
+func BenchmarkPipeline128(b *testing.B) {
+	benchmarkPipeline(b, 128)
+}
@@ -0,0 +1,130 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"strings"
+)
+
+// allowedSubscribed reports whether a RESP2 client subscribed to channels
+// can run the command
+func allowedSubscribed(name string) bool {
+	switch strings.ToLower(name) {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ssubscribe", "sunsubscribe", "ping", "quit", "reset":
+		return true
+	}
+	return false
+}
+
+// subscribe implements SUBSCRIBE channel [channel ...]. Each channel is
+// confirmed with a message of its own, the first one being the reply.
+func (e *Eventloop) subscribe(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'subscribe' command")
+	}
+
+	confirmations := make([]protocol.Value, 0, len(args)-1)
+	for _, ch := range args[1:] {
+		if !c.channels[ch] {
+			c.channels[ch] = true
+			if e.channels[ch] == nil {
+				e.channels[ch] = make(map[*client]bool)
+			}
+			e.channels[ch][c] = true
+		}
+		confirmations = append(confirmations, c.pushValue(
+			protocol.NewBulkStringValue("subscribe"),
+			protocol.NewBulkStringValue(ch),
+			protocol.NewSimpleIntValue(int64(len(c.channels))),
+		))
+	}
+	return e.confirm(c, confirmations)
+}
+
+// unsubscribe implements UNSUBSCRIBE [channel ...], which leaves every
+// channel when none is given
+func (e *Eventloop) unsubscribe(c *client, args []string) protocol.Value {
+	channels := args[1:]
+	if len(channels) == 0 {
+		for ch := range c.channels {
+			channels = append(channels, ch)
+		}
+	}
+
+	if len(channels) == 0 {
+		return c.pushValue(
+			protocol.NewBulkStringValue("unsubscribe"),
+			protocol.NewNilValue(),
+			protocol.NewSimpleIntValue(0),
+		)
+	}
+
+	confirmations := make([]protocol.Value, 0, len(channels))
+	for _, ch := range channels {
+		e.leaveChannel(c, ch)
+		confirmations = append(confirmations, c.pushValue(
+			protocol.NewBulkStringValue("unsubscribe"),
+			protocol.NewBulkStringValue(ch),
+			protocol.NewSimpleIntValue(int64(len(c.channels))),
+		))
+	}
+	return e.confirm(c, confirmations)
+}
+
+// confirm replies with the first confirmation and pushes the others
+func (e *Eventloop) confirm(c *client, confirmations []protocol.Value) protocol.Value {
+	for _, v := range confirmations[1:] {
+		e.push(c, v)
+	}
+	return confirmations[0]
+}
+
+// unsubscribeAll removes a client that is going away from its channels
+func (e *Eventloop) unsubscribeAll(c *client) {
+	for ch := range c.channels {
+		e.leaveChannel(c, ch)
+	}
+}
+
+func (e *Eventloop) leaveChannel(c *client, ch string) {
+	delete(c.channels, ch)
+	delete(e.channels[ch], c)
+	if len(e.channels[ch]) == 0 {
+		delete(e.channels, ch)
+	}
+}
+
+// publish implements PUBLISH channel message, replying with the number of
+// clients that received the message
+func (e *Eventloop) publish(args []string) protocol.Value {
+	if len(args) != 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'publish' command")
+	}
+	return protocol.NewSimpleIntValue(int64(e.publishMessage(args[1], protocol.NewBulkStringValue(args[2]))))
+}
+
+// publishMessage sends a message to the subscribers of a channel
+func (e *Eventloop) publishMessage(ch string, message protocol.Value) int {
+	for c := range e.channels[ch] {
+		e.push(c, c.pushValue(protocol.NewBulkStringValue("message"), protocol.NewBulkStringValue(ch), message))
+	}
+	return len(e.channels[ch])
+}
+
+// push sends an out of band message to a client. Messages for the client
+// whose command is running follow its reply. A client that does not read
+// its messages fast enough is disconnected rather than holding up the loop.
+func (e *Eventloop) push(c *client, v protocol.Value) {
+	if c == e.current {
+		c.pending = v.AppendEncode(c.pending)
+		return
+	}
+	if c.pushes == nil {
+		return
+	}
+
+	select {
+	case c.pushes <- v.Encode():
+	default:
+		c.conn.Close()
+	}
+}
@@ -0,0 +1,34 @@
+package eventloop
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PubSub(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	sub := dialTestServer(t, addr)
+	pub := dialTestServer(t, addr)
+
+	reply := sub.do("SUBSCRIBE", "news", "sport").Array()
+	assert.Equal([]string{"subscribe", "news"}, stringArray(reply[:2]))
+	assert.Equal(int64(1), reply[2].Integer())
+	reply = sub.read().Array()
+	assert.Equal([]string{"subscribe", "sport"}, stringArray(reply[:2]))
+	assert.Equal(int64(2), reply[2].Integer())
+	assert.Equal("ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context",
+		sub.do("GET", "foo").String())
+
+	assert.Equal(int64(1), pub.do("PUBLISH", "news", "hello").Integer())
+	assert.Equal(int64(0), pub.do("PUBLISH", "weather", "rain").Integer())
+	assert.Equal([]string{"message", "news", "hello"}, stringArray(sub.read().Array()))
+
+	assert.Contains(pub.do("CLIENT", "LIST", "TYPE", "pubsub").String(), " flags=P ")
+
+	assert.Equal("unsubscribe", sub.do("UNSUBSCRIBE", "news").Array()[0].String())
+	assert.Equal(int64(0), pub.do("PUBLISH", "news", "again").Integer())
+	assert.Equal(int64(0), sub.do("UNSUBSCRIBE").Array()[2].Integer())
+	assert.Equal("", sub.do("GET", "foo").String())
+}
@@ -0,0 +1,955 @@
+package eventloop
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/store"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// replBacklogSize is how much of the replication stream is kept around
+	// for replicas that reconnect after a short disconnect
+	replBacklogSize = 1024 * 1024
+	// replPingPeriod is how often a master pings its replicas
+	replPingPeriod = 10 * time.Second
+	// replTimeout is how long a replica waits on a silent master
+	replTimeout = 60 * time.Second
+	// replAckPeriod is how often a replica reports its offset to the master
+	replAckPeriod = time.Second
+	// replReconnectDelay is how long a replica waits before reconnecting
+	replReconnectDelay = time.Second
+	// replicaOutputLimit is how much unsent stream a replica may fall behind
+	// by before it is disconnected
+	replicaOutputLimit = 256 * 1024 * 1024
+)
+
+// replicationState holds the replication ID, offset and backlog, which are
+// kept by masters and replicas alike so any server can serve partial
+// resyncs, and the state of the link to our master when we are a replica.
+type replicationState struct {
+	// guards the IDs, which are read by the master link goroutine
+	mu           sync.Mutex
+	replID       string
+	replID2      string
+	secondOffset int64
+
+	// number of bytes of replication stream produced or applied so far
+	offset   atomic.Int64
+	backlog  *backlog
+	replicas map[*replica]struct{}
+	lastPing time.Time
+	// database the last command in the stream was run against, -1 forces
+	// a SELECT before the next one
+	selDB int
+
+	// link to our master, nil when we are a master
+	link *masterLink
+	// fake client that applies the commands streamed by our master
+	masterClient *client
+	// credentials sent to our master, if it requires a password. Guarded
+	// by mu as they can be changed with CONFIG SET.
+	masterUser     string
+	masterPassword string
+
+	syncFull       atomic.Int64
+	syncPartialOk  atomic.Int64
+	syncPartialErr atomic.Int64
+}
+
+func newReplicationState() *replicationState {
+	return &replicationState{
+		replID:       newReplID(),
+		replID2:      strings.Repeat("0", 40),
+		secondOffset: -1,
+		backlog:      newBacklog(replBacklogSize, 0),
+		replicas:     make(map[*replica]struct{}),
+		selDB:        -1,
+		masterClient: &client{master: true},
+	}
+}
+
+// newReplID returns a random 40 character replication ID
+func newReplID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ids returns the current and previous replication IDs and the offset up to
+// which the previous one is valid
+func (r *replicationState) ids() (string, string, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.replID, r.replID2, r.secondOffset
+}
+
+func (r *replicationState) setIDs(replID, replID2 string, secondOffset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replID, r.replID2, r.secondOffset = replID, replID2, secondOffset
+}
+
+// shiftReplID starts a new history while still accepting partial resyncs
+// against the old one up to the current offset. It is used when a replica
+// is promoted, so its own replicas can continue from where they were.
+func (r *replicationState) shiftReplID() {
+	replID, _, _ := r.ids()
+	r.setIDs(newReplID(), replID, r.offset.Load()+1)
+}
+
+func (r *replicationState) isReplica() bool {
+	return r.link != nil
+}
+
+// backlog is a ring buffer holding the most recent part of the replication
+// stream
+type backlog struct {
+	data    []byte
+	idx     int
+	histlen int
+	// replication offset of the last byte written
+	end int64
+}
+
+func newBacklog(size int, offset int64) *backlog {
+	return &backlog{data: make([]byte, size), end: offset}
+}
+
+func (b *backlog) write(p []byte) {
+	b.end += int64(len(p))
+
+	if len(p) > len(b.data) {
+		p = p[len(p)-len(b.data):]
+	}
+
+	for len(p) > 0 {
+		n := copy(b.data[b.idx:], p)
+		b.idx = (b.idx + n) % len(b.data)
+		b.histlen += n
+		p = p[n:]
+	}
+
+	if b.histlen > len(b.data) {
+		b.histlen = len(b.data)
+	}
+}
+
+// firstOffset returns the replication offset of the oldest byte held
+func (b *backlog) firstOffset() int64 {
+	return b.end - int64(b.histlen) + 1
+}
+
+// since returns the stream from offset onwards, or false if that part of
+// the stream is no longer held
+func (b *backlog) since(offset int64) ([]byte, bool) {
+	if offset < b.firstOffset() || offset > b.end+1 {
+		return nil, false
+	}
+
+	n := int(b.end - offset + 1)
+	out := make([]byte, 0, n)
+	start := (b.idx - n + len(b.data)) % len(b.data)
+
+	if start+n <= len(b.data) {
+		out = append(out, b.data[start:start+n]...)
+	} else {
+		out = append(out, b.data[start:]...)
+		out = append(out, b.data[:n-(len(b.data)-start)]...)
+	}
+
+	return out, true
+}
+
+// replica is a connected replica as seen by its master. The loop appends
+// the replication stream to buf and the connection goroutine writes it out,
+// so a slow replica never blocks the loop.
+type replica struct {
+	addr string
+	port int
+
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+	online bool
+	notify chan struct{}
+	done   chan struct{}
+
+	// dataset to send before the stream on a full resync
+	snapshot [][]store.Entry
+
+	ackOffset atomic.Int64
+	lastAck   atomic.Int64
+}
+
+func newReplica(c *client) *replica {
+	r := &replica{
+		addr:   c.host(),
+		port:   c.listeningPort,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	r.lastAck.Store(time.Now().Unix())
+	return r
+}
+
+// write queues part of the stream, and reports false once the replica is
+// gone or has fallen too far behind
+func (r *replica) write(p []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return false
+	}
+
+	if len(r.buf)+len(p) > replicaOutputLimit {
+		fmt.Println("replica ", r.addr, " exceeded the output buffer limit, disconnecting")
+		r.closeLocked()
+		return false
+	}
+
+	r.buf = append(r.buf, p...)
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// take returns and clears the queued stream
+func (r *replica) take() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf := r.buf
+	r.buf = nil
+	return buf
+}
+
+func (r *replica) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeLocked()
+}
+
+func (r *replica) closeLocked() {
+	if !r.closed {
+		r.closed = true
+		close(r.done)
+	}
+}
+
+func (r *replica) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+func (r *replica) state() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.online {
+		return "online"
+	}
+	return "wait_bgsave"
+}
+
+// masterLink is the connection from a replica to its master
+type masterLink struct {
+	host string
+	port int
+	stop chan struct{}
+
+	mu     sync.Mutex
+	state  string
+	conn   net.Conn
+	lastIO time.Time
+}
+
+func newMasterLink(host string, port int) *masterLink {
+	return &masterLink{
+		host:  host,
+		port:  port,
+		stop:  make(chan struct{}),
+		state: "connect",
+	}
+}
+
+func (l *masterLink) setState(state string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = state
+}
+
+func (l *masterLink) status() (string, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state, l.lastIO
+}
+
+func (l *masterLink) touch() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastIO = time.Now()
+}
+
+// setConn records the live connection, and reports false if the link was
+// stopped while connecting
+func (l *masterLink) setConn(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.stop:
+		return false
+	default:
+	}
+
+	l.conn = conn
+	return true
+}
+
+// send writes a command to the master
+func (l *masterLink) send(args ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return errors.New("not connected")
+	}
+
+	l.conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	_, err := l.conn.Write(aof.Encode(args))
+	return err
+}
+
+func (l *masterLink) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	close(l.stop)
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+// Messages sent to the loop by the master link goroutine. Each carries the
+// link it came from so messages from a link that has since been replaced
+// are dropped.
+type masterFullSync struct {
+	link   *masterLink
+	replID string
+	offset int64
+	cmds   [][]string
+}
+
+type masterContinue struct {
+	link   *masterLink
+	replID string
+}
+
+type masterCommand struct {
+	link *masterLink
+	args []string
+}
+
+type replicationCron struct{}
+
+// SetPort tells the loop which port the server listens on, so it can be
+// announced to a master
+func (e *Eventloop) SetPort(port int) {
+	e.port = port
+}
+
+// SetMasterAuth sets the credentials used to authenticate with the master.
+// An empty user authenticates as the default user. Changes apply the next
+// time the replica connects.
+func (e *Eventloop) SetMasterAuth(user, password string) {
+	e.repl.mu.Lock()
+	defer e.repl.mu.Unlock()
+	e.repl.masterUser, e.repl.masterPassword = user, password
+}
+
+func (r *replicationState) masterAuthInfo() (user, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.masterUser, r.masterPassword
+}
+
+// masterAuthArgs returns the AUTH arguments sent to our master, or nil if
+// no password is set
+func (r *replicationState) masterAuthArgs() []string {
+	user, password := r.masterAuthInfo()
+	if password == "" {
+		return nil
+	}
+	if user != "" {
+		return []string{user, password}
+	}
+	return []string{password}
+}
+
+// StartReplicationTimer drives the periodic replication tasks
+func (e *Eventloop) StartReplicationTimer() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.done:
+			return
+		}
+		e.send(replicationCron{})
+	}
+}
+
+// ReplicaOf turns the server into a replica of the master at host:port.
+// Until the loop is started it may be called directly, afterwards only from
+// the loop.
+func (e *Eventloop) ReplicaOf(host string, port int) {
+	if e.repl.link != nil {
+		e.repl.link.close()
+	}
+
+	// our own replicas have to sync again against the new history
+	e.disconnectReplicas()
+
+	l := newMasterLink(host, port)
+	e.repl.link = l
+	go e.runMasterLink(l)
+}
+
+func (e *Eventloop) disconnectReplicas() {
+	for r := range e.repl.replicas {
+		r.close()
+		delete(e.repl.replicas, r)
+	}
+}
+
+// replicationFeed appends part of the stream to the backlog and sends it to
+// every replica
+func (e *Eventloop) replicationFeed(p []byte) {
+	e.repl.backlog.write(p)
+	e.repl.offset.Add(int64(len(p)))
+
+	for r := range e.repl.replicas {
+		if !r.write(p) {
+			delete(e.repl.replicas, r)
+		}
+	}
+}
+
+func (e *Eventloop) replicationCron() {
+	for r := range e.repl.replicas {
+		if r.isClosed() {
+			delete(e.repl.replicas, r)
+		}
+	}
+
+	if !e.repl.isReplica() && len(e.repl.replicas) > 0 && time.Since(e.repl.lastPing) >= replPingPeriod {
+		e.replicationFeed(aof.Encode([]string{"PING"}))
+		e.repl.lastPing = time.Now()
+	}
+}
+
+func (e *Eventloop) replicaOfCommand(args []string) protocol.Value {
+	if len(args) != 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'replicaof' command")
+	}
+
+	if strings.EqualFold(args[1], "no") && strings.EqualFold(args[2], "one") {
+		if e.repl.link != nil {
+			e.repl.link.close()
+			e.repl.link = nil
+			e.repl.shiftReplID()
+			fmt.Println("MASTER MODE enabled")
+		}
+		return protocol.NewSimpleStringValue(&OK)
+	}
+
+	port, err := strconv.Atoi(args[2])
+	if err != nil || port <= 0 || port > 65535 {
+		return protocol.NewErrorValue("ERR Invalid master port")
+	}
+
+	if l := e.repl.link; l != nil && l.host == args[1] && l.port == port {
+		already := "OK Already connected to specified master"
+		return protocol.NewSimpleStringValue(&already)
+	}
+
+	e.ReplicaOf(args[1], port)
+	fmt.Printf("REPLICAOF %s:%d enabled\n", args[1], port)
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+func (e *Eventloop) replconf(c *client, args []string) protocol.Value {
+	if len(args)%2 == 0 {
+		return protocol.NewErrorValue("ERR syntax error")
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			port, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return protocol.NewErrorValue("ERR value is not an integer or out of range")
+			}
+			c.listeningPort = port
+		case "capa", "ack", "getack":
+		default:
+			return protocol.NewErrorValue("ERR Unrecognized REPLCONF option: " + args[i])
+		}
+	}
+
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+// psync serves a replica asking to sync. If its history is still in the
+// backlog it continues from where it left off, otherwise it gets the whole
+// dataset first. Either way the connection is handed over to serveReplica
+// once the reply has been written.
+func (e *Eventloop) psync(c *client, args []string) protocol.Value {
+	if len(args) != 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'psync' command")
+	}
+
+	if l := e.repl.link; l != nil {
+		if state, _ := l.status(); state != "connected" {
+			return protocol.NewErrorValue("NOMASTERLINK Can't SYNC while not connected with my master")
+		}
+	}
+
+	r := newReplica(c)
+	replID, replID2, secondOffset := e.repl.ids()
+	offset, err := strconv.ParseInt(args[2], 10, 64)
+
+	if err == nil && (args[1] == replID || (args[1] == replID2 && offset <= secondOffset)) {
+		if data, ok := e.repl.backlog.since(offset); ok {
+			r.online = true
+			r.write(data)
+			e.repl.replicas[r] = struct{}{}
+			c.replica = r
+			e.repl.syncPartialOk.Add(1)
+
+			reply := "CONTINUE " + replID
+			return protocol.NewSimpleStringValue(&reply)
+		}
+	}
+
+	if args[1] != "?" {
+		e.repl.syncPartialErr.Add(1)
+	}
+
+	r.snapshot = e.snapshot()
+	// the replica starts from the snapshot, which may end on any database
+	e.repl.selDB = -1
+	e.repl.replicas[r] = struct{}{}
+	c.replica = r
+	e.repl.syncFull.Add(1)
+
+	reply := fmt.Sprintf("FULLRESYNC %s %d", replID, e.repl.offset.Load())
+	return protocol.NewSimpleStringValue(&reply)
+}
+
+// serveReplica takes over the connection of a replica after PSYNC. It sends
+// the dataset if a full resync was needed, then the stream, while reading
+// the acknowledged offsets the replica sends back.
+func (e *Eventloop) serveReplica(c *client) {
+	r := c.replica
+	defer r.close()
+
+	if r.snapshot != nil {
+		var payload bytes.Buffer
+		writeSnapshot(&payload, r.snapshot)
+		r.snapshot = nil
+
+		if _, err := c.conn.Write([]byte(fmt.Sprintf("$%d\r\n", payload.Len()))); err != nil {
+			return
+		}
+		if _, err := c.conn.Write(payload.Bytes()); err != nil {
+			return
+		}
+
+		r.mu.Lock()
+		r.online = true
+		r.mu.Unlock()
+	}
+
+	go func() {
+		reader := bufio.NewReader(c.conn)
+		for {
+			value, err := protocol.DecodeRESP(reader)
+			if err != nil {
+				r.close()
+				return
+			}
+
+			args := value.Array()
+			if len(args) == 3 && strings.EqualFold(args[0].String(), "replconf") && strings.EqualFold(args[1].String(), "ack") {
+				if offset, err := strconv.ParseInt(args[2].String(), 10, 64); err == nil {
+					r.ackOffset.Store(offset)
+					r.lastAck.Store(time.Now().Unix())
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.notify:
+		}
+
+		if _, err := c.conn.Write(r.take()); err != nil {
+			return
+		}
+	}
+}
+
+// runMasterLink keeps a replica connected to its master until the link is
+// stopped
+func (e *Eventloop) runMasterLink(l *masterLink) {
+	for {
+		err := e.syncWithMaster(l)
+
+		select {
+		case <-l.stop:
+			return
+		default:
+		}
+
+		fmt.Println("connection with master lost: ", err)
+		l.setState("connect")
+
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(replReconnectDelay):
+		}
+	}
+}
+
+// syncWithMaster runs one session with the master: the handshake, then a
+// full or partial resync, then applying the stream until the connection
+// breaks
+func (e *Eventloop) syncWithMaster(l *masterLink) error {
+	l.setState("connecting")
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(l.host, strconv.Itoa(l.port)), replTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if !l.setConn(conn) {
+		return errors.New("link stopped")
+	}
+
+	reader := bufio.NewReader(conn)
+	request := func(args ...string) (string, error) {
+		if err := l.send(args...); err != nil {
+			return "", err
+		}
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+		reply, err := protocol.DecodeRESP(reader)
+		return reply.String(), err
+	}
+
+	l.setState("sync")
+
+	if _, err := request("PING"); err != nil {
+		return err
+	}
+	if auth := e.repl.masterAuthArgs(); auth != nil {
+		if err := l.send(append([]string{"AUTH"}, auth...)...); err != nil {
+			return err
+		}
+		reply, err := protocol.DecodeRESP(reader)
+		if err != nil {
+			return err
+		}
+		if reply.IsError() {
+			return fmt.Errorf("unable to AUTH to master: %s", reply.String())
+		}
+	}
+	if _, err := request("REPLCONF", "listening-port", strconv.Itoa(e.port)); err != nil {
+		return err
+	}
+	if _, err := request("REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	replID, _, _ := e.repl.ids()
+	reply, err := request("PSYNC", replID, strconv.FormatInt(e.repl.offset.Load()+1, 10))
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad offset in reply to PSYNC: %s", reply)
+		}
+
+		cmds, err := readSyncPayload(reader)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("full resync from master %s:%d, %d commands\n", l.host, l.port, len(cmds))
+		e.send(masterFullSync{link: l, replID: fields[1], offset: offset, cmds: cmds})
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		fmt.Printf("partial resync from master %s:%d accepted\n", l.host, l.port)
+		if len(fields) == 2 {
+			e.send(masterContinue{link: l, replID: fields[1]})
+		}
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC: %s", reply)
+	}
+
+	l.setState("connected")
+	l.touch()
+
+	done := make(chan struct{})
+	defer close(done)
+	go e.sendAcks(l, done)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+		value, err := protocol.DecodeRESP(reader)
+		if err != nil {
+			return err
+		}
+		l.touch()
+
+		items := value.Array()
+		if len(items) == 0 {
+			continue
+		}
+
+		args := make([]string, 0, len(items))
+		for _, v := range items {
+			args = append(args, v.String())
+		}
+
+		e.send(masterCommand{link: l, args: args})
+
+		if len(args) >= 2 && strings.EqualFold(args[0], "replconf") && strings.EqualFold(args[1], "getack") {
+			l.send("REPLCONF", "ACK", strconv.FormatInt(e.repl.offset.Load(), 10))
+		}
+	}
+}
+
+// sendAcks reports the replication offset to the master until done is closed
+func (e *Eventloop) sendAcks(l *masterLink, done chan struct{}) {
+	ticker := time.NewTicker(replAckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.send("REPLCONF", "ACK", strconv.FormatInt(e.repl.offset.Load(), 10))
+		}
+	}
+}
+
+// readSyncPayload reads the dataset sent on a full resync: a bulk length
+// followed by that many bytes of commands, without a trailing CRLF
+func readSyncPayload(reader *bufio.Reader) ([][]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "$") {
+		return nil, fmt.Errorf("bad sync payload header: %s", line)
+	}
+
+	size, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("bad sync payload length: %s", line)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	cmds := make([][]string, 0)
+	payloadReader := bufio.NewReader(bytes.NewReader(payload))
+	for {
+		value, err := protocol.DecodeRESP(payloadReader)
+		if errors.Is(err, io.EOF) {
+			return cmds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		args := make([]string, 0, len(value.Array()))
+		for _, v := range value.Array() {
+			args = append(args, v.String())
+		}
+		cmds = append(cmds, args)
+	}
+}
+
+// loadFullSync replaces the dataset with the one sent by the master and
+// adopts the master's history
+func (e *Eventloop) loadFullSync(sync masterFullSync) {
+	for _, db := range e.dbs {
+		db.Flush()
+	}
+	e.repl.masterClient.db = 0
+	for _, cmd := range sync.cmds {
+		e.execute(e.repl.masterClient, cmd)
+	}
+
+	e.repl.setIDs(sync.replID, strings.Repeat("0", 40), -1)
+	e.repl.offset.Store(sync.offset)
+	e.repl.backlog = newBacklog(replBacklogSize, sync.offset)
+	e.disconnectReplicas()
+
+	// the AOF no longer describes the dataset, so start it over
+	if e.aof != nil {
+		if resp := e.bgRewriteAOF(); resp.IsError() {
+			fmt.Println("could not rewrite the append only file after a full resync")
+		}
+	}
+}
+
+// continueSync adopts the master's replication ID after a partial resync,
+// keeping ours as the previous one
+func (e *Eventloop) continueSync(cont masterContinue) {
+	replID, _, _ := e.repl.ids()
+	if cont.replID != replID {
+		e.repl.setIDs(cont.replID, replID, e.repl.offset.Load()+1)
+		e.disconnectReplicas()
+	}
+}
+
+// applyMasterCommand runs a command streamed by the master. It goes to the
+// AOF like any write, and is forwarded unchanged to our own replicas so our
+// offset stays in step with the master's.
+func (e *Eventloop) applyMasterCommand(args []string) {
+	db := e.repl.masterClient.db
+	c, known := lookupCommand(args[0])
+	if known {
+		e.feedMonitors(e.repl.masterClient, c, args)
+	}
+	resp := e.execute(e.repl.masterClient, args)
+
+	if known && c.isWrite() && !resp.IsError() {
+		if err := e.appendAOF(db, args); err != nil {
+			fmt.Println("error writing to append only file: ", err.Error())
+		}
+		e.invalidateWrite(e.repl.masterClient, c, args)
+	}
+
+	// SELECTs in the stream are forwarded too, so our replicas are on the
+	// same database as the master
+	e.replicationFeed(aof.Encode(args))
+	e.repl.selDB = e.repl.masterClient.db
+}
+
+func (e *Eventloop) role() protocol.Value {
+	if l := e.repl.link; l != nil {
+		state, _ := l.status()
+		return protocol.NewArrayValue([]protocol.Value{
+			protocol.NewBulkStringValue("slave"),
+			protocol.NewBulkStringValue(l.host),
+			protocol.NewSimpleIntValue(int64(l.port)),
+			protocol.NewBulkStringValue(state),
+			protocol.NewSimpleIntValue(e.repl.offset.Load()),
+		})
+	}
+
+	replicas := make([]protocol.Value, 0, len(e.repl.replicas))
+	for r := range e.repl.replicas {
+		replicas = append(replicas, protocol.NewArrayBulkStringValue([]string{
+			r.addr,
+			strconv.Itoa(r.port),
+			strconv.FormatInt(r.ackOffset.Load(), 10),
+		}))
+	}
+
+	return protocol.NewArrayValue([]protocol.Value{
+		protocol.NewBulkStringValue("master"),
+		protocol.NewSimpleIntValue(e.repl.offset.Load()),
+		protocol.NewArrayValue(replicas),
+	})
+}
+
+func (e *Eventloop) infoReplication() string {
+	var b strings.Builder
+	replID, replID2, secondOffset := e.repl.ids()
+	offset := e.repl.offset.Load()
+
+	if l := e.repl.link; l != nil {
+		state, lastIO := l.status()
+		linkStatus := "down"
+		if state == "connected" {
+			linkStatus = "up"
+		}
+		lastIOAgo := -1
+		if !lastIO.IsZero() {
+			lastIOAgo = int(time.Since(lastIO).Seconds())
+		}
+		syncing := 0
+		if state == "sync" {
+			syncing = 1
+		}
+
+		fmt.Fprintf(&b, "role:slave\r\n")
+		fmt.Fprintf(&b, "master_host:%s\r\n", l.host)
+		fmt.Fprintf(&b, "master_port:%d\r\n", l.port)
+		fmt.Fprintf(&b, "master_link_status:%s\r\n", linkStatus)
+		fmt.Fprintf(&b, "master_last_io_seconds_ago:%d\r\n", lastIOAgo)
+		fmt.Fprintf(&b, "master_sync_in_progress:%d\r\n", syncing)
+		fmt.Fprintf(&b, "slave_repl_offset:%d\r\n", offset)
+		fmt.Fprintf(&b, "slave_read_only:1\r\n")
+	} else {
+		fmt.Fprintf(&b, "role:master\r\n")
+	}
+
+	fmt.Fprintf(&b, "connected_slaves:%d\r\n", len(e.repl.replicas))
+	i := 0
+	for r := range e.repl.replicas {
+		lag := time.Now().Unix() - r.lastAck.Load()
+		fmt.Fprintf(&b, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n", i, r.addr, r.port, r.state(), r.ackOffset.Load(), lag)
+		i++
+	}
+
+	fmt.Fprintf(&b, "master_replid:%s\r\n", replID)
+	fmt.Fprintf(&b, "master_replid2:%s\r\n", replID2)
+	fmt.Fprintf(&b, "master_repl_offset:%d\r\n", offset)
+	fmt.Fprintf(&b, "second_repl_offset:%d\r\n", secondOffset)
+	fmt.Fprintf(&b, "repl_backlog_active:1\r\n")
+	fmt.Fprintf(&b, "repl_backlog_size:%d\r\n", len(e.repl.backlog.data))
+	fmt.Fprintf(&b, "repl_backlog_first_byte_offset:%d\r\n", e.repl.backlog.firstOffset())
+	fmt.Fprintf(&b, "repl_backlog_histlen:%d\r\n", e.repl.backlog.histlen)
+
+	return b.String()
+}
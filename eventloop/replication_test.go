@@ -0,0 +1,103 @@
+package eventloop
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Backlog(t *testing.T) {
+	assert := assert.New(t)
+	b := newBacklog(8, 0)
+
+	b.write([]byte("abcde"))
+	assert.Equal(int64(1), b.firstOffset())
+
+	data, ok := b.since(3)
+	assert.True(ok)
+	assert.Equal("cde", string(data))
+
+	// wraps around and drops the oldest bytes
+	b.write([]byte("fghij"))
+	assert.Equal(int64(3), b.firstOffset())
+
+	data, ok = b.since(3)
+	assert.True(ok)
+	assert.Equal("cdefghij", string(data))
+
+	_, ok = b.since(2)
+	assert.False(ok)
+
+	data, ok = b.since(11)
+	assert.True(ok)
+	assert.Empty(data)
+
+	_, ok = b.since(12)
+	assert.False(ok)
+}
+
+func Test_Replication(t *testing.T) {
+	assert := assert.New(t)
+	master, masterAddr := startTestServer(t)
+	replicaEl, replicaAddr := startTestServer(t)
+	host, port := splitHostPort(t, masterAddr)
+
+	m := dialTestServer(t, masterAddr)
+	r := dialTestServer(t, replicaAddr)
+
+	assert.Equal("OK", m.do("SET", "before", "1").String())
+	assert.Equal("OK", r.do("REPLICAOF", host, port).String())
+
+	// full resync brings over the existing dataset
+	assert.Eventually(func() bool {
+		return r.do("GET", "before").String() == "1"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// then writes are streamed
+	m.do("SET", "after", "2", "100")
+	m.do("ZADD", "zs", "1", "one")
+	assert.Eventually(func() bool {
+		return r.do("GET", "after").String() == "2" && len(r.do("ZRANGE", "zs", "0", "-1").Array()) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// on the database they were made in
+	m.do("SELECT", "3")
+	m.do("SET", "other", "db")
+	m.do("SELECT", "0")
+	r.do("SELECT", "3")
+	assert.Eventually(func() bool {
+		return r.do("GET", "other").String() == "db"
+	}, 5*time.Second, 20*time.Millisecond)
+	r.do("SELECT", "0")
+	assert.Equal("", r.do("GET", "other").String())
+
+	assert.True(strings.HasPrefix(r.do("SET", "foo", "bar").String(), "READONLY"))
+
+	role := r.do("ROLE").Array()
+	assert.Equal("slave", role[0].String())
+	assert.Equal("connected", role[3].String())
+	assert.Equal("master", m.do("ROLE").Array()[0].String())
+	assert.Contains(m.do("INFO", "replication").String(), "connected_slaves:1")
+
+	// after a short disconnect the replica continues from the backlog
+	link := replicaEl.repl.link
+	link.mu.Lock()
+	link.conn.Close()
+	link.mu.Unlock()
+
+	m.do("SET", "during", "3")
+	assert.Eventually(func() bool {
+		return r.do("GET", "during").String() == "3"
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(int64(1), master.repl.syncFull.Load())
+	assert.Equal(int64(1), master.repl.syncPartialOk.Load())
+
+	// a promoted replica accepts writes and keeps the old history as its
+	// previous replication ID
+	masterID, _, _ := master.repl.ids()
+	assert.Equal("OK", r.do("REPLICAOF", "NO", "ONE").String())
+	assert.Equal("OK", r.do("SET", "foo", "bar").String())
+	assert.Contains(r.do("INFO", "replication").String(), "master_replid2:"+masterID)
+}
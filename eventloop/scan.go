@@ -0,0 +1,129 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/glob"
+	"noelzubin/redis-go/protocol"
+	"strconv"
+	"strings"
+)
+
+// defaultScanCount is how many elements a scan call looks for by default
+const defaultScanCount = 10
+
+// scanOptions are the options shared by the scan commands
+type scanOptions struct {
+	cursor uint64
+	// MATCH pattern, * by default
+	pattern glob.Pattern
+	count   int
+	// type of the keys SCAN returns, any if empty
+	typ string
+}
+
+// parseScan reads the cursor at args[0] and the options after it. TYPE is
+// only accepted if withType is set.
+func parseScan(args []string, withType bool) (scanOptions, protocol.Value, bool) {
+	opts := scanOptions{pattern: glob.Compile("*"), count: defaultScanCount}
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return opts, protocol.NewErrorValue("ERR invalid cursor"), false
+	}
+	opts.cursor = cursor
+
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, protocol.NewErrorValue("ERR syntax error"), false
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			opts.pattern = glob.Compile(args[i+1])
+		case "count":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, protocol.NewErrorValue("ERR value is not an integer or out of range"), false
+			}
+			if n < 1 {
+				return opts, protocol.NewErrorValue("ERR syntax error"), false
+			}
+			opts.count = n
+		case "type":
+			if !withType {
+				return opts, protocol.NewErrorValue("ERR syntax error"), false
+			}
+			opts.typ = strings.ToLower(args[i+1])
+		default:
+			return opts, protocol.NewErrorValue("ERR syntax error"), false
+		}
+	}
+
+	return opts, protocol.Value{}, true
+}
+
+// scanReply is the reply of the scan commands, the next cursor and the
+// elements found
+func scanReply(cursor uint64, elements []string) protocol.Value {
+	return protocol.NewArrayValue([]protocol.Value{
+		protocol.NewBulkStringValue(strconv.FormatUint(cursor, 10)),
+		protocol.NewArrayBulkStringValue(elements),
+	})
+}
+
+// scan iterates the keys of the selected database a few at a time
+func (e *Eventloop) scan(c *client, args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'scan' command")
+	}
+	opts, errValue, ok := parseScan(args[1:], true)
+	if !ok {
+		return errValue
+	}
+
+	db := e.dbs[c.db]
+	next, keys := db.Scan(opts.cursor, opts.count)
+
+	found := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !opts.pattern.Match(k) {
+			continue
+		}
+		// expired keys are deleted rather than returned
+		value, ok := db.Peek(k)
+		if !ok || (opts.typ != "" && value.Type() != opts.typ) {
+			continue
+		}
+		found = append(found, k)
+	}
+
+	return scanReply(next, found)
+}
+
+// zscan iterates the members of the sorted set stored at a key, with their
+// scores
+func (e *Eventloop) zscan(c *client, args []string) protocol.Value {
+	if len(args) < 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for '" + strings.ToLower(args[0]) + "' command")
+	}
+	opts, errValue, ok := parseScan(args[2:], false)
+	if !ok {
+		return errValue
+	}
+
+	value, ok := e.dbs[c.db].Peek(args[1])
+	if !ok {
+		return scanReply(0, []string{})
+	}
+	if value.Type() != "zset" {
+		return protocol.NewErrorValue("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	next, members := value.ZScan(opts.cursor, opts.count)
+
+	found := make([]string, 0, 2*len(members))
+	for _, m := range members {
+		if opts.pattern.Match(m.Member()) {
+			found = append(found, m.Member(), strconv.FormatInt(m.Score(), 10))
+		}
+	}
+	return scanReply(next, found)
+}
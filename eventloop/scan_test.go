@@ -0,0 +1,129 @@
+package eventloop
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scanAll iterates a scan command to the end, returning how many times
+// each element was returned. The cursor goes between cmd and opts.
+func scanAll(c *testConn, cmd []string, opts ...string) map[string]int {
+	seen := make(map[string]int)
+	cursor := "0"
+	for {
+		call := append(append(append([]string{}, cmd...), cursor), opts...)
+		reply := c.do(call...).Array()
+		for _, element := range reply[1].Array() {
+			seen[element.String()]++
+		}
+		cursor = reply[0].String()
+		if cursor == "0" {
+			return seen
+		}
+	}
+}
+
+func Test_Scan(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	for i := 0; i < 200; i++ {
+		c.do("SET", "key:"+strconv.Itoa(i), "x")
+	}
+	c.do("ZADD", "zset", "1", "a")
+
+	assert.Len(scanAll(c, []string{"SCAN"}, "COUNT", "7"), 201)
+	assert.Len(scanAll(c, []string{"SCAN"}, "MATCH", "key:1?"), 10)
+	assert.Equal(map[string]int{"zset": 1}, scanAll(c, []string{"SCAN"}, "TYPE", "zset"))
+
+	assert.Equal("ERR invalid cursor", c.do("SCAN", "nope").String())
+	assert.Equal("ERR syntax error", c.do("SCAN", "0", "COUNT", "0").String())
+	assert.Equal("ERR syntax error", c.do("SCAN", "0", "MATCH").String())
+}
+
+func Test_Scan_Growth(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	for i := 0; i < 100; i++ {
+		c.do("SET", "old:"+strconv.Itoa(i), "x")
+	}
+
+	// keys added while iterating make the keyspace grow several times
+	seen := make(map[string]int)
+	cursor, added := "0", 0
+	for {
+		reply := c.do("SCAN", cursor, "COUNT", "5").Array()
+		for _, k := range reply[1].Array() {
+			seen[k.String()]++
+		}
+		for j := 0; j < 20 && added < 2000; j++ {
+			c.do("SET", "new:"+strconv.Itoa(added), "x")
+			added++
+		}
+		if cursor = reply[0].String(); cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < 100; i++ {
+		assert.Contains(seen, "old:"+strconv.Itoa(i))
+	}
+}
+
+func Test_ZScan(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	// small sets come back whole
+	c.do("ZADD", "small", "1", "a", "2", "b")
+	reply := c.do("ZSCAN", "small", "0").Array()
+	assert.Equal("0", reply[0].String())
+	assert.Equal([]string{"a", "1", "b", "2"}, stringArray(reply[1].Array()))
+
+	args := []string{"ZADD", "big"}
+	for i := 0; i < 300; i++ {
+		args = append(args, strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	c.do(args...)
+	seen := scanAll(c, []string{"ZSCAN", "big"}, "MATCH", "m1*")
+	assert.Contains(seen, "m1")
+	assert.Contains(seen, "m199")
+	assert.NotContains(seen, "m2")
+	assert.Contains(seen, "150")
+
+	reply = c.do("ZSCAN", "missing", "0").Array()
+	assert.Equal("0", reply[0].String())
+	assert.Empty(reply[1].Array())
+
+	c.do("SET", "str", "x")
+	assert.True(c.do("ZSCAN", "str", "0").IsError())
+	assert.True(c.do("ZSCAN", "small", "0", "TYPE", "zset").IsError())
+}
+
+func Test_Keys(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	for _, k := range []string{"user:1", "user:2", "user:10", "other", "h*llo"} {
+		c.do("SET", k, "x")
+	}
+
+	assert.Len(c.do("KEYS", "*").Array(), 5)
+	assert.ElementsMatch([]string{"user:1", "user:2", "user:10"}, stringArray(c.do("KEYS", "user:*").Array()))
+	assert.ElementsMatch([]string{"user:1", "user:2"}, stringArray(c.do("KEYS", "user:?").Array()))
+	assert.ElementsMatch([]string{"user:2"}, stringArray(c.do("KEYS", "user:[^1]*").Array()))
+	assert.Equal([]string{"other"}, stringArray(c.do("KEYS", "other").Array()))
+	assert.Equal([]string{"h*llo"}, stringArray(c.do("KEYS", `h\*llo`).Array()))
+	assert.Empty(c.do("KEYS", "missing").Array())
+	// an empty pattern only matches the empty key
+	assert.Empty(c.do("KEYS", "").Array())
+	c.do("SET", "", "x")
+	assert.Equal([]string{""}, stringArray(c.do("KEYS", "").Array()))
+	assert.True(c.do("KEYS").IsError())
+}
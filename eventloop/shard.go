@@ -0,0 +1,175 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// shard runs the commands whose keys hash to it, next to the main loop and
+// the other shards. It owns the partition of every database holding those
+// keys.
+type shard struct {
+	id      int
+	reqChan chan *ReqCommand
+	// held while a command runs against the partition. A command with keys
+	// in several shards takes their locks in the order of their IDs.
+	mu sync.Mutex
+	// commands run by the shard, added to those of the loop when read
+	calls callStats
+}
+
+// EnableShards has n shards run the commands that only touch their keys,
+// single key commands going straight to the shard of their key. Every
+// database must be a store.Sharded of n partitions. It must be called
+// before the loop is started.
+func (e *Eventloop) EnableShards(n int) {
+	if n < 2 {
+		e.shards = nil
+		return
+	}
+
+	e.shards = make([]*shard, n)
+	for i := range e.shards {
+		e.shards[i] = &shard{id: i, reqChan: make(chan *ReqCommand), calls: newCallStats()}
+	}
+}
+
+// submit hands a batch of commands from a client to the shard of the first
+// key of its first command if a shard can run it, and to the main loop
+// otherwise. It reports false if the server has shut down.
+func (e *Eventloop) submit(req *ReqCommand) bool {
+	if len(e.shards) > 0 {
+		if keys, ok := shardKeys(req.commands[0].args); ok {
+			select {
+			case e.shards[store.ShardOf(keys[0], len(e.shards))].reqChan <- req:
+				return true
+			case <-e.done:
+				return false
+			}
+		}
+	}
+	return e.send(req)
+}
+
+// shardKeys returns the keys of a command if it only touches them
+func shardKeys(args []string) ([]string, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+	cmd, ok := lookupCommand(args[0])
+	if !ok || cmd.flags&flagKeysOnly == 0 {
+		return nil, false
+	}
+	keys := cmd.keys(args)
+	return keys, len(keys) > 0
+}
+
+// runShard runs the batches sent to a shard until the server shuts down.
+// The rest of a batch goes on to the main loop from the first command the
+// shard cannot run.
+func (e *Eventloop) runShard(s *shard) {
+	for {
+		var req *ReqCommand
+		select {
+		case req = <-s.reqChan:
+		case <-e.done:
+			return
+		}
+
+		for ; req.next < len(req.commands); req.next++ {
+			resp, ok := e.callOnShard(s, req)
+			if !ok {
+				break
+			}
+			req.reply(resp)
+		}
+
+		if req.next < len(req.commands) {
+			e.send(req)
+			continue
+		}
+		req.flush(true)
+	}
+}
+
+// callOnShard runs the next command of a batch on shard s, returning false
+// if it needs the main loop. The command runs while the main loop waits,
+// holding the locks of the shards of its keys, while the other shards keep
+// running the commands of other keys. It is counted by s, and only takes
+// e.shared if it updates what the shards share.
+func (e *Eventloop) callOnShard(s *shard, req *ReqCommand) (protocol.Value, bool) {
+	e.world.RLock()
+	defer e.world.RUnlock()
+
+	c, args := req.client, req.commands[req.next].args
+	keys, ok := shardKeys(args)
+	if !ok || !e.shardable(c) || e.stopped() {
+		return protocol.Value{}, false
+	}
+	ids := e.shardsOf(keys)
+	cmd, _ := lookupCommand(args[0])
+	shared := e.sharesState(cmd)
+
+	c.qbuf = req.qbuf
+	if shared {
+		e.shared.Lock()
+	}
+	run, resp, ok := e.beginCall(&s.calls, c, args)
+	if shared {
+		e.shared.Unlock()
+	}
+	if !ok {
+		return resp, true
+	}
+
+	for _, id := range ids {
+		e.shards[id].mu.Lock()
+	}
+	start := time.Now()
+	resp = e.execute(c, args)
+	took := time.Since(start)
+	// a write is propagated before the next write to its keys runs, which
+	// may come from another shard if a batch routed there names them too
+	if shared {
+		e.shared.Lock()
+		defer e.shared.Unlock()
+	}
+	for _, id := range ids {
+		e.shards[id].mu.Unlock()
+	}
+
+	return e.finishCall(c, run, args, resp, took), true
+}
+
+// sharesState reports whether cmd updates what the shards share when it
+// runs on one: writes go to the AOF and replicas in a single stream, and
+// monitors and tracked keys are fed by every shard. Counters and logs are
+// kept per shard or take locks of their own.
+func (e *Eventloop) sharesState(cmd command) bool {
+	return cmd.isWrite() || len(e.monitors) > 0 || e.tracking.hooked
+}
+
+// shardable reports whether commands of c can run on a shard at the
+// moment. Pauses, shutdowns waiting for replicas, cluster redirects,
+// eviction and the stream from our master are left to the main loop.
+func (e *Eventloop) shardable(c *client) bool {
+	return e.pause.mode == "" && !e.shutdown.pending && e.cluster == nil && e.evict.maxmemory == 0 && !c.master
+}
+
+// shardsOf returns the IDs of the shards of keys, in increasing order
+func (e *Eventloop) shardsOf(keys []string) []int {
+	ids := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, k := range keys {
+		id := store.ShardOf(k, len(e.shards))
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,292 @@
+package eventloop
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/protocol"
+	"noelzubin/redis-go/set"
+	"noelzubin/redis-go/store"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testShards is the number of shards sharded test servers run
+const testShards = 4
+
+// testShardedDBs creates n empty databases split in shards partitions
+func testShardedDBs(n, shards int) []store.Store {
+	dbs := make([]store.Store, n)
+	for i := range dbs {
+		parts := make([]store.Store, shards)
+		for j := range parts {
+			parts[j] = store.InitStore(set.InitStringSet())
+		}
+		dbs[i] = store.NewSharded(parts...)
+	}
+	return dbs
+}
+
+// startShardedTestServer runs a test server with shards
+func startShardedTestServer(t testing.TB, shards int) (*Eventloop, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	el := InitEventloop(testShardedDBs(testDatabases, shards)...)
+	el.EnableShards(shards)
+	go el.RunLoop()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go el.HandleConnection(conn)
+		}
+	}()
+	return el, l.Addr().String()
+}
+
+func Test_Shards_Single_Key_Commands(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startShardedTestServer(t, testShards)
+	c := dialTestServer(t, addr)
+
+	for i := 0; i < 100; i++ {
+		assert.Equal("OK", c.do("SET", "key:"+strconv.Itoa(i), strconv.Itoa(i)).String())
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(strconv.Itoa(i), c.do("GET", "key:"+strconv.Itoa(i)).String())
+	}
+	assert.Equal(int64(1), c.do("ZADD", "zset", "1", "a").Integer())
+	assert.Equal([]string{"a"}, stringArray(c.do("ZRANGE", "zset", "0", "-1").Array()))
+
+	// commands of the whole keyspace go through the main loop
+	assert.Equal(int64(101), c.do("DBSIZE").Integer())
+	assert.Len(c.do("KEYS", "key:*").Array(), 100)
+	assert.Len(scanAll(c, []string{"SCAN"}, "COUNT", "7"), 101)
+
+	fields := infoFields(c.do("INFO", "commandstats").String())
+	assert.Contains(fields["cmdstat_set"], "calls=100,")
+	assert.Contains(fields["cmdstat_get"], "calls=100,")
+}
+
+func Test_Shards_Multi_Key_Commands(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startShardedTestServer(t, testShards)
+	c := dialTestServer(t, addr)
+
+	keys := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		k := "key:" + strconv.Itoa(i)
+		keys = append(keys, k)
+		c.do("SET", k, "v")
+	}
+
+	// keys of every shard at once
+	assert.Equal(int64(20), c.do(append([]string{"EXISTS"}, keys...)...).Integer())
+	for i := 0; i < 19; i++ {
+		assert.Equal("OK", c.do("RENAME", keys[i], "renamed:"+keys[i]).String())
+	}
+	assert.Equal(int64(1), c.do("COPY", keys[19], "copied").Integer())
+	assert.Equal(int64(21), c.do("DBSIZE").Integer())
+	assert.Equal(int64(19), c.do(append([]string{"DEL"}, stringArgs("renamed:", keys[:19])...)...).Integer())
+	assert.Equal(int64(2), c.do("DBSIZE").Integer())
+
+	// keys of one database move within their shard
+	assert.Equal(int64(1), c.do("MOVE", "copied", "3").Integer())
+	c.do("SELECT", "3")
+	assert.Equal("v", c.do("GET", "copied").String())
+}
+
+func stringArgs(prefix string, keys []string) []string {
+	args := make([]string, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, prefix+k)
+	}
+	return args
+}
+
+func Test_Shards_Concurrent_Clients(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startShardedTestServer(t, testShards)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		c := dialTestServer(t, addr)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				k := fmt.Sprintf("key:%d:%d", i, j)
+				c.do("SET", k, "v")
+				c.do("RENAME", k, k+":renamed")
+				c.do("GET", k+":renamed")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	c := dialTestServer(t, addr)
+	assert.Equal(int64(1600), c.do("DBSIZE").Integer())
+	fields := infoFields(c.do("INFO", "stats").String())
+	assert.Equal(strconv.Itoa(8*600+2), fields["total_commands_processed"])
+}
+
+func Test_Shards_Stats(t *testing.T) {
+	assert := assert.New(t)
+	el, addr := startShardedTestServer(t, testShards)
+	c := dialTestServer(t, addr)
+	m := dialTestServer(t, addr)
+
+	for i := 0; i < 40; i++ {
+		c.do("GET", "key:"+strconv.Itoa(i))
+	}
+
+	// each shard counts the commands it ran, which add up on read
+	counted := 0
+	el.world.Lock()
+	for _, s := range el.shards {
+		if stats, ok := s.calls.commands["get"]; ok && stats.calls > 0 {
+			counted++
+		}
+	}
+	el.world.Unlock()
+	assert.Greater(counted, 1)
+	assert.Contains(infoFields(c.do("INFO", "commandstats").String())["cmdstat_get"], "calls=40,")
+	histogram := c.do("LATENCY", "HISTOGRAM", "get").Array()
+	assert.Equal(int64(40), histogram[1].Array()[1].Integer())
+
+	assert.Equal("OK", c.do("CONFIG", "RESETSTAT").String())
+	assert.NotContains(infoFields(c.do("INFO", "commandstats").String()), "cmdstat_get")
+
+	// monitors see the commands the shards run
+	assert.Equal("OK", m.do("MONITOR").String())
+	c.do("GET", "foo")
+	assert.Regexp(`"GET" "foo"$`, m.read().String())
+}
+
+func Test_Shards_Propagation_Order(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	l := listenTest(t)
+	serveTest(t, l, func(el *Eventloop) {
+		el.SetDatabases(testShardedDBs(testDatabases, testShards)...)
+		assert.NoError(el.EnableAOF(path, aof.FsyncAlways))
+		el.EnableShards(testShards)
+	})
+
+	// batches routed to two shards by their first key, both writing "k"
+	routes := []string{"a"}
+	for i := 0; len(routes) < 2; i++ {
+		if k := "b" + strconv.Itoa(i); store.ShardOf(k, testShards) != store.ShardOf("a", testShards) {
+			routes = append(routes, k)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, route := range routes {
+		c := dialTestServer(t, l.Addr().String())
+		wg.Add(1)
+		go func(route string) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				batch := append(aof.Encode([]string{"SET", route, "v"}), aof.Encode([]string{"SET", "k", route + strconv.Itoa(i)})...)
+				c.conn.Write(batch)
+				c.read()
+				c.read()
+			}
+		}(route)
+	}
+	wg.Wait()
+
+	// the file replays to the value the server holds
+	var last string
+	_, err := aof.Load(path, func(args []string) {
+		if strings.EqualFold(args[0], "set") && args[1] == "k" {
+			last = args[2]
+		}
+	})
+	assert.NoError(err)
+	assert.Equal(dialTestServer(t, l.Addr().String()).do("GET", "k").String(), last)
+}
+
+func Test_Shards_Main_Loop_Commands(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startShardedTestServer(t, testShards)
+	c := dialTestServer(t, addr)
+
+	// shards leave eviction to the main loop
+	assert.Equal("OK", c.do("CONFIG", "SET", "maxmemory", "100mb").String())
+	assert.Equal("OK", c.do("SET", "foo", "bar").String())
+	assert.Equal("bar", c.do("GET", "foo").String())
+
+	assert.Equal("OK", c.do("FLUSHALL").String())
+	assert.Equal(int64(0), c.do("DBSIZE").Integer())
+}
+
+// BenchmarkShards runs GET, or SET and GET, of random keys from as many
+// clients as GOMAXPROCS, on a server with a single loop and on one with as
+// many shards as GOMAXPROCS. Compare the runs of go test -bench Shards -cpu
+// 1,2,4,8.
+func BenchmarkShards(b *testing.B) {
+	for _, writes := range []bool{false, true} {
+		name := "get"
+		if writes {
+			name = "set-get"
+		}
+		b.Run("loop/"+name, func(b *testing.B) {
+			el := InitEventloop(testDBs(testDatabases)...)
+			go el.RunLoop()
+			benchmarkCommands(b, el, writes)
+		})
+		b.Run("shards/"+name, func(b *testing.B) {
+			shards := runtime.GOMAXPROCS(0)
+			if shards < 2 {
+				shards = 2
+			}
+			el := InitEventloop(testShardedDBs(testDatabases, shards)...)
+			el.EnableShards(shards)
+			go el.RunLoop()
+			benchmarkCommands(b, el, writes)
+		})
+	}
+}
+
+// benchmarkCommands runs GET, and SET every other command if writes is
+// set, on el over in-memory connections
+func benchmarkCommands(b *testing.B, el *Eventloop, writes bool) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go el.HandleConnection(server)
+		reader := bufio.NewReader(client)
+
+		i := 0
+		for pb.Next() {
+			k := "key:" + strconv.Itoa(i%1000)
+			args := []string{"GET", k}
+			if writes && i%2 == 0 {
+				args = []string{"SET", k, "value"}
+			}
+			client.Write(aof.Encode(args))
+			if _, err := protocol.DecodeRESP(reader); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/protocol"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// default of shutdown-timeout, the seconds a shutdown waits for
+	// lagging replicas
+	defaultShutdownTimeout = 10
+	// how long connections get to write their last replies once the server
+	// shuts down
+	shutdownWriteTimeout = time.Second
+)
+
+var errShutdown = errors.New("Errors trying to SHUTDOWN. Check logs.")
+
+// shutdownFlags are the options of SHUTDOWN
+type shutdownFlags struct {
+	// rewrite the append only file from the dataset, or leave it as it is
+	save   bool
+	noSave bool
+	// skip waiting for lagging replicas
+	now bool
+	// shut down even if the dataset could not be persisted
+	force bool
+}
+
+// shutdownState is a shutdown waiting for replicas to catch up, which
+// holds back writes in the meantime
+type shutdownState struct {
+	pending bool
+	flags   shutdownFlags
+	// replication offset every replica has to acknowledge, and when to
+	// stop waiting for them
+	offset   int64
+	deadline time.Time
+	// batches of the clients that ran SHUTDOWN, blocked until it fails or
+	// is aborted
+	blocked []*ReqCommand
+	// told how the shutdown went, for Stop
+	results []chan error
+}
+
+// shutdownRequest asks the loop to shut down, the way Stop does
+type shutdownRequest struct {
+	flags  shutdownFlags
+	result chan error
+}
+
+// Done is closed once the server has shut down
+func (e *Eventloop) Done() <-chan struct{} {
+	return e.done
+}
+
+// stopped reports whether the server has shut down
+func (e *Eventloop) stopped() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// send hands msg to the loop, and reports false if the server has shut
+// down
+func (e *Eventloop) send(msg interface{}) bool {
+	select {
+	case e.reqChan <- msg:
+		return true
+	case <-e.done:
+		return false
+	}
+}
+
+// Stop shuts the server down as SHUTDOWN with no option does, then waits
+// for the connections to write their last replies and close. If ctx is done
+// while lagging replicas are waited for, the server shuts down right away.
+// If the dataset cannot be persisted the server keeps running.
+func (e *Eventloop) Stop(ctx context.Context) error {
+	result := make(chan error, 1)
+	if e.send(shutdownRequest{result: result}) {
+		select {
+		case err := <-result:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			forced := make(chan error, 1)
+			if e.send(shutdownRequest{flags: shutdownFlags{now: true, force: true}, result: forced}) {
+				<-forced
+			}
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		e.conns.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Serve hands every connection accepted on l to the loop until the server
+// shuts down, which closes l
+func (e *Eventloop) Serve(l net.Listener) error {
+	if !e.addListener(l) {
+		return nil
+	}
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if e.stopped() {
+				return nil
+			}
+			// out of file descriptors and the like, wait for some to be
+			// released
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() || errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+				delay = acceptBackoff(delay)
+				fmt.Println("Error accepting connection: ", err.Error())
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+
+		delay = 0
+		go e.HandleConnection(conn)
+	}
+}
+
+// acceptBackoff returns how long to wait after an accept failed again
+// following delay
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+	if delay *= 2; delay > time.Second {
+		delay = time.Second
+	}
+	return delay
+}
+
+// addListener registers a listener to close on shutdown, unless the
+// server has shut down already
+func (e *Eventloop) addListener(l io.Closer) bool {
+	e.stopMu.Lock()
+	defer e.stopMu.Unlock()
+
+	if e.stopped() {
+		l.Close()
+		return false
+	}
+	e.listeners = append(e.listeners, l)
+	return true
+}
+
+// addConn counts a connection Stop waits for, unless the server has shut
+// down already
+func (e *Eventloop) addConn() bool {
+	e.stopMu.Lock()
+	defer e.stopMu.Unlock()
+
+	if e.stopped() {
+		return false
+	}
+	e.conns.Add(1)
+	return true
+}
+
+// stopAccepting marks the server as shut down and closes its listeners
+func (e *Eventloop) stopAccepting() {
+	e.stopMu.Lock()
+	defer e.stopMu.Unlock()
+
+	close(e.done)
+	for _, l := range e.listeners {
+		l.Close()
+	}
+	e.listeners = nil
+}
+
+func (e *Eventloop) shutdownCommand(c *client, args []string) protocol.Value {
+	var flags shutdownFlags
+	abort := false
+	for _, arg := range args[1:] {
+		switch strings.ToLower(arg) {
+		case "save":
+			flags.save = true
+		case "nosave":
+			flags.noSave = true
+		case "now":
+			flags.now = true
+		case "force":
+			flags.force = true
+		case "abort":
+			abort = true
+		default:
+			return protocol.NewErrorValue("ERR syntax error")
+		}
+	}
+	if flags.save && flags.noSave || abort && len(args) != 2 {
+		return protocol.NewErrorValue("ERR syntax error")
+	}
+
+	if abort {
+		if !e.shutdown.pending {
+			return protocol.NewErrorValue("ERR No shutdown in progress.")
+		}
+		fmt.Println("Shutdown aborted with SHUTDOWN ABORT")
+		e.cancelShutdown(errShutdown)
+		return protocol.NewSimpleStringValue(&OK)
+	}
+
+	if err := e.startShutdown(flags); err != nil {
+		return protocol.NewErrorValue("ERR " + err.Error())
+	}
+	// the connection gets no reply if the server shuts down, only closed,
+	// and waits for the replicas until then
+	c.blocked = e.shutdown.pending
+	return protocol.Value{}
+}
+
+// startShutdown shuts the server down, or waits for lagging replicas to
+// catch up first unless told not to
+func (e *Eventloop) startShutdown(flags shutdownFlags) error {
+	if e.shutdown.pending {
+		// a shutdown that waits already takes the new options
+		e.shutdown.flags = flags
+	} else {
+		e.shutdown = shutdownState{flags: flags, offset: e.repl.offset.Load()}
+	}
+
+	timeout := time.Duration(e.shutdownTimeout) * time.Second
+	if flags.now || timeout == 0 || !e.replicasLagging(e.shutdown.offset) {
+		return e.finishShutdown()
+	}
+
+	if !e.shutdown.pending {
+		fmt.Println("Waiting for replicas before shutting down")
+		e.shutdown.pending = true
+		e.shutdown.deadline = time.Now().Add(timeout)
+		// replicas acknowledge what they got right away rather than on
+		// their next periodic ACK
+		e.replicationFeed(aof.Encode([]string{"REPLCONF", "GETACK", "*"}))
+	}
+	return nil
+}
+
+// replicasLagging reports whether a replica has yet to acknowledge offset
+func (e *Eventloop) replicasLagging(offset int64) bool {
+	for r := range e.repl.replicas {
+		if !r.isClosed() && r.ackOffset.Load() < offset {
+			return true
+		}
+	}
+	return false
+}
+
+// shutdownCron shuts down a server waiting for its replicas once they
+// caught up or the wait timed out
+func (e *Eventloop) shutdownCron() {
+	if !e.shutdown.pending {
+		return
+	}
+
+	if e.replicasLagging(e.shutdown.offset) {
+		if time.Now().Before(e.shutdown.deadline) {
+			return
+		}
+		fmt.Println("Lagging replicas did not catch up before shutdown-timeout, shutting down anyway")
+	}
+	e.finishShutdown()
+}
+
+// finishShutdown persists the dataset, stops accepting connections and
+// closes the connected ones. If the dataset cannot be persisted the server
+// keeps running, unless forced to shut down.
+func (e *Eventloop) finishShutdown() error {
+	flags := e.shutdown.flags
+	if err := e.persist(flags); err != nil {
+		fmt.Println("Error trying to persist the dataset on shutdown: ", err.Error())
+		if !flags.force {
+			e.cancelShutdown(errShutdown)
+			return errShutdown
+		}
+		fmt.Println("Shutting down anyway, as forced to")
+	}
+
+	fmt.Println("Shutting down")
+	e.stopAccepting()
+	if e.aof != nil {
+		if err := e.aof.Close(); err != nil {
+			fmt.Println("Error closing the append only file: ", err.Error())
+		}
+	}
+	if e.repl.link != nil {
+		e.repl.link.close()
+	}
+	e.disconnectReplicas()
+
+	// connections write what they were sending and close, as the loop no
+	// longer reads what they submit
+	deadline := time.Now()
+	for _, c := range e.clients {
+		c.interrupt(deadline, deadline.Add(shutdownWriteTimeout))
+	}
+
+	for _, result := range e.shutdown.results {
+		result <- nil
+	}
+	e.shutdown = shutdownState{}
+	return nil
+}
+
+// persist makes sure the dataset is on disk before the server shuts down
+func (e *Eventloop) persist(flags shutdownFlags) error {
+	if e.aof == nil {
+		if flags.save {
+			return errors.New("no append only file to save the dataset to")
+		}
+		return nil
+	}
+
+	// a background rewrite still running is dropped with the server, as
+	// its file would only replace the live one, which has every write
+	if flags.save && !e.aof.Rewriting() {
+		return e.rewriteAOF()
+	}
+	return e.aof.Sync()
+}
+
+// cancelShutdown gives up on a shutdown, telling those waiting for it and
+// letting writes through again
+func (e *Eventloop) cancelShutdown(err error) {
+	state := e.shutdown
+	e.shutdown = shutdownState{}
+
+	for _, result := range state.results {
+		result <- err
+	}
+	for _, req := range state.blocked {
+		req.client.blocked = false
+		req.reply(protocol.NewErrorValue("ERR " + err.Error()))
+		req.next++
+		e.runCommands(req)
+	}
+	// writes held back by the shutdown rather than by CLIENT PAUSE
+	if e.pause.mode == "" {
+		e.unpause()
+	}
+}
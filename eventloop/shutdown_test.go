@@ -0,0 +1,228 @@
+package eventloop
+
+import (
+	"context"
+	"net"
+	"noelzubin/redis-go/aof"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitShutdown fails the test unless the server shuts down shortly
+func waitShutdown(t *testing.T, el *Eventloop) {
+	t.Helper()
+	select {
+	case <-el.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+// assertClosed checks the server closed the connection
+func assertClosed(t *testing.T, c *testConn) {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.reader.ReadByte()
+	assert.Error(t, err)
+}
+
+// fakeReplica registers a connection as a replica that acknowledges what
+// it is told to
+func fakeReplica(t *testing.T, addr string) *testConn {
+	r := dialTestServer(t, addr)
+	assert.Equal(t, "OK", r.do("REPLCONF", "listening-port", "6380").String())
+	r.do("PSYNC", "?", "-1")
+	// the dataset, written before the stream
+	if _, err := r.reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func Test_Shutdown(t *testing.T) {
+	assert := assert.New(t)
+	el, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	other := dialTestServer(t, addr)
+
+	assert.Equal("ERR syntax error", c.do("SHUTDOWN", "SAVE", "NOSAVE").String())
+	assert.Equal("ERR syntax error", c.do("SHUTDOWN", "NOW", "ABORT").String())
+	assert.Equal("ERR syntax error", c.do("SHUTDOWN", "LATER").String())
+	assert.Equal("ERR No shutdown in progress.", c.do("SHUTDOWN", "ABORT").String())
+
+	// there is nothing to save the dataset to without an append only file
+	assert.Equal("ERR Errors trying to SHUTDOWN. Check logs.", c.do("SHUTDOWN", "SAVE").String())
+	assert.Equal("PONG", other.do("PING").String())
+
+	// the connection is closed without a reply, along with the others
+	_, err := c.conn.Write(aof.Encode([]string{"SHUTDOWN"}))
+	assert.NoError(err)
+	assertClosed(t, c)
+	assertClosed(t, other)
+	waitShutdown(t, el)
+
+	// and no connection is accepted anymore
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("connection accepted after shutdown")
+	}
+}
+
+func Test_Shutdown_Force(t *testing.T) {
+	el, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	_, err := c.conn.Write(aof.Encode([]string{"SHUTDOWN", "SAVE", "FORCE"}))
+	assert.NoError(t, err)
+	assertClosed(t, c)
+	waitShutdown(t, el)
+}
+
+func Test_Shutdown_Save(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	l := listenTest(t)
+	el := serveTest(t, l, func(el *Eventloop) {
+		assert.NoError(el.EnableAOF(path, aof.FsyncNo))
+	})
+	c := dialTestServer(t, l.Addr().String())
+
+	for i := 0; i < 10; i++ {
+		c.do("SET", "foo", strconv.Itoa(i))
+	}
+	_, err := c.conn.Write(aof.Encode([]string{"SHUTDOWN", "SAVE"}))
+	assert.NoError(err)
+	waitShutdown(t, el)
+
+	// the file holds the dataset rather than every write
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal(string(aof.Encode([]string{"SELECT", "0"}))+string(aof.Encode([]string{"SET", "foo", "9"})), string(data))
+}
+
+func Test_Shutdown_Save_During_Rewrite(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	l := listenTest(t)
+	el := serveTest(t, l, func(el *Eventloop) {
+		assert.NoError(el.EnableAOF(path, aof.FsyncNo))
+	})
+	c := dialTestServer(t, l.Addr().String())
+
+	c.do("SET", "foo", "0")
+	// a BGREWRITEAOF still writing its file
+	assert.NoError(el.aof.StartRewrite())
+	c.do("SET", "foo", "1")
+
+	_, err := c.conn.Write(aof.Encode([]string{"SHUTDOWN", "SAVE"}))
+	assert.NoError(err)
+	assertClosed(t, c)
+	waitShutdown(t, el)
+
+	// the live file is kept, with every write on disk
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal(string(aof.Encode([]string{"SELECT", "0"}))+string(aof.Encode([]string{"SET", "foo", "0"}))+
+		string(aof.Encode([]string{"SET", "foo", "1"})), string(data))
+}
+
+func Test_Shutdown_Waits_For_Replicas(t *testing.T) {
+	assert := assert.New(t)
+	l := listenTest(t)
+	el := serveTest(t, l, func(el *Eventloop) {
+		go el.StartCleanUpTimer()
+	})
+	addr := l.Addr().String()
+	c := dialTestServer(t, addr)
+	other := dialTestServer(t, addr)
+	r := fakeReplica(t, addr)
+
+	c.do("SET", "foo", "bar")
+
+	// SHUTDOWN waits for the replica, holding back writes but not reads
+	_, err := c.conn.Write(aof.Encode([]string{"SHUTDOWN"}))
+	assert.NoError(err)
+	reader := dialTestServer(t, addr)
+	assert.Eventually(func() bool {
+		return strings.Contains(reader.do("CLIENT", "LIST").String(), "flags=b")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	written := make(chan string)
+	go func() { written <- other.do("SET", "foo", "baz").String() }()
+	select {
+	case <-written:
+		t.Fatal("write ran while the shutdown waited for replicas")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Equal("bar", reader.do("GET", "foo").String())
+
+	// until it is aborted
+	assert.Equal("OK", reader.do("SHUTDOWN", "ABORT").String())
+	assert.Equal("ERR Errors trying to SHUTDOWN. Check logs.", c.read().String())
+	assert.Equal("OK", <-written)
+
+	// once the replica has caught up the server shuts down
+	_, err = c.conn.Write(aof.Encode([]string{"SHUTDOWN"}))
+	assert.NoError(err)
+	assert.Eventually(func() bool {
+		return strings.Contains(reader.do("CLIENT", "LIST").String(), "flags=b")
+	}, 5*time.Second, 20*time.Millisecond)
+	_, err = r.conn.Write(aof.Encode([]string{"REPLCONF", "ACK", strconv.FormatInt(el.repl.offset.Load(), 10)}))
+	assert.NoError(err)
+	assertClosed(t, c)
+	waitShutdown(t, el)
+}
+
+func Test_Shutdown_Timeout(t *testing.T) {
+	assert := assert.New(t)
+	l := listenTest(t)
+	el := serveTest(t, l, func(el *Eventloop) {
+		go el.StartCleanUpTimer()
+	})
+	addr := l.Addr().String()
+	c := dialTestServer(t, addr)
+	fakeReplica(t, addr)
+
+	c.do("SET", "foo", "bar")
+	assert.Equal("OK", c.do("CONFIG", "SET", "shutdown-timeout", "1").String())
+
+	// a replica that never catches up is waited for until the timeout
+	start := time.Now()
+	_, err := c.conn.Write(aof.Encode([]string{"SHUTDOWN"}))
+	assert.NoError(err)
+	assertClosed(t, c)
+	waitShutdown(t, el)
+	assert.GreaterOrEqual(time.Since(start), 900*time.Millisecond)
+}
+
+func Test_Stop(t *testing.T) {
+	assert := assert.New(t)
+	l := listenTest(t)
+	el := serveTest(t, l, func(el *Eventloop) {
+		go el.StartCleanUpTimer()
+	})
+	addr := l.Addr().String()
+	c := dialTestServer(t, addr)
+	fakeReplica(t, addr)
+	c.do("SET", "foo", "bar")
+
+	// the context cuts the wait for the replica short
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(el.Stop(ctx), context.DeadlineExceeded)
+	assert.Less(time.Since(start), 5*time.Second)
+	waitShutdown(t, el)
+	assertClosed(t, c)
+
+	// stopping a server that has shut down waits for its connections
+	assert.NoError(el.Stop(context.Background()))
+}
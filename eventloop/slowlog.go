@@ -0,0 +1,145 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaults of slowlog-log-slower-than, in microseconds, and of
+	// slowlog-max-len
+	defaultSlowlogSlowerThan = 10000
+	defaultSlowlogMaxLen     = 128
+
+	// arguments beyond slowlogMaxArgs, and bytes of an argument beyond
+	// slowlogMaxArgLen, are left out of an entry
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry is a command that ran for longer than the threshold
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowLog keeps the latest slow commands, newest first. The shards log
+// their slow commands too, so adding an entry takes a lock.
+type slowLog struct {
+	mu      sync.Mutex
+	entries []slowlogEntry
+	nextID  int64
+	// threshold in microseconds, a negative one disables the log and zero
+	// logs every command
+	slowerThan int
+	maxLen     int
+}
+
+func newSlowLog() *slowLog {
+	return &slowLog{slowerThan: defaultSlowlogSlowerThan, maxLen: defaultSlowlogMaxLen}
+}
+
+// slow reports whether a command that took d is to be logged
+func (l *slowLog) slow(d time.Duration) bool {
+	return l.slowerThan >= 0 && d.Microseconds() >= int64(l.slowerThan)
+}
+
+// add logs a slow command run by c
+func (l *slowLog) add(c *client, args []string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := slowlogEntry{
+		id:       l.nextID,
+		time:     time.Now(),
+		duration: d,
+		args:     slowlogArgs(args),
+		addr:     c.addr,
+		name:     c.name,
+	}
+	l.nextID++
+
+	l.entries = append([]slowlogEntry{entry}, l.entries...)
+	if len(l.entries) > l.maxLen {
+		l.entries = l.entries[:l.maxLen]
+	}
+}
+
+// slowlogArgs truncates the arguments of a command to keep the log small
+func slowlogArgs(args []string) []string {
+	n := len(args)
+	if n > slowlogMaxArgs {
+		n = slowlogMaxArgs
+	}
+
+	logged := make([]string, n)
+	for i := range logged {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			logged[i] = "... (" + strconv.Itoa(len(args)-slowlogMaxArgs+1) + " more arguments)"
+			break
+		}
+		logged[i] = args[i]
+		if len(args[i]) > slowlogMaxArgLen {
+			logged[i] = args[i][:slowlogMaxArgLen] + "... (" + strconv.Itoa(len(args[i])-slowlogMaxArgLen) + " more bytes)"
+		}
+	}
+	return logged
+}
+
+// slowlogCommand implements SLOWLOG GET [count], LEN and RESET
+func (e *Eventloop) slowlogCommand(args []string) protocol.Value {
+	if len(args) < 2 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'slowlog' command")
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "get":
+		if len(args) > 3 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'slowlog|get' command")
+		}
+		count := 10
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < -1 {
+				return protocol.NewErrorValue("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		if count == -1 || count > len(e.slowlog.entries) {
+			count = len(e.slowlog.entries)
+		}
+
+		entries := make([]protocol.Value, 0, count)
+		for _, entry := range e.slowlog.entries[:count] {
+			entries = append(entries, protocol.NewArrayValue([]protocol.Value{
+				protocol.NewSimpleIntValue(entry.id),
+				protocol.NewSimpleIntValue(entry.time.Unix()),
+				protocol.NewSimpleIntValue(entry.duration.Microseconds()),
+				protocol.NewArrayBulkStringValue(entry.args),
+				protocol.NewBulkStringValue(entry.addr),
+				protocol.NewBulkStringValue(entry.name),
+			}))
+		}
+		return protocol.NewArrayValue(entries)
+	case "len":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'slowlog|len' command")
+		}
+		return protocol.NewSimpleIntValue(int64(len(e.slowlog.entries)))
+	case "reset":
+		if len(args) != 2 {
+			return protocol.NewErrorValue("ERR wrong number of arguments for 'slowlog|reset' command")
+		}
+		e.slowlog.entries = nil
+		return protocol.NewSimpleStringValue(&OK)
+	}
+
+	return protocol.NewErrorValue("ERR unknown subcommand '" + args[1] + "'. Try SLOWLOG HELP.")
+}
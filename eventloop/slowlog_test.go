@@ -0,0 +1,56 @@
+package eventloop
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Slowlog(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+
+	assert.Equal(int64(0), c.do("SLOWLOG", "LEN").Integer())
+
+	// log every command
+	c.do("CONFIG", "SET", "slowlog-log-slower-than", "0", "slowlog-max-len", "3")
+	c.do("CLIENT", "SETNAME", "slow")
+	c.do("SET", "foo", strings.Repeat("x", 200))
+	c.do("GET", "foo")
+
+	// SLOWLOG is logged too, once it has run
+	assert.Equal(int64(3), c.do("SLOWLOG", "LEN").Integer())
+	entries := c.do("SLOWLOG", "GET", "3").Array()
+	assert.Len(entries, 3)
+	assert.Equal([]string{"SLOWLOG", "LEN"}, stringArray(entries[0].Array()[3].Array()))
+
+	get := entries[1].Array()
+	assert.Greater(get[0].Integer(), entries[2].Array()[0].Integer())
+	assert.Equal([]string{"GET", "foo"}, stringArray(get[3].Array()))
+	assert.Equal(c.conn.LocalAddr().String(), get[4].String())
+	assert.Equal("slow", get[5].String())
+
+	// long arguments are truncated
+	set := stringArray(entries[2].Array()[3].Array())
+	assert.Equal(strings.Repeat("x", 128)+"... (72 more bytes)", set[2])
+
+	assert.Len(c.do("SLOWLOG", "GET", "-1").Array(), 3)
+	assert.Equal("ERR count should be greater than or equal to -1", c.do("SLOWLOG", "GET", "-2").String())
+	assert.Equal("OK", c.do("SLOWLOG", "RESET").String())
+	assert.Equal(int64(1), c.do("SLOWLOG", "LEN").Integer())
+}
+
+func Test_SlowlogArgs(t *testing.T) {
+	assert := assert.New(t)
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = "a"
+	}
+
+	logged := slowlogArgs(args)
+	assert.Len(logged, slowlogMaxArgs)
+	assert.Equal("... (9 more arguments)", logged[slowlogMaxArgs-1])
+	assert.Equal([]string{"a"}, slowlogArgs([]string{"a"}))
+}
@@ -0,0 +1,303 @@
+package eventloop
+
+import (
+	"fmt"
+	"noelzubin/redis-go/metrics"
+	"noelzubin/redis-go/store"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// redisVersion is the version of Redis the server is compatible with, which
+// clients look at to know which commands they can use
+const redisVersion = "7.0.0"
+
+// commandStats counts the calls of a command
+type commandStats struct {
+	calls int64
+	// time spent executing the command, in microseconds
+	usec int64
+	// calls refused before the command ran, and calls that returned an
+	// error
+	rejected int64
+	failed   int64
+	// calls by how long they took, see latencyBucket
+	latency [latencyHistogramBuckets]int64
+}
+
+// serverStats holds the counters reported by INFO
+type serverStats struct {
+	startTime time.Time
+	// random ID of this run of the server
+	runID string
+	// heap in use once the server started, reported by MEMORY STATS
+	startupAllocated uint64
+
+	totalConnections int64
+	// commands run by the loop, the shards counting their own
+	calls callStats
+}
+
+// callStats counts the commands run by the loop or by a shard. Each keeps
+// its own so the shards do not contend on them, and readers add them up
+// with Eventloop.callStats.
+type callStats struct {
+	total    int64
+	commands map[string]*commandStats
+	// latency of each command, exported on /metrics
+	histograms map[string]*metrics.Histogram
+}
+
+func newCallStats() callStats {
+	return callStats{
+		commands:   make(map[string]*commandStats),
+		histograms: make(map[string]*metrics.Histogram),
+	}
+}
+
+func newServerStats() *serverStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return &serverStats{
+		startTime:        time.Now(),
+		runID:            newReplID(),
+		startupAllocated: m.HeapAlloc,
+		calls:            newCallStats(),
+	}
+}
+
+// command returns the counters of the command called with args, and the
+// name they are reported under
+func (s *callStats) command(cmd command, args []string) (*commandStats, string) {
+	name := cmd.name
+	if len(args) > 1 {
+		for _, sub := range cmd.subcommands {
+			if strings.EqualFold(args[1], sub) {
+				name += "|" + sub
+				break
+			}
+		}
+	}
+
+	stats, ok := s.commands[name]
+	if !ok {
+		stats = &commandStats{}
+		s.commands[name] = stats
+	}
+	return stats, name
+}
+
+// observe records how long a command took to execute
+func (s *callStats) observe(name string, d time.Duration) {
+	h, ok := s.histograms[name]
+	if !ok {
+		h = metrics.NewHistogram(latencyBuckets...)
+		s.histograms[name] = h
+	}
+	h.Observe(d.Seconds())
+}
+
+// reset zeroes the counters that CONFIG RESETSTAT resets. The latency
+// histograms are exported as Prometheus counters, which never go down.
+func (s *callStats) reset() {
+	s.total = 0
+	// the counters are zeroed in place, as the running command already
+	// holds its own
+	for _, stats := range s.commands {
+		*stats = commandStats{}
+	}
+}
+
+// add adds the counters of o to s
+func (s *commandStats) add(o *commandStats) {
+	s.calls += o.calls
+	s.usec += o.usec
+	s.rejected += o.rejected
+	s.failed += o.failed
+	for i, n := range o.latency {
+		s.latency[i] += n
+	}
+}
+
+// callStats adds up the commands run by the loop and by every shard. The
+// shards wait while the loop reads them.
+func (e *Eventloop) callStats() callStats {
+	if len(e.shards) == 0 {
+		return e.stats.calls
+	}
+
+	total := newCallStats()
+	merge := func(s *callStats) {
+		total.total += s.total
+		for name, stats := range s.commands {
+			if total.commands[name] == nil {
+				total.commands[name] = &commandStats{}
+			}
+			total.commands[name].add(stats)
+		}
+		for name, h := range s.histograms {
+			if total.histograms[name] == nil {
+				total.histograms[name] = metrics.NewHistogram(latencyBuckets...)
+			}
+			total.histograms[name].Add(h)
+		}
+	}
+	merge(&e.stats.calls)
+	for _, s := range e.shards {
+		merge(&s.calls)
+	}
+	return total
+}
+
+// reset zeroes the counters that CONFIG RESETSTAT resets, those of the
+// shards included
+func (s *serverStats) reset(shards []*shard) {
+	s.totalConnections = 0
+	s.calls.reset()
+	for _, sh := range shards {
+		sh.calls.reset()
+	}
+}
+
+// dbStats adds up the counters of every database
+func (e *Eventloop) dbStats() store.Stats {
+	var total store.Stats
+	for _, db := range e.dbs {
+		s := db.Stats()
+		total.Keys += s.Keys
+		total.Expires += s.Expires
+		total.ExpiredKeys += s.ExpiredKeys
+		total.EvictedKeys += s.EvictedKeys
+		total.Hits += s.Hits
+		total.Misses += s.Misses
+	}
+	return total
+}
+
+func (e *Eventloop) infoServer() string {
+	var b strings.Builder
+	uptime := time.Since(e.stats.startTime)
+	mode := "standalone"
+	if e.cluster != nil {
+		mode = "cluster"
+	}
+	executable, _ := os.Executable()
+
+	fmt.Fprintf(&b, "redis_version:%s\r\n", redisVersion)
+	fmt.Fprintf(&b, "redis_mode:%s\r\n", mode)
+	fmt.Fprintf(&b, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "arch_bits:%d\r\n", 32<<(^uint(0)>>63))
+	fmt.Fprintf(&b, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(&b, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(&b, "run_id:%s\r\n", e.stats.runID)
+	fmt.Fprintf(&b, "tcp_port:%d\r\n", e.port)
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+	fmt.Fprintf(&b, "uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	fmt.Fprintf(&b, "hz:%d\r\n", e.hz.Load())
+	fmt.Fprintf(&b, "executable:%s\r\n", executable)
+	fmt.Fprintf(&b, "config_file:%s\r\n", e.config.Path())
+
+	return b.String()
+}
+
+func (e *Eventloop) infoClients() string {
+	return fmt.Sprintf("connected_clients:%d\r\n", len(e.clients))
+}
+
+func (e *Eventloop) infoMemory() string {
+	var b strings.Builder
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	fmt.Fprintf(&b, "used_memory:%d\r\n", m.HeapAlloc)
+	fmt.Fprintf(&b, "used_memory_human:%s\r\n", humanBytes(m.HeapAlloc))
+	fmt.Fprintf(&b, "used_memory_rss:%d\r\n", m.Sys)
+	fmt.Fprintf(&b, "used_memory_rss_human:%s\r\n", humanBytes(m.Sys))
+	fmt.Fprintf(&b, "used_memory_dataset:%d\r\n", e.usedMemory())
+	fmt.Fprintf(&b, "maxmemory:%d\r\n", e.evict.maxmemory)
+	fmt.Fprintf(&b, "maxmemory_human:%s\r\n", humanBytes(uint64(e.evict.maxmemory)))
+	fmt.Fprintf(&b, "maxmemory_policy:%s\r\n", e.evict.policy)
+	fmt.Fprintf(&b, "lazyfree_pending_objects:%d\r\n", e.lazyfree.pending.Load())
+	fmt.Fprintf(&b, "mem_allocator:go\r\n")
+
+	return b.String()
+}
+
+func (e *Eventloop) infoStats() string {
+	var b strings.Builder
+	dbs := e.dbStats()
+
+	fmt.Fprintf(&b, "total_connections_received:%d\r\n", e.stats.totalConnections)
+	fmt.Fprintf(&b, "total_commands_processed:%d\r\n", e.callStats().total)
+	fmt.Fprintf(&b, "sync_full:%d\r\n", e.repl.syncFull.Load())
+	fmt.Fprintf(&b, "sync_partial_ok:%d\r\n", e.repl.syncPartialOk.Load())
+	fmt.Fprintf(&b, "sync_partial_err:%d\r\n", e.repl.syncPartialErr.Load())
+	fmt.Fprintf(&b, "expired_keys:%d\r\n", dbs.ExpiredKeys)
+	fmt.Fprintf(&b, "expired_stale_perc:%.2f\r\n", e.expire.stalePerc)
+	fmt.Fprintf(&b, "expired_time_cap_reached_count:%d\r\n", e.expire.timeCapReached)
+	fmt.Fprintf(&b, "expire_cycle_cpu_milliseconds:%d\r\n", e.expire.cpuTime.Milliseconds())
+	fmt.Fprintf(&b, "evicted_keys:%d\r\n", dbs.EvictedKeys)
+	fmt.Fprintf(&b, "lazyfreed_objects:%d\r\n", e.lazyfree.freed.Load())
+	fmt.Fprintf(&b, "keyspace_hits:%d\r\n", dbs.Hits)
+	fmt.Fprintf(&b, "keyspace_misses:%d\r\n", dbs.Misses)
+
+	return b.String()
+}
+
+func (e *Eventloop) infoCommandStats() string {
+	var b strings.Builder
+	calls := e.callStats()
+
+	names := make([]string, 0, len(calls.commands))
+	for name, s := range calls.commands {
+		if s.calls > 0 || s.rejected > 0 || s.failed > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := calls.commands[name]
+		perCall := 0.0
+		if s.calls > 0 {
+			perCall = float64(s.usec) / float64(s.calls)
+		}
+		fmt.Fprintf(&b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d\r\n",
+			name, s.calls, s.usec, perCall, s.rejected, s.failed)
+	}
+
+	return b.String()
+}
+
+// infoKeyspace lists the databases that hold keys
+func (e *Eventloop) infoKeyspace() string {
+	var b strings.Builder
+	for i, db := range e.dbs {
+		s := db.Stats()
+		if s.Keys == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "db%d:keys=%d,expires=%d\r\n", i, s.Keys, s.Expires)
+	}
+	return b.String()
+}
+
+// humanBytes formats a number of bytes the way INFO does, as in 1.50M
+func humanBytes(n uint64) string {
+	units := []string{"K", "M", "G", "T", "P"}
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	size := float64(n) / 1024
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.2f%s", size, units[unit])
+}
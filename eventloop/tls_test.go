@@ -0,0 +1,97 @@
+package eventloop
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert creates a certificate for cn signed by parent, or a self-signed
+// CA if parent is nil
+func testCert(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func Test_TLS_Client_Cert_User(t *testing.T) {
+	assert := assert.New(t)
+	ca := testCert(t, "test ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	l := tls.NewListener(listenTest(t), &tls.Config{
+		Certificates: []tls.Certificate{testCert(t, "server", &ca)},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	serveTest(t, l, func(el *Eventloop) {
+		el.SetRequirePass("secret")
+		el.acl.SetUser("alice", []string{"on", "resetpass", "~*", "+@all"})
+		el.EnableTLSClientCertUsers()
+	})
+
+	dial := func(certs ...tls.Certificate) *testConn {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: pool, Certificates: certs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	}
+
+	// the certificate names the user, who needs no password
+	c := dial(testCert(t, "alice", &ca))
+	assert.Equal("alice", c.do("ACL", "WHOAMI").String())
+	assert.Equal("OK", c.do("SET", "foo", "bar").String())
+
+	// certificates of unknown users do not authenticate
+	c = dial(testCert(t, "mallory", &ca))
+	assert.Equal("NOAUTH Authentication required.", c.do("GET", "foo").String())
+
+	// and clients without one use AUTH as usual
+	c = dial()
+	assert.Equal("OK", c.do("AUTH", "secret").String())
+	assert.Equal("bar", c.do("GET", "foo").String())
+}
@@ -0,0 +1,347 @@
+package eventloop
+
+import (
+	"noelzubin/redis-go/protocol"
+	"strconv"
+	"strings"
+)
+
+// invalidateChannel is the channel RESP2 clients receive invalidations on
+const invalidateChannel = "__redis__:invalidate"
+
+// trackingState holds the CLIENT TRACKING settings of a client
+type trackingState struct {
+	enabled bool
+	// ID of the client invalidations are sent to, zero to send them to the
+	// client itself
+	redirect int64
+	// set once the client invalidations are redirected to has gone
+	redirectBroken bool
+	// in BCAST mode the client gets invalidations for every key starting
+	// with one of its prefixes, whether it read the key or not
+	bcast    bool
+	prefixes []string
+	// with OPTIN only the keys of commands following CLIENT CACHING yes are
+	// tracked, with OPTOUT those following CLIENT CACHING no are not
+	optin  bool
+	optout bool
+	// do not send invalidations for keys the client changed itself
+	noloop bool
+	// set with CLIENT CACHING for the next command, "yes", "no" or empty
+	caching string
+}
+
+// redirectID returns the client invalidations are redirected to as CLIENT
+// GETREDIR reports it, -1 when tracking is off
+func (t trackingState) redirectID() int64 {
+	if !t.enabled {
+		return -1
+	}
+	return t.redirect
+}
+
+// trackingTable remembers which clients may have cached which keys. Keys
+// are shared by every database, so a change in one invalidates the key
+// everywhere.
+type trackingTable struct {
+	// IDs of the clients that read each key since it last changed
+	keys map[string]map[int64]bool
+	// IDs of the BCAST clients by prefix
+	prefixes map[string]map[int64]bool
+	// set once the stores report the keys they expire
+	hooked bool
+}
+
+func newTrackingTable() trackingTable {
+	return trackingTable{
+		keys:     make(map[string]map[int64]bool),
+		prefixes: make(map[string]map[int64]bool),
+	}
+}
+
+// clientTracking implements CLIENT TRACKING ON|OFF [REDIRECT id]
+// [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func (e *Eventloop) clientTracking(c *client, args []string) protocol.Value {
+	if len(args) < 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'client|tracking' command")
+	}
+
+	t := trackingState{enabled: true}
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "redirect":
+			if i+1 >= len(args) {
+				return protocol.NewErrorValue("ERR syntax error")
+			}
+			i++
+			id, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return protocol.NewErrorValue("ERR value is not an integer or out of range")
+			}
+			if _, ok := e.clients[id]; !ok {
+				return protocol.NewErrorValue("ERR The client ID you want redirect to does not exist")
+			}
+			t.redirect = id
+		case "prefix":
+			if i+1 >= len(args) {
+				return protocol.NewErrorValue("ERR syntax error")
+			}
+			i++
+			t.prefixes = append(t.prefixes, args[i])
+		case "bcast":
+			t.bcast = true
+		case "optin":
+			t.optin = true
+		case "optout":
+			t.optout = true
+		case "noloop":
+			t.noloop = true
+		default:
+			return protocol.NewErrorValue("ERR syntax error")
+		}
+	}
+
+	switch strings.ToLower(args[2]) {
+	case "on":
+	case "off":
+		e.disableTracking(c)
+		return protocol.NewSimpleStringValue(&OK)
+	default:
+		return protocol.NewErrorValue("ERR syntax error")
+	}
+
+	old := c.tracking
+	switch {
+	case t.bcast && (t.optin || t.optout):
+		return protocol.NewErrorValue("ERR You can't use BCAST mode together with OPTIN or OPTOUT mode.")
+	case t.optin && t.optout:
+		return protocol.NewErrorValue("ERR You can't use both OPTIN and OPTOUT.")
+	case old.enabled && old.bcast != t.bcast:
+		return protocol.NewErrorValue("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+	case old.enabled && (old.optin != t.optin || old.optout != t.optout):
+		return protocol.NewErrorValue("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.")
+	case len(t.prefixes) > 0 && !t.bcast:
+		return protocol.NewErrorValue("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+
+	// prefixes are added to those of a client already in BCAST mode, and
+	// one key must not match two of them
+	if t.bcast {
+		prefixes := append([]string{}, old.prefixes...)
+		for _, p := range t.prefixes {
+			for _, q := range prefixes {
+				if strings.HasPrefix(p, q) || strings.HasPrefix(q, p) {
+					return protocol.NewErrorValue("ERR Prefix '" + p + "' overlaps with an existing prefix '" + q + "'. Prefixes for a single client must not overlap.")
+				}
+			}
+			prefixes = append(prefixes, p)
+		}
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+		t.prefixes = prefixes
+
+		for _, p := range t.prefixes {
+			if e.tracking.prefixes[p] == nil {
+				e.tracking.prefixes[p] = make(map[int64]bool)
+			}
+			e.tracking.prefixes[p][c.id] = true
+		}
+	}
+
+	c.tracking = t
+	e.hookExpiry()
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+// hookExpiry has the stores report the keys they expire, which is only
+// done once a client tracks keys
+func (e *Eventloop) hookExpiry() {
+	if e.tracking.hooked {
+		return
+	}
+	e.tracking.hooked = true
+	// keys expire on the shards as well as in the loop
+	for _, db := range e.dbs {
+		db.OnExpire(func(k string) {
+			e.shared.Lock()
+			defer e.shared.Unlock()
+			e.invalidateKeys(nil, []string{k})
+		})
+	}
+}
+
+// disableTracking turns tracking off for c. Its entries in the table of
+// keys are dropped lazily, when the keys change.
+func (e *Eventloop) disableTracking(c *client) {
+	for _, p := range c.tracking.prefixes {
+		delete(e.tracking.prefixes[p], c.id)
+		if len(e.tracking.prefixes[p]) == 0 {
+			delete(e.tracking.prefixes, p)
+		}
+	}
+	c.tracking = trackingState{}
+}
+
+// clientCaching implements CLIENT CACHING YES|NO
+func clientCaching(c *client, args []string) protocol.Value {
+	if len(args) != 3 {
+		return protocol.NewErrorValue("ERR wrong number of arguments for 'client|caching' command")
+	}
+	if !c.tracking.enabled || (!c.tracking.optin && !c.tracking.optout) {
+		return protocol.NewErrorValue("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+
+	switch strings.ToLower(args[2]) {
+	case "yes":
+		if !c.tracking.optin {
+			return protocol.NewErrorValue("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+		c.tracking.caching = "yes"
+	case "no":
+		if !c.tracking.optout {
+			return protocol.NewErrorValue("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+		c.tracking.caching = "no"
+	default:
+		return protocol.NewErrorValue("ERR syntax error")
+	}
+	return protocol.NewSimpleStringValue(&OK)
+}
+
+// trackingInfo implements CLIENT TRACKINGINFO
+func trackingInfo(c *client) protocol.Value {
+	t := c.tracking
+	flags := []string{"off"}
+	if t.enabled {
+		flags = []string{"on"}
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{
+			{t.bcast, "bcast"},
+			{t.optin, "optin"},
+			{t.optout, "optout"},
+			{t.caching == "yes", "caching-yes"},
+			{t.caching == "no", "caching-no"},
+			{t.noloop, "noloop"},
+			{t.redirectBroken, "broken_redirect"},
+		} {
+			if f.set {
+				flags = append(flags, f.name)
+			}
+		}
+	}
+
+	prefixes := append([]string{}, t.prefixes...)
+
+	return c.mapValue(
+		protocol.NewBulkStringValue("flags"), protocol.NewArrayBulkStringValue(flags),
+		protocol.NewBulkStringValue("redirect"), protocol.NewSimpleIntValue(t.redirectID()),
+		protocol.NewBulkStringValue("prefixes"), protocol.NewArrayBulkStringValue(prefixes),
+	)
+}
+
+// trackKeys remembers that c read the keys of a command. caching is what
+// CLIENT CACHING set for the command.
+func (e *Eventloop) trackKeys(c *client, cmd command, args []string, caching string) {
+	t := c.tracking
+	if !t.enabled || t.bcast || (t.optin && caching != "yes") || (t.optout && caching == "no") {
+		return
+	}
+
+	for _, k := range cmd.keys(args) {
+		if e.tracking.keys[k] == nil {
+			e.tracking.keys[k] = make(map[int64]bool)
+		}
+		e.tracking.keys[k][c.id] = true
+	}
+}
+
+// invalidateWrite sends invalidations for the keys a write changed. c is
+// the client that ran it.
+func (e *Eventloop) invalidateWrite(c *client, cmd command, args []string) {
+	switch cmd.name {
+	case "flushdb", "flushall", "swapdb":
+		e.invalidateAll()
+	default:
+		e.invalidateKeys(c, cmd.keys(args))
+	}
+}
+
+// invalidateKeys tells the clients that read keys, or watch a prefix of
+// them, that the keys changed. writer is the client that changed them, if
+// any.
+func (e *Eventloop) invalidateKeys(writer *client, keys []string) {
+	if len(e.tracking.keys) == 0 && len(e.tracking.prefixes) == 0 {
+		return
+	}
+
+	for _, k := range keys {
+		targets := make(map[int64]bool)
+		for id := range e.tracking.keys[k] {
+			targets[id] = true
+		}
+		delete(e.tracking.keys, k)
+		for p, ids := range e.tracking.prefixes {
+			if strings.HasPrefix(k, p) {
+				for id := range ids {
+					targets[id] = true
+				}
+			}
+		}
+
+		for id := range targets {
+			c, ok := e.clients[id]
+			if !ok || !c.tracking.enabled || (c.tracking.noloop && c == writer) {
+				continue
+			}
+			e.sendInvalidation(c, protocol.NewArrayBulkStringValue([]string{k}))
+		}
+	}
+}
+
+// invalidateAll tells every tracking client to drop its whole cache, as the
+// dataset was flushed
+func (e *Eventloop) invalidateAll() {
+	for _, c := range e.clients {
+		if c.tracking.enabled {
+			e.sendInvalidation(c, protocol.NewNilValue())
+		}
+	}
+	e.tracking.keys = make(map[string]map[int64]bool)
+}
+
+// sendInvalidation sends an invalidation message for the keys cached by c,
+// as a push if the receiving connection speaks RESP3 and on the invalidate
+// channel if it is a RESP2 connection subscribed to it
+func (e *Eventloop) sendInvalidation(c *client, keys protocol.Value) {
+	target := c
+	if c.tracking.redirect != 0 {
+		var ok bool
+		if target, ok = e.clients[c.tracking.redirect]; !ok {
+			if !c.tracking.redirectBroken {
+				c.tracking.redirectBroken = true
+				if c.resp == 3 {
+					e.push(c, protocol.NewPushValue([]protocol.Value{
+						protocol.NewBulkStringValue("tracking-redir-broken"),
+						protocol.NewSimpleIntValue(c.tracking.redirect),
+					}))
+				}
+			}
+			return
+		}
+	}
+
+	if target.resp == 3 {
+		e.push(target, protocol.NewPushValue([]protocol.Value{protocol.NewBulkStringValue("invalidate"), keys}))
+		return
+	}
+	if target.channels[invalidateChannel] {
+		e.push(target, protocol.NewArrayValue([]protocol.Value{
+			protocol.NewBulkStringValue("message"),
+			protocol.NewBulkStringValue(invalidateChannel),
+			keys,
+		}))
+	}
+}
@@ -0,0 +1,122 @@
+package eventloop
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertInvalidate checks that v is a push invalidating keys
+func assertInvalidate(t *testing.T, c *testConn, keys ...string) {
+	t.Helper()
+	v := c.read()
+	assert.True(t, v.IsPush())
+	assert.Equal(t, "invalidate", v.Array()[0].String())
+	assert.Equal(t, keys, stringArray(v.Array()[1].Array()))
+}
+
+func Test_Tracking(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	writer := dialTestServer(t, addr)
+
+	hello := c.do("HELLO", "3")
+	assert.Equal("proto", hello.Array()[4].String())
+	assert.Equal(int64(3), hello.Array()[5].Integer())
+
+	assert.Equal("OK", c.do("CLIENT", "TRACKING", "on").String())
+	writer.do("SET", "foo", "1")
+	assert.Equal("1", c.do("GET", "foo").String())
+
+	// a key is invalidated once, until it is read again
+	writer.do("SET", "foo", "2")
+	assertInvalidate(t, c, "foo")
+	writer.do("SET", "foo", "3")
+	c.do("GET", "foo")
+
+	// the invalidation caused by the client itself follows its reply
+	assert.Equal("OK", c.do("SET", "foo", "4").String())
+	assertInvalidate(t, c, "foo")
+
+	info := c.do("CLIENT", "TRACKINGINFO").Array()
+	assert.Equal([]string{"on"}, stringArray(info[1].Array()))
+	assert.Equal(int64(0), info[3].Integer())
+	assert.Equal(int64(0), c.do("CLIENT", "GETREDIR").Integer())
+
+	// keys that expire are invalidated too
+	writer.do("SET", "soon", "1", "1")
+	c.do("GET", "soon")
+	time.Sleep(1100 * time.Millisecond)
+	writer.do("GET", "soon")
+	assertInvalidate(t, c, "soon")
+
+	c.do("GET", "foo")
+	writer.do("FLUSHALL")
+	v := c.read()
+	assert.True(v.IsPush())
+	assert.Equal("", v.Array()[1].String())
+
+	assert.Equal("OK", c.do("CLIENT", "TRACKING", "off").String())
+	assert.Equal(int64(-1), c.do("CLIENT", "GETREDIR").Integer())
+}
+
+func Test_Tracking_Redirect(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	sub := dialTestServer(t, addr)
+	writer := dialTestServer(t, addr)
+
+	id := sub.do("CLIENT", "ID").Integer()
+	sub.do("SUBSCRIBE", invalidateChannel)
+	assert.Equal("ERR The client ID you want redirect to does not exist", c.do("CLIENT", "TRACKING", "on", "REDIRECT", "12345").String())
+	assert.Equal("OK", c.do("CLIENT", "TRACKING", "on", "REDIRECT", strconv.FormatInt(id, 10), "NOLOOP").String())
+
+	c.do("GET", "foo")
+	c.do("GET", "bar")
+	// changes made by the client itself are left out with NOLOOP
+	c.do("SET", "foo", "1")
+	writer.do("DEL", "foo", "bar")
+
+	msg := sub.read().Array()
+	assert.Equal([]string{"message", invalidateChannel}, stringArray(msg[:2]))
+	assert.Equal([]string{"bar"}, stringArray(msg[2].Array()))
+	assert.Equal(id, c.do("CLIENT", "GETREDIR").Integer())
+}
+
+func Test_Tracking_BcastAndOptin(t *testing.T) {
+	assert := assert.New(t)
+	_, addr := startTestServer(t)
+	c := dialTestServer(t, addr)
+	writer := dialTestServer(t, addr)
+	c.do("HELLO", "3")
+
+	assert.Equal("ERR PREFIX option requires BCAST mode to be enabled", c.do("CLIENT", "TRACKING", "on", "PREFIX", "user:").String())
+	assert.Equal("ERR You can't use BCAST mode together with OPTIN or OPTOUT mode.", c.do("CLIENT", "TRACKING", "on", "BCAST", "OPTIN").String())
+	assert.Equal("OK", c.do("CLIENT", "TRACKING", "on", "BCAST", "PREFIX", "user:", "PREFIX", "session:").String())
+	assert.True(c.do("CLIENT", "TRACKING", "on", "BCAST", "PREFIX", "user:1").IsError())
+
+	// keys are invalidated without being read first
+	writer.do("SET", "other", "1")
+	writer.do("SET", "user:1", "1")
+	assertInvalidate(t, c, "user:1")
+
+	info := c.do("CLIENT", "TRACKINGINFO").Array()
+	assert.Equal([]string{"on", "bcast"}, stringArray(info[1].Array()))
+	assert.Equal([]string{"user:", "session:"}, stringArray(info[5].Array()))
+	assert.Equal("OK", c.do("CLIENT", "TRACKING", "off").String())
+
+	// with OPTIN only reads right after CLIENT CACHING yes are tracked
+	assert.Equal("OK", c.do("CLIENT", "TRACKING", "on", "OPTIN").String())
+	assert.Equal("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.", c.do("CLIENT", "CACHING", "no").String())
+	c.do("GET", "a")
+	c.do("CLIENT", "CACHING", "yes")
+	c.do("GET", "b")
+	c.do("GET", "c")
+	writer.do("DEL", "a", "b", "c")
+	assertInvalidate(t, c, "b")
+	assert.Equal("PONG", c.do("PING").String())
+}
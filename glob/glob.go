@@ -0,0 +1,171 @@
+// Package glob matches strings against the glob-style patterns used by
+// Redis. A '*' matches any sequence of characters and '?' any single
+// character. "[abc]" matches one of the characters in the brackets, "[^abc]"
+// any other character and "[a-z]" a range. A backslash matches the
+// character after it literally.
+//
+// Matching is done on bytes and is case sensitive.
+package glob
+
+import "strings"
+
+// Pattern is a pattern prepared to be matched against many strings, as the
+// keys of a KEYS or SCAN call. Literal patterns and literal prefixes
+// followed by '*' are matched by comparing strings.
+type Pattern struct {
+	pattern string
+	// unescaped literal part, the whole pattern or the part before the
+	// trailing stars
+	literal string
+	kind    patternKind
+}
+
+type patternKind int
+
+const (
+	kindGlob patternKind = iota
+	kindLiteral
+	kindPrefix
+)
+
+// Compile prepares pattern for matching
+func Compile(pattern string) Pattern {
+	var literal strings.Builder
+	for p := 0; p < len(pattern); p++ {
+		switch pattern[p] {
+		case '*':
+			if strings.Trim(pattern[p:], "*") == "" {
+				return Pattern{pattern: pattern, literal: literal.String(), kind: kindPrefix}
+			}
+			return Pattern{pattern: pattern}
+		case '?', '[':
+			return Pattern{pattern: pattern}
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+		}
+		literal.WriteByte(pattern[p])
+	}
+	return Pattern{pattern: pattern, literal: literal.String(), kind: kindLiteral}
+}
+
+// Match reports whether s matches the pattern
+func (p Pattern) Match(s string) bool {
+	switch p.kind {
+	case kindLiteral:
+		return s == p.literal
+	case kindPrefix:
+		return strings.HasPrefix(s, p.literal)
+	}
+	return Match(p.pattern, s)
+}
+
+// Literal returns the string the pattern matches if it has no wildcards
+func (p Pattern) Literal() (string, bool) {
+	return p.literal, p.kind == kindLiteral
+}
+
+// String returns the pattern as it was given
+func (p Pattern) String() string {
+	return p.pattern
+}
+
+// Match reports whether s matches pattern
+func Match(pattern, s string) bool {
+	p, i := 0, 0
+	// position after the last star seen, and where in s it started
+	// matching, for backtracking
+	starP, starI := -1, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starI = p, i
+				continue
+			default:
+				if next, ok := matchOne(pattern, p, s[i]); ok {
+					p = next
+					i++
+					continue
+				}
+			}
+		}
+
+		// mismatch, let the last star swallow one more character
+		if starP == -1 {
+			return false
+		}
+		starI++
+		p, i = starP, starI
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchOne matches c against the single character token at pattern[p],
+// returning the position of the next token
+func matchOne(pattern string, p int, c byte) (int, bool) {
+	switch pattern[p] {
+	case '?':
+		return p + 1, true
+	case '[':
+		return matchClass(pattern, p+1, c)
+	case '\\':
+		if p+1 < len(pattern) {
+			p++
+		}
+	}
+	return p + 1, pattern[p] == c
+}
+
+// matchClass matches c against the class starting at pattern[p], just after
+// the opening bracket. An unterminated class extends to the end of the
+// pattern.
+func matchClass(pattern string, p int, c byte) (int, bool) {
+	negate := false
+	if p < len(pattern) && pattern[p] == '^' {
+		negate = true
+		p++
+	}
+
+	match := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				match = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			start, end := pattern[p], pattern[p+2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				match = true
+			}
+			p += 2
+		default:
+			if pattern[p] == c {
+				match = true
+			}
+		}
+		p++
+	}
+
+	if p < len(pattern) {
+		p++
+	}
+	return p, match != negate
+}
@@ -0,0 +1,78 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Match(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"foo", "foo", true},
+		{"foo", "foobar", false},
+		{"foo*", "foobar", true},
+		{"*bar", "foobar", true},
+		{"f*o*r", "foobar", true},
+		{"f*o*z", "foobar", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`[\]]`, "]", true},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:email", false},
+		{"a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(c.match, Match(c.pattern, c.s), "%q against %q", c.s, c.pattern)
+	}
+}
+
+func Test_Compile(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		pattern string
+		kind    patternKind
+		literal string
+	}{
+		{"foo", kindLiteral, "foo"},
+		{`f\*o`, kindLiteral, "f*o"},
+		{"user:*", kindPrefix, "user:"},
+		{"user:**", kindPrefix, "user:"},
+		{`a\[*`, kindPrefix, "a["},
+		{"*", kindPrefix, ""},
+		{"user:*:name", kindGlob, ""},
+		{"h?llo*", kindGlob, ""},
+		{"[ab]*", kindGlob, ""},
+	}
+	for _, c := range cases {
+		p := Compile(c.pattern)
+		assert.Equal(c.kind, p.kind, c.pattern)
+		assert.Equal(c.literal, p.literal, c.pattern)
+		assert.Equal(c.pattern, p.String())
+	}
+
+	// compiled patterns match like Match
+	for _, pattern := range []string{"foo", `f\*o`, "user:*", "*", "user:*:name", "[ab]*"} {
+		for _, s := range []string{"foo", "f*o", "user:42", "user:42:name", "bar", ""} {
+			assert.Equal(Match(pattern, s), Compile(pattern).Match(s), "%q against %q", s, pattern)
+		}
+	}
+}
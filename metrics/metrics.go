@@ -0,0 +1,184 @@
+// Package metrics writes metrics in the Prometheus text exposition format.
+// It only covers what the server exports: counters, gauges and histograms
+// with fixed buckets, written from values the caller already keeps.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Histogram counts observations in cumulative buckets. It is safe to use
+// from several goroutines.
+type Histogram struct {
+	mu sync.Mutex
+	// upper bounds of the buckets, in increasing order. The +Inf bucket is
+	// implicit.
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with buckets for the given upper bounds
+func NewHistogram(bounds ...float64) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	return &Histogram{bounds: sorted, counts: make([]uint64, len(sorted))}
+}
+
+// Observe adds a value to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// counts are kept per bucket and made cumulative when written
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// Add adds the observations of o, which must have the same buckets, to h
+func (h *Histogram) Add(o *Histogram) {
+	o.mu.Lock()
+	counts, sum, count := append([]uint64(nil), o.counts...), o.sum, o.count
+	o.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, c := range counts {
+		h.counts[i] += c
+	}
+	h.sum += sum
+	h.count += count
+}
+
+// snapshot returns the cumulative bucket counts, the sum and the count
+func (h *Histogram) snapshot() ([]uint64, float64, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative := make([]uint64, len(h.counts))
+	var total uint64
+	for i, c := range h.counts {
+		total += c
+		cumulative[i] = total
+	}
+	return cumulative, h.sum, h.count
+}
+
+// Label is a label of a metric
+type Label struct {
+	Name  string
+	Value string
+}
+
+// L creates a label
+func L(name, value string) Label {
+	return Label{Name: name, Value: value}
+}
+
+// Writer writes metrics to w. The HELP and TYPE lines of a metric are
+// written before its first sample, so all the samples of a metric must be
+// written one after the other.
+type Writer struct {
+	w         io.Writer
+	described map[string]bool
+	err       error
+}
+
+// NewWriter creates a writer of metrics to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, described: make(map[string]bool)}
+}
+
+// Err returns the first error met while writing
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Counter writes a sample of a counter
+func (w *Writer) Counter(name, help string, value float64, labels ...Label) {
+	w.describe(name, help, "counter")
+	w.sample(name, value, labels)
+}
+
+// Gauge writes a sample of a gauge
+func (w *Writer) Gauge(name, help string, value float64, labels ...Label) {
+	w.describe(name, help, "gauge")
+	w.sample(name, value, labels)
+}
+
+// Histogram writes the buckets, sum and count of a histogram
+func (w *Writer) Histogram(name, help string, h *Histogram, labels ...Label) {
+	w.describe(name, help, "histogram")
+
+	counts, sum, count := h.snapshot()
+	for i, bound := range h.bounds {
+		w.sample(name+"_bucket", float64(counts[i]), append(labels, L("le", formatFloat(bound))))
+	}
+	w.sample(name+"_bucket", float64(count), append(labels, L("le", "+Inf")))
+	w.sample(name+"_sum", sum, labels)
+	w.sample(name+"_count", float64(count), labels)
+}
+
+func (w *Writer) describe(name, help, kind string) {
+	if w.described[name] {
+		return
+	}
+	w.described[name] = true
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+func (w *Writer) sample(name string, value float64, labels []Label) {
+	if len(labels) == 0 {
+		w.printf("%s %s\n", name, formatFloat(value))
+		return
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.Name+"=\""+escapeLabel(l.Value)+"\"")
+	}
+	w.printf("%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Writer(t *testing.T) {
+	assert := assert.New(t)
+	var b strings.Builder
+	w := NewWriter(&b)
+
+	w.Counter("commands_total", "Commands run.", 3, L("cmd", "get"))
+	w.Counter("commands_total", "Commands run.", 1, L("cmd", `we"ird\`))
+	w.Gauge("clients", "Connected clients.", 2)
+
+	assert.NoError(w.Err())
+	assert.Equal(`# HELP commands_total Commands run.
+# TYPE commands_total counter
+commands_total{cmd="get"} 3
+commands_total{cmd="we\"ird\\"} 1
+# HELP clients Connected clients.
+# TYPE clients gauge
+clients 2
+`, b.String())
+}
+
+func Test_Histogram(t *testing.T) {
+	assert := assert.New(t)
+	h := NewHistogram(1, 0.1, 0.5)
+	for _, v := range []float64{0.05, 0.1, 0.3, 2} {
+		h.Observe(v)
+	}
+
+	var b strings.Builder
+	w := NewWriter(&b)
+	w.Histogram("latency_seconds", "Latency.", h, L("cmd", "get"))
+
+	assert.Equal(`# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{cmd="get",le="0.1"} 2
+latency_seconds_bucket{cmd="get",le="0.5"} 3
+latency_seconds_bucket{cmd="get",le="1"} 3
+latency_seconds_bucket{cmd="get",le="+Inf"} 4
+latency_seconds_sum{cmd="get"} 2.45
+latency_seconds_count{cmd="get"} 4
+`, b.String())
+}
+
+func Test_Histogram_Add(t *testing.T) {
+	assert := assert.New(t)
+	h, o := NewHistogram(0.1, 1), NewHistogram(0.1, 1)
+	h.Observe(0.05)
+	o.Observe(0.5)
+	o.Observe(2)
+	h.Add(o)
+
+	counts, sum, count := h.snapshot()
+	assert.Equal([]uint64{1, 2}, counts)
+	assert.Equal(2.55, sum)
+	assert.Equal(uint64(3), count)
+	_, _, count = o.snapshot()
+	assert.Equal(uint64(2), count)
+}
@@ -19,6 +19,9 @@ const (
 	Integer      Type = ':'
 	Nil          Type = '_'
 	Error        Type = '-'
+	// RESP3 types
+	Map  Type = '%'
+	Push Type = '>'
 )
 
 // Value represents the data of a valid RESP type.
@@ -29,11 +32,11 @@ type Value struct {
 	intVal int64
 }
 
-// String converts Value to a string.
+// String converts Value to a string. For errors this is the message.
 //
 // If Value cannot be converted, an empty string is returned.
 func (v Value) String() string {
-	if v.typ == BulkString || v.typ == SimpleString {
+	if v.typ == BulkString || v.typ == SimpleString || v.typ == Error {
 		return string(v.bytes)
 	}
 
@@ -55,26 +58,39 @@ func (v Value) Integer() int64 {
 	return 1
 }
 
-// Array converts Value to an array.
+// Array converts Value to an array. The elements of a push are returned as
+// is, and those of a map as alternating keys and values.
 //
 // If Value cannot be converted, an empty array is returned.
 func (v Value) Array() []Value {
-	if v.typ == Array {
+	if v.typ == Array || v.typ == Push || v.typ == Map {
 		return v.array
 	}
 	return []Value{}
 }
 
+// IsPush reports whether Value is an out of band RESP3 push.
+func (v Value) IsPush() bool {
+	return v.typ == Push
+}
+
+// IsError reports whether Value is an error reply.
+func (v Value) IsError() bool {
+	return v.typ == Error
+}
+
 // Output converts Value to a string for output.
 func (v Value) Output() string {
 	s := make([]string, 0)
 
 	if v.typ == Array {
 		for _, v := range v.array {
-			s = append(s, v.String())
+			s = append(s, v.Output())
 		}
 	} else if v.typ == SimpleString || v.typ == BulkString {
 		s = append(s, v.String())
+	} else if v.typ == Error {
+		s = append(s, "(error) "+v.String())
 	} else if v.typ == Integer {
 		s = append(s, strconv.Itoa(int(v.Integer())))
 	} else {
@@ -97,7 +113,11 @@ func DecodeRESP(byteStream *bufio.Reader) (Value, error) {
 	case "$":
 		return decodeBulkString(byteStream)
 	case "*":
-		return decodeArray(byteStream)
+		return decodeArray(byteStream, Array, 1)
+	case ">":
+		return decodeArray(byteStream, Push, 1)
+	case "%":
+		return decodeArray(byteStream, Map, 2)
 	case ":":
 		return decodeInteger(byteStream)
 	case "-":
@@ -139,7 +159,7 @@ func decodeError(byteStream *bufio.Reader) (Value, error) {
 	}
 
 	return Value{
-		typ:   SimpleString,
+		typ:   Error,
 		bytes: readBytes,
 	}, nil
 }
@@ -167,19 +187,25 @@ func decodeBulkString(byteStream *bufio.Reader) (Value, error) {
 		return Value{}, fmt.Errorf("failed to parse bulk string length: %s", err)
 	}
 
-	readBytes := make([]byte, count+2)
+	if count < 0 {
+		return Value{}, fmt.Errorf("invalid bulk string length %d", count)
+	}
 
-	if _, err := io.ReadFull(byteStream, readBytes); err != nil {
+	// the length is not trusted to size the buffer up front
+	var readBytes bytes.Buffer
+	if _, err := io.CopyN(&readBytes, byteStream, int64(count)+2); err != nil {
 		return Value{}, fmt.Errorf("failed to read bulk string contents: %s", err)
 	}
 
 	return Value{
 		typ:   BulkString,
-		bytes: readBytes[:count],
+		bytes: readBytes.Bytes()[:count],
 	}, nil
 }
 
-func decodeArray(byteStream *bufio.Reader) (Value, error) {
+// decodeArray reads the elements of an aggregate type. Maps are counted in
+// pairs, so they hold perElement elements for each one counted.
+func decodeArray(byteStream *bufio.Reader, typ Type, perElement int) (Value, error) {
 	readBytesForCount, err := readUntilCRLF(byteStream)
 	if err != nil {
 		return Value{}, fmt.Errorf("failed to read bulk string length: %s", err)
@@ -192,7 +218,7 @@ func decodeArray(byteStream *bufio.Reader) (Value, error) {
 
 	array := []Value{}
 
-	for i := 1; i <= count; i++ {
+	for i := 1; i <= count*perElement; i++ {
 		value, err := DecodeRESP(byteStream)
 		if err != nil {
 			return Value{}, err
@@ -202,12 +228,140 @@ func decodeArray(byteStream *bufio.Reader) (Value, error) {
 	}
 
 	return Value{
-		typ:   Array,
+		typ:   typ,
 		array: array,
 	}, nil
 
 }
 
+// maxMultiBulkLen is the largest number of arguments a command may have
+const maxMultiBulkLen = 1024 * 1024
+
+// ProtocolError is returned by ReadCommand for a command that is malformed
+// or too large to read. The stream cannot be resynchronised after one, so
+// the connection should be closed.
+type ProtocolError string
+
+func (e ProtocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// ReadCommand reads a command, an array of bulk strings, appending its
+// arguments to args. The arguments are read straight from the buffer of r
+// rather than as Values. Other messages are decoded with DecodeRESP and
+// the strings of their elements appended.
+//
+// Bulk strings longer than maxBulkLen and arrays with more elements than
+// maxMultiBulkLen are rejected with a ProtocolError before any of them is
+// read.
+func ReadCommand(r *bufio.Reader, args []string, maxBulkLen int) ([]string, error) {
+	if b, err := r.Peek(1); err != nil {
+		return args, err
+	} else if b[0] != '*' {
+		if !isLine(b[0]) {
+			return args, ProtocolError(fmt.Sprintf("expected '*', got '%c'", b[0]))
+		}
+		value, err := DecodeRESP(r)
+		if err != nil {
+			return args, err
+		}
+		for _, element := range value.Array() {
+			args = append(args, element.String())
+		}
+		return args, nil
+	}
+
+	r.ReadByte()
+	count, err := readLength(r)
+	if err != nil {
+		return args, ProtocolError("invalid multibulk length")
+	} else if count > maxMultiBulkLen {
+		return args, ProtocolError("invalid multibulk length")
+	}
+
+	for i := 0; i < count; i++ {
+		if b, err := r.Peek(1); err != nil {
+			return args, err
+		} else if b[0] != '$' {
+			if !isLine(b[0]) {
+				return args, ProtocolError(fmt.Sprintf("expected '$', got '%c'", b[0]))
+			}
+			value, err := DecodeRESP(r)
+			if err != nil {
+				return args, err
+			}
+			args = append(args, value.String())
+			continue
+		}
+
+		r.ReadByte()
+		n, err := readLength(r)
+		if err != nil || n < 0 || n > maxBulkLen {
+			return args, ProtocolError("invalid bulk length")
+		}
+		arg, err := readBulk(r, n)
+		if err != nil {
+			return args, fmt.Errorf("failed to read bulk string contents: %s", err)
+		}
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+// isLine reports whether typ is a type held on a single line, which
+// ReadCommand decodes with DecodeRESP as it cannot be of any size
+func isLine(typ byte) bool {
+	switch Type(typ) {
+	case SimpleString, Integer, Nil, Error:
+		return true
+	}
+	return false
+}
+
+// readLength reads the length that follows the type of an array or bulk
+// string, up to the CRLF
+func readLength(r *bufio.Reader) (int, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return 0, fmt.Errorf("missing CRLF after %q", line)
+	}
+	return strconv.Atoi(string(line[:len(line)-2]))
+}
+
+// readBulk reads the n bytes of a bulk string and the CRLF after them. A
+// string that fits in the buffer of r is copied out of it once.
+func readBulk(r *bufio.Reader, n int) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("invalid length %d", n)
+	}
+
+	if n+2 <= r.Size() {
+		b, err := r.Peek(n + 2)
+		if err != nil {
+			return "", err
+		}
+		s := string(b[:n])
+		r.Discard(n + 2)
+		return s, nil
+	}
+
+	// the buffer grows as the string arrives rather than being sized by
+	// the length a client claims up front
+	var b strings.Builder
+	b.Grow(r.Size())
+	if _, err := io.CopyN(&b, r, int64(n)); err != nil {
+		return "", err
+	}
+	if _, err := r.Discard(2); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
 func readUntilCRLF(byteStream *bufio.Reader) ([]byte, error) {
 	readBytes := []byte{}
 
@@ -228,27 +382,43 @@ func readUntilCRLF(byteStream *bufio.Reader) ([]byte, error) {
 
 // Encode encodes a value into a RESP Message
 func (v *Value) Encode() []byte {
-	buf := new(bytes.Buffer)
+	return v.AppendEncode(nil)
+}
+
+// AppendEncode appends the RESP message of a value to dst and returns the
+// extended buffer
+func (v *Value) AppendEncode(dst []byte) []byte {
 	switch v.typ {
 	case BulkString:
-	case SimpleString:
-		buf.Write([]byte("+" + string(v.bytes) + "\r\n"))
-	case Array:
-		buf.Write([]byte("*"))
-		buf.Write([]byte(strconv.Itoa(len(v.array))))
-		buf.Write([]byte("\r\n"))
-		for _, v := range v.array {
-			buf.Write(v.Encode())
+		dst = append(dst, '$')
+		dst = strconv.AppendInt(dst, int64(len(v.bytes)), 10)
+		dst = append(dst, "\r\n"...)
+		dst = append(dst, v.bytes...)
+		dst = append(dst, "\r\n"...)
+	case SimpleString, Error:
+		dst = append(dst, byte(v.typ))
+		dst = append(dst, v.bytes...)
+		dst = append(dst, "\r\n"...)
+	case Array, Push, Map:
+		n := len(v.array)
+		if v.typ == Map {
+			n /= 2
+		}
+		dst = append(dst, byte(v.typ))
+		dst = strconv.AppendInt(dst, int64(n), 10)
+		dst = append(dst, "\r\n"...)
+		for i := range v.array {
+			dst = v.array[i].AppendEncode(dst)
 		}
 	case Integer:
-		buf.Write([]byte(":" + strconv.Itoa(int(v.intVal)) + "\r\n"))
-	case Error:
-		buf.Write([]byte("-" + string(v.bytes) + "\r\n"))
+		dst = append(dst, ':')
+		dst = strconv.AppendInt(dst, v.intVal, 10)
+		dst = append(dst, "\r\n"...)
 	case Nil:
-		buf.Write([]byte("_\r\n"))
+		dst = append(dst, "_\r\n"...)
 	}
 
-	return buf.Bytes()
+	return dst
 }
 
 // NewErrorValue creates a new Error Value
@@ -267,6 +437,14 @@ func NewSimpleStringValue(str *string) Value {
 	}
 }
 
+// NewBulkStringValue creates a new binary safe String Value
+func NewBulkStringValue(str string) Value {
+	return Value{
+		typ:   '$',
+		bytes: []byte(str),
+	}
+}
+
 // NewSimpleIntValue creates a new Integer Value
 func NewSimpleIntValue(val int64) Value {
 	return Value{
@@ -295,3 +473,42 @@ func NewArrayStringValue(arr []string) Value {
 		array: vals,
 	}
 }
+
+// NewArrayBulkStringValue creates a new Array Value of binary safe strings
+func NewArrayBulkStringValue(arr []string) Value {
+	vals := make([]Value, 0, len(arr))
+
+	for _, a := range arr {
+		vals = append(vals, NewBulkStringValue(a))
+	}
+
+	return Value{
+		typ:   '*',
+		array: vals,
+	}
+}
+
+// NewArrayValue creates a new Array Value holding vals
+func NewArrayValue(vals []Value) Value {
+	return Value{
+		typ:   '*',
+		array: vals,
+	}
+}
+
+// NewPushValue creates a new RESP3 Push Value holding vals
+func NewPushValue(vals []Value) Value {
+	return Value{
+		typ:   '>',
+		array: vals,
+	}
+}
+
+// NewMapValue creates a new RESP3 Map Value from alternating keys and
+// values
+func NewMapValue(vals []Value) Value {
+	return Value{
+		typ:   '%',
+		array: vals,
+	}
+}
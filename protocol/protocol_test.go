@@ -3,6 +3,8 @@ package protocol
 import (
 	"bufio"
 	"bytes"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -88,3 +90,120 @@ func TestEncodeArrayOfStrings(t *testing.T) {
 
 	assert.Equal(value.Encode(), []byte("*2\r\n+GET\r\n+THIS\r\n"))
 }
+
+func TestEncodeBulkString(t *testing.T) {
+	assert := assert.New(t)
+
+	value := NewBulkStringValue("a\r\nb")
+
+	assert.Equal(value.Encode(), []byte("$4\r\na\r\nb\r\n"))
+}
+
+func TestEncodeArrayOfBulkStrings(t *testing.T) {
+	assert := assert.New(t)
+
+	value := NewArrayBulkStringValue([]string{"SET", "foo", ""})
+
+	assert.Equal(value.Encode(), []byte("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$0\r\n\r\n"))
+}
+
+func TestDecodeError(t *testing.T) {
+	assert := assert.New(t)
+
+	value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString("-ERR bad\r\n")))
+
+	assert.Nil(err)
+	assert.True(value.IsError())
+	assert.Equal("ERR bad", value.String())
+	assert.Equal("(error) ERR bad", value.Output())
+}
+
+func TestEncodePush(t *testing.T) {
+	assert := assert.New(t)
+
+	value := NewPushValue([]Value{NewBulkStringValue("invalidate"), NewArrayBulkStringValue([]string{"foo"})})
+
+	assert.Equal(value.Encode(), []byte(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"))
+}
+
+func TestDecodePushAndMap(t *testing.T) {
+	assert := assert.New(t)
+
+	value, err := DecodeRESP(bufio.NewReader(bytes.NewBufferString(">2\r\n+message\r\n:1\r\n")))
+	assert.Nil(err)
+	assert.True(value.IsPush())
+	assert.Equal(value.Array()[0].String(), "message")
+
+	value, err = DecodeRESP(bufio.NewReader(bytes.NewBufferString("%1\r\n+proto\r\n:3\r\n")))
+	assert.Nil(err)
+	assert.Equal(value.typ, Map)
+	assert.Len(value.Array(), 2)
+	assert.Equal(value.Array()[1].Integer(), int64(3))
+}
+
+func TestEncodeMap(t *testing.T) {
+	assert := assert.New(t)
+
+	value := NewMapValue([]Value{NewBulkStringValue("proto"), NewSimpleIntValue(3)})
+
+	assert.Equal(value.Encode(), []byte("%1\r\n$5\r\nproto\r\n:3\r\n"))
+}
+
+func TestReadCommand(t *testing.T) {
+	assert := assert.New(t)
+	long := strings.Repeat("x", 5000)
+	r := bufio.NewReader(bytes.NewBufferString("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$5000\r\n" + long + "\r\n*1\r\n+PING\r\n"))
+
+	args, err := ReadCommand(r, nil, 1<<20)
+	assert.Nil(err)
+	assert.Equal([]string{"SET", "foo", long}, args)
+
+	// the buffer is reused
+	args, err = ReadCommand(r, args[:0], 1<<20)
+	assert.Nil(err)
+	assert.Equal([]string{"PING"}, args)
+
+	_, err = ReadCommand(r, nil, 1<<20)
+	assert.Equal(io.EOF, err)
+
+	_, err = ReadCommand(bufio.NewReader(bytes.NewBufferString("*1\r\n$x\r\n")), nil, 1<<20)
+	assert.Equal(ProtocolError("invalid bulk length"), err)
+}
+
+func TestReadCommand_Limits(t *testing.T) {
+	assert := assert.New(t)
+	read := func(s string, maxBulkLen int) error {
+		_, err := ReadCommand(bufio.NewReader(bytes.NewBufferString(s)), nil, maxBulkLen)
+		return err
+	}
+
+	// lengths are checked before anything is allocated for them
+	assert.Equal(ProtocolError("invalid bulk length"), read("*1\r\n$9999999999\r\n", 1<<20))
+	assert.Equal(ProtocolError("invalid bulk length"), read("*1\r\n$-5\r\n", 1<<20))
+	assert.Equal(ProtocolError("invalid bulk length"), read("*1\r\n$6\r\nfoobar\r\n", 5))
+	assert.Nil(read("*1\r\n$5\r\nfooba\r\n", 5))
+	assert.Equal(ProtocolError("invalid multibulk length"), read("*9999999999\r\n", 1<<20))
+	assert.Equal("Protocol error: invalid multibulk length", read("*x\r\n", 1<<20).Error())
+
+	// nested aggregates are not arguments
+	assert.Equal(ProtocolError("expected '$', got '*'"), read("*1\r\n*1\r\n$1\r\na\r\n", 1<<20))
+	assert.Equal(ProtocolError("expected '*', got '$'"), read("$9999999999\r\n", 1<<20))
+
+	// a string longer than the buffer is read in full
+	long := strings.Repeat("x", 100000)
+	args, err := ReadCommand(bufio.NewReader(bytes.NewBufferString("*1\r\n$100000\r\n"+long+"\r\n")), nil, 1<<20)
+	assert.Nil(err)
+	assert.Equal([]string{long}, args)
+	_, err = ReadCommand(bufio.NewReader(bytes.NewBufferString("*1\r\n$100000\r\nshort\r\n")), nil, 1<<20)
+	assert.EqualError(err, "failed to read bulk string contents: EOF")
+}
+
+func TestAppendEncode(t *testing.T) {
+	assert := assert.New(t)
+	v := NewArrayValue([]Value{NewBulkStringValue("a"), NewSimpleIntValue(-3), NewNilValue(), NewErrorValue("ERR x")})
+
+	assert.Equal("*4\r\n$1\r\na\r\n:-3\r\n_\r\n-ERR x\r\n", string(v.Encode()))
+	assert.Equal("+OK\r\n*4\r\n$1\r\na\r\n:-3\r\n_\r\n-ERR x\r\n", string(v.AppendEncode([]byte("+OK\r\n"))))
+	m := NewMapValue([]Value{NewBulkStringValue("k"), NewBulkStringValue("v")})
+	assert.Equal("%1\r\n$1\r\nk\r\n$1\r\nv\r\n", string(m.Encode()))
+}
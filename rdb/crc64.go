@@ -0,0 +1,30 @@
+package rdb
+
+// crc64Table is the lookup table for the reflected Jones polynomial
+// (0xad93d23594c935a9) used by Redis
+var crc64Table = makeCRC64Table(0x95ac9329ac4bc9b5)
+
+func makeCRC64Table(poly uint64) *[256]uint64 {
+	t := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// CRC64 updates crc with p. Unlike hash/crc64 there is no inversion before
+// or after, matching the checksum Redis puts in DUMP payloads.
+func CRC64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -0,0 +1,269 @@
+// Package rdb serializes single values in the format used by the DUMP and
+// RESTORE commands: the value in RDB encoding, followed by a two byte RDB
+// version and a CRC64 checksum of everything before it.
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"noelzubin/redis-go/store"
+	"strconv"
+)
+
+// Version is the RDB version written in the footer of dumped values. It is
+// old enough for any recent server to accept.
+const Version = 9
+
+// maxVersion is the newest RDB version accepted on restore
+const maxVersion = 12
+
+// RDB object types
+const (
+	typeString = 0
+	typeZSet   = 3
+	typeZSet2  = 5
+)
+
+// special length encodings used for integers stored as strings
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+)
+
+// ErrBadPayload is returned when a payload is truncated, has the wrong
+// checksum or an unsupported version
+var ErrBadPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// ErrBadFormat is returned when a payload passes the checks but can not be
+// decoded
+var ErrBadFormat = errors.New("Bad data format")
+
+// Dump serializes a value without its expiry
+func Dump(v store.Value) []byte {
+	var buf bytes.Buffer
+
+	switch v.Type() {
+	case "string":
+		s, _ := v.Str()
+		buf.WriteByte(typeString)
+		writeString(&buf, s)
+	case "zset":
+		members := v.ScoreMembers()
+		buf.WriteByte(typeZSet2)
+		writeLength(&buf, uint64(len(members)))
+		for _, m := range members {
+			writeString(&buf, m.Member())
+			binary.Write(&buf, binary.LittleEndian, math.Float64bits(float64(m.Score())))
+		}
+	}
+
+	binary.Write(&buf, binary.LittleEndian, uint16(Version))
+	binary.Write(&buf, binary.LittleEndian, CRC64(0, buf.Bytes()))
+
+	return buf.Bytes()
+}
+
+// Restore parses a payload created by Dump
+func Restore(payload []byte) (store.Value, error) {
+	if len(payload) < 10 {
+		return store.Value{}, ErrBadPayload
+	}
+
+	footer := payload[len(payload)-10:]
+	version := binary.LittleEndian.Uint16(footer[:2])
+	checksum := binary.LittleEndian.Uint64(footer[2:])
+
+	if version > maxVersion || CRC64(0, payload[:len(payload)-8]) != checksum {
+		return store.Value{}, ErrBadPayload
+	}
+
+	r := bytes.NewReader(payload[:len(payload)-10])
+	typ, err := r.ReadByte()
+	if err != nil {
+		return store.Value{}, ErrBadFormat
+	}
+
+	var v store.Value
+	switch typ {
+	case typeString:
+		s, err := readString(r)
+		if err != nil {
+			return store.Value{}, ErrBadFormat
+		}
+		v = store.NewStringValue(s)
+	case typeZSet, typeZSet2:
+		members, err := readZSet(r, typ)
+		if errors.Is(err, ErrBadPayload) {
+			return store.Value{}, err
+		}
+		if err != nil {
+			return store.Value{}, ErrBadFormat
+		}
+		v = store.NewZSetValue(members)
+	default:
+		return store.Value{}, ErrBadFormat
+	}
+
+	if r.Len() != 0 {
+		return store.Value{}, ErrBadFormat
+	}
+
+	return v, nil
+}
+
+func readZSet(r *bytes.Reader, typ byte) ([]store.ScoreMember, error) {
+	n, _, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// every member takes at least a byte for its length and one for its
+	// score, so a count beyond what is left is made up
+	if n > uint64(r.Len()) {
+		return nil, ErrBadPayload
+	}
+
+	members := make([]store.ScoreMember, 0, n)
+	for i := uint64(0); i < n; i++ {
+		member, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		var score float64
+		if typ == typeZSet2 {
+			var bits uint64
+			if err := binary.Read(r, binary.LittleEndian, &bits); err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(bits)
+		} else {
+			score, err = readStringScore(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		members = append(members, store.NewScoreMember(int64(score), member))
+	}
+
+	return members, nil
+}
+
+// readStringScore reads a score stored as a length prefixed decimal string,
+// as used by the old ZSET type
+func readStringScore(r *bytes.Reader) (float64, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// writeLength writes n using the RDB variable length encoding
+func writeLength(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n < 1<<6:
+		buf.WriteByte(byte(n))
+	case n < 1<<14:
+		buf.WriteByte(byte(n>>8) | 0x40)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(0x80)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(0x81)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// readLength reads a length. If encoded is set the value is not a length
+// but one of the special string encodings.
+func readLength(r *bytes.Reader) (n uint64, encoded bool, err error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			var n32 uint32
+			err := binary.Read(r, binary.BigEndian, &n32)
+			return uint64(n32), false, err
+		case 0x81:
+			err := binary.Read(r, binary.BigEndian, &n)
+			return n, false, err
+		}
+		return 0, false, ErrBadFormat
+	}
+
+	return uint64(b & 0x3f), true, nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeLength(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, encoded, err := readLength(r)
+	if err != nil {
+		return "", err
+	}
+
+	if encoded {
+		switch n {
+		case encInt8:
+			var i int8
+			err := binary.Read(r, binary.LittleEndian, &i)
+			return strconv.Itoa(int(i)), err
+		case encInt16:
+			var i int16
+			err := binary.Read(r, binary.LittleEndian, &i)
+			return strconv.Itoa(int(i)), err
+		case encInt32:
+			var i int32
+			err := binary.Read(r, binary.LittleEndian, &i)
+			return strconv.Itoa(int(i)), err
+		}
+		return "", ErrBadFormat
+	}
+
+	if n > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
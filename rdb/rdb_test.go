@@ -0,0 +1,81 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"noelzubin/redis-go/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CRC64(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(uint64(0xe9c6d914c4b8d9ca), CRC64(0, []byte("123456789")))
+}
+
+func Test_Restore_Redis_Payload(t *testing.T) {
+	assert := assert.New(t)
+
+	// DUMP of the integer 10, as documented for Redis
+	v, err := Restore([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))
+	assert.Nil(err)
+
+	s, ok := v.Str()
+	assert.True(ok)
+	assert.Equal("10", s)
+}
+
+func Test_Dump_Restore_String(t *testing.T) {
+	assert := assert.New(t)
+
+	long := string(make([]byte, 20000))
+	for _, s := range []string{"", "bar", long} {
+		v, err := Restore(Dump(store.NewStringValue(s)))
+		assert.Nil(err)
+
+		restored, _ := v.Str()
+		assert.Equal(s, restored)
+	}
+}
+
+func Test_Dump_Restore_ZSet(t *testing.T) {
+	assert := assert.New(t)
+	members := []store.ScoreMember{store.NewScoreMember(-5, "neg"), store.NewScoreMember(1, "one"), store.NewScoreMember(2, "two")}
+
+	v, err := Restore(Dump(store.NewZSetValue(members)))
+	assert.Nil(err)
+	assert.Equal("zset", v.Type())
+	assert.Equal(members, v.ScoreMembers())
+}
+
+func Test_Restore_Bad_Checksum(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := Dump(store.NewStringValue("bar"))
+	payload[1] = 'x'
+
+	_, err := Restore(payload)
+	assert.Equal(ErrBadPayload, err)
+
+	_, err = Restore([]byte("short"))
+	assert.Equal(ErrBadPayload, err)
+}
+
+// withFooter adds the version and checksum to a serialized value
+func withFooter(value []byte) []byte {
+	payload := binary.LittleEndian.AppendUint16(value, Version)
+	return binary.LittleEndian.AppendUint64(payload, CRC64(0, payload))
+}
+
+func Test_Restore_Bad_Length(t *testing.T) {
+	assert := assert.New(t)
+
+	// a sorted set claiming more members than the payload could hold
+	huge := []byte{typeZSet2, 0x81, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	_, err := Restore(withFooter(huge))
+	assert.Equal(ErrBadPayload, err)
+
+	_, err = Restore(withFooter([]byte{typeZSet2, 0x05, 0x01, 'a'}))
+	assert.Equal(ErrBadPayload, err)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenTCP listens on port at every address in bind. Addresses prefixed
+// with '-' are optional and skipped if they are not available on this
+// host. A TLS config turns the listeners into TLS ones.
+func listenTCP(bind []string, port int, config *tls.Config) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(bind))
+
+	for _, addr := range bind {
+		optional := strings.HasPrefix(addr, "-")
+		addr = strings.TrimPrefix(addr, "-")
+		if addr == "*" {
+			addr = "0.0.0.0"
+		}
+
+		l, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+		if err != nil {
+			if optional && (errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EAFNOSUPPORT)) {
+				fmt.Printf("skipping unavailable address %s\n", addr)
+				continue
+			}
+			closeAll(listeners)
+			return nil, err
+		}
+
+		if config != nil {
+			l = tls.NewListener(l, config)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("none of the bind addresses are available")
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a Unix socket at path, replacing a stale socket
+// left by a previous run. A non zero perm sets the permissions of the
+// socket file.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
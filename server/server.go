@@ -1,40 +1,339 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"noelzubin/redis-go/aof"
+	"noelzubin/redis-go/cluster"
+	"noelzubin/redis-go/config"
 	"noelzubin/redis-go/eventloop"
 	"noelzubin/redis-go/set"
 	"noelzubin/redis-go/store"
+	"noelzubin/redis-go/tlsconfig"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// server params, set from the config file and the command line
+var (
+	// addresses to listen on, separated by spaces. '*' is every IPv4
+	// address, a '-' prefix makes an address optional
+	bind = "*"
+	// port to listen on, 0 to not accept plain TCP connections
+	port           = 6379
+	unixSocket     = ""
+	unixSocketPerm = "0"
+	// port to accept TLS connections on, 0 to disable TLS
+	tlsPort        = 0
+	tlsCertFile    = ""
+	tlsKeyFile     = ""
+	tlsCACertFile  = ""
+	tlsAuthClients = "yes"
+	// CN authenticates TLS clients as the user named by the common name of
+	// their certificate
+	tlsAuthUser    = "off"
+	databases      = 16
+	replicaOf      = ""
+	appendOnly     = false
+	appendFilename = "appendonly.aof"
+	appendFsync    = "everysec"
+	clusterEnabled = false
+	clusterConfig  = "nodes.conf"
+	aclFile        = ""
+	// port of the HTTP listener serving Prometheus metrics on /metrics, 0
+	// to disable it
+	metricsPort = 0
+	// how the keys with an expiry of each database are indexed, sampled at
+	// random or kept in a timing wheel by deadline
+	expireStrategy = "sample"
+	// goroutines running the commands that only touch their keys next to
+	// the main loop, each owning a partition of the keyspace. 1 runs every
+	// command in the main loop.
+	shards = 1
+)
+
+const usage = "Usage: server [/path/to/redis.conf] [--option value ...]"
+
 func main() {
-	expiredSet := set.InitStringSet()
-	store := store.InitStore(expiredSet)
-	el := eventloop.InitEventloop(store)
+	// every database tracks its own keys with an expiry
+	newStore := func() store.Store {
+		if expireStrategy == "wheel" {
+			return store.InitStore(set.NewTimingWheel(time.Now()))
+		}
+		return store.InitStore(set.InitStringSet())
+	}
+	// with shards, every database has a partition for each of them
+	newDatabases := func(n int) []store.Store {
+		dbs := make([]store.Store, n)
+		for i := range dbs {
+			if shards < 2 {
+				dbs[i] = newStore()
+				continue
+			}
+			parts := make([]store.Store, shards)
+			for j := range parts {
+				parts[j] = newStore()
+			}
+			dbs[i] = store.NewSharded(parts...)
+		}
+		return dbs
+	}
+	el := eventloop.InitEventloop(newDatabases(databases)...)
+
+	cfg := config.New()
+	cfg.Register(
+		config.String("bind", &bind).Multi(),
+		config.Int("port", &port, 0, 65535),
+		config.String("unixsocket", &unixSocket),
+		config.String("unixsocketperm", &unixSocketPerm),
+		config.Int("tls-port", &tlsPort, 0, 65535),
+		config.String("tls-cert-file", &tlsCertFile),
+		config.String("tls-key-file", &tlsKeyFile),
+		config.String("tls-ca-cert-file", &tlsCACertFile),
+		config.Enum("tls-auth-clients", &tlsAuthClients, "yes", "optional", "no"),
+		config.Enum("tls-auth-clients-user", &tlsAuthUser, "CN", "off"),
+		config.NewParam("databases", func() string {
+			return strconv.Itoa(databases)
+		}, func(value string) error {
+			n, err := config.ParseInt(value, 1, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			databases = n
+			el.SetDatabases(newDatabases(n)...)
+			return nil
+		}),
+		config.NewParam("active-expire-strategy", func() string {
+			return expireStrategy
+		}, func(value string) error {
+			switch strings.ToLower(value) {
+			case "sample", "wheel":
+				expireStrategy = strings.ToLower(value)
+			default:
+				return errors.New("argument(s) must be one of the following: sample, wheel")
+			}
+			el.SetDatabases(newDatabases(databases)...)
+			return nil
+		}),
+		config.NewParam("event-loop-shards", func() string {
+			return strconv.Itoa(shards)
+		}, func(value string) error {
+			n, err := config.ParseInt(value, 1, 1024)
+			if err != nil {
+				return err
+			}
+			shards = n
+			el.SetDatabases(newDatabases(databases)...)
+			el.EnableShards(n)
+			return nil
+		}),
+		config.String("replicaof", &replicaOf).Multi(),
+		config.Bool("appendonly", &appendOnly),
+		config.String("appendfilename", &appendFilename),
+		config.Enum("appendfsync", &appendFsync, "always", "everysec", "no"),
+		config.Bool("cluster-enabled", &clusterEnabled),
+		config.String("cluster-config-file", &clusterConfig),
+		config.String("aclfile", &aclFile),
+		config.Int("metrics-port", &metricsPort, 0, 65535),
+	)
+	el.RegisterConfig(cfg)
+
+	if err := cfg.Load(os.Args[1:]); err != nil {
+		fmt.Println(err.Error())
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+
+	if aclFile != "" {
+		if err := el.EnableACLFile(aclFile); err != nil {
+			fmt.Println("Failed to load the ACL file: ", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if appendOnly {
+		policy, err := aof.ParseFsyncPolicy(appendFsync)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		if err := el.EnableAOF(appendFilename, policy); err != nil {
+			fmt.Println("Failed to load the append only file: ", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	el.SetPort(port)
+
+	if clusterEnabled {
+		c, err := cluster.Load(clusterConfig, port)
+		if err != nil {
+			fmt.Println("Failed to load the cluster config: ", err.Error())
+			os.Exit(1)
+		}
+		el.EnableCluster(c)
+	}
+
+	if replicaOf != "" {
+		fields := strings.Fields(replicaOf)
+		if len(fields) != 2 {
+			fmt.Println("replicaof must be \"<host> <port>\"")
+			os.Exit(1)
+		}
+
+		masterPort, err := strconv.Atoi(fields[1])
+		if err != nil {
+			fmt.Println("Invalid master port: ", fields[1])
+			os.Exit(1)
+		}
+
+		el.ReplicaOf(fields[0], masterPort)
+	}
 
 	// Main Event loop
 	el.Start()
 
-	l, err := net.Listen("tcp", "0.0.0.0:6379")
-	if err != nil {
-		fmt.Println("Failed to bind to port 6379")
-		os.Exit(1)
+	listeners := make([]net.Listener, 0)
+	bindAddrs := strings.Fields(bind)
+
+	if port != 0 {
+		ls, err := listenTCP(bindAddrs, port, nil)
+		if err != nil {
+			fmt.Printf("Failed to bind to port %d: %s\n", port, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("running server on port %d\n", port)
+		listeners = append(listeners, ls...)
 	}
 
-	fmt.Println("running server on port 6379")
+	if tlsPort != 0 {
+		auth, err := tlsconfig.ParseClientAuth(tlsAuthClients)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 
-	for {
-		conn, err := l.Accept()
-		fmt.Println("accepted connection ")
+		tlsConf, err := tlsconfig.Server(tlsCertFile, tlsKeyFile, tlsCACertFile, auth)
+		if err != nil {
+			fmt.Println("Failed to configure TLS: ", err.Error())
+			os.Exit(1)
+		}
 
+		if strings.EqualFold(tlsAuthUser, "CN") {
+			el.EnableTLSClientCertUsers()
+		}
+
+		ls, err := listenTCP(bindAddrs, tlsPort, tlsConf)
+		if err != nil {
+			fmt.Printf("Failed to bind to port %d: %s\n", tlsPort, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("accepting TLS connections on port %d\n", tlsPort)
+		listeners = append(listeners, ls...)
+	}
+
+	if unixSocket != "" {
+		perm, err := strconv.ParseUint(unixSocketPerm, 8, 32)
 		if err != nil {
-			fmt.Println("Error accepting connection: ", err.Error())
+			fmt.Println("Invalid unixsocketperm: ", unixSocketPerm)
 			os.Exit(1)
 		}
 
-		fmt.Println("accepted connection ")
-		go el.HandleConnection(conn)
+		l, err := listenUnix(unixSocket, os.FileMode(perm))
+		if err != nil {
+			fmt.Printf("Failed to listen on %s: %s\n", unixSocket, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("accepting connections on %s\n", unixSocket)
+		listeners = append(listeners, l)
+	}
+
+	if metricsPort != 0 {
+		ls, err := listenTCP(bindAddrs, metricsPort, nil)
+		if err != nil {
+			fmt.Printf("Failed to bind to port %d: %s\n", metricsPort, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("serving metrics on port %d\n", metricsPort)
+		for _, l := range ls {
+			go func(l net.Listener) {
+				if err := el.ServeMetrics(l); err != nil {
+					fmt.Println("Error serving metrics: ", err.Error())
+				}
+			}(l)
+		}
+	}
+
+	if len(listeners) == 0 {
+		fmt.Println("No port or unix socket to listen on")
+		os.Exit(1)
+	}
+
+	// Serve returns nil once the server has shut down
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- el.Serve(l)
+		}(l)
+	}
+	waitForShutdown(el, errs)
+}
+
+// waitForShutdown returns once the server has shut down, after SHUTDOWN,
+// SIGTERM or SIGINT, or a listener failing. A second signal while the
+// server waits for its replicas exits right away.
+func waitForShutdown(el *eventloop.Eventloop, errs <-chan error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	done := el.Done()
+	stopping := false
+	stopped := make(chan error, 1)
+	stop := func() {
+		stopping = true
+		go func() {
+			stopped <- el.Stop(context.Background())
+		}()
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if stopping {
+				fmt.Printf("Received %s while shutting down, exiting now\n", sig)
+				os.Exit(1)
+			}
+			fmt.Printf("Received %s, shutting down\n", sig)
+			stop()
+		case err := <-errs:
+			if err == nil {
+				continue
+			}
+			fmt.Println("Error accepting connections: ", err.Error())
+			if !stopping {
+				stop()
+			}
+		case err := <-stopped:
+			stopping = false
+			if err != nil {
+				fmt.Println("Failed to shut down, still running: ", err.Error())
+				continue
+			}
+			return
+		case <-done:
+			// SHUTDOWN was called, Stop waits for the connections to close
+			done = nil
+			if !stopping {
+				stop()
+			}
+		}
 	}
 }
@@ -14,6 +14,25 @@ func (_m *IStringSet) Add(v string) {
 	_m.Called(v)
 }
 
+// Clear provides a mock function with given fields:
+func (_m *IStringSet) Clear() {
+	_m.Called()
+}
+
+// Len provides a mock function with given fields:
+func (_m *IStringSet) Len() int {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
 // RandomN provides a mock function with given fields: n
 func (_m *IStringSet) RandomN(n int) []string {
 	ret := _m.Called(n)
@@ -0,0 +1,241 @@
+package set
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+const (
+	// minBuckets is the smallest size of the table of a ScanSet
+	minBuckets = 4
+	// buckets moved to the new table by every Add, Remove and Contains
+	// while the set is resized
+	rehashStep = 1
+)
+
+// ScanSet is a set of strings that can be iterated a few at a time with a
+// cursor, like a Redis dict. Strings are kept in a table of buckets whose
+// size is a power of two, doubled as the set grows and halved as it
+// shrinks.
+//
+// As in a Redis dict, resizing is incremental: the set keeps both tables
+// and moves a bucket to the new one on every operation, or more of them
+// with Rehash, so no single call pays for moving every string.
+//
+// Scan visits buckets in reverse binary order of their index, so that
+// every string present for the whole iteration is returned at least once
+// even if the table is resized between calls. Strings may be returned more
+// than once.
+type ScanSet struct {
+	seed    maphash.Seed
+	buckets [][]string
+	// while resizing, the table strings are moved to and the next bucket
+	// of buckets to move
+	next      [][]string
+	rehashIdx int
+	count     int
+}
+
+func NewScanSet() *ScanSet {
+	return &ScanSet{seed: maphash.MakeSeed(), buckets: make([][]string, minBuckets)}
+}
+
+func (s *ScanSet) hash(v string) uint64 {
+	return maphash.String(s.seed, v)
+}
+
+func mask(table [][]string) uint64 {
+	return uint64(len(table) - 1)
+}
+
+func (s *ScanSet) rehashing() bool {
+	return s.next != nil
+}
+
+// find returns the table and bucket holding v, or the table a new string
+// goes to and -1
+func (s *ScanSet) find(v string) ([][]string, int, int) {
+	h := s.hash(v)
+	i := h & mask(s.buckets)
+	for j, existing := range s.buckets[i] {
+		if existing == v {
+			return s.buckets, int(i), j
+		}
+	}
+	if !s.rehashing() {
+		return s.buckets, int(i), -1
+	}
+
+	i = h & mask(s.next)
+	for j, existing := range s.next[i] {
+		if existing == v {
+			return s.next, int(i), j
+		}
+	}
+	return s.next, int(i), -1
+}
+
+// Add adds v to the set, returning false if it was already there
+func (s *ScanSet) Add(v string) bool {
+	s.step()
+	table, i, j := s.find(v)
+	if j >= 0 {
+		return false
+	}
+
+	table[i] = append(table[i], v)
+	s.count++
+	s.resizeIfNeeded()
+	return true
+}
+
+// Remove removes v from the set, returning false if it was not there
+func (s *ScanSet) Remove(v string) bool {
+	s.step()
+	table, i, j := s.find(v)
+	if j < 0 {
+		return false
+	}
+
+	bucket := table[i]
+	bucket[j] = bucket[len(bucket)-1]
+	bucket[len(bucket)-1] = ""
+	table[i] = bucket[:len(bucket)-1]
+	s.count--
+	s.resizeIfNeeded()
+	return true
+}
+
+// Contains reports whether v is in the set
+func (s *ScanSet) Contains(v string) bool {
+	s.step()
+	_, _, j := s.find(v)
+	return j >= 0
+}
+
+func (s *ScanSet) Len() int {
+	return s.count
+}
+
+func (s *ScanSet) Clear() {
+	s.buckets = make([][]string, minBuckets)
+	s.next = nil
+	s.rehashIdx = 0
+	s.count = 0
+}
+
+// resizeIfNeeded starts growing a table holding more strings than buckets,
+// or shrinking one mostly empty, unless a resize is in progress already
+func (s *ScanSet) resizeIfNeeded() {
+	if s.rehashing() {
+		return
+	}
+	if s.count > len(s.buckets) {
+		s.resize(len(s.buckets) * 2)
+	} else if len(s.buckets) > minBuckets && s.count*8 < len(s.buckets) {
+		s.resize(len(s.buckets) / 2)
+	}
+}
+
+// resize starts moving the strings into a table of n buckets
+func (s *ScanSet) resize(n int) {
+	s.next = make([][]string, n)
+	s.rehashIdx = 0
+}
+
+// step moves a few buckets along a resize in progress
+func (s *ScanSet) step() {
+	if s.rehashing() {
+		s.Rehash(rehashStep)
+	}
+}
+
+// Rehash moves up to n buckets to the new table of a resize in progress,
+// skipping at most 10 times as many empty ones. It reports whether there is
+// more left to move, which includes a resize started once the last one
+// completed.
+func (s *ScanSet) Rehash(n int) bool {
+	if !s.rehashing() {
+		return false
+	}
+
+	m, empty := mask(s.next), n*10
+	for ; n > 0 && s.rehashIdx < len(s.buckets); s.rehashIdx++ {
+		bucket := s.buckets[s.rehashIdx]
+		if len(bucket) == 0 {
+			if empty--; empty == 0 {
+				s.rehashIdx++
+				return true
+			}
+			continue
+		}
+		for _, v := range bucket {
+			i := s.hash(v) & m
+			s.next[i] = append(s.next[i], v)
+		}
+		s.buckets[s.rehashIdx] = nil
+		n--
+	}
+
+	if s.rehashIdx < len(s.buckets) {
+		return true
+	}
+	s.buckets, s.next, s.rehashIdx = s.next, nil, 0
+	// the set may have outgrown the new table while strings were moved
+	s.resizeIfNeeded()
+	return s.rehashing()
+}
+
+// Scan calls fn with the strings of the buckets from cursor on, until
+// count strings were found or 10 times as many buckets were visited. It
+// returns the cursor to continue from, which is 0 once every bucket has
+// been visited. fn must not modify the set.
+func (s *ScanSet) Scan(cursor uint64, count int, fn func(v string)) uint64 {
+	if count < 1 {
+		count = 1
+	}
+	found, visits := 0, count*10
+	visit := func(bucket []string) {
+		for _, v := range bucket {
+			fn(v)
+		}
+		found += len(bucket)
+	}
+
+	for {
+		if !s.rehashing() {
+			m := mask(s.buckets)
+			visit(s.buckets[cursor&m])
+			cursor = nextCursor(cursor, m)
+		} else {
+			// visit the bucket of the smaller table, then every bucket of
+			// the larger one it expands to
+			small, large := s.buckets, s.next
+			if len(small) > len(large) {
+				small, large = large, small
+			}
+			m0, m1 := mask(small), mask(large)
+			visit(small[cursor&m0])
+			for {
+				visit(large[cursor&m1])
+				cursor = nextCursor(cursor, m1)
+				if cursor&(m0^m1) == 0 {
+					break
+				}
+			}
+		}
+		visits--
+
+		if cursor == 0 || found >= count || visits == 0 {
+			return cursor
+		}
+	}
+}
+
+// nextCursor increments the reversed cursor, so buckets that were split
+// from or merged into one already visited are not visited again after a
+// resize
+func nextCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	return bits.Reverse64(bits.Reverse64(cursor) + 1)
+}
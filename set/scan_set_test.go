@@ -0,0 +1,168 @@
+package set
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ScanSet(t *testing.T) {
+	assert := assert.New(t)
+	s := NewScanSet()
+
+	assert.True(s.Add("one"))
+	assert.False(s.Add("one"))
+	assert.True(s.Contains("one"))
+	assert.True(s.Remove("one"))
+	assert.False(s.Remove("one"))
+	assert.Equal(0, s.Len())
+
+	for i := 0; i < 1000; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	for s.Rehash(100) {
+	}
+	assert.Equal(1000, s.Len())
+	assert.Equal(1024, len(s.buckets))
+
+	for i := 0; i < 990; i++ {
+		s.Remove(strconv.Itoa(i))
+	}
+	for s.Rehash(100) {
+	}
+	assert.Equal(10, s.Len())
+	assert.Less(len(s.buckets), 128)
+	assert.True(s.Contains("995"))
+}
+
+func Test_ScanSet_Rehash(t *testing.T) {
+	assert := assert.New(t)
+	s := NewScanSet()
+	for i := 0; i < 512; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	for s.Rehash(100) {
+	}
+	assert.Equal(512, len(s.buckets))
+
+	// growing only allocates the new table, strings move a bucket per
+	// operation
+	s.Add("512")
+	assert.True(s.rehashing())
+	assert.Equal(1024, len(s.next))
+	for i := 0; i < 10; i++ {
+		s.Add("new" + strconv.Itoa(i))
+	}
+	assert.True(s.rehashing())
+
+	// strings are found in either table meanwhile
+	for i := 0; i <= 512; i++ {
+		assert.True(s.Contains(strconv.Itoa(i)))
+	}
+	assert.False(s.Add("7"))
+	assert.True(s.Remove("7"))
+	assert.False(s.Contains("7"))
+	assert.Equal(522, s.Len())
+
+	for s.Rehash(1) {
+	}
+	assert.False(s.rehashing())
+	assert.Equal(1024, len(s.buckets))
+	assert.Equal(522, len(scanAll(s, 10, func(int) {})))
+
+	s.Clear()
+	assert.False(s.rehashing())
+	assert.False(s.Rehash(1))
+}
+
+// scanAll iterates s to the end, calling between after every step
+func scanAll(s *ScanSet, count int, between func(step int)) map[string]int {
+	seen := make(map[string]int)
+	cursor, step := uint64(0), 0
+	for {
+		cursor = s.Scan(cursor, count, func(v string) { seen[v]++ })
+		if cursor == 0 {
+			return seen
+		}
+		between(step)
+		step++
+	}
+}
+
+func Test_ScanSet_Scan(t *testing.T) {
+	assert := assert.New(t)
+	s := NewScanSet()
+	for i := 0; i < 100; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+
+	seen := scanAll(s, 10, func(int) {})
+	assert.Len(seen, 100)
+	for v, n := range seen {
+		assert.Equal(1, n, v)
+	}
+	assert.Equal(uint64(0), NewScanSet().Scan(0, 10, func(string) {}))
+}
+
+func Test_ScanSet_Scan_Resize(t *testing.T) {
+	assert := assert.New(t)
+
+	// strings present for the whole iteration are returned even when the
+	// table grows...
+	s := NewScanSet()
+	for i := 0; i < 100; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	next := 100
+	seen := scanAll(s, 5, func(int) {
+		for j := 0; j < 20 && next < 2000; j++ {
+			s.Add("new" + strconv.Itoa(next))
+			next++
+		}
+	})
+	for i := 0; i < 100; i++ {
+		assert.Contains(seen, strconv.Itoa(i))
+	}
+
+	// ...or shrinks
+	s = NewScanSet()
+	for i := 0; i < 2000; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	removed := 100
+	seen = scanAll(s, 20, func(int) {
+		for j := 0; j < 100 && removed < 2000; j++ {
+			s.Remove(strconv.Itoa(removed))
+			removed++
+		}
+	})
+	for i := 0; i < 100; i++ {
+		assert.Contains(seen, strconv.Itoa(i))
+	}
+}
+
+func Test_ScanSet_Scan_Rehashing(t *testing.T) {
+	assert := assert.New(t)
+
+	// while growing and while shrinking, with the strings spread over both
+	// tables
+	for _, n := range []int{64, 1024} {
+		s := NewScanSet()
+		for i := 0; i < 100; i++ {
+			s.Add(strconv.Itoa(i))
+		}
+		for s.Rehash(100) {
+		}
+		s.resize(n)
+		s.Rehash(len(s.buckets) / 4)
+		assert.True(s.rehashing())
+
+		seen := scanAll(s, 3, func(int) {})
+		assert.True(s.rehashing())
+		assert.Len(seen, 100)
+		for v, n := range seen {
+			assert.Equal(1, n, v)
+		}
+	}
+}
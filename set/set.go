@@ -8,4 +8,8 @@ type IStringSet interface {
 	Remove(v string)
 	// Get n random strings from the set
 	RandomN(n int) []string
+	// Remove every string from the set
+	Clear()
+	// Number of strings in the set
+	Len() int
 }
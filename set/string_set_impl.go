@@ -2,48 +2,62 @@ package set
 
 import "math/rand"
 
-var empty struct{}
-
+// StringSet keeps its strings in a slice as well as a map from each string
+// to its position, so that random strings are picked in constant time.
+// Removing a string moves the last one into its place.
 type StringSet struct {
-	data map[string]struct{}
+	index map[string]int
+	items []string
 }
 
 func InitStringSet() *StringSet {
-	return &StringSet{data: make(map[string]struct{})}
+	return &StringSet{index: make(map[string]int)}
 }
 
 func (s *StringSet) Add(v string) {
-	s.data[v] = empty
+	if _, ok := s.index[v]; ok {
+		return
+	}
+	s.index[v] = len(s.items)
+	s.items = append(s.items, v)
 }
 
 func (s *StringSet) Remove(v string) {
-	delete(s.data, v)
+	i, ok := s.index[v]
+	if !ok {
+		return
+	}
+
+	last := len(s.items) - 1
+	s.items[i] = s.items[last]
+	s.index[s.items[i]] = i
+	s.items[last] = ""
+	s.items = s.items[:last]
+	delete(s.index, v)
 }
 
-func (s *StringSet) size() int {
-	return len(s.data)
+func (s *StringSet) Clear() {
+	s.index = make(map[string]int)
+	s.items = nil
+}
+
+func (s *StringSet) Len() int {
+	return len(s.items)
 }
 
 // RandomN returns n random strings from the set
 // The returned elements could repeat. if n > size
 // of set then all elements are returned.
 func (s *StringSet) RandomN(n int) []string {
-	size := s.size()
-
-	// This is inneficcient, but it's a start
-	keys := make([]string, 0, size)
-	for k := range s.data {
-		keys = append(keys, k)
-	}
+	size := s.Len()
 
 	if n > size {
-		return keys
+		return append([]string{}, s.items...)
 	}
 
-	randomKeys := make([]string, 0, size)
+	randomKeys := make([]string, 0, n)
 	for i := 0; i < n; i++ {
-		ind := rand.Intn(size)
-		randomKeys = append(randomKeys, keys[ind])
+		randomKeys = append(randomKeys, s.items[rand.Intn(size)])
 	}
 
 	return randomKeys
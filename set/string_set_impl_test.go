@@ -48,3 +48,27 @@ func Test_Random_Remove(t *testing.T) {
 	res = s.RandomN(1)
 	assert.Equal(0, len(res))
 }
+
+func Test_Clear(t *testing.T) {
+	assert := assert.New(t)
+	s := InitStringSet()
+	s.Add("one")
+	s.Add("two")
+
+	s.Clear()
+	assert.Equal(0, len(s.RandomN(1)))
+}
+
+func Test_Remove_Keeps_Others(t *testing.T) {
+	assert := assert.New(t)
+	s := InitStringSet()
+	s.Add("one")
+	s.Add("two")
+	s.Add("three")
+	s.Add("two")
+
+	s.Remove("one")
+	s.Remove("missing")
+	assert.Equal(2, s.Len())
+	assert.ElementsMatch([]string{"two", "three"}, s.RandomN(3))
+}
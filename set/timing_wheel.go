@@ -0,0 +1,225 @@
+package set
+
+import (
+	"math/bits"
+	"time"
+)
+
+// IExpirySet is a set of strings that each have a deadline, which can hand
+// out the strings whose deadline passed
+type IExpirySet interface {
+	IStringSet
+	// Add v, or move it, to be due at t
+	AddAt(v string, t time.Time)
+	// Remove and return up to n strings that are due at now
+	Due(now time.Time, n int) []string
+}
+
+const (
+	// each level of the wheel has 1<<wheelBits slots, and a slot of a
+	// level spans as many milliseconds as the whole level below it
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelLevels = 6
+	// deadlines further than this from the current time wait in the
+	// overflow, about two years away with 6 levels
+	wheelSpan = 1 << (wheelBits * wheelLevels)
+)
+
+// position of a string in a TimingWheel
+type wheelPos struct {
+	// level and slot, or one of the levels below
+	level int8
+	slot  uint8
+}
+
+const (
+	levelDue      int8 = -1
+	levelOverflow int8 = wheelLevels
+)
+
+// TimingWheel is a hashed hierarchical timing wheel with a resolution of a
+// millisecond. A string lives in the lowest level whose slots are wide enough
+// to tell its deadline apart from the current time, in the slot of its
+// deadline. As time moves on, the slot of a level the current time enters is
+// moved down to the levels below, until its strings reach the slot of the
+// lowest level the current time is in and become due.
+//
+// Adding and removing a string take constant time, and so does moving time
+// on by a millisecond. Levels holding nothing are skipped over.
+type TimingWheel struct {
+	// every string, for RandomN
+	all *StringSet
+	pos map[string]wheelPos
+	// deadline of each string, in unix milliseconds
+	deadlines map[string]int64
+	slots     [wheelLevels][wheelSlots]map[string]struct{}
+	// number of strings held by each level
+	counts   [wheelLevels]int
+	overflow map[string]struct{}
+	due      map[string]struct{}
+	// current time of the wheel, in unix milliseconds
+	now int64
+}
+
+// NewTimingWheel creates a wheel whose current time is now
+func NewTimingWheel(now time.Time) *TimingWheel {
+	w := &TimingWheel{now: now.UnixMilli()}
+	w.Clear()
+	return w
+}
+
+// deadlineMillis rounds t up to a millisecond, so that a string is never
+// due before t
+func deadlineMillis(t time.Time) int64 {
+	ms := t.UnixMilli()
+	if t.Sub(time.UnixMilli(ms)) > 0 {
+		ms++
+	}
+	return ms
+}
+
+// Add adds v as due right away. Use AddAt to give it a deadline.
+func (w *TimingWheel) Add(v string) {
+	w.AddAt(v, time.UnixMilli(w.now))
+}
+
+func (w *TimingWheel) AddAt(v string, t time.Time) {
+	w.Remove(v)
+	w.all.Add(v)
+	w.deadlines[v] = deadlineMillis(t)
+	w.place(v)
+}
+
+// place puts v where its deadline belongs given the current time
+func (w *TimingWheel) place(v string) {
+	at := w.deadlines[v]
+	if at <= w.now {
+		w.due[v] = struct{}{}
+		w.pos[v] = wheelPos{level: levelDue}
+		return
+	}
+
+	// the highest bit that differs from the current time picks the level
+	level := (bits.Len64(uint64(at^w.now)) - 1) / wheelBits
+	if level >= wheelLevels {
+		w.overflow[v] = struct{}{}
+		w.pos[v] = wheelPos{level: levelOverflow}
+		return
+	}
+
+	slot := uint8(at>>(wheelBits*level)) & (wheelSlots - 1)
+	w.slots[level][slot][v] = struct{}{}
+	w.counts[level]++
+	w.pos[v] = wheelPos{level: int8(level), slot: slot}
+}
+
+func (w *TimingWheel) Remove(v string) {
+	p, ok := w.pos[v]
+	if !ok {
+		return
+	}
+
+	switch p.level {
+	case levelDue:
+		delete(w.due, v)
+	case levelOverflow:
+		delete(w.overflow, v)
+	default:
+		delete(w.slots[p.level][p.slot], v)
+		w.counts[p.level]--
+	}
+	delete(w.pos, v)
+	delete(w.deadlines, v)
+	w.all.Remove(v)
+}
+
+func (w *TimingWheel) RandomN(n int) []string {
+	return w.all.RandomN(n)
+}
+
+func (w *TimingWheel) Clear() {
+	w.all = InitStringSet()
+	w.pos = make(map[string]wheelPos)
+	w.deadlines = make(map[string]int64)
+	for l := range w.slots {
+		for s := range w.slots[l] {
+			w.slots[l][s] = make(map[string]struct{})
+		}
+	}
+	w.counts = [wheelLevels]int{}
+	w.overflow = make(map[string]struct{})
+	w.due = make(map[string]struct{})
+}
+
+func (w *TimingWheel) Len() int {
+	return w.all.Len()
+}
+
+func (w *TimingWheel) Due(now time.Time, n int) []string {
+	w.advance(now.UnixMilli())
+
+	due := make([]string, 0, n)
+	for v := range w.due {
+		if len(due) == n {
+			break
+		}
+		due = append(due, v)
+	}
+	for _, v := range due {
+		w.Remove(v)
+	}
+	return due
+}
+
+// advance moves the current time on to the given millisecond
+func (w *TimingWheel) advance(to int64) {
+	for w.now < to {
+		// jump to the next slot boundary of the lowest level holding
+		// anything, as the levels below it have nothing to move
+		step := int64(1)
+		for l := 0; l < wheelLevels && w.counts[l] == 0; l++ {
+			step = int64(1) << (wheelBits * (l + 1))
+		}
+		if step == wheelSpan && len(w.overflow) == 0 {
+			w.now = to
+			return
+		}
+
+		next := (w.now | (step - 1)) + 1
+		if next > to {
+			// no boundary with anything to move is crossed
+			w.now = to
+			return
+		}
+		w.now = next
+		w.tick()
+	}
+}
+
+// tick moves down the slots the current time just entered
+func (w *TimingWheel) tick() {
+	if w.now%wheelSpan == 0 {
+		overflow := w.overflow
+		w.overflow = make(map[string]struct{})
+		for v := range overflow {
+			w.place(v)
+		}
+	}
+
+	for l := wheelLevels - 1; l >= 0; l-- {
+		if l > 0 && w.now&(int64(1)<<(wheelBits*l)-1) != 0 {
+			continue
+		}
+		slot := uint8(w.now>>(wheelBits*l)) & (wheelSlots - 1)
+		moved := w.slots[l][slot]
+		if len(moved) == 0 {
+			continue
+		}
+		w.slots[l][slot] = make(map[string]struct{})
+		w.counts[l] -= len(moved)
+		for v := range moved {
+			w.place(v)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package set
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TimingWheel_Due(t *testing.T) {
+	assert := assert.New(t)
+	start := time.UnixMilli(1_000_000)
+	w := NewTimingWheel(start)
+
+	w.AddAt("soon", start.Add(5*time.Millisecond))
+	w.AddAt("later", start.Add(3*time.Second))
+	w.AddAt("past", start.Add(-time.Second))
+	assert.Equal(3, w.Len())
+
+	assert.Equal([]string{"past"}, w.Due(start, 10))
+	assert.Empty(w.Due(start.Add(4*time.Millisecond), 10))
+	assert.Equal([]string{"soon"}, w.Due(start.Add(5*time.Millisecond), 10))
+	assert.Empty(w.Due(start.Add(2999*time.Millisecond), 10))
+	assert.Equal([]string{"later"}, w.Due(start.Add(time.Hour), 10))
+	assert.Equal(0, w.Len())
+}
+
+func Test_TimingWheel_Rounds_Up(t *testing.T) {
+	assert := assert.New(t)
+	start := time.UnixMilli(1_000_000)
+	w := NewTimingWheel(start)
+
+	w.AddAt("key", start.Add(1500*time.Microsecond))
+	assert.Empty(w.Due(start.Add(time.Millisecond), 10))
+	assert.Equal([]string{"key"}, w.Due(start.Add(2*time.Millisecond), 10))
+}
+
+func Test_TimingWheel_Move_And_Remove(t *testing.T) {
+	assert := assert.New(t)
+	start := time.UnixMilli(1_000_000)
+	w := NewTimingWheel(start)
+
+	w.AddAt("moved", start.Add(time.Minute))
+	w.AddAt("moved", start.Add(time.Second))
+	w.AddAt("removed", start.Add(time.Second))
+	w.Remove("removed")
+	assert.Equal(1, w.Len())
+	assert.Equal([]string{"moved"}, w.RandomN(1))
+
+	assert.Equal([]string{"moved"}, w.Due(start.Add(time.Second), 10))
+	assert.Empty(w.Due(start.Add(2*time.Minute), 10))
+}
+
+func Test_TimingWheel_Far_Deadlines(t *testing.T) {
+	assert := assert.New(t)
+	start := time.UnixMilli(1_000_000)
+	w := NewTimingWheel(start)
+
+	// past every level, in the overflow
+	far := start.Add(3 * 365 * 24 * time.Hour)
+	w.AddAt("far", far)
+	w.AddAt("day", start.Add(24*time.Hour))
+
+	assert.Equal([]string{"day"}, w.Due(far.Add(-time.Millisecond), 10))
+	assert.Equal([]string{"far"}, w.Due(far, 10))
+}
+
+func Test_TimingWheel_Due_In_Batches(t *testing.T) {
+	assert := assert.New(t)
+	start := time.UnixMilli(1_000_000)
+	w := NewTimingWheel(start)
+
+	for i := 0; i < 100; i++ {
+		w.AddAt(fmt.Sprintf("key:%d", i), start.Add(time.Duration(i)*time.Second))
+	}
+
+	// keys 0 to 59 are due
+	end := start.Add(59500 * time.Millisecond)
+	seen := make(map[string]bool)
+	for _, n := range []int{20, 20, 20} {
+		due := w.Due(end, n)
+		assert.Len(due, n)
+		for _, k := range due {
+			seen[k] = true
+		}
+	}
+	assert.Len(w.Due(end, 20), 0)
+	assert.Len(seen, 60)
+	assert.Equal(40, w.Len())
+}
+
+func Test_TimingWheel_Clear(t *testing.T) {
+	assert := assert.New(t)
+	start := time.UnixMilli(1_000_000)
+	w := NewTimingWheel(start)
+
+	w.AddAt("one", start.Add(time.Second))
+	w.Add("two")
+	w.Clear()
+	assert.Equal(0, w.Len())
+	assert.Empty(w.Due(start.Add(time.Hour), 10))
+}
+
+func Test_TimingWheel_Matches_Deadlines(t *testing.T) {
+	assert := assert.New(t)
+	start := time.UnixMilli(1_000_000)
+	w := NewTimingWheel(start)
+	r := rand.New(rand.NewSource(1))
+
+	deadlines := make(map[string]time.Time)
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("key:%d", i)
+		deadlines[k] = start.Add(time.Duration(r.Int63n(int64(48 * time.Hour))))
+		w.AddAt(k, deadlines[k])
+	}
+
+	// every key comes out once, after its deadline
+	now := start
+	for w.Len() > 0 {
+		now = now.Add(time.Duration(r.Int63n(int64(10 * time.Minute))))
+		for _, k := range w.Due(now, 2000) {
+			assert.False(deadlines[k].After(now), k)
+			delete(deadlines, k)
+		}
+		for k, d := range deadlines {
+			assert.True(d.After(now), k)
+		}
+	}
+	assert.Empty(deadlines)
+}
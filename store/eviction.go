@@ -0,0 +1,167 @@
+package store
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// access counter of new keys, so they are not evicted before they had
+	// a chance to be read
+	lfuInitVal = 5
+	// how hard it gets to increment the counter as it grows. With 10 it
+	// saturates after about a million accesses.
+	lfuLogFactor = 10
+	// the counter is decremented once for each period without access
+	lfuDecayTime = time.Minute
+)
+
+// rough sizes of the bookkeeping around keys and values, added to the
+// lengths of the strings they hold
+const (
+	entryOverhead  = 64
+	stringOverhead = 16
+	zsetOverhead   = 96
+	memberOverhead = 64
+)
+
+// newValue wraps v with fresh access metadata
+func newValue(v interface{}) Value {
+	return Value{value: v, accessed: time.Now().UnixNano(), freq: lfuInitVal}
+}
+
+// Idle returns the time since the key was last read or written
+func (v Value) Idle() time.Duration {
+	return time.Since(time.Unix(0, v.accessed))
+}
+
+// Freq returns the logarithmic access counter of the key, decremented for
+// every lfuDecayTime since the last access
+func (v Value) Freq() uint8 {
+	periods := int64(v.Idle() / lfuDecayTime)
+	if periods >= int64(v.freq) {
+		return 0
+	}
+	return v.freq - uint8(periods)
+}
+
+// WithIdle returns a copy of the value last accessed idle ago
+func (v Value) WithIdle(idle time.Duration) Value {
+	v.accessed = time.Now().Add(-idle).UnixNano()
+	if v.freq == 0 {
+		v.freq = lfuInitVal
+	}
+	return v
+}
+
+// WithFreq returns a copy of the value with the given LFU access counter
+func (v Value) WithFreq(freq uint8) Value {
+	v.freq = freq
+	if v.accessed == 0 {
+		v.accessed = time.Now().UnixNano()
+	}
+	return v
+}
+
+// access records an access to the value. The counter is incremented with a
+// probability that falls as it grows.
+func (v *Value) access(now time.Time) {
+	counter := v.Freq()
+	if counter < 255 {
+		base := float64(counter) - lfuInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	v.freq = counter
+	v.accessed = now.UnixNano()
+}
+
+// size estimates the bytes held by the value
+func (v Value) size() int64 {
+	switch val := v.value.(type) {
+	case string:
+		return int64(len(val)) + stringOverhead
+	case *zset:
+		return zsetOverhead + val.bytes
+	}
+	return 0
+}
+
+func keySize(k string) int64 {
+	return int64(len(k)) + entryOverhead
+}
+
+func memberSize(m string) int64 {
+	return int64(len(m)) + memberOverhead
+}
+
+// put stores v at k and accounts for its memory. Values that were not read
+// from the store get fresh access metadata.
+func (s *InMemStore) put(k string, v Value) {
+	if old, ok := s.data[k]; ok {
+		s.used -= keySize(k) + old.size()
+	} else {
+		s.keys.Add(k)
+	}
+	if v.accessed == 0 {
+		v.accessed = time.Now().UnixNano()
+		v.freq = lfuInitVal
+	}
+	s.used += keySize(k) + v.size()
+	s.data[k] = v
+}
+
+// remove deletes k and the memory accounted for it
+func (s *InMemStore) remove(k string) {
+	if old, ok := s.data[k]; ok {
+		s.used -= keySize(k) + old.size()
+		delete(s.data, k)
+		s.keys.Remove(k)
+	}
+	s.removeExpiry(k)
+}
+
+// touch records a read of the value stored at k
+func (s *InMemStore) touch(k string, v Value) {
+	v.access(time.Now())
+	s.data[k] = v
+}
+
+func (s *InMemStore) UsedMemory() int64 {
+	return s.used
+}
+
+func (s *InMemStore) Sample(n int, volatile bool) []Entry {
+	entries := make([]Entry, 0, n)
+	if volatile {
+		for _, k := range s.keysWithExpiry.RandomN(n) {
+			// only keys that still expire are volatile
+			if v, ok := s.data[k]; ok && v.Expiry() != nil {
+				entries = append(entries, Entry{Key: k, Value: v})
+			}
+		}
+		return entries
+	}
+
+	// map iteration starts at a random place
+	for k, v := range s.data {
+		if len(entries) == n {
+			break
+		}
+		entries = append(entries, Entry{Key: k, Value: v})
+	}
+	return entries
+}
+
+func (s *InMemStore) Evict(k string) bool {
+	if _, ok := s.data[k]; !ok {
+		return false
+	}
+	s.remove(k)
+	s.stats.EvictedKeys++
+	return true
+}
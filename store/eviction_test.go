@@ -0,0 +1,96 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"noelzubin/redis-go/set"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_UsedMemory(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	expireSet.On("Clear").Return()
+	s := InitStore(expireSet)
+
+	s.Set("foo", "bar", nil)
+	assert.Equal(keySize("foo")+3+stringOverhead, s.UsedMemory())
+
+	// overwriting replaces the size of the old value
+	s.Set("foo", "barbaz", nil)
+	assert.Equal(keySize("foo")+6+stringOverhead, s.UsedMemory())
+
+	s.ZAdd("z", []ScoreMember{NewScoreMember(1, "a"), NewScoreMember(2, "b")})
+	s.ZAdd("z", []ScoreMember{NewScoreMember(3, "a")})
+	zset := keySize("z") + zsetOverhead + 2*memberSize("a")
+	assert.Equal(keySize("foo")+6+stringOverhead+zset, s.UsedMemory())
+
+	s.Del("foo")
+	assert.Equal(zset, s.UsedMemory())
+
+	s.Flush()
+	assert.Equal(int64(0), s.UsedMemory())
+}
+
+func Test_Sample_And_Evict(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	expireSet.On("Len").Return(0)
+	s := InitStore(expireSet)
+	s.Set("a", "1", nil)
+	s.Set("b", "2", nil)
+	s.Set("c", "3", nil)
+
+	assert.Len(s.Sample(2, false), 2)
+	assert.Len(s.Sample(10, false), 3)
+
+	assert.True(s.Evict("a"))
+	assert.False(s.Evict("a"))
+	assert.Equal(int64(1), s.Stats().EvictedKeys)
+	assert.Equal(2, s.Len())
+}
+
+func Test_Freq(t *testing.T) {
+	assert := assert.New(t)
+	v := newValue("x")
+	assert.Equal(uint8(lfuInitVal), v.Freq())
+
+	// close to the initial value every access counts
+	v.access(time.Now())
+	assert.Equal(uint8(lfuInitVal+1), v.Freq())
+
+	// and the counter decays while the key is not used
+	v.accessed = time.Now().Add(-3 * lfuDecayTime).UnixNano()
+	assert.Equal(uint8(lfuInitVal-2), v.Freq())
+	v.accessed = time.Now().Add(-time.Hour).UnixNano()
+	assert.Equal(uint8(0), v.Freq())
+	assert.GreaterOrEqual(v.Idle(), time.Hour)
+}
+
+func Test_Sample_Volatile_Persisted_Keys(t *testing.T) {
+	for name, keysWithExpiry := range map[string]set.IStringSet{
+		"sample": set.InitStringSet(),
+		"wheel":  set.NewTimingWheel(time.Now()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			s := InitStore(keysWithExpiry)
+			later := time.Now().Add(time.Hour)
+
+			// SET without a ttl and ZADD make a key persistent again
+			s.Set("k", "1", &later)
+			s.Set("k", "2", nil)
+			s.ZAdd("z", []ScoreMember{NewScoreMember(1, "a")})
+			s.ExpireAt("z", later)
+			s.ZAdd("z", []ScoreMember{NewScoreMember(2, "b")})
+			s.Set("v", "1", &later)
+
+			assert.Equal(1, s.Stats().Expires)
+			sampled := s.Sample(5, true)
+			assert.Len(sampled, 1)
+			assert.Equal("v", sampled[0].Key)
+		})
+	}
+}
@@ -2,16 +2,22 @@ package store
 
 import (
 	"fmt"
+	"noelzubin/redis-go/glob"
 	"noelzubin/redis-go/set"
 	"time"
-
-	"github.com/wangjia184/sortedset"
 )
 
+// buckets of the key table moved along a resize by every CleanUp
+const rehashPerCycle = 100
+
 // Value is the wrapper for value stored in the store
 type Value struct {
 	value  interface{}
 	expiry *time.Time
+	// when the key was last read or written, in unix nanoseconds, and the
+	// logarithmic access counter of the LFU policies
+	accessed int64
+	freq     uint8
 }
 
 // checks if the value is expired
@@ -19,17 +25,119 @@ func (v *Value) isExpired() bool {
 	return v.expiry != nil && v.expiry.Before(time.Now())
 }
 
+// NewStringValue creates a string value without an expiry
+func NewStringValue(s string) Value {
+	return Value{value: s}
+}
+
+// NewZSetValue creates a sorted set value without an expiry
+func NewZSetValue(members []ScoreMember) Value {
+	set := newZSet()
+	for _, m := range members {
+		set.add(m.member, m.score)
+	}
+	return Value{value: set}
+}
+
+// WithExpiry returns a copy of the value that expires at e
+func (v Value) WithExpiry(e *time.Time) Value {
+	v.expiry = e
+	return v
+}
+
+// Type returns the name of the type held by the value
+func (v Value) Type() string {
+	switch v.value.(type) {
+	case string:
+		return "string"
+	case *zset:
+		return "zset"
+	}
+	return "none"
+}
+
+// Expiry returns the expiry time of the value if it has one
+func (v Value) Expiry() *time.Time {
+	return v.expiry
+}
+
+// Str returns the value if it holds a string
+func (v Value) Str() (string, bool) {
+	s, ok := v.value.(string)
+	return s, ok
+}
+
+// ScoreMembers returns the members of a sorted set value in rank order
+func (v Value) ScoreMembers() []ScoreMember {
+	set, ok := v.value.(*zset)
+	if !ok {
+		return nil
+	}
+
+	nodes := set.GetByRankRange(1, -1, false)
+	members := make([]ScoreMember, 0, len(nodes))
+	for _, n := range nodes {
+		members = append(members, NewScoreMember(int64(n.Score()), n.Key()))
+	}
+	return members
+}
+
+// Len returns the number of elements of the value, 1 for a string
+func (v Value) Len() int {
+	if set, ok := v.value.(*zset); ok {
+		return set.GetCount()
+	}
+	return 1
+}
+
+// Release tears down a value that was removed from the store, element by
+// element
+func (v Value) Release() {
+	if set, ok := v.value.(*zset); ok {
+		set.release()
+	}
+}
+
+// Clone returns a copy of the value that shares no mutable state
+func (v Value) Clone() Value {
+	set, ok := v.value.(*zset)
+	if !ok {
+		return v
+	}
+
+	copied := newZSet()
+	for _, n := range set.GetByRankRange(1, -1, false) {
+		copied.add(n.Key(), int64(n.Score()))
+	}
+	v.value = copied
+	return v
+}
+
 // InMemStore is the main inmemory implementation of the store
 type InMemStore struct {
 	data           map[string]Value
 	keysWithExpiry set.IStringSet
+	// keysWithExpiry when it keeps the deadline of each key, in which case
+	// CleanUp deletes the keys it says are due instead of sampling
+	wheel    set.IExpirySet
+	cycle    ExpireCycle
+	stats    Stats
+	onExpire func(k string)
+	// estimated bytes held by keys and values
+	used int64
+	// every key, for SCAN to iterate
+	keys *set.ScanSet
 }
 
 // InitStore initializes a new InMemStore
 func InitStore(keysWithExpiry set.IStringSet) *InMemStore {
+	wheel, _ := keysWithExpiry.(set.IExpirySet)
 	return &InMemStore{
 		data:           make(map[string]Value),
 		keysWithExpiry: keysWithExpiry,
+		wheel:          wheel,
+		cycle:          DefaultExpireCycle,
+		keys:           set.NewScanSet(),
 	}
 }
 
@@ -42,15 +150,18 @@ func (s *InMemStore) Get(k string) *string {
 	value, ok := s.data[k]
 
 	if !ok {
+		s.stats.Misses++
 		return nil
 	}
 
 	if value.isExpired() {
-		delete(s.data, k)
-		s.keysWithExpiry.Remove(k)
+		s.deleteExpired(k)
+		s.stats.Misses++
 		return nil
 	}
 
+	s.stats.Hits++
+	s.touch(k, value)
 	res, ok := value.value.(string)
 
 	if !ok {
@@ -60,73 +171,190 @@ func (s *InMemStore) Get(k string) *string {
 	return &res
 }
 
+// deleteExpired removes a key found to be expired
+func (s *InMemStore) deleteExpired(k string) {
+	s.remove(k)
+	s.stats.ExpiredKeys++
+	if s.onExpire != nil {
+		s.onExpire(k)
+	}
+}
+
 func (s *InMemStore) Set(k string, v string, e *time.Time) {
-	value := Value{value: v, expiry: e}
-	s.data[k] = value
+	old, exists := s.data[k]
+	s.put(k, Value{value: v, expiry: e})
 
 	if e != nil {
-		s.keysWithExpiry.Add(k)
+		s.addExpiry(k, *e)
+	} else if exists && old.expiry != nil {
+		// SET without a ttl makes the key persistent
+		s.removeExpiry(k)
+	}
+}
+
+// addExpiry indexes a key that expires at t
+func (s *InMemStore) addExpiry(k string, t time.Time) {
+	if s.wheel != nil {
+		s.wheel.AddAt(k, t)
+		return
+	}
+	s.keysWithExpiry.Add(k)
+}
+
+// removeExpiry drops a key that no longer expires from the index. The wheel
+// is the index itself when there is one.
+func (s *InMemStore) removeExpiry(k string) {
+	s.keysWithExpiry.Remove(k)
+}
+
+func (s *InMemStore) GetValue(k string) (Value, bool) {
+	value, ok := s.data[k]
+
+	if !ok {
+		return Value{}, false
+	}
+
+	if value.isExpired() {
+		s.deleteExpired(k)
+		return Value{}, false
+	}
+
+	s.touch(k, value)
+	return value, true
+}
+
+func (s *InMemStore) SetValue(k string, v Value) {
+	s.put(k, v)
+
+	if v.expiry != nil {
+		s.addExpiry(k, *v.expiry)
+	} else {
+		s.removeExpiry(k)
 	}
 }
 
 func (s *InMemStore) Del(keys ...string) int {
 	delCount := 0
 	for _, k := range keys {
-		if (s.Get(k)) != nil {
+		if _, ok := s.Peek(k); ok {
 			delCount++
-			delete(s.data, k)
-			s.keysWithExpiry.Remove(k)
+			s.remove(k)
 		}
 	}
 	return delCount
 }
 
+func (s *InMemStore) Unlink(k string) (Value, bool) {
+	value, ok := s.Peek(k)
+	if !ok {
+		return Value{}, false
+	}
+	s.remove(k)
+	return value, true
+}
+
 func (s *InMemStore) Expire(k string, seconds int) int {
+	return s.ExpireAt(k, time.Now().Add(time.Duration(seconds)*time.Second))
+}
+
+func (s *InMemStore) ExpireAt(k string, t time.Time) int {
 	value, ok := s.data[k]
 
 	if !ok {
 		fmt.Println("Key does not exist")
+		return 0
+	}
+	// a key past its ttl is gone, even if not deleted yet
+	if value.isExpired() {
+		s.deleteExpired(k)
+		return 0
 	}
 
-	expiry := time.Now().Add(time.Duration(seconds) * time.Second)
-	value.expiry = &expiry
+	value.expiry = &t
 	s.data[k] = value
-	s.keysWithExpiry.Add(k)
+	s.addExpiry(k, t)
 
 	return 1
 }
 
-func (s *InMemStore) Keys(k string) []string {
-	keys := make([]string, 0, len(s.data))
+func (s *InMemStore) Keys(pattern string) []string {
+	matcher := glob.Compile(pattern)
+
+	// a pattern without wildcards names a single key
+	if k, ok := matcher.Literal(); ok {
+		if _, ok := s.Peek(k); ok {
+			return []string{k}
+		}
+		return []string{}
+	}
 
+	keys := make([]string, 0)
 	for k, v := range s.data {
+		if !matcher.Match(k) {
+			continue
+		}
 		if !v.isExpired() {
 			keys = append(keys, k)
 		} else {
-			delete(s.data, k)
-			s.keysWithExpiry.Remove(k)
+			s.deleteExpired(k)
 		}
 	}
 
 	return keys
 }
 
+func (s *InMemStore) Len() int {
+	return len(s.data)
+}
+
+// Flush swaps in an empty keyspace. The old one is reclaimed by the garbage
+// collector.
+func (s *InMemStore) Flush() {
+	s.data = make(map[string]Value)
+	s.keysWithExpiry.Clear()
+	s.keys.Clear()
+	s.used = 0
+}
+
+func (s *InMemStore) Snapshot() []Entry {
+	entries := make([]Entry, 0, len(s.data))
+
+	for k, v := range s.data {
+		if v.isExpired() {
+			continue
+		}
+		entries = append(entries, Entry{Key: k, Value: v.Clone()})
+	}
+
+	return entries
+}
+
 func (s *InMemStore) ZAdd(k string, scoreMembers []ScoreMember) int {
 	value, ok := s.data[k]
 
-	var set *sortedset.SortedSet
+	var set *zset
 
 	if ok {
-		set = value.value.(*sortedset.SortedSet)
+		set = value.value.(*zset)
+		value.access(time.Now())
 	} else {
-		set = sortedset.New()
+		set = newZSet()
+		value = newValue(set)
+		s.used += keySize(k) + value.size()
+		s.keys.Add(k)
 	}
 
 	for _, scoreMember := range scoreMembers {
-		set.AddOrUpdate(scoreMember.member, sortedset.SCORE(scoreMember.score), nil)
+		if set.add(scoreMember.member, scoreMember.score) {
+			s.used += memberSize(scoreMember.member)
+		}
 	}
 
-	s.data[k] = Value{value: set, expiry: nil}
+	if value.expiry != nil {
+		value.expiry = nil
+		s.removeExpiry(k)
+	}
+	s.data[k] = value
 
 	return len(scoreMembers)
 }
@@ -140,7 +368,7 @@ func (s *InMemStore) ZRange(k string, start int, stop int, withScores bool) []st
 		return values
 	}
 
-	set := value.value.(*sortedset.SortedSet)
+	set := value.value.(*zset)
 
 	fmt.Println("starst")
 	for _, v := range set.GetByRankRange(start, stop, false) {
@@ -156,31 +384,100 @@ func (s *InMemStore) ZRange(k string, start int, stop int, withScores bool) []st
 	return values
 }
 
-func (s *InMemStore) CleanUp() {
-	needsCleanup := true
-	// Cleanup until there are very few expired keys
-	for needsCleanup {
-		needsCleanup = false
-		randomKeys := s.keysWithExpiry.RandomN(20)
-		expiredCount := 0
+func (s *InMemStore) CleanUp(deadline time.Time) ExpireRun {
+	// a resize of the keys a quiet keyspace does not move along, as
+	// activerehashing does
+	s.keys.Rehash(rehashPerCycle)
+
+	if s.wheel != nil {
+		return s.cleanUpDue(deadline)
+	}
+
+	samples, threshold := s.cycle.keysPerRound(), s.cycle.staleThreshold()
+	var run ExpireRun
 
-		// Delete the expired keys
-		for _, k := range randomKeys {
+	for {
+		expired := 0
+		for _, k := range s.keysWithExpiry.RandomN(samples) {
 			val, ok := s.data[k]
 			if !ok {
 				continue
-			} else {
-				if val.isExpired() {
-					expiredCount++
-					delete(s.data, k)
-					s.keysWithExpiry.Remove(k)
-				}
+			}
+			run.Sampled++
+			if val.isExpired() {
+				expired++
+				s.deleteExpired(k)
 			}
 		}
+		run.Expired += expired
 
-		// If more than 25% is expired
-		if expiredCount > 5 {
-			needsCleanup = true
+		// few keys are expired, or there is no time left for another round
+		if expired*100 <= samples*threshold {
+			run.Done = true
+			return run
+		}
+		if !time.Now().Before(deadline) {
+			return run
 		}
 	}
 }
+
+// cleanUpDue deletes the keys whose deadline passed, in rounds of as many
+// keys as a round of sampling looks at, until none is left or the deadline
+// of the cycle is reached
+func (s *InMemStore) cleanUpDue(deadline time.Time) ExpireRun {
+	n := s.cycle.keysPerRound()
+	var run ExpireRun
+
+	for {
+		due := s.wheel.Due(time.Now(), n)
+		for _, k := range due {
+			val, ok := s.data[k]
+			if !ok {
+				continue
+			}
+			run.Sampled++
+			if val.isExpired() {
+				run.Expired++
+				s.deleteExpired(k)
+			} else if val.expiry != nil {
+				s.wheel.AddAt(k, *val.expiry)
+			}
+		}
+
+		if len(due) < n {
+			run.Done = true
+			return run
+		}
+		if !time.Now().Before(deadline) {
+			return run
+		}
+	}
+}
+
+func (s *InMemStore) SetExpireCycle(c ExpireCycle) {
+	s.cycle = c
+}
+
+func (s *InMemStore) Stats() Stats {
+	stats := s.stats
+	stats.Keys = len(s.data)
+	stats.Expires = s.keysWithExpiry.Len()
+	return stats
+}
+
+func (s *InMemStore) ResetStats() {
+	s.stats = Stats{}
+}
+
+func (s *InMemStore) OnExpire(fn func(k string)) {
+	s.onExpire = fn
+}
+
+func (s *InMemStore) Scan(cursor uint64, count int) (uint64, []string) {
+	keys := make([]string, 0, count)
+	next := s.keys.Scan(cursor, count, func(k string) {
+		keys = append(keys, k)
+	})
+	return next, keys
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"noelzubin/redis-go/set"
 	"noelzubin/redis-go/set/mocks"
 
 	"github.com/stretchr/testify/assert"
@@ -229,7 +230,7 @@ func Test_CleanUp(t *testing.T) {
 	resp := make([]string, 0)
 	expireSet.On("RandomN", mock.AnythingOfType("int")).Return(resp)
 
-	s.CleanUp()
+	s.CleanUp(time.Now().Add(time.Second))
 	expireSet.AssertNumberOfCalls(t, "RandomN", 1)
 
 	bar := "bar"
@@ -249,7 +250,7 @@ func Test_CleanUp_Expired_Value(t *testing.T) {
 	resp := []string{"foo"}
 	expireSet.On("RandomN", mock.AnythingOfType("int")).Return(resp)
 
-	s.CleanUp()
+	s.CleanUp(time.Now().Add(time.Second))
 	expireSet.AssertNumberOfCalls(t, "Remove", 1)
 
 	// No more extra Remove calls
@@ -270,7 +271,7 @@ func Test_CleanUp_All_Expired_Values(t *testing.T) {
 	resp := []string{"foo", "one"}
 	expireSet.On("RandomN", mock.AnythingOfType("int")).Return(resp)
 
-	s.CleanUp()
+	s.CleanUp(time.Now().Add(time.Second))
 	expireSet.AssertNumberOfCalls(t, "Remove", 2)
 
 	s.Get("foo")
@@ -299,7 +300,7 @@ func Test_CleanUp_Call_Multiple_Times_If_More_Than_5(t *testing.T) {
 	resp2 := []string{"seven", "eight"}
 	expireSet.On("RandomN", mock.AnythingOfType("int")).Return(resp2).Once()
 
-	s.CleanUp()
+	s.CleanUp(time.Now().Add(time.Second))
 	expireSet.AssertNumberOfCalls(t, "Remove", 6)
 	expireSet.AssertNumberOfCalls(t, "RandomN", 2)
 }
@@ -325,7 +326,302 @@ func Test_CleanUp_Call_Multiple_Times_If_Less_Than_5(t *testing.T) {
 	resp2 := []string{"four", "five"}
 	expireSet.On("RandomN", mock.AnythingOfType("int")).Return(resp2).Once()
 
-	s.CleanUp()
+	s.CleanUp(time.Now().Add(time.Second))
 	expireSet.AssertNumberOfCalls(t, "Remove", 4)
 	expireSet.AssertNumberOfCalls(t, "RandomN", 1)
 }
+
+func Test_ExpireAt_Missing_Key(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	s := InitStore(expireSet)
+
+	assert.Equal(0, s.ExpireAt("foo", time.Now()))
+	expireSet.AssertNumberOfCalls(t, "Add", 0)
+}
+
+func Test_Expire_Expired_Key(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	s := InitStore(expireSet)
+	soon := time.Now().Add(time.Millisecond)
+	s.Set("foo", "bar", &soon)
+	time.Sleep(2 * time.Millisecond)
+
+	assert.Equal(0, s.Expire("foo", 100))
+	assert.Nil(s.Get("foo"))
+	expireSet.AssertNumberOfCalls(t, "Add", 1)
+	expireSet.AssertNumberOfCalls(t, "Remove", 1)
+}
+
+func Test_Snapshot(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	s := InitStore(expireSet)
+	past := time.Now().Add(-1 * time.Second)
+	s.Set("foo", "bar", nil)
+	s.Set("old", "bar", &past)
+	s.ZAdd("zs", []ScoreMember{{2, "two"}, {1, "one"}})
+
+	entries := s.Snapshot()
+	assert.Equal(2, len(entries))
+
+	// the snapshot is not affected by later writes
+	s.ZAdd("zs", []ScoreMember{{3, "three"}})
+	for _, e := range entries {
+		if e.Key == "zs" {
+			assert.Equal("zset", e.Value.Type())
+			assert.Equal([]ScoreMember{{1, "one"}, {2, "two"}}, e.Value.ScoreMembers())
+		} else {
+			str, ok := e.Value.Str()
+			assert.True(ok)
+			assert.Equal("bar", str)
+		}
+	}
+}
+
+func Test_Flush(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	expireSet.On("Clear").Return()
+	s := InitStore(expireSet)
+	s.Set("foo", "bar", nil)
+	s.Set("uno", "one", nil)
+	assert.Equal(2, s.Len())
+
+	s.Flush()
+	assert.Equal(0, s.Len())
+	assert.Nil(s.Get("foo"))
+	expireSet.AssertNumberOfCalls(t, "Clear", 1)
+}
+
+func Test_Stats(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	expireSet.On("Len").Return(1)
+	s := InitStore(expireSet)
+	past := time.Now().Add(-1 * time.Second)
+	future := time.Now().Add(time.Hour)
+	s.Set("foo", "bar", nil)
+	s.Set("old", "one", &past)
+	s.Set("new", "one", &future)
+
+	s.Get("foo")
+	s.Get("missing")
+	s.Get("old")
+
+	assert.Equal(Stats{Keys: 2, Expires: 1, ExpiredKeys: 1, Hits: 1, Misses: 2}, s.Stats())
+
+	s.ResetStats()
+	assert.Equal(Stats{Keys: 2, Expires: 1}, s.Stats())
+}
+
+func Test_OnExpire(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	s := InitStore(expireSet)
+	past := time.Now().Add(-1 * time.Second)
+	s.data["old"] = Value{value: "one", expiry: &past}
+
+	expired := []string{}
+	s.OnExpire(func(k string) { expired = append(expired, k) })
+	s.Get("old")
+
+	assert.Equal([]string{"old"}, expired)
+}
+
+func Test_Scan(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	s := InitStore(expireSet)
+	for i := 0; i < 50; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), "x", nil)
+	}
+	s.ZAdd("z", []ScoreMember{NewScoreMember(1, "a")})
+	s.Del("key:0")
+
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for {
+		var keys []string
+		cursor, keys = s.Scan(cursor, 10)
+		for _, k := range keys {
+			seen[k] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	assert.Len(seen, 50)
+	assert.True(seen["z"])
+	assert.False(seen["key:0"])
+}
+
+func Test_Del_And_Unlink_ZSet(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	s := InitStore(expireSet)
+	s.ZAdd("z", []ScoreMember{NewScoreMember(1, "a"), NewScoreMember(2, "b")})
+	s.ZAdd("other", []ScoreMember{NewScoreMember(1, "a")})
+
+	assert.Equal(1, s.Del("z"))
+	_, ok := s.GetValue("z")
+	assert.False(ok)
+
+	value, ok := s.Unlink("other")
+	assert.True(ok)
+	assert.Equal(1, value.Len())
+	assert.Equal(int64(0), s.UsedMemory())
+	value.Release()
+	assert.Equal(0, value.Len())
+
+	_, ok = s.Unlink("other")
+	assert.False(ok)
+}
+
+func Test_CleanUp_Stops_At_Deadline(t *testing.T) {
+	assert := assert.New(t)
+	s := InitStore(set.InitStringSet())
+
+	past := time.Now().Add(-1 * time.Second)
+	for i := 0; i < 1000; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), "v", &past)
+	}
+
+	// a single round runs when the deadline already passed. Samples may
+	// repeat, and a key deleted earlier in the round is skipped.
+	run := s.CleanUp(time.Now())
+	assert.False(run.Done)
+	assert.LessOrEqual(run.Sampled, 20)
+	assert.Equal(run.Sampled, run.Expired)
+	assert.Equal(1000-run.Expired, s.Len())
+
+	run = s.CleanUp(time.Now().Add(time.Second))
+	assert.True(run.Done)
+	assert.Less(s.Len(), 100)
+}
+
+func Test_CleanUp_Effort(t *testing.T) {
+	assert := assert.New(t)
+	s := InitStore(set.InitStringSet())
+	s.SetExpireCycle(ExpireCycle{Samples: 20, Threshold: 25, Effort: 5})
+
+	past := time.Now().Add(-1 * time.Second)
+	for i := 0; i < 1000; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), "v", &past)
+	}
+
+	// 5 more keys per round for each step of effort above 1
+	run := s.CleanUp(time.Now())
+	assert.Greater(run.Sampled, 20)
+	assert.LessOrEqual(run.Sampled, 40)
+}
+
+func Test_CleanUp_Timing_Wheel(t *testing.T) {
+	assert := assert.New(t)
+	s := InitStore(set.NewTimingWheel(time.Now()))
+
+	expired := make([]string, 0)
+	s.OnExpire(func(k string) { expired = append(expired, k) })
+
+	past := time.Now().Add(-time.Second)
+	later := time.Now().Add(time.Hour)
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("gone:%d", i), "v", &past)
+	}
+	s.Set("live", "v", &later)
+	s.Set("plain", "v", nil)
+	s.SetValue("restored", NewStringValue("v").WithExpiry(&past))
+	s.Set("persisted", "v", &past)
+	s.SetValue("persisted", NewStringValue("v"))
+
+	// every due key is deleted, however few keys of the store they are
+	run := s.CleanUp(time.Now().Add(time.Second))
+	assert.True(run.Done)
+	assert.Equal(101, run.Expired)
+	assert.Len(expired, 101)
+	assert.ElementsMatch([]string{"live", "plain", "persisted"}, s.Keys("*"))
+	assert.Equal(1, s.Stats().Expires)
+}
+
+func Test_CleanUp_Timing_Wheel_Deadline(t *testing.T) {
+	assert := assert.New(t)
+	s := InitStore(set.NewTimingWheel(time.Now()))
+
+	past := time.Now().Add(-time.Second)
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("gone:%d", i), "v", &past)
+	}
+
+	run := s.CleanUp(time.Now())
+	assert.False(run.Done)
+	assert.Equal(20, run.Expired)
+	assert.Equal(80, s.Len())
+}
+
+// fillExpiring adds n keys that expire in an hour and m keys that expired
+func fillExpiring(s *InMemStore, n, m int) {
+	past := time.Now().Add(-time.Second)
+	later := time.Now().Add(time.Hour)
+	for i := 0; i < n; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), "v", &later)
+	}
+	for i := 0; i < m; i++ {
+		s.Set(fmt.Sprintf("expired:%d", i), "v", &past)
+	}
+}
+
+// BenchmarkCleanUp runs a cycle of 25ms over a store with 100000 keys with
+// an expiry, a tenth of them expired, and reports how many keys each cycle
+// deletes. Sampling stops as soon as few sampled keys are expired, while
+// the wheel deletes every expired key.
+func BenchmarkCleanUp(b *testing.B) {
+	strategies := map[string]func() set.IStringSet{
+		"sample": func() set.IStringSet { return set.InitStringSet() },
+		"wheel":  func() set.IStringSet { return set.NewTimingWheel(time.Now()) },
+	}
+
+	for name, newIndex := range strategies {
+		b.Run(name, func(b *testing.B) {
+			expired := 0
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				s := InitStore(newIndex())
+				fillExpiring(s, 90000, 10000)
+				b.StartTimer()
+
+				expired += s.CleanUp(time.Now().Add(25 * time.Millisecond)).Expired
+			}
+			b.ReportMetric(float64(expired)/float64(b.N), "expired/op")
+		})
+	}
+}
+
+// BenchmarkExpiryIndex measures adding and removing keys with an expiry
+func BenchmarkExpiryIndex(b *testing.B) {
+	b.Run("sample", func(b *testing.B) {
+		s := InitStore(set.InitStringSet())
+		benchmarkExpiryIndex(b, s)
+	})
+	b.Run("wheel", func(b *testing.B) {
+		s := InitStore(set.NewTimingWheel(time.Now()))
+		benchmarkExpiryIndex(b, s)
+	})
+}
+
+func benchmarkExpiryIndex(b *testing.B, s *InMemStore) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		k := keys[i%len(keys)]
+		e := time.Now().Add(time.Duration(i%3600) * time.Second)
+		s.Set(k, "v", &e)
+		if i%2 == 0 {
+			s.Del(k)
+		}
+	}
+}
@@ -15,9 +15,18 @@ type Store struct {
 	mock.Mock
 }
 
-// CleanUp provides a mock function with given fields:
-func (_m *Store) CleanUp() {
-	_m.Called()
+// CleanUp provides a mock function with given fields: deadline
+func (_m *Store) CleanUp(deadline time.Time) store.ExpireRun {
+	ret := _m.Called(deadline)
+
+	var r0 store.ExpireRun
+	if rf, ok := ret.Get(0).(func(time.Time) store.ExpireRun); ok {
+		r0 = rf(deadline)
+	} else {
+		r0 = ret.Get(0).(store.ExpireRun)
+	}
+
+	return r0
 }
 
 // Del provides a mock function with given fields: keys
@@ -40,6 +49,20 @@ func (_m *Store) Del(keys ...string) int {
 	return r0
 }
 
+// Evict provides a mock function with given fields: k
+func (_m *Store) Evict(k string) bool {
+	ret := _m.Called(k)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(k)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // Expire provides a mock function with given fields: k, seconds
 func (_m *Store) Expire(k string, seconds int) int {
 	ret := _m.Called(k, seconds)
@@ -54,6 +77,25 @@ func (_m *Store) Expire(k string, seconds int) int {
 	return r0
 }
 
+// ExpireAt provides a mock function with given fields: k, t
+func (_m *Store) ExpireAt(k string, t time.Time) int {
+	ret := _m.Called(k, t)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string, time.Time) int); ok {
+		r0 = rf(k, t)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// Flush provides a mock function with given fields:
+func (_m *Store) Flush() {
+	_m.Called()
+}
+
 // Get provides a mock function with given fields: k
 func (_m *Store) Get(k string) *string {
 	ret := _m.Called(k)
@@ -70,6 +112,30 @@ func (_m *Store) Get(k string) *string {
 	return r0
 }
 
+// GetValue provides a mock function with given fields: k
+func (_m *Store) GetValue(k string) (store.Value, bool) {
+	ret := _m.Called(k)
+
+	var r0 store.Value
+	var r1 bool
+	if rf, ok := ret.Get(0).(func(string) (store.Value, bool)); ok {
+		return rf(k)
+	}
+	if rf, ok := ret.Get(0).(func(string) store.Value); ok {
+		r0 = rf(k)
+	} else {
+		r0 = ret.Get(0).(store.Value)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) bool); ok {
+		r1 = rf(k)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	return r0, r1
+}
+
 // Keys provides a mock function with given fields: k
 func (_m *Store) Keys(k string) []string {
 	ret := _m.Called(k)
@@ -86,6 +152,46 @@ func (_m *Store) Keys(k string) []string {
 	return r0
 }
 
+// Len provides a mock function with given fields:
+func (_m *Store) Len() int {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// OnExpire provides a mock function with given fields: fn
+func (_m *Store) OnExpire(fn func(string)) {
+	_m.Called(fn)
+}
+
+// Peek provides a mock function with given fields: k
+func (_m *Store) Peek(k string) (store.Value, bool) {
+	ret := _m.Called(k)
+
+	var r0 store.Value
+	if rf, ok := ret.Get(0).(func(string) store.Value); ok {
+		r0 = rf(k)
+	} else {
+		r0 = ret.Get(0).(store.Value)
+	}
+
+	var r1 bool
+	if rf, ok := ret.Get(1).(func(string) bool); ok {
+		r1 = rf(k)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	return r0, r1
+}
+
 // Ping provides a mock function with given fields:
 func (_m *Store) Ping() *string {
 	ret := _m.Called()
@@ -102,11 +208,130 @@ func (_m *Store) Ping() *string {
 	return r0
 }
 
+// ResetStats provides a mock function with given fields:
+func (_m *Store) ResetStats() {
+	_m.Called()
+}
+
+// Sample provides a mock function with given fields: n, volatile
+func (_m *Store) Sample(n int, volatile bool) []store.Entry {
+	ret := _m.Called(n, volatile)
+
+	var r0 []store.Entry
+	if rf, ok := ret.Get(0).(func(int, bool) []store.Entry); ok {
+		r0 = rf(n, volatile)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.Entry)
+		}
+	}
+
+	return r0
+}
+
+// Scan provides a mock function with given fields: cursor, count
+func (_m *Store) Scan(cursor uint64, count int) (uint64, []string) {
+	ret := _m.Called(cursor, count)
+
+	var r0 uint64
+	if rf, ok := ret.Get(0).(func(uint64, int) uint64); ok {
+		r0 = rf(cursor, count)
+	} else {
+		r0 = ret.Get(0).(uint64)
+	}
+
+	var r1 []string
+	if rf, ok := ret.Get(1).(func(uint64, int) []string); ok {
+		r1 = rf(cursor, count)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]string)
+		}
+	}
+
+	return r0, r1
+}
+
 // Set provides a mock function with given fields: k, v, e
 func (_m *Store) Set(k string, v string, e *time.Time) {
 	_m.Called(k, v, e)
 }
 
+// SetExpireCycle provides a mock function with given fields: c
+func (_m *Store) SetExpireCycle(c store.ExpireCycle) {
+	_m.Called(c)
+}
+
+// SetValue provides a mock function with given fields: k, v
+func (_m *Store) SetValue(k string, v store.Value) {
+	_m.Called(k, v)
+}
+
+// Snapshot provides a mock function with given fields:
+func (_m *Store) Snapshot() []store.Entry {
+	ret := _m.Called()
+
+	var r0 []store.Entry
+	if rf, ok := ret.Get(0).(func() []store.Entry); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]store.Entry)
+		}
+	}
+
+	return r0
+}
+
+// Stats provides a mock function with given fields:
+func (_m *Store) Stats() store.Stats {
+	ret := _m.Called()
+
+	var r0 store.Stats
+	if rf, ok := ret.Get(0).(func() store.Stats); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(store.Stats)
+	}
+
+	return r0
+}
+
+// Unlink provides a mock function with given fields: k
+func (_m *Store) Unlink(k string) (store.Value, bool) {
+	ret := _m.Called(k)
+
+	var r0 store.Value
+	if rf, ok := ret.Get(0).(func(string) store.Value); ok {
+		r0 = rf(k)
+	} else {
+		r0 = ret.Get(0).(store.Value)
+	}
+
+	var r1 bool
+	if rf, ok := ret.Get(1).(func(string) bool); ok {
+		r1 = rf(k)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	return r0, r1
+}
+
+// UsedMemory provides a mock function with given fields:
+func (_m *Store) UsedMemory() int64 {
+	ret := _m.Called()
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	return r0
+}
+
 // ZAdd provides a mock function with given fields: k, s
 func (_m *Store) ZAdd(k string, s []store.ScoreMember) int {
 	ret := _m.Called(k, s)
@@ -0,0 +1,68 @@
+package store
+
+import "strconv"
+
+// limits under which Redis keeps values in their compact encodings
+const (
+	embstrMaxLen        = 44
+	listpackMaxEntries  = 128
+	listpackMaxValueLen = 64
+)
+
+// Encoding returns the name of the internal representation Redis would use
+// for the value, as reported by OBJECT ENCODING
+func (v Value) Encoding() string {
+	switch val := v.value.(type) {
+	case string:
+		if len(val) <= 20 {
+			if _, err := strconv.ParseInt(val, 10, 64); err == nil {
+				return "int"
+			}
+		}
+		if len(val) <= embstrMaxLen {
+			return "embstr"
+		}
+		return "raw"
+	case *zset:
+		if val.GetCount() > listpackMaxEntries {
+			return "skiplist"
+		}
+		for _, n := range val.GetByRankRange(1, -1, false) {
+			if len(n.Key()) > listpackMaxValueLen {
+				return "skiplist"
+			}
+		}
+		return "listpack"
+	}
+	return "none"
+}
+
+// MemoryUsage estimates the bytes held by key k and its value v. The
+// members of a sorted set are sized from the first samples of them, or
+// all of them if samples is 0.
+func MemoryUsage(k string, v Value, samples int) int64 {
+	set, ok := v.value.(*zset)
+	if !ok || samples == 0 || set.GetCount() <= samples {
+		return keySize(k) + v.size()
+	}
+
+	var sampled int64
+	for _, n := range set.GetByRankRange(1, samples, false) {
+		sampled += memberSize(n.Key())
+	}
+	return keySize(k) + zsetOverhead + sampled*int64(set.GetCount())/int64(samples)
+}
+
+func (s *InMemStore) Peek(k string) (Value, bool) {
+	value, ok := s.data[k]
+	if !ok {
+		return Value{}, false
+	}
+
+	if value.isExpired() {
+		s.deleteExpired(k)
+		return Value{}, false
+	}
+
+	return value, true
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Encoding(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("int", NewStringValue("12345").Encoding())
+	assert.Equal("embstr", NewStringValue("hello").Encoding())
+	assert.Equal("raw", NewStringValue(strings.Repeat("x", 45)).Encoding())
+	assert.Equal("listpack", NewZSetValue([]ScoreMember{NewScoreMember(1, "a")}).Encoding())
+	assert.Equal("skiplist", NewZSetValue([]ScoreMember{NewScoreMember(1, strings.Repeat("x", 65))}).Encoding())
+
+	members := make([]ScoreMember, 0, 200)
+	for i := 0; i < 200; i++ {
+		members = append(members, NewScoreMember(int64(i), strconv.Itoa(i)))
+	}
+	assert.Equal("skiplist", NewZSetValue(members).Encoding())
+}
+
+func Test_MemoryUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	v := NewStringValue("bar")
+	assert.Equal(keySize("foo")+3+stringOverhead, MemoryUsage("foo", v, 5))
+
+	// members of the same size are estimated exactly from a sample
+	members := make([]ScoreMember, 0, 100)
+	for i := 0; i < 100; i++ {
+		members = append(members, NewScoreMember(int64(i), strconv.Itoa(100+i)))
+	}
+	z := NewZSetValue(members)
+	assert.Equal(MemoryUsage("z", z, 0), MemoryUsage("z", z, 5))
+	assert.Equal(keySize("z")+zsetOverhead+100*memberSize("100"), MemoryUsage("z", z, 0))
+}
+
+func Test_Peek(t *testing.T) {
+	setup()
+	assert := assert.New(t)
+	s := InitStore(expireSet)
+	s.Set("foo", "bar", nil)
+
+	value := s.data["foo"]
+	value.accessed = time.Now().Add(-time.Hour).UnixNano()
+	s.data["foo"] = value
+
+	// peeking leaves the access time alone, reading does not
+	v, ok := s.Peek("foo")
+	assert.True(ok)
+	assert.GreaterOrEqual(v.Idle(), time.Hour)
+	s.GetValue("foo")
+	v, _ = s.Peek("foo")
+	assert.Less(v.Idle(), time.Minute)
+
+	_, ok = s.Peek("missing")
+	assert.False(ok)
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"time"
+)
+
+// shardSeed hashes keys to shards the same way for the life of the process
+var shardSeed = maphash.MakeSeed()
+
+// ShardOf returns which of n shards key k belongs to
+func ShardOf(k string, n int) int {
+	return int(maphash.String(shardSeed, k) % uint64(n))
+}
+
+// Sharded is a store made of partitions, each holding the keys ShardOf
+// assigns to it. A key is only ever looked up in its own partition, so
+// partitions can be used by different goroutines as long as each one is
+// used by a single goroutine at a time.
+type Sharded struct {
+	parts []Store
+	// partition the next CleanUp starts with, the one the last CleanUp ran
+	// out of time in
+	nextCleanUp int
+}
+
+// NewSharded creates a store out of the partitions parts
+func NewSharded(parts ...Store) *Sharded {
+	return &Sharded{parts: parts}
+}
+
+func (s *Sharded) part(k string) Store {
+	return s.parts[ShardOf(k, len(s.parts))]
+}
+
+func (s *Sharded) Ping() *string {
+	return s.parts[0].Ping()
+}
+
+func (s *Sharded) Get(k string) *string {
+	return s.part(k).Get(k)
+}
+
+func (s *Sharded) Set(k string, v string, e *time.Time) {
+	s.part(k).Set(k, v, e)
+}
+
+func (s *Sharded) GetValue(k string) (Value, bool) {
+	return s.part(k).GetValue(k)
+}
+
+func (s *Sharded) Peek(k string) (Value, bool) {
+	return s.part(k).Peek(k)
+}
+
+func (s *Sharded) SetValue(k string, v Value) {
+	s.part(k).SetValue(k, v)
+}
+
+func (s *Sharded) Del(keys ...string) int {
+	deleted := 0
+	for _, k := range keys {
+		deleted += s.part(k).Del(k)
+	}
+	return deleted
+}
+
+func (s *Sharded) Unlink(k string) (Value, bool) {
+	return s.part(k).Unlink(k)
+}
+
+func (s *Sharded) Expire(k string, seconds int) int {
+	return s.part(k).Expire(k, seconds)
+}
+
+func (s *Sharded) ExpireAt(k string, t time.Time) int {
+	return s.part(k).ExpireAt(k, t)
+}
+
+func (s *Sharded) Keys(pattern string) []string {
+	keys := make([]string, 0)
+	for _, p := range s.parts {
+		keys = append(keys, p.Keys(pattern)...)
+	}
+	return keys
+}
+
+// Scan goes through the partitions one after the other. The low bits of
+// the cursor hold the partition and the others the cursor within it.
+func (s *Sharded) Scan(cursor uint64, count int) (uint64, []string) {
+	shift := bits.Len(uint(len(s.parts) - 1))
+	i := int(cursor & (1<<shift - 1))
+	if i >= len(s.parts) {
+		return 0, []string{}
+	}
+
+	next, keys := s.parts[i].Scan(cursor>>shift, count)
+	if next != 0 {
+		return next<<shift | uint64(i), keys
+	}
+	if i+1 < len(s.parts) {
+		return uint64(i + 1), keys
+	}
+	return 0, keys
+}
+
+func (s *Sharded) ZAdd(k string, members []ScoreMember) int {
+	return s.part(k).ZAdd(k, members)
+}
+
+func (s *Sharded) ZRange(k string, start int, stop int, withScores bool) []string {
+	return s.part(k).ZRange(k, start, stop, withScores)
+}
+
+func (s *Sharded) Len() int {
+	n := 0
+	for _, p := range s.parts {
+		n += p.Len()
+	}
+	return n
+}
+
+func (s *Sharded) Flush() {
+	for _, p := range s.parts {
+		p.Flush()
+	}
+}
+
+func (s *Sharded) Snapshot() []Entry {
+	entries := make([]Entry, 0)
+	for _, p := range s.parts {
+		entries = append(entries, p.Snapshot()...)
+	}
+	return entries
+}
+
+// CleanUp cleans up the partitions in turn, starting with the one the last
+// call ran out of time in
+func (s *Sharded) CleanUp(deadline time.Time) ExpireRun {
+	var run ExpireRun
+	for i := 0; i < len(s.parts); i++ {
+		p := s.nextCleanUp % len(s.parts)
+		r := s.parts[p].CleanUp(deadline)
+		run.Sampled += r.Sampled
+		run.Expired += r.Expired
+
+		if !r.Done {
+			s.nextCleanUp = p
+			return run
+		}
+		s.nextCleanUp = p + 1
+	}
+	run.Done = true
+	return run
+}
+
+func (s *Sharded) SetExpireCycle(c ExpireCycle) {
+	for _, p := range s.parts {
+		p.SetExpireCycle(c)
+	}
+}
+
+func (s *Sharded) Stats() Stats {
+	var total Stats
+	for _, p := range s.parts {
+		stats := p.Stats()
+		total.Keys += stats.Keys
+		total.Expires += stats.Expires
+		total.ExpiredKeys += stats.ExpiredKeys
+		total.EvictedKeys += stats.EvictedKeys
+		total.Hits += stats.Hits
+		total.Misses += stats.Misses
+	}
+	return total
+}
+
+func (s *Sharded) ResetStats() {
+	for _, p := range s.parts {
+		p.ResetStats()
+	}
+}
+
+func (s *Sharded) OnExpire(fn func(k string)) {
+	for _, p := range s.parts {
+		p.OnExpire(fn)
+	}
+}
+
+func (s *Sharded) UsedMemory() int64 {
+	var used int64
+	for _, p := range s.parts {
+		used += p.UsedMemory()
+	}
+	return used
+}
+
+// Sample takes an even share of the keys from every partition
+func (s *Sharded) Sample(n int, volatile bool) []Entry {
+	share := (n + len(s.parts) - 1) / len(s.parts)
+	entries := make([]Entry, 0, n)
+	for _, p := range s.parts {
+		entries = append(entries, p.Sample(share, volatile)...)
+	}
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+func (s *Sharded) Evict(k string) bool {
+	return s.part(k).Evict(k)
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"fmt"
+	"noelzubin/redis-go/set"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSharded(n int) (*Sharded, []*InMemStore) {
+	parts := make([]Store, n)
+	stores := make([]*InMemStore, n)
+	for i := range parts {
+		stores[i] = InitStore(set.InitStringSet())
+		parts[i] = stores[i]
+	}
+	return NewSharded(parts...), stores
+}
+
+func Test_Sharded_Routes_Keys(t *testing.T) {
+	assert := assert.New(t)
+	s, parts := newTestSharded(4)
+
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), "v", nil)
+	}
+	assert.Equal(100, s.Len())
+	for i, p := range parts {
+		for _, k := range p.Keys("*") {
+			assert.Equal(i, ShardOf(k, 4))
+		}
+	}
+
+	v := "v"
+	assert.Equal(&v, s.Get("key:7"))
+	assert.Equal(2, s.Del("key:1", "key:2", "missing"))
+	assert.Len(s.Keys("key:*"), 98)
+	assert.Equal(98, s.Stats().Keys)
+	assert.Equal(int64(1), s.Stats().Hits)
+}
+
+func Test_Sharded_Scan(t *testing.T) {
+	assert := assert.New(t)
+	for _, n := range []int{1, 3, 4} {
+		s, _ := newTestSharded(n)
+		for i := 0; i < 500; i++ {
+			s.Set(fmt.Sprintf("key:%d", i), "v", nil)
+		}
+
+		seen := make(map[string]bool)
+		cursor := uint64(0)
+		for {
+			next, keys := s.Scan(cursor, 10)
+			for _, k := range keys {
+				seen[k] = true
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+		assert.Len(seen, 500)
+	}
+}
+
+func Test_Sharded_CleanUp_Resumes(t *testing.T) {
+	assert := assert.New(t)
+	s, _ := newTestSharded(4)
+
+	past := time.Now().Add(-time.Second)
+	for i := 0; i < 2000; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), "v", &past)
+	}
+
+	// out of time in the first partition, which the next call starts with
+	run := s.CleanUp(time.Now())
+	assert.False(run.Done)
+	assert.Equal(0, s.nextCleanUp)
+
+	run = s.CleanUp(time.Now().Add(time.Second))
+	assert.True(run.Done)
+	assert.Less(s.Len(), 2000)
+}
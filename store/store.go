@@ -13,6 +13,22 @@ func NewScoreMember(score int64, member string) ScoreMember {
 	return ScoreMember{score: score, member: member}
 }
 
+// Score returns the score of the member
+func (s ScoreMember) Score() int64 {
+	return s.score
+}
+
+// Member returns the name of the member
+func (s ScoreMember) Member() string {
+	return s.member
+}
+
+// Entry is a key and a copy of its value taken at a point in time
+type Entry struct {
+	Key   string
+	Value Value
+}
+
 // Store interface wraps the methods that a store must implement
 type Store interface {
 	// Ping the store
@@ -21,16 +37,125 @@ type Store interface {
 	Get(k string) *string
 	// Set a value for key with optional expiry time
 	Set(k string, v string, e *time.Time)
+	// GetValue returns the value stored at key, whatever its type. The
+	// returned value must not be modified.
+	GetValue(k string) (Value, bool)
+	// Peek returns the value stored at key like GetValue, without counting
+	// it as an access
+	Peek(k string) (Value, bool)
+	// SetValue stores a value at key, replacing any previous value
+	SetValue(k string, v Value)
 	// Del deletes a Key from store
 	Del(keys ...string) int
+	// Unlink deletes a key like Del and returns its value, which the
+	// caller may Release away from the store
+	Unlink(k string) (Value, bool)
 	// Expire updates the expiry time for a key
 	Expire(k string, seconds int) int
-	// Keys returns all keys
-	Keys(k string) []string
+	// ExpireAt sets an absolute expiry time for a key
+	ExpireAt(k string, t time.Time) int
+	// Keys returns the keys matching a glob pattern, or all keys if the
+	// pattern is empty
+	Keys(pattern string) []string
+	// Scan returns keys from the cursor on, about count of them, and the
+	// cursor to continue from, which is 0 at the end. Every key present
+	// for the whole iteration is returned at least once. Expired keys may
+	// be returned.
+	Scan(cursor uint64, count int) (uint64, []string)
 	// ZAdd adds a member to a sorted set
 	ZAdd(k string, s []ScoreMember) int
 	// ZRange returns a range of members from a sorted set
 	ZRange(k string, start int, stop int, withScores bool) []string
-	// Cleanup tries to cleanup expired keys
-	CleanUp()
+	// Len returns the number of keys, including expired keys that have not
+	// been removed yet
+	Len() int
+	// Flush removes every key
+	Flush()
+	// Snapshot returns a copy of every live key and its value
+	Snapshot() []Entry
+	// CleanUp deletes expired keys in rounds of random samples until few
+	// of them are expired or the deadline is reached
+	CleanUp(deadline time.Time) ExpireRun
+	// SetExpireCycle changes how CleanUp samples keys
+	SetExpireCycle(c ExpireCycle)
+	// Stats returns the counters of the store
+	Stats() Stats
+	// ResetStats zeroes the counters returned by Stats
+	ResetStats()
+	// OnExpire sets a function called with each key deleted because it
+	// expired
+	OnExpire(fn func(k string))
+	// UsedMemory returns an estimate of the bytes held by keys and values
+	UsedMemory() int64
+	// Sample returns up to n keys picked at random, among the keys with an
+	// expiry only if volatile is set. The values must not be modified.
+	Sample(n int, volatile bool) []Entry
+	// Evict deletes a key to free memory
+	Evict(k string) bool
+}
+
+// Stats describes the keyspace of a store and counts what happened to it
+type Stats struct {
+	// number of keys and of keys with an expiry
+	Keys    int
+	Expires int
+	// keys deleted because they expired, and to free memory
+	ExpiredKeys int64
+	EvictedKeys int64
+	// lookups with Get that found a key and that did not
+	Hits   int64
+	Misses int64
+}
+
+// ExpireCycle tunes the active expiry of CleanUp. Each round samples
+// Samples keys with an expiry and deletes the expired ones, and another
+// round follows while more than Threshold percent of them were expired.
+// Effort, from 1 to 10, samples more keys per round, lowers the threshold
+// and gives the cycle more time, as active-expire-effort does in Redis.
+type ExpireCycle struct {
+	Samples   int
+	Threshold int
+	Effort    int
+}
+
+// DefaultExpireCycle samples 20 keys and repeats above 25% expired
+var DefaultExpireCycle = ExpireCycle{Samples: 20, Threshold: 25, Effort: 1}
+
+// extraEffort is how much effort was asked for above the minimum
+func (c ExpireCycle) extraEffort() int {
+	if c.Effort < 1 {
+		return 0
+	}
+	return c.Effort - 1
+}
+
+// keysPerRound is how many keys each round samples, a quarter more for
+// every step of effort
+func (c ExpireCycle) keysPerRound() int {
+	return c.Samples + c.Samples/4*c.extraEffort()
+}
+
+// staleThreshold is the percentage of expired keys in a round above which
+// another round follows
+func (c ExpireCycle) staleThreshold() int {
+	if t := c.Threshold - c.extraEffort(); t > 1 {
+		return t
+	}
+	return 1
+}
+
+// TimeLimitPercent is the share of the time between two cycles a cycle may
+// take
+func (c ExpireCycle) TimeLimitPercent() int {
+	return 25 + 2*c.extraEffort()
+}
+
+// ExpireRun reports what a call to CleanUp did
+type ExpireRun struct {
+	// keys looked at and deleted
+	Sampled int
+	Expired int
+	// false if the deadline was reached while many sampled keys were
+	// still expired
+	Done bool
 }
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"noelzubin/redis-go/set"
+
+	"github.com/wangjia184/sortedset"
+)
+
+// zset is a sorted set along with an index of its members, which ZSCAN
+// iterates with a cursor like the dict of a Redis sorted set
+type zset struct {
+	*sortedset.SortedSet
+	members *set.ScanSet
+	// estimated bytes held by the members
+	bytes int64
+}
+
+func newZSet() *zset {
+	return &zset{SortedSet: sortedset.New(), members: set.NewScanSet()}
+}
+
+// add adds a member or updates its score, returning true if it was added
+func (z *zset) add(member string, score int64) bool {
+	z.AddOrUpdate(member, sortedset.SCORE(score), nil)
+	if !z.members.Add(member) {
+		return false
+	}
+	z.bytes += memberSize(member)
+	return true
+}
+
+// release drops every member, one at a time
+func (z *zset) release() {
+	for z.GetCount() > 0 {
+		n := z.PopMin()
+		z.members.Remove(n.Key())
+	}
+	z.bytes = 0
+}
+
+// ZScan returns members of a sorted set value and their scores from the
+// buckets at cursor on, and the cursor to continue from. Like Redis, small
+// sets are returned whole with a 0 cursor.
+func (v Value) ZScan(cursor uint64, count int) (uint64, []ScoreMember) {
+	z, ok := v.value.(*zset)
+	if !ok {
+		return 0, nil
+	}
+	if v.Encoding() == "listpack" {
+		return 0, v.ScoreMembers()
+	}
+
+	members := make([]ScoreMember, 0, count)
+	next := z.members.Scan(cursor, count, func(member string) {
+		if n := z.GetByKey(member); n != nil {
+			members = append(members, NewScoreMember(int64(n.Score()), member))
+		}
+	})
+	return next, members
+}
@@ -0,0 +1,108 @@
+// Package tlsconfig builds the TLS configuration of the server and of the
+// clients connecting to it from PEM files.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseClientAuth parses the value of the tls-auth-clients option: yes
+// requires clients to present a certificate signed by the CA, optional
+// verifies it only if one is presented and no does not ask for one
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "no":
+		return tls.NoClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("invalid tls-auth-clients %s, expected yes, optional or no", s)
+}
+
+// Server returns the configuration of a TLS listener. caFile holds the
+// certificates client certificates are verified against, and may only be
+// empty if auth is tls.NoClientCert.
+func Server(certFile, keyFile, caFile string, auth tls.ClientAuthType) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   auth,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if auth != tls.NoClientCert {
+		if caFile == "" {
+			return nil, errors.New("a CA certificate is needed to verify client certificates")
+		}
+		config.ClientCAs, err = loadCA(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// Client returns the configuration for connecting to serverName. Without
+// caFile the server certificate is verified against the system roots. The
+// client certificate is optional.
+func Client(serverName, caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pool, err := loadCA(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func loadCA(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// PeerSubject returns the subject and common name of the verified
+// certificate the peer presented, if any. The handshake must be complete.
+func PeerSubject(conn *tls.Conn) (subject string, commonName string, ok bool) {
+	chains := conn.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", "", false
+	}
+
+	cert := chains[0][0]
+	return cert.Subject.String(), cert.Subject.CommonName, true
+}
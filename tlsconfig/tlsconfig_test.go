@@ -0,0 +1,195 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPKI is a self-signed CA and the files of certificates it issued
+type testPKI struct {
+	t    *testing.T
+	dir  string
+	ca   *x509.Certificate
+	key  *ecdsa.PrivateKey
+	next int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	p := &testPKI{t: t, dir: t.TempDir(), next: 1}
+	p.ca, p.key = p.issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	p.write("ca.crt", "CERTIFICATE", p.ca.Raw)
+	return p
+}
+
+// issue signs template with the CA, or self-signs it if parent is nil
+func (p *testPKI) issue(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(p.next)
+	p.next++
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return cert, key
+}
+
+// leaf issues a certificate for name and returns the paths of its files
+func (p *testPKI) leaf(name string, usage x509.ExtKeyUsage) (string, string) {
+	cert, key := p.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: name, Organization: []string{"redis-go"}},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, p.ca, p.key)
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return p.write(name+".crt", "CERTIFICATE", cert.Raw), p.write(name+".key", "EC PRIVATE KEY", der)
+}
+
+func (p *testPKI) write(name, typ string, der []byte) string {
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		p.t.Fatal(err)
+	}
+	return path
+}
+
+// handshake connects a client with config to a server with serverConfig
+// and returns the server side of the connection
+func handshake(t *testing.T, serverConfig, config *tls.Config) (*tls.Conn, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan *tls.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		tc := conn.(*tls.Conn)
+		tc.Handshake()
+		accepted <- tc
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), config)
+	if err == nil {
+		// with TLS 1.3 a rejected client certificate only shows up on the
+		// first read
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err = conn.Read(make([]byte, 1)); isTimeout(err) {
+			err = nil
+		}
+		defer conn.Close()
+	}
+
+	server := <-accepted
+	if server != nil {
+		t.Cleanup(func() { server.Close() })
+	}
+	return server, err
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func Test_ParseClientAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	auth, err := ParseClientAuth("YES")
+	assert.NoError(err)
+	assert.Equal(tls.RequireAndVerifyClientCert, auth)
+
+	auth, _ = ParseClientAuth("optional")
+	assert.Equal(tls.VerifyClientCertIfGiven, auth)
+
+	_, err = ParseClientAuth("maybe")
+	assert.Error(err)
+}
+
+func Test_Mutual_TLS(t *testing.T) {
+	assert := assert.New(t)
+	pki := newTestPKI(t)
+	serverCert, serverKey := pki.leaf("server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := pki.leaf("alice", x509.ExtKeyUsageClientAuth)
+	caFile := filepath.Join(pki.dir, "ca.crt")
+
+	serverConfig, err := Server(serverCert, serverKey, caFile, tls.RequireAndVerifyClientCert)
+	assert.NoError(err)
+
+	config, err := Client("localhost", caFile, clientCert, clientKey)
+	assert.NoError(err)
+	server, err := handshake(t, serverConfig, config)
+	assert.NoError(err)
+
+	subject, cn, ok := PeerSubject(server)
+	assert.True(ok)
+	assert.Equal("alice", cn)
+	assert.Equal("CN=alice,O=redis-go", subject)
+
+	// a client without a certificate is turned away
+	config, err = Client("localhost", caFile, "", "")
+	assert.NoError(err)
+	_, err = handshake(t, serverConfig, config)
+	assert.Error(err)
+
+	// unless certificates are optional
+	serverConfig, err = Server(serverCert, serverKey, caFile, tls.VerifyClientCertIfGiven)
+	assert.NoError(err)
+	server, err = handshake(t, serverConfig, config)
+	assert.NoError(err)
+	_, _, ok = PeerSubject(server)
+	assert.False(ok)
+}
+
+func Test_Server_Needs_CA(t *testing.T) {
+	pki := newTestPKI(t)
+	serverCert, serverKey := pki.leaf("server", x509.ExtKeyUsageServerAuth)
+
+	_, err := Server(serverCert, serverKey, "", tls.RequireAndVerifyClientCert)
+	assert.Error(t, err)
+	_, err = Server(serverCert, serverKey, "", tls.NoClientCert)
+	assert.NoError(t, err)
+}